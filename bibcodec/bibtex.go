@@ -0,0 +1,736 @@
+package bibcodec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// bibtexPrefix starts the Book.Extra keys of BibTeX fields, which are the
+// field name, as in "bibtex:keywords". The entry type, when it is not
+// "book", and the citation key are kept under "bibtex:@type" and
+// "bibtex:@key". Values are kept as they were written, LaTeX and all, with
+// macros expanded.
+const bibtexPrefix = "bibtex:"
+
+const (
+	bibtexTypeKey     = bibtexPrefix + "@type"
+	bibtexCitationKey = bibtexPrefix + "@key"
+)
+
+// bibtexMonths are the month macros every BibTeX style defines
+var bibtexMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+// NewBibTeXDecoder returns a Decoder for the entries of a BibTeX file.
+// @string macros are expanded, and @comment and @preamble are skipped.
+func NewBibTeXDecoder(r io.Reader) Decoder {
+	macros := make(map[string]string)
+	for i, month := range bibtexMonths {
+		macros[month] = time.Month(i + 1).String()
+	}
+	return &bibtexDecoder{r: bufio.NewReader(r), macros: macros}
+}
+
+type bibtexDecoder struct {
+	r      *bufio.Reader
+	macros map[string]string
+}
+
+// bibtexEntry is an entry as written, with its fields in order
+type bibtexEntry struct {
+	kind   string
+	key    string
+	fields []bibtexField
+}
+
+type bibtexField struct {
+	name  string
+	value string
+}
+
+func (d *bibtexDecoder) Decode() (*models.Book, error) {
+	for {
+		// Text outside entries is a comment.
+		if err := d.skipTo('@'); err != nil {
+			return nil, err
+		}
+		entry, err := d.entry()
+		if errors.Is(err, io.EOF) {
+			return nil, &RecordError{Err: errors.New("the last entry is not closed")}
+		}
+		if err != nil {
+			return nil, &RecordError{Err: err}
+		}
+		if entry != nil {
+			return bibtexBook(entry), nil
+		}
+	}
+}
+
+// entry reads what follows an @: an entry, or nil for @comment, @preamble
+// and @string
+func (d *bibtexDecoder) entry() (*bibtexEntry, error) {
+	kind, err := d.name()
+	if err != nil {
+		return nil, err
+	}
+	kind = strings.ToLower(kind)
+	open, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	closing := map[rune]rune{'{': '}', '(': ')'}[open]
+	if closing == 0 && kind == "comment" {
+		// An @comment without braces runs to the end of the line.
+		_, err := d.until("\n")
+		return nil, err
+	}
+	if closing == 0 {
+		return nil, fmt.Errorf("@%s: expected { or (, got %q", kind, open)
+	}
+
+	switch kind {
+	case "comment":
+		_, err := d.braced(closing)
+		return nil, err
+	case "preamble":
+		if _, err := d.value(); err != nil {
+			return nil, fmt.Errorf("@preamble: %w", err)
+		}
+		return nil, d.expect(closing)
+	case "string":
+		name, err := d.name()
+		if err != nil {
+			return nil, fmt.Errorf("@string: %w", err)
+		}
+		if err := d.expect('='); err != nil {
+			return nil, fmt.Errorf("@string %s: %w", name, err)
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, fmt.Errorf("@string %s: %w", name, err)
+		}
+		d.macros[strings.ToLower(name)] = value
+		return nil, d.expect(closing)
+	}
+
+	entry := &bibtexEntry{kind: kind}
+	key, err := d.until(",", string(closing))
+	if err != nil {
+		return nil, err
+	}
+	entry.key = strings.TrimSpace(key)
+	for {
+		// Fields are separated by commas, and the last may have one too.
+		r, err := d.nextNonSpace()
+		if err != nil {
+			return nil, err
+		}
+		if r == closing {
+			return entry, nil
+		}
+		if r == ',' {
+			if r, err = d.nextNonSpace(); err != nil {
+				return nil, err
+			}
+			if r == closing {
+				return entry, nil
+			}
+		} else if len(entry.fields) > 0 {
+			return nil, fmt.Errorf("@%s{%s: field %s: expected ',' or '%c', got %q", kind, entry.key, entry.fields[len(entry.fields)-1].name, closing, r)
+		}
+		d.r.UnreadRune()
+
+		name, err := d.name()
+		if err != nil {
+			return nil, fmt.Errorf("@%s{%s: %w", kind, entry.key, err)
+		}
+		if err := d.expect('='); err != nil {
+			return nil, fmt.Errorf("@%s{%s: field %s: %w", kind, entry.key, name, err)
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, fmt.Errorf("@%s{%s: field %s: %w", kind, entry.key, name, err)
+		}
+		entry.fields = append(entry.fields, bibtexField{name: strings.ToLower(name), value: value})
+	}
+}
+
+// value reads a field value: braced or quoted text, a number or a macro,
+// or several of them joined with #
+func (d *bibtexDecoder) value() (string, error) {
+	var b strings.Builder
+	for {
+		r, err := d.nextNonSpace()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case r == '{':
+			text, err := d.braced('}')
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(text)
+		case r == '"':
+			text, err := d.quoted()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(text)
+		case unicode.IsDigit(r):
+			d.r.UnreadRune()
+			number, err := d.name()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(number)
+		default:
+			d.r.UnreadRune()
+			name, err := d.name()
+			if err != nil {
+				return "", err
+			}
+			value, ok := d.macros[strings.ToLower(name)]
+			if !ok {
+				return "", fmt.Errorf("undefined macro %q", name)
+			}
+			b.WriteString(value)
+		}
+
+		r, err = d.nextNonSpace()
+		if err != nil {
+			return "", err
+		}
+		if r != '#' {
+			d.r.UnreadRune()
+			return collapseSpace(b.String()), nil
+		}
+	}
+}
+
+// braced reads up to the closing rune that balances an opening brace or
+// parenthesis already read, and returns the text in between
+func (d *bibtexDecoder) braced(closing rune) (string, error) {
+	var b strings.Builder
+	depth := 0
+	for {
+		r, err := d.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case r == closing && depth == 0:
+			return b.String(), nil
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		}
+		b.WriteRune(r)
+	}
+}
+
+// quoted reads up to the closing quote of a quoted value; quotes inside
+// braces are part of the value
+func (d *bibtexDecoder) quoted() (string, error) {
+	var b strings.Builder
+	depth := 0
+	for {
+		r, err := d.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case r == '"' && depth == 0:
+			return b.String(), nil
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		}
+		b.WriteRune(r)
+	}
+}
+
+// name reads an identifier: a field, macro or entry type name, or a number
+func (d *bibtexDecoder) name() (string, error) {
+	if _, err := d.nextNonSpace(); err != nil {
+		return "", err
+	}
+	d.r.UnreadRune()
+	var b strings.Builder
+	for {
+		r, err := d.next()
+		if err == io.EOF && b.Len() > 0 {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if unicode.IsSpace(r) || strings.ContainsRune(`{}()",=#%'`, r) {
+			d.r.UnreadRune()
+			if b.Len() == 0 {
+				return "", fmt.Errorf("expected a name, got %q", r)
+			}
+			return b.String(), nil
+		}
+		b.WriteRune(r)
+	}
+}
+
+// until reads up to, but not including, the first of some runes
+func (d *bibtexDecoder) until(stops ...string) (string, error) {
+	var b strings.Builder
+	for {
+		r, err := d.next()
+		if err != nil {
+			return "", err
+		}
+		for _, stop := range stops {
+			if strings.ContainsRune(stop, r) {
+				d.r.UnreadRune()
+				return b.String(), nil
+			}
+		}
+		b.WriteRune(r)
+	}
+}
+
+func (d *bibtexDecoder) expect(want rune) error {
+	r, err := d.nextNonSpace()
+	if err != nil {
+		return err
+	}
+	if r != want {
+		return fmt.Errorf("expected %q, got %q", want, r)
+	}
+	return nil
+}
+
+func (d *bibtexDecoder) skipTo(want rune) error {
+	for {
+		r, err := d.next()
+		if err != nil {
+			return err
+		}
+		if r == want {
+			return nil
+		}
+	}
+}
+
+func (d *bibtexDecoder) nextNonSpace() (rune, error) {
+	for {
+		r, err := d.next()
+		if err != nil || !unicode.IsSpace(r) {
+			return r, err
+		}
+	}
+}
+
+func (d *bibtexDecoder) next() (rune, error) {
+	r, _, err := d.r.ReadRune()
+	return r, err
+}
+
+// bibtexAnd splits an author list on "and" outside braces
+var bibtexAnd = regexp.MustCompile(`(?i)\s+and\s+`)
+
+// bibtexBook converts an entry to a book. The fields a book models are
+// title, author, isbn, publisher, abstract (the description), the date, or
+// else the year and month, pagetotal, or else pages if it is a page count
+// rather than a range, and language. LaTeX in them is converted to text.
+// Only the first of each field is read into the book; everything else is
+// kept in Book.Extra.
+func bibtexBook(entry *bibtexEntry) *models.Book {
+	book := new(models.Book)
+	if entry.kind != "book" {
+		addExtra(book, bibtexTypeKey, entry.kind)
+	}
+	if entry.key != "" {
+		addExtra(book, bibtexCitationKey, entry.key)
+	}
+
+	first := make(map[string]string)
+	for _, f := range entry.fields {
+		if _, ok := first[f.name]; !ok {
+			first[f.name] = f.value
+		}
+	}
+	modelled := make(map[string]bool)
+	use := func(name string, set func(value string) bool) {
+		if value, ok := first[name]; ok && set(value) {
+			modelled[name] = true
+		}
+	}
+
+	use("title", func(value string) bool {
+		book.Title = latexText(value)
+		return book.Title != ""
+	})
+	use("author", func(value string) bool {
+		var names []string
+		for _, name := range splitBraced(value, bibtexAnd) {
+			if name = invertName(latexText(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+		book.Author = strings.Join(names, ", ")
+		return book.Author != ""
+	})
+	use("isbn", func(value string) bool {
+		book.ISBN = latexText(value)
+		return book.ISBN != ""
+	})
+	use("publisher", func(value string) bool {
+		book.Publisher = latexText(value)
+		return book.Publisher != ""
+	})
+	use("abstract", func(value string) bool {
+		book.Description = latexText(value)
+		return book.Description != ""
+	})
+	use("language", func(value string) bool {
+		book.Language = languageName(latexText(value))
+		return book.Language != ""
+	})
+	setPages := func(value string) bool {
+		pages, err := strconv.Atoi(latexText(value))
+		if err != nil || pages < 0 {
+			return false
+		}
+		book.Pages = pages
+		return true
+	}
+	use("pagetotal", setPages)
+	if !modelled["pagetotal"] {
+		use("pages", setPages)
+	}
+	use("date", func(value string) bool {
+		value = latexText(value)
+		for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				book.PublishDate = t
+				return true
+			}
+		}
+		return false
+	})
+	if modelled["date"] {
+		// year and month say the same as date
+		modelled["year"], modelled["month"] = first["year"] != "", first["month"] != ""
+	} else {
+		use("year", func(value string) bool {
+			year, err := strconv.Atoi(latexText(value))
+			if err != nil || year <= 0 {
+				return false
+			}
+			month := time.January
+			use("month", func(value string) bool {
+				if m := bibtexMonth(latexText(value)); m != 0 {
+					month = m
+					return true
+				}
+				return false
+			})
+			book.PublishDate = time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+			return true
+		})
+	}
+
+	read := make(map[string]bool)
+	for _, f := range entry.fields {
+		if modelled[f.name] && !read[f.name] {
+			read[f.name] = true
+			continue
+		}
+		addExtra(book, bibtexPrefix+f.name, f.value)
+	}
+	return book
+}
+
+// bibtexMonth reads a month written as a name, an abbreviation or a number
+func bibtexMonth(value string) time.Month {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 && n <= 12 {
+		return time.Month(n)
+	}
+	for i, month := range bibtexMonths {
+		if len(value) >= 3 && strings.HasPrefix(value, month) {
+			return time.Month(i + 1)
+		}
+	}
+	return 0
+}
+
+// splitBraced splits text on a separator that is not inside braces. The
+// separator must not match braces itself.
+func splitBraced(text string, sep *regexp.Regexp) []string {
+	var parts []string
+	start, scanned, depth := 0, 0, 0
+	for _, loc := range sep.FindAllStringIndex(text, -1) {
+		depth += strings.Count(text[scanned:loc[0]], "{") - strings.Count(text[scanned:loc[0]], "}")
+		scanned = loc[1]
+		if depth == 0 {
+			parts = append(parts, text[start:loc[0]])
+			start = loc[1]
+		}
+	}
+	return append(parts, text[start:])
+}
+
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// The LaTeX a .bib file commonly has in names and titles: accents over a
+// letter, written \'e or \'{e}, and letters and symbols of their own.
+// Accents are listed as pairs of the letter and the accented letter.
+var (
+	latexAccents = map[rune]string{
+		'\'': "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚYÝCĆNŃSŚZŹ",
+		'`':  "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+		'^':  "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+		'"':  "aäeëiïoöuüyÿAÄEËIÏOÖUÜ",
+		'~':  "aãnñoõAÃNÑOÕ",
+		'=':  "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+		'c':  "cçsşCÇSŞ",
+		'v':  "cčsšzžrřeěnňCČSŠZŽRŘEĚNŇ",
+		'u':  "aăgğAĂGĞ",
+		'H':  "oőuűOŐUŰ",
+		'k':  "aąeęAĄEĘ",
+		'.':  "zżeėZŻEĖ",
+		'r':  "aåuůAÅUŮ",
+	}
+	latexSymbols = map[string]string{
+		"ss": "ß", "o": "ø", "O": "Ø", "aa": "å", "AA": "Å", "ae": "æ", "AE": "Æ",
+		"oe": "œ", "OE": "Œ", "l": "ł", "L": "Ł", "i": "ı", "j": "ȷ",
+		"textbackslash": `\`, "textasciitilde": "~", "textasciicircum": "^",
+		"&": "&", "%": "%", "$": "$", "#": "#", "_": "_", "{": "{", "}": "}",
+	}
+	latexEscaper = strings.NewReplacer(
+		`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`,
+		"$", `\$`, "#", `\#`, "_", `\_`, "~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+	)
+)
+
+// latexText converts a BibTeX value to plain text: accents and escaped
+// symbols become the characters they stand for, other commands such as
+// \emph are dropped with their braces, and ~ is a space
+func latexText(value string) string {
+	runes := []rune(value)
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '{', '}':
+		case '~':
+			b.WriteRune(' ')
+		case '\\':
+			if i+1 == len(runes) {
+				break
+			}
+			command := string(runes[i+1])
+			end := i + 2
+			if unicode.IsLetter(runes[i+1]) {
+				for end < len(runes) && unicode.IsLetter(runes[end]) {
+					end++
+				}
+				command = string(runes[i+1 : end])
+			}
+
+			if accent, ok := latexAccents[runes[i+1]]; ok && (len(command) == 1) {
+				// The accented letter follows, maybe in braces.
+				j := end
+				for j < len(runes) && runes[j] == ' ' && unicode.IsLetter(runes[i+1]) {
+					j++
+				}
+				braced := j < len(runes) && runes[j] == '{'
+				if braced {
+					j++
+				}
+				if j < len(runes) {
+					letter := runes[j]
+					if letter == '\\' && j+1 < len(runes) && (runes[j+1] == 'i' || runes[j+1] == 'j') {
+						letter = runes[j+1]
+						j++
+					}
+					b.WriteRune(accented(accent, letter))
+					i = j
+					if braced && i+1 < len(runes) && runes[i+1] == '}' {
+						i++
+					}
+					continue
+				}
+			}
+
+			b.WriteString(latexSymbols[command])
+			i = end - 1
+			// A space after a command name only ends the name.
+			if unicode.IsLetter(runes[i]) && i+1 < len(runes) && runes[i+1] == ' ' {
+				i++
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return collapseSpace(b.String())
+}
+
+// accented returns a letter with an accent, or the letter if the accent
+// table does not have it
+func accented(pairs string, letter rune) rune {
+	runes := []rune(pairs)
+	for i := 0; i+1 < len(runes); i += 2 {
+		if runes[i] == letter {
+			return runes[i+1]
+		}
+	}
+	return letter
+}
+
+// NewBibTeXEncoder returns an Encoder that writes one entry per book, @book
+// unless Book.Extra has another type. Citation keys are kept from Book.Extra
+// or made from the first author's surname and the year, and a letter is
+// added to any key written before, so every key in the file is unique.
+func NewBibTeXEncoder(w io.Writer) Encoder {
+	return &bibtexEncoder{w: w, keys: make(map[string]bool)}
+}
+
+type bibtexEncoder struct {
+	w    io.Writer
+	keys map[string]bool
+}
+
+func (e *bibtexEncoder) Encode(book *models.Book) error {
+	kind := "book"
+	if kept := book.Extra[bibtexTypeKey]; len(kept) > 0 && kept[0] != "" {
+		kind = kept[0]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "@%s{%s,\n", kind, e.key(book))
+	written := make(map[string]bool)
+	field := func(name, value string) {
+		written[name] = true
+		fmt.Fprintf(&b, "  %s = %s,\n", name, value)
+	}
+
+	if book.Title != "" {
+		field("title", "{"+latexEscaper.Replace(book.Title)+"}")
+	}
+	if authors := splitAuthors(book.Author); len(authors) > 0 {
+		for i := range authors {
+			authors[i] = latexEscaper.Replace(authors[i])
+		}
+		field("author", "{"+strings.Join(authors, " and ")+"}")
+	}
+	if book.Publisher != "" {
+		field("publisher", "{"+latexEscaper.Replace(book.Publisher)+"}")
+	}
+	if !book.PublishDate.IsZero() {
+		field("year", "{"+strconv.Itoa(book.PublishDate.Year())+"}")
+		if date := book.PublishDate; date.Month() != time.January || date.Day() != 1 {
+			field("month", bibtexMonths[date.Month()-1])
+		}
+	}
+	if book.ISBN != "" {
+		field("isbn", "{"+latexEscaper.Replace(book.ISBN)+"}")
+	}
+	if book.Pages > 0 {
+		field("pagetotal", "{"+strconv.Itoa(book.Pages)+"}")
+	}
+	if book.Language != "" {
+		field("language", "{"+latexEscaper.Replace(babelName(book.Language))+"}")
+	}
+	if book.Description != "" {
+		field("abstract", "{"+latexEscaper.Replace(book.Description)+"}")
+	}
+
+	for _, name := range extraKeys(book, bibtexPrefix) {
+		if strings.HasPrefix(name, "@") || written[name] || !validBibTeXName(name) {
+			continue
+		}
+		for _, value := range book.Extra[bibtexPrefix+name] {
+			if balancedBraces(value) {
+				fmt.Fprintf(&b, "  %s = {%s},\n", name, value)
+			}
+		}
+	}
+	b.WriteString("}\n\n")
+
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *bibtexEncoder) Close() error {
+	return nil
+}
+
+// key returns the citation key of a book, unique in the file
+func (e *bibtexEncoder) key(book *models.Book) string {
+	key := ""
+	if kept := book.Extra[bibtexCitationKey]; len(kept) > 0 {
+		key = strings.Map(keyRune, kept[0])
+	}
+	if key == "" {
+		if authors := splitAuthors(book.Author); len(authors) > 0 {
+			names := strings.Fields(authors[0])
+			key = strings.ToLower(strings.Map(keyRune, names[len(names)-1]))
+		}
+		if !book.PublishDate.IsZero() {
+			key += strconv.Itoa(book.PublishDate.Year())
+		}
+	}
+	if key == "" {
+		key = "book"
+	}
+
+	unique := key
+	for n := 0; e.keys[unique]; n++ {
+		if n < 26 {
+			unique = key + string(rune('a'+n))
+		} else {
+			unique = key + "-" + strconv.Itoa(n+1)
+		}
+	}
+	e.keys[unique] = true
+	return unique
+}
+
+// keyRune drops the runes BibTeX does not allow in a citation key
+func keyRune(r rune) rune {
+	if unicode.IsSpace(r) || strings.ContainsRune(`{}(),="#%'\~`, r) {
+		return -1
+	}
+	return r
+}
+
+func validBibTeXName(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`{}(),="#%'`, r)
+	})
+}
+
+func balancedBraces(value string) bool {
+	depth := 0
+	for _, r := range value {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth < 0 {
+			return false
+		}
+	}
+	return depth == 0
+}
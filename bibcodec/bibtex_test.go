@@ -0,0 +1,125 @@
+package bibcodec
+
+import (
+	"bytes"
+	"go-elastic/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bibFile = `% Exported from a reference manager
+@preamble{"\newcommand{\noopsort}[1]{}"}
+@string{aw = "Addison-Wesley"}
+@comment{ignored @book{nope, title = {Not a book}} }
+
+@Book{knuth1997,
+  Author    = {Knuth, Donald E.},
+  Title     = {The Art of Computer Programming: {Volume} 1},
+  publisher = aw # " Professional",
+  year      = 1997,
+  month     = jul,
+  isbn      = {0-201-89683-4},
+  pages     = {xx+650},
+  language  = {american},
+  keywords  = {algorithms, {TeX}},
+}
+
+@book{broken,
+  title = {Missing the comma}
+  year = 2000,
+}
+
+@incollection(gödel,
+  author = "G{\"o}del, Kurt and {Barnes and Noble} and Jos\'{e} Mart\'\i",
+  title = "{\"U}ber formal unentscheidbare S{\"a}tze \& \emph{mehr}",
+  date = {1931-03-15},
+  year = {1931},
+  pagetotal = 25,
+)
+`
+
+func TestBibTeXDecoder(t *testing.T) {
+	books, recordErrs := decodeAll(t, NewBibTeXDecoder(strings.NewReader(bibFile)))
+	require.Len(t, books, 2)
+	require.Len(t, recordErrs, 1)
+	assert.ErrorContains(t, recordErrs[0], `@book{broken: field title: expected ',' or '}'`)
+
+	knuth := books[0]
+	assert.Equal(t, "The Art of Computer Programming: Volume 1", knuth.Title)
+	assert.Equal(t, "Donald E. Knuth", knuth.Author)
+	assert.Equal(t, "Addison-Wesley Professional", knuth.Publisher)
+	assert.Equal(t, time.Date(1997, 7, 1, 0, 0, 0, 0, time.UTC), knuth.PublishDate)
+	assert.Equal(t, "0-201-89683-4", knuth.ISBN)
+	assert.Equal(t, 0, knuth.Pages, "a page range is not a page count")
+	assert.Equal(t, "English", knuth.Language)
+	assert.Equal(t, map[string][]string{
+		"bibtex:@key":     {"knuth1997"},
+		"bibtex:pages":    {"xx+650"},
+		"bibtex:keywords": {"algorithms, {TeX}"},
+	}, knuth.Extra)
+
+	godel := books[1]
+	assert.Equal(t, "Über formal unentscheidbare Sätze & mehr", godel.Title)
+	assert.Equal(t, "Kurt Gödel, Barnes and Noble, José Martí", godel.Author)
+	assert.Equal(t, time.Date(1931, 3, 15, 0, 0, 0, 0, time.UTC), godel.PublishDate)
+	assert.Equal(t, 25, godel.Pages)
+	assert.Equal(t, map[string][]string{
+		"bibtex:@type": {"incollection"},
+		"bibtex:@key":  {"gödel"},
+	}, godel.Extra, "year says the same as date")
+}
+
+func TestBibTeXDecoder_UndefinedMacro(t *testing.T) {
+	books, recordErrs := decodeAll(t, NewBibTeXDecoder(strings.NewReader("@book{a, publisher = acm}\n@book{b, title = {B}}")))
+	require.Len(t, recordErrs, 1)
+	assert.ErrorContains(t, recordErrs[0], `undefined macro "acm"`)
+	require.Len(t, books, 1)
+	assert.Equal(t, "B", books[0].Title)
+}
+
+func TestBibTeXEncoder(t *testing.T) {
+	books := []*models.Book{
+		{Title: "100% {Pure} C#", Author: "Brian W. Kernighan and Dennis M. Ritchie", PublishDate: time.Date(1988, 4, 1, 0, 0, 0, 0, time.UTC), Language: "English"},
+		{Title: "Second", Author: "Dennis Ritchie", PublishDate: time.Date(1988, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Title: "Kept", Extra: map[string][]string{"bibtex:@type": {"manual"}, "bibtex:@key": {"ritchie1988"}, "bibtex:url": {`https://example.com/\~dmr`}}},
+		{Title: "Anonymous"},
+	}
+
+	var out bytes.Buffer
+	data := encodeAll(t, NewBibTeXEncoder(&out), &out, books...)
+	assert.Equal(t, `@book{kernighan1988,
+  title = {100\% \{Pure\} C\#},
+  author = {Brian W. Kernighan and Dennis M. Ritchie},
+  year = {1988},
+  month = apr,
+  language = {english},
+}
+
+@book{ritchie1988,
+  title = {Second},
+  author = {Dennis Ritchie},
+  year = {1988},
+}
+
+@manual{ritchie1988a,
+  title = {Kept},
+  url = {https://example.com/\~dmr},
+}
+
+@book{book,
+  title = {Anonymous},
+}
+
+`, data)
+
+	again, recordErrs := decodeAll(t, NewBibTeXDecoder(strings.NewReader(data)))
+	require.Empty(t, recordErrs)
+	require.Len(t, again, len(books))
+	assert.Equal(t, books[0].Title, again[0].Title)
+	assert.Equal(t, "Brian W. Kernighan, Dennis M. Ritchie", again[0].Author)
+	assert.Equal(t, []string{`https://example.com/\~dmr`}, again[2].Extra["bibtex:url"])
+}
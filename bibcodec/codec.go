@@ -0,0 +1,104 @@
+// Package bibcodec converts books to and from the bibliographic record
+// formats that libraries, publishers and researchers exchange: MARC21, as
+// binary ISO 2709 records and as MARCXML, ONIX for Books 3.0 and BibTeX.
+//
+// Each format maps its own fields to title, author, ISBN, description,
+// publisher, publish date, pages and language. Every other field of a record
+// is kept in Book.Extra under a key naming the format, such as "marc:650",
+// "onix:DescriptiveDetail/Subject" or "bibtex:keywords", and encoding the
+// book in the same format writes it back unchanged. The fields a book models
+// are rebuilt from the book, so an edit to the book shows up in the export.
+//
+// Records are read and written one at a time, so files can be larger than
+// memory.
+package bibcodec
+
+import (
+	"go-elastic/models"
+	"slices"
+	"strings"
+)
+
+// Decoder reads books one record at a time
+type Decoder interface {
+	// Decode returns the book in the next record, or io.EOF after the last
+	// one. A *RecordError means that record is not a book and decoding can
+	// go on; any other error means the rest of the input cannot be read.
+	Decode() (*models.Book, error)
+}
+
+// Encoder writes books one record at a time
+type Encoder interface {
+	Encode(book *models.Book) error
+	// Close writes whatever ends the file, such as the closing tag of an
+	// XML document, and flushes it. It does not close the underlying
+	// io.Writer.
+	Close() error
+}
+
+// RecordError is returned by Decode for a record that cannot be converted
+// to a book
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// addExtra appends a value to a key of book.Extra
+func addExtra(book *models.Book, key, value string) {
+	if book.Extra == nil {
+		book.Extra = make(map[string][]string)
+	}
+	book.Extra[key] = append(book.Extra[key], value)
+}
+
+// extraKeys returns the keys of book.Extra with a format prefix, such as
+// "marc:", without it, in their sorted order
+func extraKeys(book *models.Book, prefix string) []string {
+	var keys []string
+	for key := range book.Extra {
+		if name, ok := strings.CutPrefix(key, prefix); ok {
+			keys = append(keys, name)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// splitAuthors splits a comma or "and" separated author list into names,
+// the way the search index does
+func splitAuthors(author string) []string {
+	var names []string
+	for _, part := range strings.Split(author, ",") {
+		for _, name := range strings.Split(part, " and ") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// invertName turns a "Surname, Forenames" heading, or BibTeX's "Surname,
+// Jr, Forenames", into "Forenames Surname", which is how Book.Author is
+// written: a comma in it separates authors.
+func invertName(name string) string {
+	parts := strings.Split(name, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	switch {
+	case len(parts) == 2 && parts[1] != "":
+		return parts[1] + " " + parts[0]
+	case len(parts) == 3 && parts[2] != "":
+		return strings.TrimSpace(parts[2] + " " + parts[0] + " " + parts[1])
+	default:
+		return strings.Join(parts, " ")
+	}
+}
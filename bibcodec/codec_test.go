@@ -0,0 +1,140 @@
+package bibcodec
+
+import (
+	"bytes"
+	"errors"
+	"go-elastic/models"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codec struct {
+	name       string
+	newDecoder func(io.Reader) Decoder
+	newEncoder func(io.Writer) Encoder
+}
+
+var codecs = []codec{
+	{"marc", NewMARCDecoder, NewMARCEncoder},
+	{"marcxml", NewMARCXMLDecoder, NewMARCXMLEncoder},
+	{"onix", NewONIXDecoder, NewONIXEncoder},
+	{"bibtex", NewBibTeXDecoder, NewBibTeXEncoder},
+}
+
+// decodeAll returns the books in a file and the record errors in between
+func decodeAll(t *testing.T, dec Decoder) ([]*models.Book, []error) {
+	t.Helper()
+	var books []*models.Book
+	var recordErrs []error
+	for {
+		book, err := dec.Decode()
+		if err == io.EOF {
+			return books, recordErrs
+		}
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			recordErrs = append(recordErrs, err)
+			continue
+		}
+		require.NoError(t, err)
+		books = append(books, book)
+	}
+}
+
+func encodeAll(t *testing.T, enc Encoder, out *bytes.Buffer, books ...*models.Book) string {
+	t.Helper()
+	for _, book := range books {
+		require.NoError(t, enc.Encode(book))
+	}
+	require.NoError(t, enc.Close())
+	return out.String()
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	books := []*models.Book{
+		{
+			Title:       "The Pragmatic Programmer: From Journeyman to Master",
+			Author:      "Andrew Hunt, David Thomas",
+			ISBN:        "9780201616224",
+			Description: "Tips & traps of 100% practical {software} craft",
+			Publisher:   "Addison-Wesley",
+			PublishDate: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+			Pages:       352,
+			Language:    "English",
+		},
+		{Title: "ノルウェイの森", Author: "村上春樹", Language: "Japanese"},
+	}
+
+	for _, c := range codecs {
+		var out bytes.Buffer
+		data := encodeAll(t, c.newEncoder(&out), &out, books...)
+		decoded, recordErrs := decodeAll(t, c.newDecoder(strings.NewReader(data)))
+		require.Empty(t, recordErrs, c.name)
+		require.Len(t, decoded, len(books), c.name)
+		for i, book := range decoded {
+			// What a format adds of its own, such as a MARC leader or a
+			// BibTeX key, is in Extra.
+			book.Extra = nil
+			assert.Equal(t, books[i], book, c.name)
+		}
+	}
+}
+
+func TestCodecs_KeepExtra(t *testing.T) {
+	files := map[string]string{
+		"marcxml": `<?xml version="1.0"?>
+<collection xmlns="http://www.loc.gov/MARC21/slim">
+  <record>
+    <leader>00000cam a2200000 a 4500</leader>
+    <controlfield tag="001">ocm123</controlfield>
+    <controlfield tag="008">850101s1965    nyu           000 1 eng d</controlfield>
+    <datafield tag="245" ind1="1" ind2="0"><subfield code="a">Dune /</subfield><subfield code="c">Frank Herbert.</subfield></datafield>
+    <datafield tag="650" ind1=" " ind2="0"><subfield code="a">Science fiction.</subfield></datafield>
+    <datafield tag="650" ind1=" " ind2="0"><subfield code="a">Costs in {US} $.</subfield></datafield>
+  </record>
+</collection>`,
+		"onix": `<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+  <Product>
+    <RecordReference>com.example.1</RecordReference>
+    <NotificationType>03</NotificationType>
+    <DescriptiveDetail>
+      <ProductComposition>00</ProductComposition>
+      <ProductForm>BC</ProductForm>
+      <TitleDetail><TitleType>01</TitleType><TitleElement><TitleElementLevel>01</TitleElementLevel><TitleText>Dune</TitleText></TitleElement></TitleDetail>
+      <Subject><SubjectSchemeIdentifier>10</SubjectSchemeIdentifier><SubjectCode>FIC028000</SubjectCode></Subject>
+    </DescriptiveDetail>
+    <ProductSupply><Market><Territory><RegionsIncluded>WORLD</RegionsIncluded></Territory></Market></ProductSupply>
+  </Product>
+</ONIXMessage>`,
+		"bibtex": `@misc{herbert65,
+  title = {Dune},
+  keywords = {sci-fi, {Arrakis} \& spice},
+  note = "first" # " edition",
+}`,
+	}
+
+	for _, c := range codecs {
+		file, ok := files[c.name]
+		if !ok {
+			continue
+		}
+		books, recordErrs := decodeAll(t, c.newDecoder(strings.NewReader(file)))
+		require.Empty(t, recordErrs, c.name)
+		require.Len(t, books, 1, c.name)
+		assert.Equal(t, "Dune", books[0].Title, c.name)
+		assert.NotEmpty(t, books[0].Extra, c.name)
+
+		// Writing the book and reading it back gives the same book.
+		var out bytes.Buffer
+		data := encodeAll(t, c.newEncoder(&out), &out, books[0])
+		again, recordErrs := decodeAll(t, c.newDecoder(strings.NewReader(data)))
+		require.Empty(t, recordErrs, c.name)
+		require.Len(t, again, 1, c.name)
+		assert.Equal(t, books[0], again[0], c.name)
+	}
+}
@@ -0,0 +1,208 @@
+package bibcodec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"io"
+	"unicode/utf8"
+)
+
+// ISO 2709 separators
+const (
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+)
+
+// Limits set by the lengths in the leader and directory
+const (
+	maxMARCRecordLength = 99999
+	maxMARCFieldLength  = 9999
+)
+
+// NewMARCDecoder returns a Decoder for binary MARC21 (ISO 2709) records.
+// Records must be in UTF-8 (leader/09 "a"); MARC-8 records are only read if
+// they are plain ASCII.
+func NewMARCDecoder(r io.Reader) Decoder {
+	return &marcDecoder{r: bufio.NewReader(r)}
+}
+
+type marcDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *marcDecoder) Decode() (*models.Book, error) {
+	data, err := d.r.ReadBytes(recordTerminator)
+	// Some files put a line break between records.
+	data = bytes.TrimLeft(data, "\r\n ")
+	if len(data) == 0 && err != nil {
+		return nil, err
+	}
+	if err == io.EOF {
+		return nil, &RecordError{Err: errors.New("the last record has no record terminator")}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := parseISO2709(data[:len(data)-1])
+	if err != nil {
+		return nil, &RecordError{Err: err}
+	}
+	return marcBook(rec), nil
+}
+
+// parseISO2709 reads a record without its record terminator
+func parseISO2709(data []byte) (marcRecord, error) {
+	if len(data) < 25 {
+		return marcRecord{}, errors.New("record is shorter than a leader")
+	}
+	leader := string(data[:24])
+	base, ok := parseDigits(data[12:17])
+	if !ok || base < 25 || base > len(data) || data[base-1] != fieldTerminator {
+		return marcRecord{}, fmt.Errorf("invalid base address of data %q", leader[12:17])
+	}
+	if leader[9] != 'a' && !isASCII(data) {
+		return marcRecord{}, errors.New("record is MARC-8 encoded; convert it to UTF-8 first")
+	}
+	if !utf8.Valid(data) {
+		return marcRecord{}, errors.New("record is not valid UTF-8")
+	}
+
+	directory := data[24 : base-1]
+	if len(directory)%12 != 0 {
+		return marcRecord{}, errors.New("directory length is not a multiple of 12")
+	}
+	rec := marcRecord{leader: leader}
+	for i := 0; i < len(directory); i += 12 {
+		entry := directory[i : i+12]
+		tag := string(entry[:3])
+		length, ok1 := parseDigits(entry[3:7])
+		start, ok2 := parseDigits(entry[7:12])
+		if !ok1 || !ok2 || length < 1 || start < 0 || base+start+length > len(data) {
+			return marcRecord{}, fmt.Errorf("invalid directory entry %q", entry)
+		}
+		field, err := parseISO2709Field(tag, data[base+start:base+start+length])
+		if err != nil {
+			return marcRecord{}, err
+		}
+		rec.fields = append(rec.fields, field)
+	}
+	return rec, nil
+}
+
+// parseDigits reads a fixed-width number of the leader or directory. Unlike
+// strconv.Atoi it takes no sign, which would let a field start before the
+// data.
+func parseDigits(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, len(b) > 0
+}
+
+func parseISO2709Field(tag string, data []byte) (marcField, error) {
+	data = bytes.TrimSuffix(data, []byte{fieldTerminator})
+	if isControlTag(tag) {
+		return marcField{tag: tag, value: string(data)}, nil
+	}
+	if len(data) < 2 {
+		return marcField{}, fmt.Errorf("field %s has no indicators", tag)
+	}
+
+	f := marcField{tag: tag, ind1: data[0], ind2: data[1]}
+	parts := bytes.Split(data[2:], []byte{subfieldDelimiter})
+	// Anything before the first delimiter is not in a subfield.
+	for _, part := range parts[1:] {
+		if len(part) == 0 {
+			continue
+		}
+		f.subfields = append(f.subfields, marcSubfield{code: part[0], value: string(part[1:])})
+	}
+	return f, nil
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// NewMARCEncoder returns an Encoder that writes binary MARC21 (ISO 2709)
+// records in UTF-8
+func NewMARCEncoder(w io.Writer) Encoder {
+	return &marcEncoder{w: w}
+}
+
+type marcEncoder struct {
+	w io.Writer
+}
+
+func (e *marcEncoder) Encode(book *models.Book) error {
+	data, err := marcRecordOf(book).iso2709()
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *marcEncoder) Close() error {
+	return nil
+}
+
+// iso2709 returns a record in binary form, with the lengths and addresses
+// in its leader filled in
+func (r marcRecord) iso2709() ([]byte, error) {
+	var directory, fields bytes.Buffer
+	for _, f := range r.fields {
+		start := fields.Len()
+		if isControlTag(f.tag) {
+			fields.WriteString(f.value)
+		} else {
+			fields.WriteByte(f.ind1)
+			fields.WriteByte(f.ind2)
+			for _, sf := range f.subfields {
+				fields.WriteByte(subfieldDelimiter)
+				fields.WriteByte(sf.code)
+				fields.WriteString(sf.value)
+			}
+		}
+		fields.WriteByte(fieldTerminator)
+
+		length := fields.Len() - start
+		if length > maxMARCFieldLength {
+			return nil, fmt.Errorf("MARC field %s is longer than %d bytes", f.tag, maxMARCFieldLength)
+		}
+		fmt.Fprintf(&directory, "%s%04d%05d", f.tag, length, start)
+	}
+	directory.WriteByte(fieldTerminator)
+
+	base := len(defaultMARCLeader) + directory.Len()
+	total := base + fields.Len() + 1
+	if total > maxMARCRecordLength {
+		return nil, fmt.Errorf("MARC record is longer than %d bytes", maxMARCRecordLength)
+	}
+
+	leader := []byte(r.leader)
+	copy(leader[0:5], fmt.Sprintf("%05d", total))
+	copy(leader[9:12], "a22")
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+
+	out := make([]byte, 0, total)
+	out = append(out, leader...)
+	out = append(out, directory.Bytes()...)
+	out = append(out, fields.Bytes()...)
+	return append(out, recordTerminator), nil
+}
@@ -0,0 +1,88 @@
+package bibcodec
+
+import "strings"
+
+// language is one language as each format writes it
+type language struct {
+	// name is how Book.Language has it
+	name string
+	// code is the ISO 639-2 bibliographic code, which MARC and ONIX use
+	code string
+	// short is the ISO 639-1 code
+	short string
+	// babel is the name BibTeX and biblatex use
+	babel string
+	// also are other codes read as the language, such as the ISO 639-2
+	// terminology code
+	also []string
+}
+
+var languages = []language{
+	{name: "English", code: "eng", short: "en", babel: "english", also: []string{"american", "british"}},
+	{name: "Japanese", code: "jpn", short: "ja", babel: "japanese"},
+	{name: "Thai", code: "tha", short: "th", babel: "thai"},
+	{name: "Chinese", code: "chi", short: "zh", babel: "chinese", also: []string{"zho"}},
+	{name: "Korean", code: "kor", short: "ko", babel: "korean"},
+	{name: "French", code: "fre", short: "fr", babel: "french", also: []string{"fra"}},
+	{name: "German", code: "ger", short: "de", babel: "german", also: []string{"deu", "ngerman"}},
+	{name: "Spanish", code: "spa", short: "es", babel: "spanish"},
+	{name: "Italian", code: "ita", short: "it", babel: "italian"},
+	{name: "Portuguese", code: "por", short: "pt", babel: "portuguese"},
+	{name: "Dutch", code: "dut", short: "nl", babel: "dutch", also: []string{"nld"}},
+	{name: "Russian", code: "rus", short: "ru", babel: "russian"},
+	{name: "Greek", code: "gre", short: "el", babel: "greek", also: []string{"ell"}},
+	{name: "Latin", code: "lat", short: "la", babel: "latin"},
+	{name: "Arabic", code: "ara", short: "ar", babel: "arabic"},
+	{name: "Hindi", code: "hin", short: "hi", babel: "hindi"},
+	{name: "Vietnamese", code: "vie", short: "vi", babel: "vietnamese"},
+	{name: "Swedish", code: "swe", short: "sv", babel: "swedish"},
+	{name: "Polish", code: "pol", short: "pl", babel: "polish"},
+}
+
+// findLanguage looks a language up by any of its names or codes,
+// case-insensitively
+func findLanguage(value string) (language, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, lang := range languages {
+		if value == strings.ToLower(lang.name) || value == lang.code || value == lang.short || value == lang.babel {
+			return lang, true
+		}
+		for _, other := range lang.also {
+			if value == other {
+				return lang, true
+			}
+		}
+	}
+	return language{}, false
+}
+
+// languageName returns the Book.Language value for a language code or name
+// read from a record. Unknown values are kept as they are.
+func languageName(value string) string {
+	if lang, ok := findLanguage(value); ok {
+		return lang.name
+	}
+	return strings.TrimSpace(value)
+}
+
+// languageCode returns the ISO 639-2 code of a Book.Language value. A value
+// that is not a known language but looks like a code is used as it is;
+// otherwise the code is "".
+func languageCode(value string) string {
+	if lang, ok := findLanguage(value); ok {
+		return lang.code
+	}
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) == 3 && strings.Trim(value, "abcdefghijklmnopqrstuvwxyz") == "" {
+		return value
+	}
+	return ""
+}
+
+// babelName returns the BibTeX name of a Book.Language value
+func babelName(value string) string {
+	if lang, ok := findLanguage(value); ok {
+		return lang.babel
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
@@ -0,0 +1,421 @@
+package bibcodec
+
+import (
+	"fmt"
+	"go-elastic/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// marcPrefix starts the Book.Extra keys of MARC fields, which are the tag,
+// as in "marc:650". The leader is kept under "marc:leader".
+const marcPrefix = "marc:"
+
+// marcLeaderKey is the Book.Extra key of the MARC leader
+const marcLeaderKey = marcPrefix + "leader"
+
+// defaultMARCLeader is the leader of a record for a book without one: a new
+// record of printed language material, a monograph, in Unicode, with ISBD
+// punctuation. Lengths are filled in when the record is written.
+const defaultMARCLeader = "00000nam a2200000 i 4500"
+
+// marcRecord is a MARC21 bibliographic record
+type marcRecord struct {
+	leader string
+	fields []marcField
+}
+
+// marcField is a control field (tags 001 to 009), which only has a value,
+// or a data field, which has two indicators and subfields
+type marcField struct {
+	tag        string
+	value      string
+	ind1, ind2 byte
+	subfields  []marcSubfield
+}
+
+type marcSubfield struct {
+	code  byte
+	value string
+}
+
+func isControlTag(tag string) bool {
+	return strings.HasPrefix(tag, "00")
+}
+
+// subfield returns the first value of a subfield, or ""
+func (f marcField) subfield(code byte) string {
+	for _, sf := range f.subfields {
+		if sf.code == code {
+			return sf.value
+		}
+	}
+	return ""
+}
+
+// In Book.Extra, a data field is written the way MARCMaker and MarcEdit
+// write it: the indicators, with \ for a blank, then each subfield as $ and
+// its code. "$", "{" and "}" in values are written {dollar}, {lcub} and
+// {rcub}. A control field is its value.
+var (
+	marcTextEscaper   = strings.NewReplacer("{", "{lcub}", "}", "{rcub}", "$", "{dollar}")
+	marcTextUnescaper = strings.NewReplacer("{lcub}", "{", "{rcub}", "}", "{dollar}", "$")
+)
+
+// text returns a field as it is kept in Book.Extra
+func (f marcField) text() string {
+	if isControlTag(f.tag) {
+		return f.value
+	}
+	var b strings.Builder
+	b.WriteByte(indicatorText(f.ind1))
+	b.WriteByte(indicatorText(f.ind2))
+	for _, sf := range f.subfields {
+		b.WriteByte('$')
+		b.WriteByte(sf.code)
+		b.WriteString(marcTextEscaper.Replace(sf.value))
+	}
+	return b.String()
+}
+
+// parseMARCText reads a field back from Book.Extra
+func parseMARCText(tag, text string) (marcField, bool) {
+	if isControlTag(tag) {
+		return marcField{tag: tag, value: text}, true
+	}
+	if len(text) < 2 {
+		return marcField{}, false
+	}
+	f := marcField{tag: tag, ind1: indicatorFromText(text[0]), ind2: indicatorFromText(text[1])}
+	rest := text[2:]
+	if rest == "" {
+		return f, true
+	}
+	if rest[0] != '$' {
+		return marcField{}, false
+	}
+	for _, part := range strings.Split(rest[1:], "$") {
+		if part == "" {
+			return marcField{}, false
+		}
+		f.subfields = append(f.subfields, marcSubfield{code: part[0], value: marcTextUnescaper.Replace(part[1:])})
+	}
+	return f, true
+}
+
+func indicatorText(ind byte) byte {
+	if ind == ' ' {
+		return '\\'
+	}
+	return ind
+}
+
+func indicatorFromText(ind byte) byte {
+	if ind == '\\' {
+		return ' '
+	}
+	return ind
+}
+
+// validTag reports whether a Book.Extra key names a MARC tag
+func validTag(tag string) bool {
+	if len(tag) != 3 {
+		return false
+	}
+	for i := 0; i < len(tag); i++ {
+		if c := tag[i]; !('0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// The fields a book models. Only the first of each is read into the book;
+// later ones, such as a second ISBN, are kept in Book.Extra.
+//
+//	020 $a  ISBN, without any qualifier such as "(pbk.)"
+//	100 $a  author, or 110 $a for a corporate author
+//	700 $a  further authors: added entries with no relator, or "author"
+//	245 $a  title, with $b, the subtitle, after a colon
+//	264 $b  publisher, from the first 264 with second indicator 1
+//	        (publication), else from the first 260
+//	300 $a  pages, the number before "p." or "pages"
+//	520 $a  description
+//
+// The publish year comes from 008/07-10, else from $c of the publication
+// field, and the language from 008/35-37, else from 041 $a. 008 itself is
+// kept in Book.Extra and its date and language are updated on export.
+var (
+	marcYear  = regexp.MustCompile(`\d{4}`)
+	marcPages = regexp.MustCompile(`(?i)(\d+)\s*(?:p\b|p\.|pages?\b)`)
+)
+
+// trimISBD removes the punctuation ISBD puts at the end of a subfield
+func trimISBD(value string, cutset string) string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(value), cutset))
+}
+
+// headingName returns the name in a heading without its punctuation. A
+// final period is kept after an initial, as in "Tolkien, J. R. R."
+func headingName(value string) string {
+	name := trimISBD(value, ",:;")
+	if before, ok := strings.CutSuffix(name, "."); ok {
+		if i := strings.LastIndexAny(before, " ,."); len(before)-i-1 != 1 {
+			name = before
+		}
+	}
+	return name
+}
+
+// marcBook converts a record to a book
+func marcBook(rec marcRecord) *models.Book {
+	book := new(models.Book)
+	if len(rec.leader) == len(defaultMARCLeader) {
+		// The record length and base address are worked out again when the
+		// record is written.
+		leader := []byte(rec.leader)
+		copy(leader[0:5], "00000")
+		copy(leader[12:17], "00000")
+		addExtra(book, marcLeaderKey, string(leader))
+	}
+
+	publication := -1
+	for i, f := range rec.fields {
+		if f.tag == "264" && f.ind2 == '1' {
+			publication = i
+			break
+		}
+		if f.tag == "260" && publication < 0 {
+			publication = i
+		}
+	}
+
+	var year, lang, langField string
+	var authors []string
+	seen := make(map[string]bool)
+	for i, f := range rec.fields {
+		modelled := false
+		switch f.tag {
+		case "008":
+			year, lang = marc008(f.value)
+		case "041":
+			if langField == "" {
+				langField = f.subfield('a')
+			}
+		case "020":
+			if isbn, _, _ := strings.Cut(strings.TrimSpace(f.subfield('a')), " "); isbn != "" && book.ISBN == "" {
+				book.ISBN = isbn
+				modelled = true
+			}
+		case "100", "110":
+			if name := headingName(f.subfield('a')); name != "" && !seen["1XX"] {
+				seen["1XX"] = true
+				if f.tag == "100" && f.ind1 == '1' {
+					name = invertName(name)
+				}
+				authors = append([]string{name}, authors...)
+				modelled = true
+			}
+		case "700":
+			if name := headingName(f.subfield('a')); name != "" && isAuthor(f) {
+				if f.ind1 == '1' {
+					name = invertName(name)
+				}
+				authors = append(authors, name)
+				modelled = true
+			}
+		case "245":
+			if title := trimISBD(f.subfield('a'), " /:;,=."); title != "" && !seen["245"] {
+				seen["245"] = true
+				book.Title = title
+				if subtitle := trimISBD(f.subfield('b'), " /:;,=."); subtitle != "" {
+					book.Title += ": " + subtitle
+				}
+				modelled = true
+			}
+		case "260", "264":
+			if i == publication {
+				book.Publisher = trimISBD(f.subfield('b'), ",:;")
+				if year == "" {
+					year = marcYear.FindString(f.subfield('c'))
+				}
+				modelled = true
+			}
+		case "300":
+			if m := marcPages.FindStringSubmatch(f.subfield('a')); m != nil && !seen["300"] {
+				seen["300"] = true
+				book.Pages, _ = strconv.Atoi(m[1])
+				modelled = true
+			}
+		case "520":
+			if summary := strings.TrimSpace(f.subfield('a')); summary != "" && !seen["520"] {
+				seen["520"] = true
+				book.Description = summary
+				modelled = true
+			}
+		}
+		if !modelled {
+			addExtra(book, marcPrefix+f.tag, f.text())
+		}
+	}
+
+	book.Author = strings.Join(authors, ", ")
+	if y, err := strconv.Atoi(year); err == nil && y > 0 {
+		book.PublishDate = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if lang == "" {
+		lang = langField
+	}
+	if lang != "" {
+		book.Language = languageName(lang)
+	}
+	return book
+}
+
+// isAuthor reports whether an added entry is for an author: one with no
+// relator, or the relator "author"
+func isAuthor(f marcField) bool {
+	for _, sf := range f.subfields {
+		switch sf.code {
+		case 'e':
+			if trimISBD(sf.value, ",.") != "author" {
+				return false
+			}
+		case '4':
+			if sf.value != "aut" && !strings.HasSuffix(sf.value, "/aut") {
+				return false
+			}
+		case 't':
+			// A name and title entry is for a related work.
+			return false
+		}
+	}
+	return true
+}
+
+// marc008 reads the publish year (Date 1) and language from the fixed-length
+// data elements in 008
+func marc008(value string) (year, lang string) {
+	if len(value) < 38 {
+		return "", ""
+	}
+	if !strings.ContainsRune("bn|", rune(value[6])) && isDigits(value[7:11]) {
+		year = value[7:11]
+	}
+	switch code := value[35:38]; code {
+	case "   ", "|||", "und", "zxx", "mul":
+	default:
+		lang = strings.TrimSpace(code)
+	}
+	return year, lang
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// marcRecordOf converts a book to a record: the fields it models, rebuilt
+// from the book, and the MARC fields in Book.Extra. Fields are in tag order,
+// with a rebuilt field before the kept ones of the same tag. Extra entries
+// that are not valid MARC are left out.
+func marcRecordOf(book *models.Book) marcRecord {
+	rec := marcRecord{leader: defaultMARCLeader}
+	if leaders := book.Extra[marcLeaderKey]; len(leaders) > 0 && len(leaders[0]) == len(defaultMARCLeader) {
+		rec.leader = leaders[0]
+	}
+
+	if len(book.Extra[marcPrefix+"001"]) == 0 && !book.ID.IsZero() {
+		rec.fields = append(rec.fields, marcField{tag: "001", value: book.ID.Hex()})
+	}
+	rec.fields = append(rec.fields, marcField{tag: "008", value: book008(book)})
+
+	dataField := func(tag string, ind1, ind2 byte, subfields ...marcSubfield) {
+		rec.fields = append(rec.fields, marcField{tag: tag, ind1: ind1, ind2: ind2, subfields: subfields})
+	}
+	if book.ISBN != "" {
+		dataField("020", ' ', ' ', marcSubfield{'a', book.ISBN})
+	}
+	authors := splitAuthors(book.Author)
+	for i, name := range authors {
+		// Names are written in direct order, as Book.Author has them.
+		tag := "700"
+		if i == 0 {
+			tag = "100"
+		}
+		dataField(tag, '0', ' ', marcSubfield{'a', name})
+	}
+	if book.Title != "" {
+		ind1 := byte('0')
+		if len(authors) > 0 {
+			ind1 = '1'
+		}
+		dataField("245", ind1, '0', marcSubfield{'a', book.Title})
+	}
+	if book.Publisher != "" || !book.PublishDate.IsZero() {
+		var subfields []marcSubfield
+		if book.Publisher != "" {
+			subfields = append(subfields, marcSubfield{'b', book.Publisher})
+		}
+		if !book.PublishDate.IsZero() {
+			subfields = append(subfields, marcSubfield{'c', strconv.Itoa(book.PublishDate.Year())})
+		}
+		dataField("264", ' ', '1', subfields...)
+	}
+	if book.Pages > 0 {
+		dataField("300", ' ', ' ', marcSubfield{'a', fmt.Sprintf("%d pages", book.Pages)})
+	}
+	if book.Description != "" {
+		dataField("520", ' ', ' ', marcSubfield{'a', book.Description})
+	}
+
+	for _, tag := range extraKeys(book, marcPrefix) {
+		if tag == "008" || !validTag(tag) {
+			continue
+		}
+		for _, text := range book.Extra[marcPrefix+tag] {
+			if f, ok := parseMARCText(tag, text); ok {
+				rec.fields = append(rec.fields, f)
+			}
+		}
+	}
+
+	sort.SliceStable(rec.fields, func(i, j int) bool {
+		return rec.fields[i].tag < rec.fields[j].tag
+	})
+	return rec
+}
+
+// book008 returns the 008 field of a book: the one in Book.Extra with the
+// book's publish year and language, or else a new one with them and the
+// other positions unfilled
+func book008(book *models.Book) string {
+	var field []byte
+	if kept := book.Extra[marcPrefix+"008"]; len(kept) > 0 && len(kept[0]) == 40 {
+		field = []byte(kept[0])
+	} else {
+		entered := "      "
+		if !book.CreatedAt.IsZero() {
+			entered = book.CreatedAt.UTC().Format("060102")
+		}
+		field = []byte(entered + "nuuuu    xx |||||||||||||| ||und d")
+	}
+
+	if !book.PublishDate.IsZero() {
+		if strings.ContainsRune("bn| ", rune(field[6])) {
+			field[6] = 's'
+		}
+		copy(field[7:11], fmt.Sprintf("%04d", book.PublishDate.Year()%10000))
+	}
+	if code := languageCode(book.Language); code != "" {
+		copy(field[35:38], code)
+	}
+	return string(field)
+}
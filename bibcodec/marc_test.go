@@ -0,0 +1,147 @@
+package bibcodec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// catalogRecord is a record the way a library catalog writes it: AACR2
+// punctuation, an inverted main entry and a translator.
+var catalogRecord = marcRecord{
+	leader: "00000cam a2200000 a 4500",
+	fields: []marcField{
+		{tag: "001", value: "ocm00123"},
+		{tag: "008", value: "850101s1990    ja a          000 1 jpn d"},
+		{tag: "020", ind1: ' ', ind2: ' ', subfields: []marcSubfield{{'a', "4062035158 (pbk.)"}}},
+		{tag: "020", ind1: ' ', ind2: ' ', subfields: []marcSubfield{{'a', "9784062035154"}}},
+		{tag: "100", ind1: '1', ind2: ' ', subfields: []marcSubfield{{'a', "Murakami, Haruki,"}, {'e', "author."}}},
+		{tag: "245", ind1: '1', ind2: '0', subfields: []marcSubfield{{'a', "Noruwei no mori :"}, {'b', "a novel /"}, {'c', "Murakami Haruki."}}},
+		{tag: "260", ind1: ' ', ind2: ' ', subfields: []marcSubfield{{'a', "Tokyo :"}, {'b', "Kodansha,"}, {'c', "c1987."}}},
+		{tag: "300", ind1: ' ', ind2: ' ', subfields: []marcSubfield{{'a', "xi, 268 p. ;"}, {'c', "20 cm."}}},
+		{tag: "650", ind1: ' ', ind2: '0', subfields: []marcSubfield{{'a', "Love stories."}}},
+		{tag: "700", ind1: '1', ind2: ' ', subfields: []marcSubfield{{'a', "Rubin, Jay,"}, {'e', "translator."}}},
+	},
+}
+
+func TestMARCDecoder(t *testing.T) {
+	data, err := catalogRecord.iso2709()
+	require.NoError(t, err)
+
+	books, recordErrs := decodeAll(t, NewMARCDecoder(bytes.NewReader(data)))
+	require.Empty(t, recordErrs)
+	require.Len(t, books, 1)
+	book := books[0]
+
+	assert.Equal(t, "Noruwei no mori: a novel", book.Title)
+	assert.Equal(t, "Haruki Murakami", book.Author)
+	assert.Equal(t, "4062035158", book.ISBN)
+	assert.Equal(t, "Kodansha", book.Publisher)
+	assert.Equal(t, time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), book.PublishDate, "008 has the year of this edition")
+	assert.Equal(t, 268, book.Pages)
+	assert.Equal(t, "Japanese", book.Language)
+
+	assert.Equal(t, []string{`\\$a9784062035154`}, book.Extra["marc:020"], "only the first ISBN is modelled")
+	assert.Equal(t, []string{`1\$aRubin, Jay,$etranslator.`}, book.Extra["marc:700"], "a translator is not an author")
+	assert.Equal(t, []string{`\0$aLove stories.`}, book.Extra["marc:650"])
+	assert.Equal(t, []string{"00000cam a2200000 a 4500"}, book.Extra["marc:leader"])
+	assert.NotContains(t, book.Extra, "marc:245")
+}
+
+func TestMARCDecoder_BadRecords(t *testing.T) {
+	good, err := catalogRecord.iso2709()
+	require.NoError(t, err)
+
+	marc8 := append([]byte(nil), good...)
+	marc8[9] = ' '
+	marc8 = bytes.Replace(marc8, []byte("Kodansha"), []byte("K\xf4dansha"), 1)
+
+	badBase := append([]byte(nil), good...)
+	copy(badBase[12:17], "00030")
+
+	// A signed start would point before the data.
+	negativeStart := append([]byte(nil), good...)
+	copy(negativeStart[24+7:24+12], "-0099")
+	signedLength := append([]byte(nil), good...)
+	copy(signedLength[24+3:24+7], "+009")
+
+	var file bytes.Buffer
+	file.Write(marc8)
+	file.WriteString("\n")
+	file.Write(badBase)
+	file.Write(negativeStart)
+	file.Write(signedLength)
+	file.Write(good)
+	file.Write(good[:100])
+
+	books, recordErrs := decodeAll(t, NewMARCDecoder(&file))
+	assert.Len(t, books, 1)
+	require.Len(t, recordErrs, 5)
+	assert.ErrorContains(t, recordErrs[0], "MARC-8")
+	assert.ErrorContains(t, recordErrs[1], "invalid base address")
+	assert.ErrorContains(t, recordErrs[2], "invalid directory entry")
+	assert.ErrorContains(t, recordErrs[3], "invalid directory entry")
+	assert.ErrorContains(t, recordErrs[4], "no record terminator")
+}
+
+func TestMARCEncoder_KeepsFields(t *testing.T) {
+	data, err := catalogRecord.iso2709()
+	require.NoError(t, err)
+	books, _ := decodeAll(t, NewMARCDecoder(bytes.NewReader(data)))
+	require.Len(t, books, 1)
+
+	book := books[0]
+	book.Title = "Norwegian Wood"
+	book.Language = "English"
+	book.PublishDate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rec := marcRecordOf(book)
+	var tags []string
+	for _, f := range rec.fields {
+		tags = append(tags, f.tag)
+	}
+	assert.Equal(t, []string{"001", "008", "020", "020", "100", "245", "264", "300", "650", "700"}, tags)
+	assert.Equal(t, "850101s2000    ja a          000 1 eng d", rec.fields[1].value, "008 is updated in place")
+	assert.Equal(t, "00000cam a2200000 a 4500", rec.leader)
+
+	var out bytes.Buffer
+	encodeAll(t, NewMARCEncoder(&out), &out, book)
+	again, recordErrs := decodeAll(t, NewMARCDecoder(&out))
+	require.Empty(t, recordErrs)
+	require.Len(t, again, 1)
+	assert.Equal(t, "Norwegian Wood", again[0].Title)
+	assert.Equal(t, book.Extra["marc:700"], again[0].Extra["marc:700"])
+}
+
+func TestMARCXMLDecoder(t *testing.T) {
+	file := `<?xml version="1.0" encoding="UTF-8"?>
+<marc:collection xmlns:marc="http://www.loc.gov/MARC21/slim">
+  <marc:record>
+    <marc:leader>00000nam a2200000 i 4500</marc:leader>
+    <marc:datafield tag="245" ind1="0" ind2="0"><marc:subfield code="a">Emma</marc:subfield></marc:datafield>
+    <marc:datafield tag="264" ind1=" " ind2="1"><marc:subfield code="b">John Murray</marc:subfield><marc:subfield code="c">1815</marc:subfield></marc:datafield>
+  </marc:record>
+  <marc:record>
+    <marc:datafield tag="245" ind1="10" ind2="0"><marc:subfield code="a">Broken</marc:subfield></marc:datafield>
+  </marc:record>
+  <marc:record>
+    <marc:datafield tag="110" ind1="2" ind2=" "><marc:subfield code="a">Unesco.</marc:subfield></marc:datafield>
+  </marc:record>
+</marc:collection>`
+
+	books, recordErrs := decodeAll(t, NewMARCXMLDecoder(strings.NewReader(file)))
+	require.Len(t, books, 2)
+	assert.Equal(t, "Emma", books[0].Title)
+	assert.Equal(t, "John Murray", books[0].Publisher)
+	assert.Equal(t, 1815, books[0].PublishDate.Year())
+	assert.Equal(t, "Unesco", books[1].Author)
+	require.Len(t, recordErrs, 1)
+	assert.ErrorContains(t, recordErrs[0], `datafield 245: invalid indicator "10"`)
+
+	_, err := NewMARCXMLDecoder(strings.NewReader("<books/>")).Decode()
+	assert.ErrorContains(t, err, "expected a MARCXML collection or record")
+}
@@ -0,0 +1,197 @@
+package bibcodec
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"io"
+)
+
+// marcXMLNamespace is the MARC21 slim schema namespace
+const marcXMLNamespace = "http://www.loc.gov/MARC21/slim"
+
+// marcXMLRecord is a <record> element. Fields are decoded in document order
+// and told apart by their element name.
+type marcXMLRecord struct {
+	XMLName xml.Name       `xml:"record"`
+	Leader  string         `xml:"leader"`
+	Fields  []marcXMLField `xml:",any"`
+}
+
+type marcXMLField struct {
+	XMLName   xml.Name
+	Tag       string            `xml:"tag,attr"`
+	Ind1      string            `xml:"ind1,attr,omitempty"`
+	Ind2      string            `xml:"ind2,attr,omitempty"`
+	Value     string            `xml:",chardata"`
+	Subfields []marcXMLSubfield `xml:"subfield"`
+}
+
+type marcXMLSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// NewMARCXMLDecoder returns a Decoder for a MARCXML <collection> of
+// records, or a single <record>
+func NewMARCXMLDecoder(r io.Reader) Decoder {
+	return &marcXMLDecoder{dec: xml.NewDecoder(r)}
+}
+
+type marcXMLDecoder struct {
+	dec     *xml.Decoder
+	started bool
+}
+
+func (d *marcXMLDecoder) Decode() (*models.Book, error) {
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !d.started {
+			d.started = true
+			if start.Name.Local == "collection" {
+				continue
+			}
+			if start.Name.Local != "record" {
+				return nil, fmt.Errorf("expected a MARCXML collection or record, got <%s>", start.Name.Local)
+			}
+		}
+		if start.Name.Local != "record" {
+			if err := d.dec.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var rec marcXMLRecord
+		if err := d.dec.DecodeElement(&rec, &start); err != nil {
+			return nil, err
+		}
+		marc, err := rec.record()
+		if err != nil {
+			return nil, &RecordError{Err: err}
+		}
+		return marcBook(marc), nil
+	}
+}
+
+func (r marcXMLRecord) record() (marcRecord, error) {
+	rec := marcRecord{leader: r.Leader}
+	for _, f := range r.Fields {
+		switch f.XMLName.Local {
+		case "controlfield":
+			rec.fields = append(rec.fields, marcField{tag: f.Tag, value: f.Value})
+		case "datafield":
+			ind1, err1 := indicator(f.Ind1)
+			ind2, err2 := indicator(f.Ind2)
+			if err := errors.Join(err1, err2); err != nil {
+				return marcRecord{}, fmt.Errorf("datafield %s: %w", f.Tag, err)
+			}
+			field := marcField{tag: f.Tag, ind1: ind1, ind2: ind2}
+			for _, sf := range f.Subfields {
+				if len(sf.Code) != 1 {
+					return marcRecord{}, fmt.Errorf("datafield %s: invalid subfield code %q", f.Tag, sf.Code)
+				}
+				field.subfields = append(field.subfields, marcSubfield{code: sf.Code[0], value: sf.Value})
+			}
+			rec.fields = append(rec.fields, field)
+		}
+	}
+	return rec, nil
+}
+
+func indicator(value string) (byte, error) {
+	switch len(value) {
+	case 0:
+		return ' ', nil
+	case 1:
+		return value[0], nil
+	default:
+		return 0, fmt.Errorf("invalid indicator %q", value)
+	}
+}
+
+// NewMARCXMLEncoder returns an Encoder that writes a MARCXML <collection>
+func NewMARCXMLEncoder(w io.Writer) Encoder {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return &marcXMLEncoder{w: w, enc: enc}
+}
+
+type marcXMLEncoder struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	started bool
+}
+
+func (e *marcXMLEncoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	if _, err := io.WriteString(e.w, xml.Header); err != nil {
+		return err
+	}
+	return e.enc.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "collection"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: marcXMLNamespace}},
+	})
+}
+
+func (e *marcXMLEncoder) Encode(book *models.Book) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.enc.Encode(newMARCXMLRecord(marcRecordOf(book)))
+}
+
+func (e *marcXMLEncoder) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	if err := e.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "collection"}}); err != nil {
+		return err
+	}
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n")
+	return err
+}
+
+// newMARCXMLRecord returns the <record> element of a record. The record
+// length and base address in the leader only mean something in ISO 2709, so
+// they are left blank.
+func newMARCXMLRecord(rec marcRecord) marcXMLRecord {
+	leader := []byte(rec.leader)
+	copy(leader[0:5], "     ")
+	copy(leader[9:12], "a22")
+	copy(leader[12:17], "     ")
+	copy(leader[20:24], "4500")
+
+	out := marcXMLRecord{Leader: string(leader)}
+	for _, f := range rec.fields {
+		if isControlTag(f.tag) {
+			out.Fields = append(out.Fields, marcXMLField{XMLName: xml.Name{Local: "controlfield"}, Tag: f.tag, Value: f.value})
+			continue
+		}
+		field := marcXMLField{
+			XMLName: xml.Name{Local: "datafield"},
+			Tag:     f.tag,
+			Ind1:    string(f.ind1),
+			Ind2:    string(f.ind2),
+		}
+		for _, sf := range f.subfields {
+			field.Subfields = append(field.Subfields, marcXMLSubfield{Code: string(sf.code), Value: sf.value})
+		}
+		out.Fields = append(out.Fields, field)
+	}
+	return out
+}
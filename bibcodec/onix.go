@@ -0,0 +1,564 @@
+package bibcodec
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go-elastic/models"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// onixPrefix starts the Book.Extra keys of ONIX elements. Elements of a
+// Product are kept under their name, as in "onix:ProductSupply", and those
+// of its DescriptiveDetail, CollateralDetail and PublishingDetail under the
+// composite and their name, as in "onix:DescriptiveDetail/Subject". Values
+// are the elements' XML as it was written.
+const onixPrefix = "onix:"
+
+// onixNamespace is the namespace of ONIX 3.0 reference tags
+const onixNamespace = "http://ns.editeur.org/onix/3.0/reference"
+
+// onixSenderName is the sender in the header of exported messages
+const onixSenderName = "go-elastic"
+
+// ONIX code list values used for the fields a book models
+const (
+	onixISBN13           = "15" // List 5
+	onixISBN10           = "02"
+	onixDistinctiveTitle = "01"  // List 15
+	onixProductLevel     = "01"  // List 149
+	onixByAuthor         = "A01" // List 17
+	onixLanguageOfText   = "01"  // List 22
+	onixMainContentPages = "00"  // List 23
+	onixContentPages     = "11"
+	onixPages            = "03" // List 24
+	onixDescription      = "03" // List 153
+	onixAnyAudience      = "00" // List 154
+	onixPublisher        = "01" // List 45
+	onixPublicationDate  = "01" // List 163
+	onixConfirmedRecord  = "03" // List 1
+	onixSingleComponent  = "00" // List 2
+	onixBookForm         = "BA" // List 150
+	onixDateYYYYMMDD     = "00" // List 55
+	onixDateYYYYMM       = "01"
+	onixDateYYYY         = "05"
+)
+
+// The composites of a Product whose elements a book models
+const (
+	onixDescriptiveDetail = "DescriptiveDetail"
+	onixCollateralDetail  = "CollateralDetail"
+	onixPublishingDetail  = "PublishingDetail"
+)
+
+// The order of elements in a Product and in the composites a book models,
+// from the ONIX 3.0 schema. Elements are written in this order, so kept and
+// rebuilt elements end up where the schema expects them.
+var onixOrder = map[string][]string{
+	"": {
+		"RecordReference", "NotificationType", "DeletionText", "RecordSourceType",
+		"RecordSourceIdentifier", "RecordSourceName", "ProductIdentifier", "Barcode",
+		onixDescriptiveDetail, onixCollateralDetail, "PromotionDetail", "ContentDetail",
+		onixPublishingDetail, "RelatedMaterial", "ProductSupply",
+	},
+	onixDescriptiveDetail: {
+		"ProductComposition", "ProductForm", "ProductFormDetail", "ProductFormFeature",
+		"ProductPackaging", "ProductFormDescription", "TradeCategory", "PrimaryContentType",
+		"ProductContentType", "Measure", "CountryOfManufacture", "EpubTechnicalProtection",
+		"EpubUsageConstraint", "EpubLicense", "MapScale", "ProductClassification",
+		"ProductPart", "Collection", "NoCollection", "TitleDetail", "ThesisType",
+		"ThesisPresentedTo", "ThesisYear", "Contributor", "ContributorStatement",
+		"NoContributor", "Conference", "Event", "EditionType", "EditionNumber",
+		"EditionVersionNumber", "EditionStatement", "NoEdition", "ReligiousText",
+		"Language", "Extent", "Illustrated", "NumberOfIllustrations", "IllustrationsNote",
+		"AncillaryContent", "Subject", "NameAsSubject", "AudienceCode", "Audience",
+		"AudienceRange", "AudienceDescription", "Complexity",
+	},
+	onixCollateralDetail: {
+		"TextContent", "CitedContent", "SupportingResource", "Prize",
+	},
+	onixPublishingDetail: {
+		"Imprint", "Publisher", "CityOfPublication", "CountryOfPublication",
+		"ProductContact", "PublishingStatus", "PublishingStatusNote", "PublishingDate",
+		"LatestReprintNumber", "CopyrightStatement", "SalesRights", "ROWSalesRightsType",
+		"SalesRestriction",
+	},
+}
+
+// onixNode is an element of a Product, decoded generically so that the
+// elements a book does not model can be kept as they were written
+type onixNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Inner   string     `xml:",innerxml"`
+	Nodes   []onixNode `xml:",any"`
+}
+
+// child returns the first child element with a name, or nil
+func (n *onixNode) child(name string) *onixNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// value returns the text of the first child element with a name, or ""
+func (n *onixNode) value(name string) string {
+	if c := n.child(name); c != nil {
+		return strings.TrimSpace(c.Text)
+	}
+	return ""
+}
+
+// values returns the text of every child element with a name
+func (n *onixNode) values(name string) []string {
+	var values []string
+	for _, c := range n.Nodes {
+		if c.XMLName.Local == name {
+			values = append(values, strings.TrimSpace(c.Text))
+		}
+	}
+	return values
+}
+
+// xml returns the element as it was written, apart from the order and
+// quoting of its attributes
+func (n *onixNode) xml() string {
+	var b strings.Builder
+	b.WriteString("<" + n.XMLName.Local)
+	for _, attr := range n.Attrs {
+		name := attr.Name.Local
+		switch attr.Name.Space {
+		case "xmlns":
+			name = "xmlns:" + name
+		case "http://www.w3.org/XML/1998/namespace":
+			name = "xml:" + name
+		}
+		b.WriteString(" " + name + `="`)
+		xml.EscapeText(&b, []byte(attr.Value))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">" + n.Inner + "</" + n.XMLName.Local + ">")
+	return b.String()
+}
+
+// NewONIXDecoder returns a Decoder for the products of an ONIX for Books 3.0
+// message with reference tags
+func NewONIXDecoder(r io.Reader) Decoder {
+	return &onixDecoder{dec: xml.NewDecoder(r)}
+}
+
+type onixDecoder struct {
+	dec     *xml.Decoder
+	started bool
+}
+
+func (d *onixDecoder) Decode() (*models.Book, error) {
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !d.started {
+			d.started = true
+			if err := checkONIXMessage(start); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if start.Name.Local != "Product" {
+			if err := d.dec.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var product onixNode
+		if err := d.dec.DecodeElement(&product, &start); err != nil {
+			return nil, err
+		}
+		book, err := onixBook(&product)
+		if err != nil {
+			return nil, &RecordError{Err: err}
+		}
+		return book, nil
+	}
+}
+
+func checkONIXMessage(start xml.StartElement) error {
+	switch start.Name.Local {
+	case "ONIXMessage":
+	case "ONIXmessage":
+		return fmt.Errorf("ONIX short tags are not supported; send the message with reference tags")
+	default:
+		return fmt.Errorf("expected an ONIXMessage, got <%s>", start.Name.Local)
+	}
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "release" && !strings.HasPrefix(attr.Value, "3.") {
+			return fmt.Errorf("ONIX release %s is not supported, expected 3.0", attr.Value)
+		}
+	}
+	return nil
+}
+
+// onixBook converts a Product to a book. The fields a book models are the
+// first ISBN-13 or ISBN-10 ProductIdentifier; the distinctive title and
+// subtitle; every contributor "By (author)", in order; the language of the
+// text; the main content page count; the description; the publisher; and
+// the publication date. Everything else is kept in Book.Extra.
+func onixBook(product *onixNode) (*models.Book, error) {
+	book := new(models.Book)
+	var authors []string
+	for i := range product.Nodes {
+		n := &product.Nodes[i]
+		name := n.XMLName.Local
+		switch name {
+		case "ProductIdentifier":
+			if idType := n.value("ProductIDType"); book.ISBN == "" && (idType == onixISBN13 || idType == onixISBN10) {
+				book.ISBN = n.value("IDValue")
+				continue
+			}
+		case onixDescriptiveDetail, onixCollateralDetail, onixPublishingDetail:
+			for j := range n.Nodes {
+				child := &n.Nodes[j]
+				modelled, err := readONIXElement(book, &authors, name, child)
+				if err != nil {
+					return nil, err
+				}
+				if !modelled {
+					addExtra(book, onixPrefix+name+"/"+child.XMLName.Local, child.xml())
+				}
+			}
+			continue
+		}
+		addExtra(book, onixPrefix+name, n.xml())
+	}
+	book.Author = strings.Join(authors, ", ")
+	return book, nil
+}
+
+// readONIXElement reads an element of a composite into the book and reports
+// whether the book models it
+func readONIXElement(book *models.Book, authors *[]string, composite string, n *onixNode) (bool, error) {
+	switch composite + "/" + n.XMLName.Local {
+	case onixDescriptiveDetail + "/TitleDetail":
+		if book.Title != "" || n.value("TitleType") != onixDistinctiveTitle {
+			return false, nil
+		}
+		element := n.child("TitleElement")
+		for i := range n.Nodes {
+			if c := &n.Nodes[i]; c.XMLName.Local == "TitleElement" && c.value("TitleElementLevel") == onixProductLevel {
+				element = c
+				break
+			}
+		}
+		if element == nil {
+			return false, nil
+		}
+		title := element.value("TitleText")
+		if title == "" {
+			title = strings.TrimSpace(element.value("TitlePrefix") + " " + element.value("TitleWithoutPrefix"))
+		}
+		if title == "" {
+			return false, nil
+		}
+		if subtitle := element.value("Subtitle"); subtitle != "" {
+			title += ": " + subtitle
+		}
+		book.Title = title
+		return true, nil
+
+	case onixDescriptiveDetail + "/Contributor":
+		if !slices.Contains(n.values("ContributorRole"), onixByAuthor) {
+			return false, nil
+		}
+		name := n.value("PersonName")
+		if name == "" {
+			name = strings.TrimSpace(n.value("NamesBeforeKey") + " " + n.value("KeyNames"))
+		}
+		if name == "" {
+			name = n.value("CorporateName")
+		}
+		if name == "" && n.value("PersonNameInverted") != "" {
+			name = invertName(n.value("PersonNameInverted"))
+		}
+		if name == "" {
+			return false, nil
+		}
+		*authors = append(*authors, name)
+		return true, nil
+
+	case onixDescriptiveDetail + "/Language":
+		if book.Language != "" || n.value("LanguageRole") != onixLanguageOfText || n.value("LanguageCode") == "" {
+			return false, nil
+		}
+		book.Language = languageName(n.value("LanguageCode"))
+		return true, nil
+
+	case onixDescriptiveDetail + "/Extent":
+		extentType := n.value("ExtentType")
+		if book.Pages != 0 || (extentType != onixMainContentPages && extentType != onixContentPages) || n.value("ExtentUnit") != onixPages {
+			return false, nil
+		}
+		pages, err := strconv.Atoi(n.value("ExtentValue"))
+		if err != nil || pages < 0 {
+			return false, fmt.Errorf("Extent: invalid page count %q", n.value("ExtentValue"))
+		}
+		book.Pages = pages
+		return true, nil
+
+	case onixCollateralDetail + "/TextContent":
+		text := n.child("Text")
+		if book.Description != "" || n.value("TextType") != onixDescription || text == nil {
+			return false, nil
+		}
+		// XHTML is kept as markup
+		book.Description = strings.TrimSpace(text.Text)
+		if len(text.Nodes) > 0 {
+			book.Description = strings.TrimSpace(text.Inner)
+		}
+		return book.Description != "", nil
+
+	case onixPublishingDetail + "/Publisher":
+		if book.Publisher != "" || n.value("PublishingRole") != onixPublisher || n.value("PublisherName") == "" {
+			return false, nil
+		}
+		book.Publisher = n.value("PublisherName")
+		return true, nil
+
+	case onixPublishingDetail + "/PublishingDate":
+		date := n.child("Date")
+		if !book.PublishDate.IsZero() || n.value("PublishingDateRole") != onixPublicationDate || date == nil {
+			return false, nil
+		}
+		format := n.value("DateFormat")
+		for _, attr := range date.Attrs {
+			if attr.Name.Local == "dateformat" {
+				format = attr.Value
+			}
+		}
+		t, err := parseONIXDate(strings.TrimSpace(date.Text), format)
+		if err != nil {
+			return false, fmt.Errorf("PublishingDate: %w", err)
+		}
+		book.PublishDate = t
+		return true, nil
+	}
+	return false, nil
+}
+
+// parseONIXDate parses a date in format YYYYMMDD, YYYYMM or YYYY. Other
+// formats, such as week numbers or ranges, are not supported.
+func parseONIXDate(value, format string) (time.Time, error) {
+	layouts := map[string]string{onixDateYYYYMMDD: "20060102", onixDateYYYYMM: "200601", onixDateYYYY: "2006"}
+	layout, ok := layouts[format]
+	if format == "" {
+		layout, ok = map[int]string{8: "20060102", 6: "200601", 4: "2006"}[len(value)]
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("unsupported date format %q", format)
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return t, nil
+}
+
+// NewONIXEncoder returns an Encoder that writes an ONIX for Books 3.0
+// message with reference tags, one Product per book
+func NewONIXEncoder(w io.Writer) Encoder {
+	return &onixEncoder{w: w, now: time.Now}
+}
+
+type onixEncoder struct {
+	w       io.Writer
+	now     func() time.Time
+	started bool
+}
+
+func (e *onixEncoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	header := xml.Header +
+		`<ONIXMessage release="3.0" xmlns="` + onixNamespace + `">` + "\n" +
+		"  <Header>\n" +
+		"    " + onixElement("Sender", onixText("SenderName", onixSenderName)) + "\n" +
+		"    " + onixText("SentDateTime", e.now().UTC().Format("20060102T150405Z")) + "\n" +
+		"  </Header>\n"
+	_, err := io.WriteString(e.w, header)
+	return err
+}
+
+func (e *onixEncoder) Encode(book *models.Book) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, onixProduct(book))
+	return err
+}
+
+func (e *onixEncoder) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</ONIXMessage>\n")
+	return err
+}
+
+// onixItem is an element of a Product or composite being written
+type onixItem struct {
+	name string
+	xml  string
+}
+
+// onixProduct returns the Product element of a book: the elements it
+// models, rebuilt from the book, and the ONIX elements in Book.Extra, in
+// schema order. RecordReference, NotificationType, ProductComposition and
+// ProductForm are required, so they are filled in when Book.Extra has none.
+func onixProduct(book *models.Book) string {
+	items := map[string][]onixItem{}
+	add := func(composite, name, xml string) {
+		items[composite] = append(items[composite], onixItem{name: name, xml: xml})
+	}
+
+	kept := func(composite, name string) bool {
+		return len(book.Extra[onixExtraKey(composite, name)]) > 0
+	}
+	if !kept("", "RecordReference") && !book.ID.IsZero() {
+		add("", "RecordReference", onixText("RecordReference", book.ID.Hex()))
+	}
+	if !kept("", "NotificationType") {
+		add("", "NotificationType", onixText("NotificationType", onixConfirmedRecord))
+	}
+	if book.ISBN != "" {
+		idType, value := onixISBN13, book.ISBN
+		if digits := strings.NewReplacer("-", "", " ", "").Replace(book.ISBN); len(digits) == 13 {
+			value = digits
+		} else if len(digits) == 10 {
+			idType, value = onixISBN10, digits
+		}
+		add("", "ProductIdentifier", onixElement("ProductIdentifier",
+			onixText("ProductIDType", idType), onixText("IDValue", value)))
+	}
+
+	if !kept(onixDescriptiveDetail, "ProductComposition") {
+		add(onixDescriptiveDetail, "ProductComposition", onixText("ProductComposition", onixSingleComponent))
+	}
+	if !kept(onixDescriptiveDetail, "ProductForm") {
+		add(onixDescriptiveDetail, "ProductForm", onixText("ProductForm", onixBookForm))
+	}
+	if book.Title != "" {
+		add(onixDescriptiveDetail, "TitleDetail", onixElement("TitleDetail",
+			onixText("TitleType", onixDistinctiveTitle),
+			onixElement("TitleElement", onixText("TitleElementLevel", onixProductLevel), onixText("TitleText", book.Title))))
+	}
+	for i, name := range splitAuthors(book.Author) {
+		add(onixDescriptiveDetail, "Contributor", onixElement("Contributor",
+			onixText("SequenceNumber", strconv.Itoa(i+1)),
+			onixText("ContributorRole", onixByAuthor),
+			onixText("PersonName", name)))
+	}
+	if code := languageCode(book.Language); code != "" {
+		add(onixDescriptiveDetail, "Language", onixElement("Language",
+			onixText("LanguageRole", onixLanguageOfText), onixText("LanguageCode", code)))
+	}
+	if book.Pages > 0 {
+		add(onixDescriptiveDetail, "Extent", onixElement("Extent",
+			onixText("ExtentType", onixMainContentPages),
+			onixText("ExtentValue", strconv.Itoa(book.Pages)),
+			onixText("ExtentUnit", onixPages)))
+	}
+	if book.Description != "" {
+		add(onixCollateralDetail, "TextContent", onixElement("TextContent",
+			onixText("TextType", onixDescription),
+			onixText("ContentAudience", onixAnyAudience),
+			onixText("Text", book.Description)))
+	}
+	if book.Publisher != "" {
+		add(onixPublishingDetail, "Publisher", onixElement("Publisher",
+			onixText("PublishingRole", onixPublisher), onixText("PublisherName", book.Publisher)))
+	}
+	if !book.PublishDate.IsZero() {
+		add(onixPublishingDetail, "PublishingDate", onixElement("PublishingDate",
+			onixText("PublishingDateRole", onixPublicationDate), onixText("Date", book.PublishDate.Format("20060102"))))
+	}
+
+	for _, key := range extraKeys(book, onixPrefix) {
+		composite, name, nested := strings.Cut(key, "/")
+		if !nested {
+			composite, name = "", key
+		}
+		if _, ok := onixOrder[composite]; !ok || name == "" {
+			continue
+		}
+		for _, xml := range book.Extra[onixPrefix+key] {
+			add(composite, name, xml)
+		}
+	}
+
+	for _, composite := range []string{onixDescriptiveDetail, onixCollateralDetail, onixPublishingDetail} {
+		if len(items[composite]) > 0 {
+			add("", composite, onixComposite(composite, items[composite], 2))
+		}
+	}
+	return "  " + onixComposite("Product", items[""], 1) + "\n"
+}
+
+func onixExtraKey(composite, name string) string {
+	if composite == "" {
+		return onixPrefix + name
+	}
+	return onixPrefix + composite + "/" + name
+}
+
+// onixComposite returns an element at an indentation depth with its
+// children in schema order, one per line. Children of the same name keep the
+// order they were added in.
+func onixComposite(name string, items []onixItem, depth int) string {
+	order := onixOrder[""]
+	if name != "Product" {
+		order = onixOrder[name]
+	}
+	position := func(item onixItem) int {
+		if i := slices.Index(order, item.name); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+	slices.SortStableFunc(items, func(a, b onixItem) int {
+		return position(a) - position(b)
+	})
+
+	var b strings.Builder
+	b.WriteString("<" + name + ">\n")
+	for _, item := range items {
+		b.WriteString(strings.Repeat("  ", depth+1) + item.xml + "\n")
+	}
+	b.WriteString(strings.Repeat("  ", depth) + "</" + name + ">")
+	return b.String()
+}
+
+// onixElement returns an element with child elements
+func onixElement(name string, children ...string) string {
+	return "<" + name + ">" + strings.Join(children, "") + "</" + name + ">"
+}
+
+// onixText returns an element with text
+func onixText(name, text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return "<" + name + ">" + b.String() + "</" + name + ">"
+}
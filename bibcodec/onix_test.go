@@ -0,0 +1,129 @@
+package bibcodec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const onixMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+  <Header><Sender><SenderName>Example Press</SenderName></Sender><SentDateTime>20240105</SentDateTime></Header>
+  <Product datestamp="20240105">
+    <RecordReference>com.example.9781234567897</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>01</ProductIDType><IDValue>EX-42</IDValue></ProductIdentifier>
+    <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>9781234567897</IDValue></ProductIdentifier>
+    <DescriptiveDetail>
+      <ProductComposition>00</ProductComposition>
+      <ProductForm>BB</ProductForm>
+      <TitleDetail>
+        <TitleType>01</TitleType>
+        <TitleElement>
+          <TitleElementLevel>01</TitleElementLevel>
+          <TitlePrefix>The</TitlePrefix><TitleWithoutPrefix>Left Hand of Darkness</TitleWithoutPrefix>
+          <Subtitle>50th Anniversary Edition</Subtitle>
+        </TitleElement>
+      </TitleDetail>
+      <Contributor><SequenceNumber>1</SequenceNumber><ContributorRole>A01</ContributorRole><NamesBeforeKey>Ursula K.</NamesBeforeKey><KeyNames>Le Guin</KeyNames></Contributor>
+      <Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>A24</ContributorRole><PersonName>David Mitchell</PersonName></Contributor>
+      <Contributor><SequenceNumber>3</SequenceNumber><ContributorRole>A01</ContributorRole><PersonNameInverted>Doe, Jane</PersonNameInverted></Contributor>
+      <Language><LanguageRole>01</LanguageRole><LanguageCode>eng</LanguageCode></Language>
+      <Extent><ExtentType>00</ExtentType><ExtentValue>304</ExtentValue><ExtentUnit>03</ExtentUnit></Extent>
+      <Subject><MainSubject/><SubjectSchemeIdentifier>10</SubjectSchemeIdentifier><SubjectCode>FIC028000</SubjectCode></Subject>
+    </DescriptiveDetail>
+    <CollateralDetail>
+      <TextContent><TextType>03</TextType><ContentAudience>00</ContentAudience><Text>Genly Ai &amp; the Gethenians.</Text></TextContent>
+      <TextContent><TextType>02</TextType><ContentAudience>00</ContentAudience><Text>A classic.</Text></TextContent>
+    </CollateralDetail>
+    <PublishingDetail>
+      <Imprint><ImprintName>Ace</ImprintName></Imprint>
+      <Publisher><PublishingRole>01</PublishingRole><PublisherName>Penguin</PublisherName></Publisher>
+      <PublishingDate><PublishingDateRole>01</PublishingDateRole><Date dateformat="01">201903</Date></PublishingDate>
+    </PublishingDetail>
+  </Product>
+  <Product>
+    <DescriptiveDetail>
+      <Extent><ExtentType>00</ExtentType><ExtentValue>many</ExtentValue><ExtentUnit>03</ExtentUnit></Extent>
+    </DescriptiveDetail>
+  </Product>
+  <Product>
+    <ProductIdentifier><ProductIDType>02</ProductIDType><IDValue>0441478123</IDValue></ProductIdentifier>
+  </Product>
+</ONIXMessage>`
+
+func TestONIXDecoder(t *testing.T) {
+	books, recordErrs := decodeAll(t, NewONIXDecoder(strings.NewReader(onixMessage)))
+	require.Len(t, books, 2)
+	require.Len(t, recordErrs, 1)
+	assert.ErrorContains(t, recordErrs[0], `Extent: invalid page count "many"`)
+
+	book := books[0]
+	assert.Equal(t, "The Left Hand of Darkness: 50th Anniversary Edition", book.Title)
+	assert.Equal(t, "Ursula K. Le Guin, Jane Doe", book.Author)
+	assert.Equal(t, "9781234567897", book.ISBN)
+	assert.Equal(t, "Genly Ai & the Gethenians.", book.Description)
+	assert.Equal(t, "Penguin", book.Publisher)
+	assert.Equal(t, time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC), book.PublishDate)
+	assert.Equal(t, 304, book.Pages)
+	assert.Equal(t, "English", book.Language)
+
+	assert.Equal(t, []string{"<RecordReference>com.example.9781234567897</RecordReference>"}, book.Extra["onix:RecordReference"])
+	assert.Equal(t, []string{"<ProductIdentifier><ProductIDType>01</ProductIDType><IDValue>EX-42</IDValue></ProductIdentifier>"}, book.Extra["onix:ProductIdentifier"])
+	assert.Equal(t, []string{"<Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>A24</ContributorRole><PersonName>David Mitchell</PersonName></Contributor>"},
+		book.Extra["onix:DescriptiveDetail/Contributor"], "only authors are modelled")
+	assert.Equal(t, []string{"<ProductForm>BB</ProductForm>"}, book.Extra["onix:DescriptiveDetail/ProductForm"])
+	assert.Len(t, book.Extra["onix:CollateralDetail/TextContent"], 1)
+	assert.Len(t, book.Extra["onix:PublishingDetail/Imprint"], 1)
+
+	assert.Equal(t, "0441478123", books[1].ISBN)
+}
+
+func TestONIXDecoder_UnsupportedMessages(t *testing.T) {
+	_, err := NewONIXDecoder(strings.NewReader(`<ONIXmessage release="3.0"><header/></ONIXmessage>`)).Decode()
+	assert.ErrorContains(t, err, "short tags are not supported")
+
+	_, err = NewONIXDecoder(strings.NewReader(`<ONIXMessage release="2.1"><Header/></ONIXMessage>`)).Decode()
+	assert.ErrorContains(t, err, "release 2.1 is not supported")
+}
+
+func TestONIXEncoder(t *testing.T) {
+	books, _ := decodeAll(t, NewONIXDecoder(strings.NewReader(onixMessage)))
+	require.NotEmpty(t, books)
+	book := books[0]
+	book.Pages = 320
+
+	var out bytes.Buffer
+	enc := NewONIXEncoder(&out).(*onixEncoder)
+	enc.now = func() time.Time { return time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC) }
+	data := encodeAll(t, enc, &out, book)
+
+	assert.Contains(t, data, "<SentDateTime>20240203T040506Z</SentDateTime>")
+	assert.Equal(t, 1, strings.Count(data, "<ProductForm>"), "the kept ProductForm replaces the default")
+	assert.Contains(t, data, "<ProductForm>BB</ProductForm>")
+
+	// Kept and rebuilt elements are in schema order.
+	order := []string{
+		"<RecordReference>", "<ProductIdentifier><ProductIDType>15", "<ProductIdentifier><ProductIDType>01",
+		"<ProductComposition>", "<TitleDetail>", "<Contributor><SequenceNumber>1", "<Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>A24",
+		"<Language>", "<ExtentValue>320</ExtentValue>", "<Subject>",
+		"<TextContent><TextType>03", "<TextContent><TextType>02",
+		"<Imprint>", "<Publisher>", "<Date>20190301</Date>",
+	}
+	last := -1
+	for _, element := range order {
+		i := strings.Index(data, element)
+		require.Greater(t, i, last, element)
+		last = i
+	}
+
+	again, recordErrs := decodeAll(t, NewONIXDecoder(strings.NewReader(data)))
+	require.Empty(t, recordErrs)
+	require.Len(t, again, 1)
+	assert.Equal(t, book.Extra, again[0].Extra)
+	assert.Equal(t, 320, again[0].Pages)
+}
@@ -0,0 +1,137 @@
+// Package bookformat reads and writes books in the file formats of bulk
+// import and export: a JSON array, newline-delimited JSON and CSV, and the
+// bibliographic formats of package bibcodec: MARC21, MARCXML, ONIX 3.0 and
+// BibTeX. Files are read and written one record at a time, so they can be
+// larger than memory.
+package bookformat
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Formats
+const (
+	JSON    = "json"
+	NDJSON  = "ndjson"
+	CSV     = "csv"
+	MARC    = "marc"
+	MARCXML = "marcxml"
+	ONIX    = "onix"
+	BibTeX  = "bibtex"
+)
+
+// formatNames lists the formats for error messages
+const formatNames = "json, ndjson, csv, marc, marcxml, onix or bibtex"
+
+// ErrUnknownFormat is returned for a format name that is not supported
+var ErrUnknownFormat = errors.New("unknown format")
+
+// Columns are the CSV columns, named like the JSON fields of a book. An
+// imported file may have any subset of them, in any order. The extra column
+// holds Book.Extra as a JSON object.
+var Columns = []string{
+	"id", "title", "author", "isbn", "description", "publisher",
+	"publish_date", "pages", "language", "created_at", "updated_at", "extra",
+}
+
+// ParseFormat checks a format name, case-insensitively
+func ParseFormat(name string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(name)); format {
+	case JSON, NDJSON, CSV, MARC, MARCXML, ONIX, BibTeX:
+		return format, nil
+	case "jsonl":
+		return NDJSON, nil
+	case "mrc", "marc21":
+		return MARC, nil
+	case "bib":
+		return BibTeX, nil
+	default:
+		return "", fmt.Errorf("%w %q, expected %s", ErrUnknownFormat, name, formatNames)
+	}
+}
+
+// Bibliographic reports whether a format is one of the record formats of
+// package bibcodec, which map their own fields to a book rather than
+// having a field per column
+func Bibliographic(format string) bool {
+	switch format {
+	case MARC, MARCXML, ONIX, BibTeX:
+		return true
+	default:
+		return false
+	}
+}
+
+// FormatFromContentType returns the format of a media type, or "" if it is
+// not one of them. ONIX has no media type of its own; it is sent as XML,
+// which could be MARCXML too, so it has to be named with ?format=.
+func FormatFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "application/json":
+		return JSON
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return NDJSON
+	case "text/csv":
+		return CSV
+	case "application/marc":
+		return MARC
+	case "application/marcxml+xml":
+		return MARCXML
+	case "application/x-bibtex":
+		return BibTeX
+	default:
+		return ""
+	}
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case NDJSON:
+		return "application/x-ndjson"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case MARC:
+		return "application/marc"
+	case MARCXML:
+		return "application/marcxml+xml"
+	case ONIX:
+		return "application/xml"
+	case BibTeX:
+		return "application/x-bibtex; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// Extension returns the usual file extension of a format, without a dot
+func Extension(format string) string {
+	switch format {
+	case MARC:
+		return "mrc"
+	case MARCXML, ONIX:
+		return "xml"
+	case BibTeX:
+		return "bib"
+	default:
+		return format
+	}
+}
+
+// FormatFromFilename returns the format of a file extension, or "" if it is
+// not one of them
+func FormatFromFilename(name string) string {
+	format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(name), "."))
+	if err != nil {
+		return ""
+	}
+	return format
+}
@@ -0,0 +1,275 @@
+package bookformat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-elastic/bibcodec"
+	"go-elastic/models"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Record is one book read from a file
+type Record struct {
+	// Row is where the record is in the file: the line number for NDJSON
+	// and CSV, the 1-based position in the array for JSON, and the 1-based
+	// position of the record for the bibliographic formats.
+	Row  int
+	Book *models.Book
+	// Err is set, and Book nil, when the record is not a book, such as a
+	// field of the wrong type. Reading can continue after it.
+	Err error
+}
+
+// Reader reads books one record at a time
+type Reader interface {
+	// Read returns the next record, or io.EOF after the last one. Any other
+	// error means the rest of the file cannot be read.
+	Read() (Record, error)
+}
+
+// NewReader returns a Reader for a file in the given format
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case JSON:
+		return &jsonReader{dec: json.NewDecoder(r)}, nil
+	case NDJSON:
+		return &ndjsonReader{r: bufio.NewReader(r)}, nil
+	case CSV:
+		return newCSVReader(r), nil
+	case MARC:
+		return &bibReader{dec: bibcodec.NewMARCDecoder(r)}, nil
+	case MARCXML:
+		return &bibReader{dec: bibcodec.NewMARCXMLDecoder(r)}, nil
+	case ONIX:
+		return &bibReader{dec: bibcodec.NewONIXDecoder(r)}, nil
+	case BibTeX:
+		return &bibReader{dec: bibcodec.NewBibTeXDecoder(r)}, nil
+	default:
+		return nil, fmt.Errorf("%w %q, expected %s", ErrUnknownFormat, format, formatNames)
+	}
+}
+
+// bibReader reads the records of a bibliographic format
+type bibReader struct {
+	dec bibcodec.Decoder
+	row int
+}
+
+func (r *bibReader) Read() (Record, error) {
+	book, err := r.dec.Decode()
+	var recordErr *bibcodec.RecordError
+	if errors.As(err, &recordErr) {
+		r.row++
+		return Record{Row: r.row, Err: recordErr.Err}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	r.row++
+	return Record{Row: r.row, Book: book}, nil
+}
+
+// jsonReader reads the elements of a top-level JSON array
+type jsonReader struct {
+	dec     *json.Decoder
+	row     int
+	started bool
+	done    bool
+}
+
+func (r *jsonReader) Read() (Record, error) {
+	if r.done {
+		return Record{}, io.EOF
+	}
+	if !r.started {
+		r.started = true
+		tok, err := r.dec.Token()
+		if err == io.EOF {
+			r.done = true
+			return Record{}, io.EOF
+		}
+		if err != nil {
+			return Record{}, err
+		}
+		if tok != json.Delim('[') {
+			return Record{}, errors.New("expected a JSON array of books")
+		}
+	}
+
+	if !r.dec.More() {
+		r.done = true
+		if _, err := r.dec.Token(); err != nil {
+			return Record{}, err
+		}
+		return Record{}, io.EOF
+	}
+
+	// Decoding into a RawMessage first keeps the decoder in step when an
+	// element does not fit a book.
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		return Record{}, fmt.Errorf("element %d: %w", r.row+1, err)
+	}
+	r.row++
+	return decodeJSON(r.row, raw), nil
+}
+
+// ndjsonReader reads one JSON object per line, skipping blank lines
+type ndjsonReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (r *ndjsonReader) Read() (Record, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Record{}, err
+		}
+		r.line++
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			if err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		return decodeJSON(r.line, line), nil
+	}
+}
+
+func decodeJSON(row int, data []byte) Record {
+	book := new(models.Book)
+	if err := json.Unmarshal(data, book); err != nil {
+		return Record{Row: row, Err: err}
+	}
+	return Record{Row: row, Book: book}
+}
+
+// csvReader reads a CSV file whose first line names the columns
+type csvReader struct {
+	csv    *csv.Reader
+	header []string
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	return &csvReader{csv: reader}
+}
+
+func (r *csvReader) Read() (Record, error) {
+	if r.header == nil {
+		if err := r.readHeader(); err != nil {
+			return Record{}, err
+		}
+	}
+
+	record, err := r.csv.Read()
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	// A record that fails to parse may be empty, so its line comes from the
+	// error rather than FieldPos.
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+		return Record{Row: parseErr.StartLine, Err: fmt.Errorf("expected %d fields, got %d", len(r.header), len(record))}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	line, _ := r.csv.FieldPos(0)
+
+	book, err := r.book(record)
+	if err != nil {
+		return Record{Row: line, Err: err}, nil
+	}
+	return Record{Row: line, Book: book}, nil
+}
+
+func (r *csvReader) readHeader() error {
+	header, err := r.csv.Read()
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+
+	r.header = make([]string, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\uFEFF")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(Columns, name) {
+			return fmt.Errorf("unknown column %q, expected some of %s", name, strings.Join(Columns, ", "))
+		}
+		if slices.Contains(r.header[:i], name) {
+			return fmt.Errorf("column %q appears twice", name)
+		}
+		r.header[i] = name
+	}
+	return nil
+}
+
+// book converts a CSV record. Empty cells are left unset.
+func (r *csvReader) book(record []string) (*models.Book, error) {
+	book := new(models.Book)
+	for i, value := range record {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		column := r.header[i]
+		switch column {
+		case "id":
+			book.ID, err = primitive.ObjectIDFromHex(value)
+		case "title":
+			book.Title = value
+		case "author":
+			book.Author = value
+		case "isbn":
+			book.ISBN = value
+		case "description":
+			book.Description = value
+		case "publisher":
+			book.Publisher = value
+		case "publish_date":
+			book.PublishDate, err = parseTime(value)
+		case "pages":
+			book.Pages, err = strconv.Atoi(value)
+		case "language":
+			book.Language = value
+		case "created_at":
+			book.CreatedAt, err = parseTime(value)
+		case "updated_at":
+			book.UpdatedAt, err = parseTime(value)
+		case "extra":
+			err = json.Unmarshal([]byte(value), &book.Extra)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q", column, value)
+		}
+	}
+	return book, nil
+}
+
+// parseTime accepts an RFC 3339 timestamp or a date
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
@@ -0,0 +1,181 @@
+package bookformat
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll reads records until io.EOF or a fatal error
+func readAll(t *testing.T, format, input string) ([]Record, error) {
+	t.Helper()
+	reader, err := NewReader(format, strings.NewReader(input))
+	require.NoError(t, err)
+
+	var records []Record
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestReader_JSON(t *testing.T) {
+	records, err := readAll(t, JSON, `[
+		{"title": "Learning Go", "author": "Jon Bodner", "pages": 375},
+		{"title": "Dune", "pages": "many"},
+		{"title": "Go in Action", "author": "William Kennedy"}
+	]`)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, 1, records[0].Row)
+	assert.Equal(t, "Learning Go", records[0].Book.Title)
+	assert.Equal(t, 375, records[0].Book.Pages)
+
+	assert.Equal(t, 2, records[1].Row)
+	assert.Nil(t, records[1].Book)
+	assert.ErrorContains(t, records[1].Err, "pages")
+
+	assert.Equal(t, 3, records[2].Row)
+	assert.Equal(t, "William Kennedy", records[2].Book.Author)
+}
+
+func TestReader_JSONInvalid(t *testing.T) {
+	records, err := readAll(t, JSON, "")
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	_, err = readAll(t, JSON, `{"title": "Dune"}`)
+	assert.ErrorContains(t, err, "expected a JSON array")
+
+	records, err = readAll(t, JSON, `[{"title": "Dune"}, {"title": `)
+	assert.Error(t, err)
+	assert.Len(t, records, 1, "records before the broken one are returned")
+}
+
+func TestReader_NDJSON(t *testing.T) {
+	records, err := readAll(t, NDJSON, "{\"title\": \"Dune\"}\n\n[1, 2]\r\n{\"title\": \"Emma\"}")
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, 1, records[0].Row)
+	assert.Equal(t, "Dune", records[0].Book.Title)
+	assert.Equal(t, 3, records[1].Row, "rows are line numbers, counting blank lines")
+	assert.Error(t, records[1].Err)
+	assert.Equal(t, 4, records[2].Row)
+	assert.Equal(t, "Emma", records[2].Book.Title)
+}
+
+func TestReader_CSV(t *testing.T) {
+	input := "\uFEFFTitle,author,pages,publish_date,created_at\n" +
+		"Learning Go,Jon Bodner,375,2021-03-02,2024-01-02T03:04:05Z\n" +
+		"\"Dune, Deluxe\",Frank Herbert,lots,,\n" +
+		"Emma,Jane Austen\n" +
+		"\"Go in\nAction\",William Kennedy,,,\n"
+	records, err := readAll(t, CSV, input)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	first := records[0]
+	assert.Equal(t, 2, first.Row)
+	require.NoError(t, first.Err)
+	assert.Equal(t, "Learning Go", first.Book.Title)
+	assert.Equal(t, 375, first.Book.Pages)
+	assert.Equal(t, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), first.Book.PublishDate)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), first.Book.CreatedAt)
+
+	assert.Equal(t, 3, records[1].Row)
+	assert.EqualError(t, records[1].Err, `pages: invalid value "lots"`)
+
+	assert.Equal(t, 4, records[2].Row)
+	assert.EqualError(t, records[2].Err, "expected 5 fields, got 2")
+
+	assert.Equal(t, 5, records[3].Row)
+	assert.Equal(t, "Go in\nAction", records[3].Book.Title)
+	assert.Zero(t, records[3].Book.Pages)
+}
+
+func TestReader_CSVHeader(t *testing.T) {
+	_, err := readAll(t, CSV, "title,rating\nDune,5\n")
+	assert.ErrorContains(t, err, `unknown column "rating"`)
+
+	_, err = readAll(t, CSV, "title,Title\nDune,Dune\n")
+	assert.ErrorContains(t, err, `column "title" appears twice`)
+
+	records, err := readAll(t, CSV, "")
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestReader_CSVMalformed(t *testing.T) {
+	records, err := readAll(t, CSV, "publish_date\n\"")
+	var parseErr *csv.ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 2, parseErr.StartLine)
+	assert.Empty(t, records)
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]string{
+		"JSON": JSON, " ndjson": NDJSON, "jsonl": NDJSON, "csv": CSV,
+		"MARC21": MARC, "mrc": MARC, "marcxml": MARCXML, "onix": ONIX, "bib": BibTeX,
+	} {
+		format, err := ParseFormat(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, format, input)
+	}
+	_, err := ParseFormat("xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat, "XML could be MARCXML or ONIX")
+
+	assert.Equal(t, NDJSON, FormatFromContentType("application/x-ndjson; charset=utf-8"))
+	assert.Equal(t, CSV, FormatFromContentType("text/csv"))
+	assert.Equal(t, MARCXML, FormatFromContentType("application/marcxml+xml"))
+	assert.Empty(t, FormatFromContentType("application/xml"))
+	assert.Empty(t, FormatFromContentType("text/plain"))
+	assert.Equal(t, CSV, FormatFromFilename("books.CSV"))
+	assert.Equal(t, MARC, FormatFromFilename("catalog.mrc"))
+	assert.Empty(t, FormatFromFilename("feed.xml"))
+	assert.Empty(t, FormatFromFilename("books"))
+}
+
+func TestReader_Bibliographic(t *testing.T) {
+	records, err := readAll(t, BibTeX, `@book{a, title = {Dune}}
+@book{b, title = {Emma} year = 1815}
+@book{c, title = {Ulysses}, keywords = {modernism}}`)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, 1, records[0].Row)
+	assert.Equal(t, "Dune", records[0].Book.Title)
+	assert.Equal(t, 2, records[1].Row)
+	assert.ErrorContains(t, records[1].Err, "expected ','")
+	assert.Nil(t, records[1].Book)
+	assert.Equal(t, 3, records[2].Row)
+	assert.Equal(t, []string{"modernism"}, records[2].Book.Extra["bibtex:keywords"])
+
+	_, err = readAll(t, ONIX, "<ONIXmessage/>")
+	assert.ErrorContains(t, err, "short tags")
+}
+
+func TestReader_CSVExtra(t *testing.T) {
+	records, err := readAll(t, CSV, `title,extra
+Dune,"{""bibtex:keywords"":[""sci-fi"",""spice""]}"
+Emma,[1]
+`)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, map[string][]string{"bibtex:keywords": {"sci-fi", "spice"}}, records[0].Book.Extra)
+	assert.EqualError(t, records[1].Err, `extra: invalid value "[1]"`)
+}
@@ -0,0 +1,272 @@
+package bookformat
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go-elastic/bibcodec"
+	"go-elastic/models"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer writes books one at a time
+type Writer interface {
+	Write(book *models.Book) error
+	// Close writes whatever ends the file, such as the closing bracket of
+	// a JSON array, and flushes it. It does not close the underlying
+	// io.Writer.
+	Close() error
+}
+
+// NewWriter returns a Writer for a file in the given format holding the
+// given fields of each book, which must be some of Columns. CSV files start
+// with a header naming the fields; JSON objects leave out empty fields. The
+// bibliographic formats write whole records, so fields must be Columns.
+func NewWriter(format string, w io.Writer, fields []string) (Writer, error) {
+	if Bibliographic(format) && !slices.Equal(fields, Columns) {
+		return nil, fmt.Errorf("fields cannot be chosen for %s, which writes whole records", format)
+	}
+	switch format {
+	case JSON:
+		return &jsonWriter{w: w, fields: fields}, nil
+	case NDJSON:
+		return &ndjsonWriter{w: w, fields: fields}, nil
+	case CSV:
+		return &csvWriter{csv: csv.NewWriter(w), fields: fields}, nil
+	case MARC:
+		return &bibWriter{enc: bibcodec.NewMARCEncoder(w)}, nil
+	case MARCXML:
+		return &bibWriter{enc: bibcodec.NewMARCXMLEncoder(w)}, nil
+	case ONIX:
+		return &bibWriter{enc: bibcodec.NewONIXEncoder(w)}, nil
+	case BibTeX:
+		return &bibWriter{enc: bibcodec.NewBibTeXEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w %q, expected %s", ErrUnknownFormat, format, formatNames)
+	}
+}
+
+// bibWriter writes the records of a bibliographic format
+type bibWriter struct {
+	enc bibcodec.Encoder
+}
+
+func (w *bibWriter) Write(book *models.Book) error {
+	return w.enc.Encode(book)
+}
+
+func (w *bibWriter) Close() error {
+	return w.enc.Close()
+}
+
+// ParseFields checks a comma-separated list of fields against Columns,
+// case-insensitively. An empty list means all of them.
+func ParseFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return Columns, nil
+	}
+	var fields []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(Columns, name) {
+			return nil, fmt.Errorf("unknown field %q, expected some of %s", name, strings.Join(Columns, ", "))
+		}
+		if slices.Contains(fields, name) {
+			return nil, fmt.Errorf("field %q appears twice", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// jsonWriter writes the elements of a top-level JSON array, one per line
+type jsonWriter struct {
+	w       io.Writer
+	fields  []string
+	started bool
+}
+
+func (w *jsonWriter) Write(book *models.Book) error {
+	prefix := ",\n"
+	if !w.started {
+		w.started = true
+		prefix = "[\n"
+	}
+	data, err := encodeJSON(book, w.fields)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append([]byte(prefix), data...))
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	end := "\n]\n"
+	if !w.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+// ndjsonWriter writes one JSON object per line
+type ndjsonWriter struct {
+	w      io.Writer
+	fields []string
+}
+
+func (w *ndjsonWriter) Write(book *models.Book) error {
+	data, err := encodeJSON(book, w.fields)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(data, '\n'))
+	return err
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+// encodeJSON encodes the given fields of a book as an object, in the order
+// of fields. Empty fields are left out, as when the whole book is encoded.
+func encodeJSON(book *models.Book, fields []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, field := range fields {
+		value := jsonValue(book, field)
+		if value == nil {
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(field))
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonValue returns a field of a book, or nil if it is empty
+func jsonValue(book *models.Book, field string) interface{} {
+	switch field {
+	case "id":
+		if !book.ID.IsZero() {
+			return book.ID
+		}
+	case "pages":
+		if book.Pages != 0 {
+			return book.Pages
+		}
+	case "publish_date", "created_at", "updated_at":
+		if t := timeField(book, field); !t.IsZero() {
+			return t
+		}
+	case "extra":
+		if len(book.Extra) > 0 {
+			return book.Extra
+		}
+	default:
+		if s := textValue(book, field); s != "" {
+			return s
+		}
+	}
+	return nil
+}
+
+// csvWriter writes a header naming the fields and then one line per book
+type csvWriter struct {
+	csv     *csv.Writer
+	fields  []string
+	started bool
+	record  []string
+}
+
+func (w *csvWriter) Write(book *models.Book) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.record = w.record[:0]
+	for _, field := range w.fields {
+		w.record = append(w.record, textValue(book, field))
+	}
+	return w.csv.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// writeHeader writes the header line, once
+func (w *csvWriter) writeHeader() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.csv.Write(w.fields)
+}
+
+// textValue returns a field of a book as it is written in CSV: times in RFC
+// 3339, extra as a JSON object and empty fields as "", which is how the CSV
+// reader reads them back.
+func textValue(book *models.Book, field string) string {
+	switch field {
+	case "id":
+		if !book.ID.IsZero() {
+			return book.ID.Hex()
+		}
+	case "title":
+		return book.Title
+	case "author":
+		return book.Author
+	case "isbn":
+		return book.ISBN
+	case "description":
+		return book.Description
+	case "publisher":
+		return book.Publisher
+	case "pages":
+		if book.Pages != 0 {
+			return strconv.Itoa(book.Pages)
+		}
+	case "language":
+		return book.Language
+	case "publish_date", "created_at", "updated_at":
+		if t := timeField(book, field); !t.IsZero() {
+			return t.Format(time.RFC3339)
+		}
+	case "extra":
+		if len(book.Extra) > 0 {
+			// A map of strings always encodes.
+			data, _ := json.Marshal(book.Extra)
+			return string(data)
+		}
+	}
+	return ""
+}
+
+func timeField(book *models.Book, field string) time.Time {
+	switch field {
+	case "publish_date":
+		return book.PublishDate
+	case "created_at":
+		return book.CreatedAt
+	default:
+		return book.UpdatedAt
+	}
+}
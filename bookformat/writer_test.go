@@ -0,0 +1,94 @@
+package bookformat
+
+import (
+	"bytes"
+	"go-elastic/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func writeAll(t *testing.T, format string, fields []string, books ...*models.Book) string {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, err := NewWriter(format, &buf, fields)
+	require.NoError(t, err)
+	for _, book := range books {
+		require.NoError(t, writer.Write(book))
+	}
+	require.NoError(t, writer.Close())
+	return buf.String()
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	books := []*models.Book{
+		{
+			ID:          primitive.NewObjectID(),
+			Title:       "Dune, Deluxe",
+			Author:      "Frank Herbert",
+			Description: "Spice\nand sand",
+			PublishDate: time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC),
+			Pages:       412,
+			CreatedAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Extra:       map[string][]string{"marc:650": {`\0$aScience fiction, "space".`}},
+		},
+		{Title: "Emma", Author: "Jane Austen", Language: "en"},
+	}
+
+	for _, format := range []string{JSON, NDJSON, CSV} {
+		records, err := readAll(t, format, writeAll(t, format, Columns, books...))
+		require.NoError(t, err, format)
+		require.Len(t, records, len(books), format)
+		for i, record := range records {
+			require.NoError(t, record.Err, format)
+			assert.Equal(t, books[i], record.Book, format)
+		}
+	}
+}
+
+func TestWriter_Fields(t *testing.T) {
+	book := &models.Book{Title: "Emma", Author: "Jane Austen", Pages: 474}
+	fields := []string{"pages", "title", "isbn"}
+
+	assert.Equal(t, "{\"pages\":474,\"title\":\"Emma\"}\n", writeAll(t, NDJSON, fields, book))
+	assert.Equal(t, "pages,title,isbn\n474,Emma,\n", writeAll(t, CSV, fields, book))
+	assert.Equal(t, "[\n{\"pages\":474,\"title\":\"Emma\"}\n]\n", writeAll(t, JSON, fields, book))
+
+	assert.Equal(t, "[]\n", writeAll(t, JSON, fields))
+	assert.Equal(t, "pages,title,isbn\n", writeAll(t, CSV, fields), "an empty CSV file still has its header")
+}
+
+func TestWriter_Bibliographic(t *testing.T) {
+	book := &models.Book{Title: "Emma", Author: "Jane Austen", Extra: map[string][]string{"bibtex:keywords": {"regency"}}}
+
+	for _, format := range []string{MARC, MARCXML, ONIX, BibTeX} {
+		records, err := readAll(t, format, writeAll(t, format, Columns, book))
+		require.NoError(t, err, format)
+		require.Len(t, records, 1, format)
+		require.NoError(t, records[0].Err, format)
+		assert.Equal(t, "Emma", records[0].Book.Title, format)
+		assert.Equal(t, "Jane Austen", records[0].Book.Author, format)
+	}
+	assert.Contains(t, writeAll(t, BibTeX, Columns, book), "keywords = {regency}")
+
+	_, err := NewWriter(MARC, &bytes.Buffer{}, []string{"title"})
+	assert.ErrorContains(t, err, "fields cannot be chosen for marc")
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields(" Title, isbn ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"title", "isbn"}, fields)
+
+	fields, err = ParseFields("")
+	require.NoError(t, err)
+	assert.Equal(t, Columns, fields)
+
+	_, err = ParseFields("title,rating")
+	assert.ErrorContains(t, err, `unknown field "rating"`)
+	_, err = ParseFields("title,TITLE")
+	assert.ErrorContains(t, err, `field "title" appears twice`)
+}
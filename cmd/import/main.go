@@ -0,0 +1,100 @@
+// Command import loads books from a JSON array, NDJSON, CSV, MARC21,
+// MARCXML, ONIX or BibTeX file into MongoDB and Elasticsearch and prints the
+// import report as JSON.
+//
+//	go run ./cmd/import books.ndjson
+//	go run ./cmd/import -format csv -batch 1000 - < books.csv
+//
+// The format is taken from the file extension unless -format is given;
+// MARCXML and ONIX files both end in .xml, so they need it. It exits with
+// status 1 if any book was not imported.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"go-elastic/bookformat"
+	"go-elastic/database"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"io"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	format := flag.String("format", "", "json, ndjson, csv, marc, marcxml, onix or bibtex (default: from the file extension)")
+	batch := flag.Int("batch", service.DefaultImportBatchSize, "books inserted and indexed per batch")
+	flag.Usage = func() {
+		log.Printf("usage: %s [-format json|ndjson|csv|marc|marcxml|onix|bibtex] [-batch n] file|-", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	if *format == "" {
+		*format = bookformat.FormatFromFilename(name)
+	}
+	if *format == "" {
+		log.Fatalf("cannot tell the format of %q, use -format", name)
+	}
+	parsed, err := bookformat.ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var input io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+	reader, err := bookformat.NewReader(parsed, input)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using defaults")
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+	if err := database.InitElasticsearch(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Books are queued in the outbox along with their insert, so that the
+	// server's relay indexes those Elasticsearch rejects, or all of a batch
+	// if the import is stopped before indexing it.
+	outboxRepo := repository.NewOutboxRepository(database.DB.Collection("outbox"))
+	bookRepo := repository.NewBookRepository(database.DB.Collection("books"), outboxRepo)
+	bookIndex := repository.NewBookIndex(service.LoadSearchConfig().Embedder)
+	svc := service.NewBookImportService(bookRepo, bookIndex, outboxRepo, nil, nil, *batch)
+
+	report, err := svc.Import(context.Background(), reader)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	if report.Error != "" || report.Invalid > 0 || report.Failed > 0 || report.Indexed < report.Created {
+		database.CloseDB()
+		os.Exit(1)
+	}
+}
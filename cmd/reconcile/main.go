@@ -0,0 +1,55 @@
+// Command reconcile compares the MongoDB books collection with the
+// Elasticsearch books index and prints a drift report as JSON.
+//
+//	go run ./cmd/reconcile           # report only
+//	go run ./cmd/reconcile -repair   # re-index missing/stale, delete orphaned
+//
+// It exits with status 1 if drift remains after the run.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"go-elastic/database"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	repair := flag.Bool("repair", false, "re-index missing and stale books and delete orphaned documents")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using defaults")
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+	if err := database.InitElasticsearch(); err != nil {
+		log.Fatal(err)
+	}
+
+	bookRepo := repository.NewBookRepository(database.DB.Collection("books"), nil)
+	svc := service.NewReconcileService(bookRepo, repository.NewBookIndex(service.LoadSearchConfig().Embedder))
+
+	report, err := svc.Reconcile(context.Background(), *repair, nil)
+	if err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	if !report.InSync() && (!report.Repair || len(report.RepairErrors) > 0) {
+		database.CloseDB()
+		os.Exit(1)
+	}
+}
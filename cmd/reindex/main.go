@@ -0,0 +1,63 @@
+// Command reindex rebuilds the Elasticsearch books index from MongoDB into a
+// new versioned index and swaps the books/books_write aliases to it.
+//
+//	go run ./cmd/reindex -status       # show live index and mapping diff
+//	go run ./cmd/reindex               # reindex to the latest mapping
+//	go run ./cmd/reindex -version 2    # reindex to books_v2
+//	go run ./cmd/reindex -rebuild      # rebuild the live version, e.g. for new stopwords
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"go-elastic/database"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	statusOnly := flag.Bool("status", false, "print the live index and mapping diff without reindexing")
+	version := flag.Int("version", 0, "mapping version to build (default: latest)")
+	rebuild := flag.Bool("rebuild", false, "build the live mapping version again into a new index")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using defaults")
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+	if err := database.InitElasticsearch(); err != nil {
+		log.Fatal(err)
+	}
+
+	bookRepo := repository.NewBookRepository(database.DB.Collection("books"), nil)
+	embedder := service.LoadSearchConfig().Embedder
+	reconcileSvc := service.NewReconcileService(bookRepo, repository.NewBookIndex(embedder))
+	analysisRepo := repository.NewSearchAnalysisRepository(database.DB.Collection("synonym_sets"), database.DB.Collection("stopword_lists"))
+	svc := service.NewBookIndexService(bookRepo, reconcileSvc, service.NewSearchAnalysisService(analysisRepo), embedder, service.LoadKeepBookIndices())
+
+	var out interface{}
+	var err error
+	if *statusOnly {
+		out, err = svc.Status(context.Background())
+	} else if *rebuild {
+		out, err = svc.Rebuild(context.Background(), nil)
+	} else {
+		out, err = svc.Reindex(context.Background(), *version, nil)
+	}
+	if err != nil {
+		log.Fatalf("reindex failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		log.Fatalf("failed to write result: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Command releval measures book search relevance against a judgment list and
+// optionally compares two search configurations side by side.
+//
+//	go run ./cmd/releval -judgments judgments.json              # current settings
+//	go run ./cmd/releval -judgments judgments.json -k 5 \
+//	    -a baseline.json -b tuned.json                          # compare two configs
+//	go run ./cmd/releval -judgments judgments.json -json        # machine-readable
+//
+// Queries go through BookService.SearchBooks, so the scores reflect
+// everything a real search does, and the metrics are computed locally.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"go-elastic/database"
+	"go-elastic/relevance"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	judgmentsPath := flag.String("judgments", "", "JSON judgment list (required)")
+	k := flag.Int("k", 10, "number of hits scored per query (1-100)")
+	configA := flag.String("a", "", "search configuration to evaluate (default: the current settings)")
+	configB := flag.String("b", "", "second search configuration to compare with -a")
+	asJSON := flag.Bool("json", false, "print the reports as JSON")
+	flag.Parse()
+
+	if *judgmentsPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *k < 1 || *k > 100 {
+		log.Fatalf("-k must be between 1 and 100")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using defaults")
+	}
+
+	judgments, err := readJudgments(*judgmentsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	configs := []relevance.Config{{Name: "current"}}
+	if *configA != "" {
+		if configs[0], err = readConfig(*configA); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *configB != "" {
+		cfg, err := readConfig(*configB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+	if err := database.InitElasticsearch(); err != nil {
+		log.Fatal(err)
+	}
+
+	bookRepo := repository.NewBookRepository(database.DB.Collection("books"), nil)
+	base := service.LoadSearchConfig()
+
+	reports := make([]*relevance.Report, 0, len(configs))
+	for _, cfg := range configs {
+		svc := service.NewBookService(bookRepo, cfg.Apply(base))
+		report, err := relevance.Evaluate(context.Background(), svc, cfg, judgments, *k)
+		if err != nil {
+			log.Fatalf("evaluation failed: %v", err)
+		}
+		reports = append(reports, report)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(reports)
+	} else {
+		err = relevance.WriteTable(os.Stdout, reports...)
+	}
+	if err != nil {
+		log.Fatalf("failed to write result: %v", err)
+	}
+}
+
+func readJudgments(path string) ([]relevance.Judgment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return relevance.ReadJudgments(f)
+}
+
+// readConfig reads a configuration file, naming it after the file when it
+// has no name.
+func readConfig(path string) (relevance.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return relevance.Config{}, err
+	}
+	defer f.Close()
+
+	cfg, err := relevance.ReadConfig(f)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Name == "" {
+		cfg.Name = path
+	}
+	return cfg, nil
+}
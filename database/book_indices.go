@@ -0,0 +1,435 @@
+package database
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go-elastic/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Books are stored in versioned physical indices (books_v1, books_v2, ...).
+// An index rebuilt with the mapping version it already has gets a generation
+// suffix (books_v8_2, books_v8_3, ...). Searches go through the read alias
+// and writes through the write alias, so a reindex can swap both to a new
+// index atomically.
+const (
+	BooksReadAlias  = "books"
+	BooksWriteAlias = "books_write"
+)
+
+//go:embed mappings/books_v*.json
+var mappingFiles embed.FS
+
+var bookIndexPattern = regexp.MustCompile(`^books_v(\d+)(?:_(\d+))?$`)
+
+// BookIndexName returns the physical index name for a mapping version
+func BookIndexName(version int) string {
+	return fmt.Sprintf("books_v%d", version)
+}
+
+// NextBookIndexName returns the physical index to build for a mapping
+// version while live is behind the aliases: a new generation of live if it
+// has that version already, BookIndexName otherwise.
+func NextBookIndexName(version int, live string) string {
+	if !bookIndexPattern.MatchString(live) || BookIndexVersion(live) != version {
+		return BookIndexName(version)
+	}
+	_, generation := bookIndexGeneration(live)
+	return fmt.Sprintf("books_v%d_%d", version, generation+1)
+}
+
+// BookIndexVersion parses the mapping version from a physical index name. It
+// returns 0 for the unversioned legacy "books" index.
+func BookIndexVersion(indexName string) int {
+	m := bookIndexPattern.FindStringSubmatch(indexName)
+	if m == nil {
+		return 0
+	}
+	version, _ := strconv.Atoi(m[1])
+	return version
+}
+
+// BookMappingVersions lists the versions in database/mappings, ascending
+func BookMappingVersions() []int {
+	entries, _ := mappingFiles.ReadDir("mappings")
+	var versions []int
+	for _, e := range entries {
+		if v := BookIndexVersion(strings.TrimSuffix(e.Name(), ".json")); v > 0 {
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// LatestBookMappingVersion returns the highest mapping version
+func LatestBookMappingVersion() int {
+	versions := BookMappingVersions()
+	return versions[len(versions)-1]
+}
+
+// BookMapping returns the index definition (settings and mappings) for a version
+func BookMapping(version int) ([]byte, error) {
+	body, err := mappingFiles.ReadFile(fmt.Sprintf("mappings/books_v%d.json", version))
+	if err != nil {
+		return nil, fmt.Errorf("unknown book mapping version %d", version)
+	}
+	return body, nil
+}
+
+// EnsureBookIndex makes sure the read and write aliases resolve to an index
+// and returns its name. On an empty cluster it creates the latest version.
+// An unversioned "books" index from before aliases were introduced is kept
+// and given the write alias until it is replaced by a reindex.
+func EnsureBookIndex() (string, error) {
+	current, err := CurrentBookIndex()
+	if err != nil {
+		return "", err
+	}
+
+	switch current {
+	case "":
+		name := BookIndexName(LatestBookMappingVersion())
+		return name, CreateBookIndex(name, LatestBookMappingVersion(), true)
+	case BooksReadAlias:
+		actions := []map[string]interface{}{
+			{"add": map[string]interface{}{"index": BooksReadAlias, "alias": BooksWriteAlias}},
+		}
+		return current, updateAliases(actions)
+	default:
+		return current, nil
+	}
+}
+
+// CurrentBookIndex returns the physical index searches currently hit: the
+// target of the read alias, "books" for the legacy unversioned index, or ""
+// if there is neither.
+func CurrentBookIndex() (string, error) {
+	live, err := LiveBookIndex()
+	if err != nil || live != "" {
+		return live, err
+	}
+
+	exists, err := IndexExists(BooksReadAlias)
+	if err != nil || !exists {
+		return "", err
+	}
+	return BooksReadAlias, nil
+}
+
+// IndexExists reports whether an index or alias with the given name exists
+func IndexExists(name string) (bool, error) {
+	res, err := ESClient.Indices.Exists([]string{name})
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return res.StatusCode == 200, nil
+}
+
+// CreateBookIndex creates a physical book index from a mapping version. With
+// withAliases set the index is created with both aliases attached.
+func CreateBookIndex(name string, version int, withAliases bool) error {
+	mapping, err := BookMapping(version)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(mapping, []byte(BooksSynonymsSet)) {
+		if err := EnsureBooksSynonymsSet(); err != nil {
+			return err
+		}
+	}
+
+	body := mapping
+	if withAliases {
+		var definition map[string]interface{}
+		if err := json.Unmarshal(mapping, &definition); err != nil {
+			return err
+		}
+		definition["aliases"] = map[string]interface{}{
+			BooksReadAlias:  map[string]interface{}{},
+			BooksWriteAlias: map[string]interface{}{"is_write_index": true},
+		}
+		if body, err = json.Marshal(definition); err != nil {
+			return err
+		}
+	}
+
+	res, err := ESClient.Indices.Create(name, ESClient.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating index %s: %s", name, res.String())
+	}
+	return nil
+}
+
+// DeleteIndex deletes a physical index. A missing index is not an error.
+func DeleteIndex(name string) error {
+	res, err := ESClient.Indices.Delete([]string{name})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error deleting index %s: %s", name, res.String())
+	}
+	return nil
+}
+
+// OldBookIndices returns the versioned book indices other than live that
+// are past the keep most recent ones, by mapping version and generation
+func OldBookIndices(indices []string, live string, keep int) []string {
+	var others []string
+	for _, name := range indices {
+		if name != live && bookIndexPattern.MatchString(name) {
+			others = append(others, name)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		vi, gi := bookIndexGeneration(others[i])
+		vj, gj := bookIndexGeneration(others[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return gi > gj
+	})
+	if keep >= len(others) {
+		return nil
+	}
+	return others[max(keep, 0):]
+}
+
+// bookIndexGeneration returns the mapping version and generation of a
+// versioned book index; books_v8 is generation 1.
+func bookIndexGeneration(name string) (int, int) {
+	m := bookIndexPattern.FindStringSubmatch(name)
+	version, _ := strconv.Atoi(m[1])
+	generation := 1
+	if m[2] != "" {
+		generation, _ = strconv.Atoi(m[2])
+	}
+	return version, generation
+}
+
+// DeleteOldBookIndices deletes the versioned book indices past the keep most
+// recent ones besides live, and returns their names
+func DeleteOldBookIndices(live string, keep int) ([]string, error) {
+	res, err := ESClient.Indices.Get([]string{"books_v*"})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error listing book indices: %s", res.String())
+	}
+	var found map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(found))
+	for name := range found {
+		indices = append(indices, name)
+	}
+
+	var deleted []string
+	for _, name := range OldBookIndices(indices, live, keep) {
+		if err := DeleteIndex(name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// RefreshIndex makes recent writes to an index visible to search
+func RefreshIndex(name string) error {
+	res, err := ESClient.Indices.Refresh(ESClient.Indices.Refresh.WithIndex(name))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error refreshing index %s: %s", name, res.String())
+	}
+	return nil
+}
+
+// LiveBookIndex returns the index behind the read alias, or "" if the alias
+// does not exist.
+func LiveBookIndex() (string, error) {
+	res, err := ESClient.Indices.GetAlias(ESClient.Indices.GetAlias.WithName(BooksReadAlias))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return "", nil
+	}
+	if res.IsError() {
+		return "", fmt.Errorf("error resolving alias %s: %s", BooksReadAlias, res.String())
+	}
+
+	var indices map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return "", err
+	}
+	for name := range indices {
+		return name, nil
+	}
+	return "", nil
+}
+
+// SwapBookAliases points both aliases at newIndex in one atomic request. If
+// the old index is the unversioned legacy "books" index it is deleted in the
+// same request, because an alias cannot share its name with an index.
+func SwapBookAliases(oldIndex, newIndex string) error {
+	var actions []map[string]interface{}
+	switch oldIndex {
+	case "":
+	case BooksReadAlias:
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": oldIndex}})
+	default:
+		actions = append(actions,
+			map[string]interface{}{"remove": map[string]interface{}{"index": oldIndex, "alias": BooksReadAlias}},
+			map[string]interface{}{"remove": map[string]interface{}{"index": oldIndex, "alias": BooksWriteAlias}},
+		)
+	}
+	actions = append(actions,
+		map[string]interface{}{"add": map[string]interface{}{"index": newIndex, "alias": BooksReadAlias}},
+		map[string]interface{}{"add": map[string]interface{}{"index": newIndex, "alias": BooksWriteAlias, "is_write_index": true}},
+	)
+	return updateAliases(actions)
+}
+
+func updateAliases(actions []map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	res, err := ESClient.Indices.UpdateAliases(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error updating aliases: %s", res.String())
+	}
+	return nil
+}
+
+// LiveMapping returns the "mappings" section of an index as stored in the cluster
+func LiveMapping(indexName string) (map[string]interface{}, error) {
+	res, err := ESClient.Indices.GetMapping(ESClient.Indices.GetMapping.WithIndex(indexName))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error getting mapping for %s: %s", indexName, res.String())
+	}
+
+	var indices map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, err
+	}
+	for _, index := range indices {
+		return index.Mappings, nil
+	}
+	return nil, fmt.Errorf("no mapping returned for %s", indexName)
+}
+
+// DiffMappings compares the field definitions in two "mappings" sections.
+// A change with an empty Have is a field the live index lacks; an empty Want
+// is a field that is only in the live index.
+func DiffMappings(want, have map[string]interface{}) []models.MappingChange {
+	wantFields := flattenMapping(want)
+	haveFields := flattenMapping(have)
+
+	changes := []models.MappingChange{}
+	for field, w := range wantFields {
+		if h := haveFields[field]; h != w {
+			changes = append(changes, models.MappingChange{Field: field, Want: w, Have: h})
+		}
+	}
+	for field, h := range haveFields {
+		if _, ok := wantFields[field]; !ok {
+			changes = append(changes, models.MappingChange{Field: field, Have: h})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// flattenMapping maps each field path to a canonical JSON encoding of its
+// definition without nested properties or multi-fields, which get their own
+// entries.
+func flattenMapping(mappings map[string]interface{}) map[string]string {
+	fields := make(map[string]string)
+	var walk func(prefix string, properties map[string]interface{})
+	walk = func(prefix string, properties map[string]interface{}) {
+		for name, raw := range properties {
+			def, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := prefix + name
+
+			own := make(map[string]interface{})
+			for k, v := range def {
+				if k != "properties" && k != "fields" {
+					own[k] = v
+				}
+			}
+			if _, ok := own["type"]; !ok {
+				own["type"] = "object"
+			}
+			encoded, _ := json.Marshal(own)
+			fields[path] = string(encoded)
+
+			if nested, ok := def["properties"].(map[string]interface{}); ok {
+				walk(path+".", nested)
+			}
+			if multi, ok := def["fields"].(map[string]interface{}); ok {
+				walk(path+".", multi)
+			}
+		}
+	}
+	if properties, ok := mappings["properties"].(map[string]interface{}); ok {
+		walk("", properties)
+	}
+	return fields
+}
+
+// FileMapping returns the "mappings" section of a versioned mapping file
+func FileMapping(version int) (map[string]interface{}, error) {
+	body, err := BookMapping(version)
+	if err != nil {
+		return nil, err
+	}
+
+	var definition struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err := json.Unmarshal(body, &definition); err != nil {
+		return nil, fmt.Errorf("invalid book mapping v%d: %w", version, err)
+	}
+	return definition.Mappings, nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"encoding/json"
+	"go-elastic/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookMappingFiles(t *testing.T) {
+	versions := BookMappingVersions()
+	require.NotEmpty(t, versions)
+	assert.Equal(t, versions[len(versions)-1], LatestBookMappingVersion())
+
+	for _, v := range versions {
+		_, err := FileMapping(v)
+		assert.NoError(t, err, "books_v%d.json", v)
+	}
+}
+
+func TestBookIndexVersion(t *testing.T) {
+	assert.Equal(t, 3, BookIndexVersion(BookIndexName(3)))
+	assert.Equal(t, 0, BookIndexVersion("books"))
+	assert.Equal(t, 0, BookIndexVersion("books_v2_old"))
+	assert.Equal(t, 8, BookIndexVersion("books_v8_2"))
+}
+
+func TestNextBookIndexName(t *testing.T) {
+	assert.Equal(t, "books_v8", NextBookIndexName(8, "books_v7_3"))
+	assert.Equal(t, "books_v8", NextBookIndexName(8, "books"))
+	assert.Equal(t, "books_v8_2", NextBookIndexName(8, "books_v8"))
+	assert.Equal(t, "books_v8_4", NextBookIndexName(8, "books_v8_3"))
+}
+
+func TestOldBookIndices(t *testing.T) {
+	indices := []string{"books_v7", "books_v8_3", "books_v8", "books_v8_2", "books_v10", "books_v9", "books_events", "books_v8_old"}
+
+	assert.Equal(t, []string{"books_v8_3", "books_v8_2", "books_v8", "books_v7"}, OldBookIndices(indices, "books_v10", 1))
+	assert.Equal(t, []string{"books_v8", "books_v7"}, OldBookIndices(indices, "books_v10", 3))
+	assert.Equal(t, []string{"books_v10", "books_v9", "books_v8_3", "books_v8", "books_v7"}, OldBookIndices(indices, "books_v8_2", 0),
+		"all others go, newer ones too")
+	assert.Empty(t, OldBookIndices(indices, "books_v10", 5))
+}
+
+func TestDiffMappings(t *testing.T) {
+	var want, have map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"properties": {
+		"title": {"type": "text"},
+		"author": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+		"pages": {"type": "integer"}
+	}}`), &want))
+	require.NoError(t, json.Unmarshal([]byte(`{"properties": {
+		"title": {"type": "text"},
+		"author": {"type": "text"},
+		"pages": {"type": "long"},
+		"id": {"type": "keyword"}
+	}}`), &have))
+
+	changes := DiffMappings(want, have)
+
+	assert.Equal(t, []models.MappingChange{
+		{Field: "author.keyword", Want: `{"type":"keyword"}`},
+		{Field: "id", Have: `{"type":"keyword"}`},
+		{Field: "pages", Want: `{"type":"integer"}`, Have: `{"type":"long"}`},
+	}, changes)
+	assert.Empty(t, DiffMappings(want, want))
+}
@@ -2,15 +2,20 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 )
 
 var ESClient *elasticsearch.Client
 
-func InitElasticsearch() {
+// InitElasticsearch creates the client and checks the connection. The client
+// is usable even when the check fails, so the app can start while
+// Elasticsearch is down and search degrades until it comes back.
+func InitElasticsearch() error {
 	var err error
 
 	esURL := os.Getenv("ELASTICSEARCH_URL")
@@ -28,17 +33,28 @@ func InitElasticsearch() {
 	}
 
 	// Verify connection
-	res, err := ESClient.Info()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := PingElasticsearch(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("Elasticsearch connection established")
+	return nil
+}
+
+// PingElasticsearch checks that Elasticsearch answers
+func PingElasticsearch(ctx context.Context) error {
+	res, err := ESClient.Ping(ESClient.Ping.WithContext(ctx))
 	if err != nil {
-		panic("Failed to connect to Elasticsearch: " + err.Error())
+		return fmt.Errorf("failed to connect to Elasticsearch: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		panic("Elasticsearch error: " + res.Status())
+		return fmt.Errorf("elasticsearch error: %s", res.Status())
 	}
-
-	fmt.Println("Elasticsearch connection established")
+	return nil
 }
 
 // CreateIndexIfNotExists creates an index if it doesn't exist
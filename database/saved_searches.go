@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Saved searches are stored as percolator queries in saved_searches_vN,
+// which has the fields and analyzers of books mapping version N so that the
+// queries are parsed the way searches of the books index are.
+const savedSearchIndexPrefix = "saved_searches_v"
+
+// SavedSearchIndexName returns the percolator index for a book mapping version
+func SavedSearchIndexName(version int) string {
+	return fmt.Sprintf("%s%d", savedSearchIndexPrefix, version)
+}
+
+// EnsureSavedSearchIndex returns the percolator index for the latest book
+// mapping, creating it if it does not exist. created reports whether it was
+// just created, in which case every saved search must be registered in it.
+func EnsureSavedSearchIndex() (name string, created bool, err error) {
+	version := LatestBookMappingVersion()
+	name = SavedSearchIndexName(version)
+
+	exists, err := IndexExists(name)
+	if err != nil || exists {
+		return name, false, err
+	}
+
+	mapping, err := BookMapping(version)
+	if err != nil {
+		return name, false, err
+	}
+	if bytes.Contains(mapping, []byte(BooksSynonymsSet)) {
+		if err := EnsureBooksSynonymsSet(); err != nil {
+			return name, false, err
+		}
+	}
+
+	var definition struct {
+		Settings map[string]interface{} `json:"settings,omitempty"`
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(mapping, &definition); err != nil {
+		return name, false, fmt.Errorf("invalid book mapping v%d: %w", version, err)
+	}
+	definition.Mappings.Properties["query"] = map[string]interface{}{"type": "percolator"}
+	definition.Mappings.Properties["user_id"] = map[string]interface{}{"type": "keyword"}
+
+	body, err := json.Marshal(definition)
+	if err != nil {
+		return name, false, err
+	}
+
+	res, err := ESClient.Indices.Create(name, ESClient.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return name, false, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return name, false, fmt.Errorf("error creating index %s: %s", name, res.String())
+	}
+	return name, true, nil
+}
+
+// DeleteOldSavedSearchIndices deletes the percolator indices of earlier book
+// mapping versions
+func DeleteOldSavedSearchIndices() error {
+	latest := LatestBookMappingVersion()
+	for _, version := range BookMappingVersions() {
+		if version == latest {
+			continue
+		}
+		if err := DeleteIndex(SavedSearchIndexName(version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
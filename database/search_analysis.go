@@ -0,0 +1,174 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Search-time synonyms and stopwords are applied by the books_synonyms and
+// books_stopwords filters of the search analyzers added in mapping version 7.
+// Synonyms are read from an Elasticsearch synonyms set, which reloads the
+// analyzers using it whenever it is replaced. Stopwords live in the index
+// settings, so new ones take a new index.
+const (
+	BooksSynonymsSet        = "books-synonyms"
+	booksStopwordsFilter    = "books_stopwords"
+	booksStopwordsNoneValue = "_none_"
+)
+
+// SynonymRule is one rule of a synonyms set in Solr format, either
+// "sci-fi, science fiction" or "vol => volume".
+type SynonymRule struct {
+	ID       string `json:"id"`
+	Synonyms string `json:"synonyms"`
+}
+
+// EnsureBooksSynonymsSet creates an empty books synonyms set if there is
+// none, since an index cannot be created with a filter naming a missing set.
+func EnsureBooksSynonymsSet() error {
+	res, err := ESClient.SynonymsGetSynonym(BooksSynonymsSet, ESClient.SynonymsGetSynonym.WithSize(1))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return PutBooksSynonyms(nil)
+	}
+	if res.IsError() {
+		return fmt.Errorf("error reading synonyms set %s: %s", BooksSynonymsSet, res.String())
+	}
+	return nil
+}
+
+// PutBooksSynonyms replaces the rules of the books synonyms set. Elasticsearch
+// validates the rules and reloads the search analyzers of every index that
+// uses the set before it responds.
+func PutBooksSynonyms(rules []SynonymRule) error {
+	if rules == nil {
+		rules = []SynonymRule{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"synonyms_set": rules})
+	if err != nil {
+		return err
+	}
+
+	res, err := ESClient.SynonymsPutSynonym(BooksSynonymsSet, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error updating synonyms set %s: %s", BooksSynonymsSet, res.String())
+	}
+	return nil
+}
+
+// BookIndexStopwords returns the words removed by the books_stopwords filter
+// of an index. ok is false if the index has no such filter, which is the
+// case before mapping version 7.
+func BookIndexStopwords(indexName string) (words []string, ok bool, err error) {
+	res, err := ESClient.Indices.GetSettings(
+		ESClient.Indices.GetSettings.WithIndex(indexName),
+		ESClient.Indices.GetSettings.WithName("index.analysis.filter."+booksStopwordsFilter+".*"),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, false, fmt.Errorf("error getting settings for %s: %s", indexName, res.String())
+	}
+
+	var indices map[string]struct {
+		Settings struct {
+			Index struct {
+				Analysis struct {
+					Filter map[string]struct {
+						Stopwords json.RawMessage `json:"stopwords"`
+					} `json:"filter"`
+				} `json:"analysis"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, false, err
+	}
+	for _, index := range indices {
+		filter, ok := index.Settings.Index.Analysis.Filter[booksStopwordsFilter]
+		if !ok {
+			return nil, false, nil
+		}
+		return decodeStopwords(filter.Stopwords), true, nil
+	}
+	return nil, false, nil
+}
+
+// decodeStopwords reads a stopwords setting, which is a list of words or a
+// single predefined list name such as _none_.
+func decodeStopwords(raw json.RawMessage) []string {
+	var words []string
+	if err := json.Unmarshal(raw, &words); err == nil {
+		return words
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil && name != booksStopwordsNoneValue && name != "" {
+		return []string{name}
+	}
+	return []string{}
+}
+
+// SetBookIndexStopwords replaces the words of the books_stopwords filter.
+// Analysis settings can only be changed on a closed index, so the index is
+// closed for the duration of the update and can be neither searched nor
+// written meanwhile; only use it on an index that is not behind the aliases
+// yet. It is reopened even if the update fails.
+func SetBookIndexStopwords(indexName string, words []string) (err error) {
+	var stopwords interface{} = words
+	if len(words) == 0 {
+		stopwords = booksStopwordsNoneValue
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"index.analysis.filter." + booksStopwordsFilter + ".type":      "stop",
+		"index.analysis.filter." + booksStopwordsFilter + ".stopwords": stopwords,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := ESClient.Indices.Close([]string{indexName})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error closing %s: %s", indexName, res.String())
+	}
+
+	defer func() {
+		res, openErr := ESClient.Indices.Open([]string{indexName}, ESClient.Indices.Open.WithWaitForActiveShards("1"))
+		if openErr == nil {
+			defer res.Body.Close()
+			if res.IsError() {
+				openErr = fmt.Errorf("error reopening %s: %s", indexName, res.String())
+			}
+		}
+		if err == nil {
+			err = openErr
+		}
+	}()
+
+	res, err = ESClient.Indices.PutSettings(bytes.NewReader(body), ESClient.Indices.PutSettings.WithIndex(indexName))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error updating stopwords of %s: %s", indexName, res.String())
+	}
+	return nil
+}
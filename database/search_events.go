@@ -0,0 +1,16 @@
+package database
+
+import _ "embed"
+
+// SearchEventsIndex stores search analytics: one document per search and
+// per result click.
+const SearchEventsIndex = "search_events"
+
+//go:embed mappings/search_events.json
+var searchEventsMapping string
+
+// EnsureSearchEventsIndex creates the search analytics index if it does not
+// exist yet
+func EnsureSearchEventsIndex() error {
+	return CreateIndexIfNotExists(SearchEventsIndex, searchEventsMapping)
+}
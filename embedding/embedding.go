@@ -0,0 +1,44 @@
+// Package embedding turns text into dense vectors for semantic search.
+package embedding
+
+import (
+	"context"
+	"math"
+)
+
+// Embedder maps texts to vectors of Dims() dimensions. Texts with similar
+// content should get vectors with a high cosine similarity. Vectors from
+// different embedders, or different dimensions, are not comparable, so the
+// books index has to be rebuilt when the embedder changes.
+type Embedder interface {
+	// Embed returns one vector per text, in order. A text without any
+	// content may get a zero vector.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Dims() int
+}
+
+// IsZero reports whether v has no magnitude. Elasticsearch rejects zero
+// vectors for cosine similarity, so they are left out of the index.
+func IsZero(v []float32) bool {
+	for _, x := range v {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize scales v to unit length in place
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
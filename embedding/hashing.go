@@ -0,0 +1,104 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// DefaultDims is the vector size of the default embedder. It must match the
+// dims of the embedding field in the books mapping.
+const DefaultDims = 256
+
+// Feature weights relative to a whole word. Character trigrams let
+// "distributed" and "distribution" share most of their features; word pairs
+// reward phrases such as "distributed systems".
+const (
+	trigramWeight  = 0.5
+	wordPairWeight = 0.7
+)
+
+// HashingEmbedder is a dependency-free embedder that runs on the CPU and
+// needs no model files. It hashes words, word pairs and character trigrams
+// into a fixed number of dimensions (the "hashing trick") with log-scaled
+// term frequencies. It captures shared words and word forms across languages
+// but not meaning: "car" and "automobile" are unrelated to it. Use an
+// Embedder backed by a language model for that.
+type HashingEmbedder struct {
+	dims int
+}
+
+// NewHashingEmbedder returns a HashingEmbedder producing vectors of dims
+// dimensions.
+func NewHashingEmbedder(dims int) *HashingEmbedder {
+	return &HashingEmbedder{dims: dims}
+}
+
+func (e *HashingEmbedder) Dims() int {
+	return e.dims
+}
+
+func (e *HashingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+func (e *HashingEmbedder) embed(text string) []float32 {
+	features := make(map[string]float64)
+	words := tokenize(text)
+	for i, word := range words {
+		features[word]++
+		padded := []rune("^" + word + "$")
+		for j := 0; j+3 <= len(padded); j++ {
+			features["#"+string(padded[j:j+3])] += trigramWeight
+		}
+		if i > 0 {
+			features[words[i-1]+" "+word] += wordPairWeight
+		}
+	}
+
+	v := make([]float32, e.dims)
+	for feature, weight := range features {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// The top bit picks the sign, so collisions cancel out on average
+		// instead of piling up.
+		sign := float32(1)
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		v[sum%uint64(e.dims)] += sign * float32(1+math.Log(1+weight))
+	}
+	normalize(v)
+	return v
+}
+
+// tokenize lower-cases text and splits it into runs of letters and digits,
+// dropping common English function words.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+	words := fields[:0]
+	for _, word := range fields {
+		if !stopwords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "with": true,
+}
@@ -0,0 +1,51 @@
+package embedding
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestHashingEmbedder(t *testing.T) {
+	e := NewHashingEmbedder(DefaultDims)
+	vectors, err := e.Embed(context.Background(), []string{
+		"Designing distributed systems",
+		"Patterns for distribution of systems and services",
+		"A cookbook of Thai desserts",
+		"Designing distributed systems",
+		"the of and",
+	})
+	require.NoError(t, err)
+	require.Len(t, vectors, 5)
+
+	for _, v := range vectors[:4] {
+		assert.Len(t, v, DefaultDims)
+		var norm float64
+		for _, x := range v {
+			norm += float64(x) * float64(x)
+		}
+		assert.InDelta(t, 1, math.Sqrt(norm), 1e-5, "unit length")
+	}
+
+	assert.Equal(t, vectors[0], vectors[3], "deterministic")
+	assert.Greater(t, cosine(vectors[0], vectors[1]), cosine(vectors[0], vectors[2]), "shared word forms score higher")
+	assert.True(t, IsZero(vectors[4]), "only stopwords")
+}
+
+func TestHashingEmbedder_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewHashingEmbedder(8).Embed(ctx, []string{"go"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
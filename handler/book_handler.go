@@ -1,19 +1,33 @@
 package handler
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-elastic/bookformat"
 	"go-elastic/models"
+	"go-elastic/querylang"
+	"go-elastic/repository"
 	"go-elastic/service"
+	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type BookHandler struct {
-	svc service.BookService
+	svc       service.BookService
+	analytics service.SearchAnalyticsService
 }
 
-func NewBookHandler(svc service.BookService) *BookHandler {
-	return &BookHandler{svc: svc}
+// NewBookHandler returns a BookHandler. Searches are logged to analytics
+// unless it is nil.
+func NewBookHandler(svc service.BookService, analytics service.SearchAnalyticsService) *BookHandler {
+	return &BookHandler{svc: svc, analytics: analytics}
 }
 
 func (h *BookHandler) CreateBook(c *fiber.Ctx) error {
@@ -27,8 +41,8 @@ func (h *BookHandler) CreateBook(c *fiber.Ctx) error {
 	}
 
 	// Validate required fields
-	if book.Title == "" || book.Author == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Title and Author are required"})
+	if err := book.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	// Set timestamps if not provided
@@ -60,27 +74,490 @@ func (h *BookHandler) GetBook(c *fiber.Ctx) error {
 	return c.JSON(book)
 }
 
+func (h *BookHandler) UpdateBook(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	book := new(models.Book)
+	if err := c.BodyParser(book); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	if err := book.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	book.ID = id
+	if err := h.svc.UpdateBook(c.UserContext(), book); err != nil {
+		return writeBookError(c, err)
+	}
+
+	return c.JSON(book)
+}
+
+func (h *BookHandler) PatchBook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	patch := new(models.BookPatch)
+	if err := c.BodyParser(patch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	if patch.IsEmpty() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No fields to update"})
+	}
+	if (patch.Title != nil && *patch.Title == "") || (patch.Author != nil && *patch.Author == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Title and Author cannot be empty"})
+	}
+
+	book, err := h.svc.PatchBook(c.UserContext(), id, patch)
+	if err != nil {
+		return writeBookError(c, err)
+	}
+
+	return c.JSON(book)
+}
+
+func (h *BookHandler) DeleteBook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := h.svc.DeleteBook(c.UserContext(), id); err != nil {
+		return writeBookError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// writeBookError maps repository errors from book writes to HTTP responses.
+func writeBookError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, repository.ErrBookNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Book not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
 func (h *BookHandler) GetAllBooks(c *fiber.Ctx) error {
-	books, err := h.svc.GetAllBooks(c.UserContext())
+	opts := models.BookListOptions{Sort: models.ParseSortField(c.Query("sort"))}
+	var err error
+	if opts.Page, err = queryInt(c, "page"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if opts.PerPage, err = queryInt(c, "per_page"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	list, err := h.svc.GetAllBooks(c.UserContext(), opts)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidListOptions) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(books)
+	setPageHeaders(c, list.Total, list.Page, list.PerPage, list.Total)
+	return c.JSON(list.Books)
 }
 
 func (h *BookHandler) SearchBooks(c *fiber.Ctx) error {
-	searchType := c.Query("type", "") // "title" or "author"
-	searchQuery := c.Query("q", "")
+	start := time.Now()
+	var params models.BookSearch
+	var result *models.BookSearchResult
+	var err error
+	defer func() { h.recordSearch(c, params, result, err, time.Since(start)) }()
 
-	if searchType == "" || searchQuery == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing query parameters: type and q"})
+	params, err = parseSearchParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
+	params.Type = c.Query("type", models.SearchTypeMulti) // "title", "author", "multi" or "query"
+	params.Mode = c.Query("mode")                         // "keyword", "semantic" or "hybrid"
+	params.Query = c.Query("q", "")
+	params.Match = c.Query("match", "") // "phrase" or "prefix"
+	params.MinimumShouldMatch = c.Query("minimum_should_match", "")
+	params.Fuzziness = c.Query("fuzziness") // "AUTO", "0", "1" or "2"
 
-	books, err := h.svc.SearchBooks(c.UserContext(), searchType, searchQuery)
+	if params.Query == "" && params.Filters.IsEmpty() {
+		err = errors.New("Missing query parameter: q (or at least one filter)")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err = h.svc.SearchBooks(c.UserContext(), params)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return writeSearchError(c, err)
+	}
+	return writeSearchResult(c, params, result)
+}
+
+// recordSearch logs a search to analytics with the status it was answered
+// with. The q parameter is read directly so that searches rejected before
+// parsing finished are logged with their query too. Strings are copied, as
+// Fiber reuses the request buffers they point into once the handler returns
+// and the event is written later.
+func (h *BookHandler) recordSearch(c *fiber.Ctx, params models.BookSearch, result *models.BookSearchResult, err error, latency time.Duration) {
+	if h.analytics == nil {
+		return
+	}
+
+	event := models.SearchEvent{
+		RequestID: requestID(c),
+		UserID:    userID(c),
+		Query:     strings.Clone(c.Query("q")),
+		Type:      strings.Clone(params.Type),
+		Mode:      strings.Clone(params.Mode),
+		Match:     strings.Clone(params.Match),
+		Filters:   models.NewSearchFilters(params.Filters),
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+		Status:    c.Response().StatusCode(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if result != nil {
+		event.Hits = result.Total
+		event.Page = result.Page
+		event.PerPage = result.PerPage
+	}
+	h.analytics.RecordSearch(c.UserContext(), event)
+}
+
+// SimilarBooks returns books like the one in the path, with the same
+// filters, paging and highlighting as SearchBooks.
+func (h *BookHandler) SimilarBooks(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	params, err := parseSearchParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.svc.SimilarBooks(c.UserContext(), id, params)
+	if err != nil {
+		if errors.Is(err, repository.ErrBookNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Book not found"})
+		}
+		return writeSearchError(c, err)
+	}
+	return writeSearchResult(c, params, result)
+}
+
+// parseSearchParams reads the parameters shared by every search endpoint:
+// filters, page, per_page, sort, cursor and highlight.
+func parseSearchParams(c *fiber.Ctx) (models.BookSearch, error) {
+	filters, err := parseBookFilters(c)
+	if err != nil {
+		return models.BookSearch{}, err
+	}
+
+	params := models.BookSearch{
+		Filters: filters,
+		Sort:    models.ParseSortField(c.Query("sort")),
+		Cursor:  c.Query("cursor"),
+	}
+	if params.Page, err = queryInt(c, "page"); err != nil {
+		return params, err
+	}
+	if params.PerPage, err = queryInt(c, "per_page"); err != nil {
+		return params, err
+	}
+	if params.Highlight, err = queryBool(c, "highlight"); err != nil {
+		return params, err
 	}
+	if params.Cursor != "" && params.Page != 0 {
+		return params, errors.New("page cannot be combined with cursor")
+	}
+	return params, nil
+}
+
+func writeSearchError(c *fiber.Ctx, err error) error {
+	var syntaxErr *querylang.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "position": syntaxErr.Pos})
+	}
+	if errors.Is(err, service.ErrInvalidSearch) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if errors.Is(err, repository.ErrSearchUnavailable) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+// degradedHeader marks search responses answered from MongoDB because
+// Elasticsearch was unavailable
+const degradedHeader = "X-Search-Degraded"
+
+// writeSearchResult sends a search result with its paging headers
+func writeSearchResult(c *fiber.Ctx, params models.BookSearch, result *models.BookSearchResult) error {
+	if result.Degraded {
+		c.Set(degradedHeader, "true")
+	}
+	if params.Cursor == "" {
+		// Offset paging stops at the result window; next_cursor goes further.
+		window := int64(service.MaxResultWindow)
+		if params.Mode == models.SearchModeSemantic || params.Mode == models.SearchModeHybrid {
+			window = service.MaxSemanticWindow
+		}
+		setPageHeaders(c, result.Total, result.Page, result.PerPage, min(result.Total, window))
+	} else {
+		c.Set("X-Total-Count", strconv.FormatInt(result.Total, 10))
+	}
+	if result.NextCursor != "" && (params.Cursor != "" || int64(result.Page*result.PerPage) >= service.MaxResultWindow) {
+		c.Append("Link", pageLink(c, "next", map[string]string{"cursor": result.NextCursor, "page": ""}))
+	}
+
+	return c.JSON(result)
+}
+
+func (h *BookHandler) SuggestBooks(c *fiber.Ctx) error {
+	size, err := queryInt(c, "size")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	suggestions, err := h.svc.SuggestBooks(c.UserContext(), c.Query("q"), size)
+	if err != nil {
+		return writeSearchError(c, err)
+	}
+
+	return c.JSON(suggestions)
+}
+
+// exportBufferSize is how much of an export is buffered before it is sent
+// as a chunk
+const exportBufferSize = 32 << 10
+
+// ExportBooks streams the books matching q and the search filters, or every
+// book without them, as ?format=ndjson (the default), json or csv with the
+// fields in ?fields=, or as whole marc, marcxml, onix or bibtex records.
+// Books are written as they are read, so exports are not held in memory.
+// Once the response has started an error can no longer change its status;
+// the connection is closed before the final chunk instead, so clients see
+// an incomplete transfer rather than a short file.
+func (h *BookHandler) ExportBooks(c *fiber.Ctx) error {
+	format, err := bookformat.ParseFormat(c.Query("format", bookformat.NDJSON))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	fields, err := bookformat.ParseFields(c.Query("fields"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	filters, err := parseBookFilters(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	// The bibliographic formats only write whole records, which NewWriter
+	// checks before anything is sent.
+	reader, pipe := io.Pipe()
+	buf := bufio.NewWriterSize(pipe, exportBufferSize)
+	writer, err := bookformat.NewWriter(format, buf, fields)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// The export runs after the handler returns, when Fiber may reuse the
+	// buffers behind c.Query, so the strings are copied.
+	params := models.BookSearch{
+		Type:               strings.Clone(c.Query("type", models.SearchTypeMulti)),
+		Query:              strings.Clone(c.Query("q")),
+		Match:              strings.Clone(c.Query("match")),
+		MinimumShouldMatch: strings.Clone(c.Query("minimum_should_match")),
+		Fuzziness:          strings.Clone(c.Query("fuzziness")),
+		Filters:            filters,
+	}
+	ctx := c.UserContext()
+	export, err := h.svc.ExportBooks(ctx, params)
+	if err != nil {
+		return writeSearchError(c, err)
+	}
+
+	go func() {
+		err := export(ctx, writer.Write)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err == nil {
+			err = buf.Flush()
+		}
+		pipe.CloseWithError(err)
+	}()
+
+	c.Set(fiber.HeaderContentType, bookformat.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="books.%s"`, bookformat.Extension(format)))
+	return c.SendStream(reader)
+}
+
+// setPageHeaders sets X-Total-Count and RFC 5988 first/prev/next/last Link
+// headers. reachable is the number of results page/per_page can address.
+func setPageHeaders(c *fiber.Ctx, total int64, page, perPage int, reachable int64) {
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	last := int((reachable + int64(perPage) - 1) / int64(perPage))
+	if last < 1 {
+		last = 1
+	}
+
+	links := []string{pageLink(c, "first", map[string]string{"page": "1"})}
+	if page > 1 {
+		links = append(links, pageLink(c, "prev", map[string]string{"page": strconv.Itoa(min(page-1, last))}))
+	}
+	if page < last {
+		links = append(links, pageLink(c, "next", map[string]string{"page": strconv.Itoa(page + 1)}))
+	}
+	links = append(links, pageLink(c, "last", map[string]string{"page": strconv.Itoa(last)}))
+	c.Set("Link", strings.Join(links, ", "))
+}
+
+// pageLink formats a Link header entry for the current request URL with the
+// given query parameters replaced. An empty value removes the parameter.
+func pageLink(c *fiber.Ctx, rel string, set map[string]string) string {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	c.Context().QueryArgs().CopyTo(args)
+	for key, value := range set {
+		if value == "" {
+			args.Del(key)
+		} else {
+			args.Set(key, value)
+		}
+	}
+	return fmt.Sprintf("<%s%s?%s>; rel=\"%s\"", c.BaseURL(), c.Path(), args.QueryString(), rel)
+}
+
+// parseBookFilters reads the structured search filters from the query string:
+// language, author and publisher (exact values; repeat the parameter for
+// several, languages may also be comma-separated), pages_min, pages_max,
+// published_after and published_before (YYYY-MM-DD or RFC 3339) and
+// publish_year. Facet bucket values from a search response can be passed
+// back as-is.
+func parseBookFilters(c *fiber.Ctx) (models.BookFilters, error) {
+	var f models.BookFilters
+	for _, lang := range queryValues(c, "language") {
+		f.Languages = append(f.Languages, splitList(lang)...)
+	}
+	f.Authors = queryValues(c, "author")
+	f.Publishers = queryValues(c, "publisher")
+
+	var err error
+	if f.PagesMin, err = queryInt(c, "pages_min"); err != nil {
+		return f, err
+	}
+	if f.PagesMax, err = queryInt(c, "pages_max"); err != nil {
+		return f, err
+	}
+	if f.PublishedAfter, err = queryDate(c, "published_after", false); err != nil {
+		return f, err
+	}
+	if f.PublishedBefore, err = queryDate(c, "published_before", true); err != nil {
+		return f, err
+	}
+
+	year, err := queryInt(c, "publish_year")
+	if err != nil {
+		return f, err
+	}
+	if year > 0 {
+		if !f.PublishedAfter.IsZero() || !f.PublishedBefore.IsZero() {
+			return f, errors.New("publish_year cannot be combined with published_after or published_before")
+		}
+		f.PublishedAfter = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+		f.PublishedBefore = f.PublishedAfter.AddDate(1, 0, 0).Add(-time.Second)
+	}
+	return f, nil
+}
+
+// queryValues returns every non-blank value of a repeated query parameter
+func queryValues(c *fiber.Ctx, key string) []string {
+	var values []string
+	for _, raw := range c.Context().QueryArgs().PeekMulti(key) {
+		if v := strings.TrimSpace(string(raw)); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// splitList splits a comma-separated value, dropping blanks
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func queryInt(c *fiber.Ctx, key string) (int, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return n, nil
+}
+
+func queryBool(c *fiber.Ctx, key string) (bool, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", key)
+	}
+	return b, nil
+}
+
+// queryDate parses a date parameter. A bare date used as an upper bound
+// covers the whole day.
+func queryDate(c *fiber.Ctx, key string, endOfDay bool) (time.Time, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 timestamp", key)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// requestID returns the ID RequestIDMiddleware gave the request, if any
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("request_id").(string)
+	return id
+}
 
-	return c.JSON(books)
+// userID returns a copy of the caller's X-User-ID header, safe to keep after
+// the request. There is no authentication, so it is only as trustworthy as
+// the client sending it.
+func userID(c *fiber.Ctx) string {
+	return strings.Clone(strings.TrimSpace(c.Get("X-User-ID")))
 }
@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/querylang"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBookService implements service.BookService with a single canned error.
+// It records the last search it was asked to run and returns total as the
+// number of matches for listings and searches.
+type fakeBookService struct {
+	err        error
+	total      int64
+	degraded   bool
+	lastSearch models.BookSearch
+	// exported are the books an export streams before failing with
+	// exportErr, if set
+	exported  []models.Book
+	exportErr error
+}
+
+func (f *fakeBookService) CreateBook(ctx context.Context, book *models.Book) error {
+	return f.err
+}
+
+func (f *fakeBookService) GetBookByID(ctx context.Context, id string) (*models.Book, error) {
+	return &models.Book{}, f.err
+}
+
+func (f *fakeBookService) UpdateBook(ctx context.Context, book *models.Book) error {
+	return f.err
+}
+
+func (f *fakeBookService) PatchBook(ctx context.Context, id string, patch *models.BookPatch) (*models.Book, error) {
+	return &models.Book{}, f.err
+}
+
+func (f *fakeBookService) DeleteBook(ctx context.Context, id string) error {
+	return f.err
+}
+
+func (f *fakeBookService) GetAllBooks(ctx context.Context, opts models.BookListOptions) (*models.BookList, error) {
+	return &models.BookList{Books: []models.Book{}, Total: f.total, Page: orDefault(opts.Page, 1), PerPage: orDefault(opts.PerPage, 20)}, f.err
+}
+
+func (f *fakeBookService) SearchBooks(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	f.lastSearch = params
+	result := &models.BookSearchResult{Total: f.total, PerPage: orDefault(params.PerPage, 20), Degraded: f.degraded}
+	if params.Cursor == "" {
+		result.Page = orDefault(params.Page, 1)
+	}
+	if int64(result.Page*result.PerPage) < f.total || params.Cursor != "" {
+		result.NextCursor = "next"
+	}
+	return result, f.err
+}
+
+func (f *fakeBookService) SimilarBooks(ctx context.Context, id string, params models.BookSearch) (*models.BookSearchResult, error) {
+	f.lastSearch = params
+	return &models.BookSearchResult{PerPage: orDefault(params.PerPage, 20), Page: orDefault(params.Page, 1)}, f.err
+}
+
+func (f *fakeBookService) SuggestBooks(ctx context.Context, prefix string, size int) (*models.BookSuggestions, error) {
+	return &models.BookSuggestions{}, f.err
+}
+
+func (f *fakeBookService) ExportBooks(ctx context.Context, params models.BookSearch) (service.BookExport, error) {
+	f.lastSearch = params
+	if f.err != nil {
+		return nil, f.err
+	}
+	return func(ctx context.Context, fn func(book *models.Book) error) error {
+		for i := range f.exported {
+			if err := fn(&f.exported[i]); err != nil {
+				return err
+			}
+		}
+		return f.exportErr
+	}, nil
+}
+
+func orDefault(n, def int) int {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+func newBookTestApp(svc *fakeBookService) *fiber.App {
+	h := NewBookHandler(svc, nil)
+	app := fiber.New()
+	app.Put("/api/books/:id", h.UpdateBook)
+	app.Patch("/api/books/:id", h.PatchBook)
+	app.Delete("/api/books/:id", h.DeleteBook)
+	app.Get("/api/books", h.GetAllBooks)
+	app.Get("/api/books/search", h.SearchBooks)
+	app.Get("/api/books/suggest", h.SuggestBooks)
+	app.Get("/api/books/export", h.ExportBooks)
+	app.Get("/api/books/:id/similar", h.SimilarBooks)
+	return app
+}
+
+func TestBookHandler_StatusCodes(t *testing.T) {
+	const validID = "507f1f77bcf86cd799439011"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		err    error
+		status int
+	}{
+		{"put ok", "PUT", "/api/books/" + validID, `{"title":"T","author":"A"}`, nil, fiber.StatusOK},
+		{"put invalid id", "PUT", "/api/books/bad", `{"title":"T","author":"A"}`, nil, fiber.StatusBadRequest},
+		{"put missing author", "PUT", "/api/books/" + validID, `{"title":"T"}`, nil, fiber.StatusBadRequest},
+		{"put not found", "PUT", "/api/books/" + validID, `{"title":"T","author":"A"}`, repository.ErrBookNotFound, fiber.StatusNotFound},
+		{"put store error", "PUT", "/api/books/" + validID, `{"title":"T","author":"A"}`, errors.New("write conflict"), fiber.StatusInternalServerError},
+		{"patch ok", "PATCH", "/api/books/" + validID, `{"pages":120}`, nil, fiber.StatusOK},
+		{"patch empty", "PATCH", "/api/books/" + validID, `{}`, nil, fiber.StatusBadRequest},
+		{"patch blank title", "PATCH", "/api/books/" + validID, `{"title":""}`, nil, fiber.StatusBadRequest},
+		{"delete ok", "DELETE", "/api/books/" + validID, "", nil, fiber.StatusNoContent},
+		{"delete not found", "DELETE", "/api/books/" + validID, "", repository.ErrBookNotFound, fiber.StatusNotFound},
+		{"search ok", "GET", "/api/books/search?q=go", "", nil, fiber.StatusOK},
+		{"search missing q", "GET", "/api/books/search?type=title", "", nil, fiber.StatusBadRequest},
+		{"search filters only", "GET", "/api/books/search?language=Japanese&pages_min=100&pages_max=300&published_after=2020-01-01", "", nil, fiber.StatusOK},
+		{"search facet filters", "GET", "/api/books/search?author=Alan+Donovan,+Brian+Kernighan&publisher=Addison-Wesley&publish_year=2015", "", nil, fiber.StatusOK},
+		{"search year with date range", "GET", "/api/books/search?q=go&publish_year=2015&published_after=2014-01-01", "", nil, fiber.StatusBadRequest},
+		{"search bad pages", "GET", "/api/books/search?q=go&pages_min=abc", "", nil, fiber.StatusBadRequest},
+		{"search bad date", "GET", "/api/books/search?q=go&published_after=01/02/2020", "", nil, fiber.StatusBadRequest},
+		{"search unknown type", "GET", "/api/books/search?type=isbn&q=go", "", service.ErrInvalidSearch, fiber.StatusBadRequest},
+		{"search bad page", "GET", "/api/books/search?q=go&page=-1", "", nil, fiber.StatusBadRequest},
+		{"search highlight", "GET", "/api/books/search?q=go&highlight=true", "", nil, fiber.StatusOK},
+		{"search bad highlight", "GET", "/api/books/search?q=go&highlight=maybe", "", nil, fiber.StatusBadRequest},
+		{"search page with cursor", "GET", "/api/books/search?q=go&page=2&cursor=abc", "", nil, fiber.StatusBadRequest},
+		{"search unavailable", "GET", "/api/books/search?q=go&mode=semantic", "", repository.ErrSearchUnavailable, fiber.StatusServiceUnavailable},
+		{"suggest ok", "GET", "/api/books/suggest?q=go", "", nil, fiber.StatusOK},
+		{"suggest bad size", "GET", "/api/books/suggest?q=go&size=many", "", nil, fiber.StatusBadRequest},
+		{"suggest invalid", "GET", "/api/books/suggest", "", service.ErrInvalidSearch, fiber.StatusBadRequest},
+		{"similar ok", "GET", "/api/books/" + validID + "/similar?language=Thai&per_page=5", "", nil, fiber.StatusOK},
+		{"similar invalid id", "GET", "/api/books/bad/similar", "", nil, fiber.StatusBadRequest},
+		{"similar not found", "GET", "/api/books/" + validID + "/similar", "", repository.ErrBookNotFound, fiber.StatusNotFound},
+		{"similar bad filter", "GET", "/api/books/" + validID + "/similar?pages_min=x", "", nil, fiber.StatusBadRequest},
+		{"list ok", "GET", "/api/books?page=2&per_page=50&sort=-title", "", nil, fiber.StatusOK},
+		{"list bad per_page", "GET", "/api/books?per_page=x", "", nil, fiber.StatusBadRequest},
+		{"list invalid options", "GET", "/api/books?sort=relevance", "", service.ErrInvalidListOptions, fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newBookTestApp(&fakeBookService{err: tt.err})
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestBookHandler_SearchParsesFilters(t *testing.T) {
+	svc := &fakeBookService{}
+	app := newBookTestApp(svc)
+
+	req := httptest.NewRequest("GET", "/api/books/search?q=go&language=Japanese,Thai&author=Alan+Donovan,+Brian+Kernighan&author=Rob+Pike&pages_min=200&pages_max=299&publish_year=2015", nil)
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	f := svc.lastSearch.Filters
+	assert.Equal(t, []string{"Japanese", "Thai"}, f.Languages)
+	assert.Equal(t, []string{"Alan Donovan, Brian Kernighan", "Rob Pike"}, f.Authors)
+	assert.Equal(t, 200, f.PagesMin)
+	assert.Equal(t, 299, f.PagesMax)
+	assert.Equal(t, "2015-01-01T00:00:00Z", f.PublishedAfter.Format(time.RFC3339))
+	assert.Equal(t, "2015-12-31T23:59:59Z", f.PublishedBefore.Format(time.RFC3339))
+}
+
+func TestBookHandler_HugePage(t *testing.T) {
+	// The real service, which rejects the page before using its repository
+	h := NewBookHandler(service.NewBookService(nil, service.SearchConfig{}), nil)
+	app := fiber.New()
+	app.Get("/api/books", h.GetAllBooks)
+	app.Get("/api/books/search", h.SearchBooks)
+
+	// page*per_page overflows to a negative number
+	page := strconv.Itoa(math.MaxInt/20 + 2)
+	for _, path := range []string{
+		"/api/books?page=" + page,
+		"/api/books/search?q=go&page=" + page,
+		"/api/books/search?q=go&per_page=100&page=101",
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil), -1)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, path)
+	}
+}
+
+func TestBookHandler_SearchParsesPaging(t *testing.T) {
+	svc := &fakeBookService{}
+	app := newBookTestApp(svc)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/books/search?q=go&page=3&per_page=50&sort=-publish_date", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, 3, svc.lastSearch.Page)
+	assert.Equal(t, 50, svc.lastSearch.PerPage)
+	assert.Equal(t, models.SortField{Key: models.SortPublishDate, Desc: true}, svc.lastSearch.Sort)
+}
+
+func TestBookHandler_LinkHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		total int64
+		links []string
+	}{
+		{
+			"list middle page", "/api/books?page=2&per_page=10&sort=title", 45,
+			[]string{
+				`<http://example.com/api/books?page=1&per_page=10&sort=title>; rel="first"`,
+				`<http://example.com/api/books?page=1&per_page=10&sort=title>; rel="prev"`,
+				`<http://example.com/api/books?page=3&per_page=10&sort=title>; rel="next"`,
+				`<http://example.com/api/books?page=5&per_page=10&sort=title>; rel="last"`,
+			},
+		},
+		{
+			"list empty", "/api/books", 0,
+			[]string{
+				`<http://example.com/api/books?page=1>; rel="first"`,
+				`<http://example.com/api/books?page=1>; rel="last"`,
+			},
+		},
+		{
+			"search last page within result window", "/api/books/search?q=go&per_page=100&page=100", 50000,
+			[]string{
+				`<http://example.com/api/books/search?q=go&per_page=100&page=1>; rel="first"`,
+				`<http://example.com/api/books/search?q=go&per_page=100&page=99>; rel="prev"`,
+				`<http://example.com/api/books/search?q=go&per_page=100&page=100>; rel="last"`,
+				`<http://example.com/api/books/search?q=go&per_page=100&cursor=next>; rel="next"`,
+			},
+		},
+		{
+			"search with cursor", "/api/books/search?q=go&cursor=abc", 50000,
+			[]string{
+				`<http://example.com/api/books/search?q=go&cursor=next>; rel="next"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newBookTestApp(&fakeBookService{total: tt.total})
+
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil), -1)
+			require.NoError(t, err)
+			require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			assert.Equal(t, strconv.FormatInt(tt.total, 10), resp.Header.Get("X-Total-Count"))
+			assert.Equal(t, tt.links, strings.Split(strings.Join(resp.Header.Values("Link"), ", "), ", "))
+		})
+	}
+}
+
+func TestBookHandler_SearchSyntaxErrorPosition(t *testing.T) {
+	svc := &fakeBookService{err: fmt.Errorf("%w: %w", service.ErrInvalidSearch, &querylang.SyntaxError{Pos: 8, Msg: "pages expects a whole number"})}
+	app := newBookTestApp(svc)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/books/search?type=query&q=pages:>many", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	var body struct {
+		Error    string `json:"error"`
+		Position int    `json:"position"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, 8, body.Position)
+	assert.Contains(t, body.Error, "syntax error at position 8")
+}
+
+func TestBookHandler_SearchDegradedHeader(t *testing.T) {
+	app := newBookTestApp(&fakeBookService{total: 1, degraded: true})
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/books/search?q=go", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Search-Degraded"))
+
+	app = newBookTestApp(&fakeBookService{total: 1})
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/books/search?q=go", nil), -1)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("X-Search-Degraded"))
+}
+
+func TestBookHandler_Export(t *testing.T) {
+	svc := &fakeBookService{exported: []models.Book{
+		{Title: "Dune", Author: "Frank Herbert", Pages: 412},
+		{Title: "Emma", Author: "Jane Austen"},
+	}}
+	app := newBookTestApp(svc)
+	get := func(target string) (*http.Response, string) {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil), -1)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, body := get("/api/books/export")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="books.ndjson"`, resp.Header.Get("Content-Disposition"))
+	assert.Equal(t, "{\"title\":\"Dune\",\"author\":\"Frank Herbert\",\"pages\":412}\n{\"title\":\"Emma\",\"author\":\"Jane Austen\"}\n", body)
+
+	resp, body = get("/api/books/export?format=csv&fields=title,pages&q=dune&language=en,fr&pages_min=100")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "title,pages\nDune,412\nEmma,\n", body)
+	assert.Equal(t, "dune", svc.lastSearch.Query)
+	assert.Equal(t, models.SearchTypeMulti, svc.lastSearch.Type)
+	assert.Equal(t, []string{"en", "fr"}, svc.lastSearch.Filters.Languages)
+	assert.Equal(t, 100, svc.lastSearch.Filters.PagesMin)
+
+	resp, body = get("/api/books/export?format=bib")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-bibtex; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="books.bib"`, resp.Header.Get("Content-Disposition"))
+	assert.Contains(t, body, "@book{herbert,\n  title = {Dune},")
+
+	for _, query := range []string{"format=xml", "fields=rating", "pages_min=-1", "format=marc&fields=title"} {
+		resp, _ = get("/api/books/export?" + query)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	app = newBookTestApp(&fakeBookService{err: fmt.Errorf("%w: exporting search results needs Elasticsearch", repository.ErrSearchUnavailable)})
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/books/export?language=en", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestBookHandler_ExportFailsMidway(t *testing.T) {
+	app := newBookTestApp(&fakeBookService{
+		exported:  []models.Book{{Title: "Dune"}},
+		exportErr: errors.New("cursor killed"),
+	})
+	// The status has been sent by then, so the server drops the connection
+	// before the last chunk, which app.Test reports as an error.
+	_, err := app.Test(httptest.NewRequest("GET", "/api/books/export?format=json", nil), -1)
+	assert.ErrorContains(t, err, "cursor killed")
+}
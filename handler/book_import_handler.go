@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"bytes"
+	"go-elastic/bookformat"
+	"go-elastic/service"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BookImportHandler serves bulk imports of books
+type BookImportHandler struct {
+	svc service.BookImportService
+}
+
+func NewBookImportHandler(svc service.BookImportService) *BookImportHandler {
+	return &BookImportHandler{svc: svc}
+}
+
+// ImportBooks stores a JSON array, NDJSON, CSV, MARC21, MARCXML, ONIX or
+// BibTeX body, taking the format from ?format= or else the Content-Type, and
+// queues a job to import it. The job's result is the import report. The body
+// is streamed when the app is configured with StreamRequestBody, and is not
+// held to the body limit.
+func (h *BookImportHandler) ImportBooks(c *fiber.Ctx) error {
+	format := bookformat.FormatFromContentType(c.Get(fiber.HeaderContentType))
+	if name := c.Query("format"); name != "" {
+		var err error
+		if format, err = bookformat.ParseFormat(name); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if format == "" {
+		format = bookformat.JSON
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	job, err := h.svc.Submit(c.UserContext(), format, body)
+	return writeJobAccepted(c, job, err)
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"go-elastic/models"
+	"go-elastic/service"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// importSubmitter records the format and body of the last import submitted
+type importSubmitter struct {
+	service.BookImportService
+	format string
+	body   string
+}
+
+func (s *importSubmitter) Submit(ctx context.Context, format string, body io.Reader) (*models.Job, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	s.format, s.body = format, string(data)
+	return &models.Job{ID: primitive.NewObjectID(), Kind: models.JobKindImport, Status: models.JobStatusQueued}, nil
+}
+
+func TestBookImportHandler(t *testing.T) {
+	svc := &importSubmitter{}
+	app := fiber.New()
+	app.Post("/api/books/bulk", NewBookImportHandler(svc).ImportBooks)
+
+	post := func(target, contentType, body string) int {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		if resp.StatusCode == fiber.StatusAccepted {
+			var job models.Job
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+			assert.Equal(t, "/api/jobs/"+job.ID.Hex(), resp.Header.Get("Location"))
+			assert.Equal(t, models.JobStatusQueued, job.Status)
+		}
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusAccepted, post("/api/books/bulk", "application/json", `[{"title":"Dune"}]`))
+	assert.Equal(t, "json", svc.format)
+	assert.Equal(t, `[{"title":"Dune"}]`, svc.body)
+
+	assert.Equal(t, fiber.StatusAccepted, post("/api/books/bulk", "text/csv; charset=utf-8", "title,author\nDune,Frank Herbert\n"))
+	assert.Equal(t, "csv", svc.format)
+
+	assert.Equal(t, fiber.StatusAccepted, post("/api/books/bulk?format=jsonl", "application/json", "{\"title\":\"Dune\"}\n"))
+	assert.Equal(t, "ndjson", svc.format, "?format= wins over the Content-Type")
+
+	assert.Equal(t, fiber.StatusAccepted, post("/api/books/bulk", "", `[]`))
+	assert.Equal(t, "json", svc.format, "JSON is the default")
+
+	assert.Equal(t, fiber.StatusAccepted, post("/api/books/bulk", "application/marcxml+xml", `<collection/>`))
+	assert.Equal(t, "marcxml", svc.format)
+
+	assert.Equal(t, fiber.StatusAccepted, post("/api/books/bulk?format=onix", "application/xml", `<ONIXMessage release="3.0"/>`))
+	assert.Equal(t, "onix", svc.format)
+
+	assert.Equal(t, fiber.StatusBadRequest, post("/api/books/bulk?format=xml", "", `<books/>`))
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"errors"
+	"go-elastic/models"
+	"go-elastic/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type BookIndexHandler struct {
+	svc  service.BookIndexService
+	jobs service.JobService
+}
+
+func NewBookIndexHandler(svc service.BookIndexService, jobs service.JobService) *BookIndexHandler {
+	return &BookIndexHandler{svc: svc, jobs: jobs}
+}
+
+// Status reports the live books index and its mapping drift from the latest
+// mapping file.
+func (h *BookIndexHandler) Status(c *fiber.Ctx) error {
+	status, err := h.svc.Status(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(status)
+}
+
+// Reindex queues a job that rebuilds the books index from MongoDB, using
+// ?version=N or the latest mapping version. Versions it would reject are
+// refused before the job is queued.
+func (h *BookIndexHandler) Reindex(c *fiber.Ctx) error {
+	version := c.QueryInt("version", 0)
+	if version < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "version must be a positive integer"})
+	}
+
+	if err := h.svc.CheckReindex(c.UserContext(), version); err != nil {
+		switch {
+		case errors.Is(err, service.ErrReindexInProgress), errors.Is(err, service.ErrIndexVersionLive):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	job, err := h.jobs.Submit(c.UserContext(), models.JobKindReindex, models.JobParams{Version: version})
+	return writeJobAccepted(c, job, err)
+}
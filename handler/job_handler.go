@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	jobKinds    = []string{models.JobKindImport, models.JobKindReindex, models.JobKindReconcile}
+	jobStatuses = []string{models.JobStatusQueued, models.JobStatusRunning, models.JobStatusSucceeded, models.JobStatusFailed}
+)
+
+// JobHandler reports on background jobs started by the bulk import, reindex
+// and reconcile endpoints
+type JobHandler struct {
+	svc service.JobService
+}
+
+func NewJobHandler(svc service.JobService) *JobHandler {
+	return &JobHandler{svc: svc}
+}
+
+func (h *JobHandler) GetJob(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	job, err := h.svc.Get(c.UserContext(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrJobNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Job not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(job)
+}
+
+// ListJobs returns the latest jobs, narrowed by ?kind= and ?status=
+func (h *JobHandler) ListJobs(c *fiber.Ctx) error {
+	opts := models.JobListOptions{Kind: c.Query("kind"), Status: c.Query("status")}
+	if opts.Kind != "" && !slices.Contains(jobKinds, opts.Kind) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "kind must be 'import', 'reindex' or 'reconcile'"})
+	}
+	if opts.Status != "" && !slices.Contains(jobStatuses, opts.Status) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be 'queued', 'running', 'succeeded' or 'failed'"})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be between 1 and 100"})
+	}
+	opts.Limit = int64(limit)
+
+	jobs, err := h.svc.List(c.UserContext(), opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(jobs)
+}
+
+// writeJobAccepted responds 202 with a submitted job and where to poll it,
+// or with the error that kept it from being submitted
+func writeJobAccepted(c *fiber.Ctx, job *models.Job, err error) error {
+	if err != nil {
+		if errors.Is(err, service.ErrJobInProgress) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Location("/api/jobs/" + job.ID.Hex())
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memJobs keeps submitted jobs in a slice and refuses a second reconcile
+type memJobs struct {
+	jobs   []models.Job
+	listed models.JobListOptions
+}
+
+func (s *memJobs) Submit(ctx context.Context, kind string, params models.JobParams) (*models.Job, error) {
+	for _, job := range s.jobs {
+		if job.Kind == kind && kind == models.JobKindReconcile {
+			return nil, fmt.Errorf("%w: reconcile job %s is queued", service.ErrJobInProgress, job.ID.Hex())
+		}
+	}
+	job := models.Job{ID: primitive.NewObjectID(), Kind: kind, Status: models.JobStatusQueued, Params: params}
+	s.jobs = append(s.jobs, job)
+	return &job, nil
+}
+
+func (s *memJobs) Get(ctx context.Context, id string) (*models.Job, error) {
+	for _, job := range s.jobs {
+		if job.ID.Hex() == id {
+			return &job, nil
+		}
+	}
+	return nil, repository.ErrJobNotFound
+}
+
+func (s *memJobs) List(ctx context.Context, opts models.JobListOptions) ([]models.Job, error) {
+	s.listed = opts
+	return s.jobs, nil
+}
+
+func TestJobHandler(t *testing.T) {
+	jobs := &memJobs{}
+	app := fiber.New()
+	app.Post("/api/admin/reconcile", NewReconcileHandler(nil, jobs).Repair)
+	h := NewJobHandler(jobs)
+	app.Get("/api/jobs", h.ListJobs)
+	app.Get("/api/jobs/:id", h.GetJob)
+
+	send := func(method, target string) (int, []byte) {
+		resp, err := app.Test(httptest.NewRequest(method, target, nil))
+		require.NoError(t, err)
+		var body json.RawMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	status, body := send("POST", "/api/admin/reconcile")
+	require.Equal(t, fiber.StatusAccepted, status)
+	var submitted models.Job
+	require.NoError(t, json.Unmarshal(body, &submitted))
+	assert.Equal(t, models.JobKindReconcile, submitted.Kind)
+
+	status, _ = send("POST", "/api/admin/reconcile")
+	assert.Equal(t, fiber.StatusConflict, status, "a second repair waits for the first")
+
+	status, body = send("GET", "/api/jobs/"+submitted.ID.Hex())
+	require.Equal(t, fiber.StatusOK, status)
+	var polled models.Job
+	require.NoError(t, json.Unmarshal(body, &polled))
+	assert.Equal(t, submitted.ID, polled.ID)
+	assert.Equal(t, models.JobStatusQueued, polled.Status)
+
+	status, _ = send("GET", "/api/jobs/"+primitive.NewObjectID().Hex())
+	assert.Equal(t, fiber.StatusNotFound, status)
+	status, _ = send("GET", "/api/jobs/42")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	status, _ = send("GET", "/api/jobs?kind=import&status=failed&limit=5")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, models.JobListOptions{Kind: "import", Status: "failed", Limit: 5}, jobs.listed)
+	for _, query := range []string{"kind=export", "status=done", "limit=0", "limit=101"} {
+		status, _ = send("GET", "/api/jobs?"+query)
+		assert.Equal(t, fiber.StatusBadRequest, status, query)
+	}
+}
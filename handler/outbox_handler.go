@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OutboxHandler struct {
+	svc service.OutboxService
+}
+
+func NewOutboxHandler(svc service.OutboxService) *OutboxHandler {
+	return &OutboxHandler{svc: svc}
+}
+
+// ListEntries returns outbox entries that are retrying or dead-lettered.
+// Use ?status=pending or ?status=dead to narrow the list.
+func (h *OutboxHandler) ListEntries(c *fiber.Ctx) error {
+	status := c.Query("status", "")
+	if status != "" && status != models.OutboxStatusPending && status != models.OutboxStatusDead {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be 'pending' or 'dead'"})
+	}
+
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be between 1 and 1000"})
+	}
+
+	entries, err := h.svc.ListFailing(c.UserContext(), status, int64(limit))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(entries)
+}
+
+// RetryEntry puts a failed entry back in the queue with a fresh attempt count
+func (h *OutboxHandler) RetryEntry(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := h.svc.Retry(c.UserContext(), id); err != nil {
+		if errors.Is(err, repository.ErrOutboxEntryNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Outbox entry not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"go-elastic/models"
+	"go-elastic/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReconcileHandler struct {
+	svc  service.ReconcileService
+	jobs service.JobService
+}
+
+func NewReconcileHandler(svc service.ReconcileService, jobs service.JobService) *ReconcileHandler {
+	return &ReconcileHandler{svc: svc, jobs: jobs}
+}
+
+// DriftReport compares MongoDB with the search index without changing either
+func (h *ReconcileHandler) DriftReport(c *fiber.Ctx) error {
+	report, err := h.svc.Reconcile(c.UserContext(), false, nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(report)
+}
+
+// Repair queues a job that re-indexes missing and stale books and deletes
+// orphaned documents. The job's result is the drift report.
+func (h *ReconcileHandler) Repair(c *fiber.Ctx) error {
+	job, err := h.jobs.Submit(c.UserContext(), models.JobKindReconcile, models.JobParams{})
+	return writeJobAccepted(c, job, err)
+}
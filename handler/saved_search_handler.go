@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SavedSearchHandler serves the caller's saved searches and notification
+// inbox. Every route requires the X-User-ID header.
+type SavedSearchHandler struct {
+	svc service.SavedSearchService
+}
+
+func NewSavedSearchHandler(svc service.SavedSearchService) *SavedSearchHandler {
+	return &SavedSearchHandler{svc: svc}
+}
+
+func (h *SavedSearchHandler) ListSavedSearches(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+
+	searches, err := h.svc.List(c.UserContext(), user)
+	if err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.JSON(searches)
+}
+
+func (h *SavedSearchHandler) GetSavedSearch(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	search, err := h.svc.Get(c.UserContext(), user, id)
+	if err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.JSON(search)
+}
+
+func (h *SavedSearchHandler) CreateSavedSearch(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+
+	search := new(models.SavedSearch)
+	if err := c.BodyParser(search); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	search.UserID = user
+	if err := h.svc.Create(c.UserContext(), search); err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(search)
+}
+
+func (h *SavedSearchHandler) UpdateSavedSearch(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	search := new(models.SavedSearch)
+	if err := c.BodyParser(search); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	search.ID = id
+	search.UserID = user
+	if err := h.svc.Update(c.UserContext(), search); err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.JSON(search)
+}
+
+func (h *SavedSearchHandler) DeleteSavedSearch(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := h.svc.Delete(c.UserContext(), user, id); err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListNotifications returns the caller's inbox, newest first, with the
+// number of unread notifications.
+func (h *SavedSearchHandler) ListNotifications(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+	unread, err := queryBool(c, "unread")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	limit, err := queryInt(c, "limit")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	list, err := h.svc.ListNotifications(c.UserContext(), user, unread, limit)
+	if err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.JSON(list)
+}
+
+func (h *SavedSearchHandler) MarkNotificationRead(c *fiber.Ctx) error {
+	user := userID(c)
+	if user == "" {
+		return userRequired(c)
+	}
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	n, err := h.svc.MarkNotificationRead(c.UserContext(), user, id)
+	if err != nil {
+		return writeSavedSearchError(c, err)
+	}
+
+	return c.JSON(n)
+}
+
+func userRequired(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "X-User-ID header is required"})
+}
+
+func writeSavedSearchError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidSavedSearch):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidSearch):
+		return writeSearchError(c, err)
+	case errors.Is(err, repository.ErrSavedSearchNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Saved search not found"})
+	case errors.Is(err, repository.ErrNotificationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Notification not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
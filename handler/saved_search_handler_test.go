@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/service"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeSavedSearches implements service.SavedSearchService for one user's
+// saved searches
+type fakeSavedSearches struct {
+	service.SavedSearchService
+	created *models.SavedSearch
+	unread  bool
+	limit   int
+}
+
+func (f *fakeSavedSearches) Create(ctx context.Context, search *models.SavedSearch) error {
+	if search.Name == "" {
+		return service.ErrInvalidSavedSearch
+	}
+	search.ID = primitive.NewObjectID()
+	f.created = search
+	return nil
+}
+
+func (f *fakeSavedSearches) Get(ctx context.Context, userID, id string) (*models.SavedSearch, error) {
+	if f.created == nil || f.created.UserID != userID || f.created.ID.Hex() != id {
+		return nil, repository.ErrSavedSearchNotFound
+	}
+	return f.created, nil
+}
+
+func (f *fakeSavedSearches) ListNotifications(ctx context.Context, userID string, unreadOnly bool, limit int) (*models.NotificationList, error) {
+	f.unread, f.limit = unreadOnly, limit
+	return &models.NotificationList{Notifications: []models.Notification{}, Unread: 2}, nil
+}
+
+func TestSavedSearchHandler(t *testing.T) {
+	svc := &fakeSavedSearches{}
+	h := NewSavedSearchHandler(svc)
+	app := fiber.New()
+	app.Post("/api/saved-searches", h.CreateSavedSearch)
+	app.Get("/api/saved-searches/:id", h.GetSavedSearch)
+	app.Get("/api/notifications", h.ListNotifications)
+
+	post := func(user, body string) int {
+		req := httptest.NewRequest("POST", "/api/saved-searches", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set("X-User-ID", user)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusBadRequest, post("", `{"name":"Go","query":"go"}`), "X-User-ID is required")
+	assert.Equal(t, fiber.StatusBadRequest, post("u1", `{"query":"go"}`))
+	assert.Equal(t, fiber.StatusCreated, post("u1", `{"name":"Go","query":"go","user_id":"someone-else"}`))
+	require.NotNil(t, svc.created)
+	assert.Equal(t, "u1", svc.created.UserID, "the owner comes from the header, not the body")
+
+	get := func(user, id string) int {
+		req := httptest.NewRequest("GET", "/api/saved-searches/"+id, nil)
+		req.Header.Set("X-User-ID", user)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, fiber.StatusOK, get("u1", svc.created.ID.Hex()))
+	assert.Equal(t, fiber.StatusNotFound, get("u2", svc.created.ID.Hex()))
+	assert.Equal(t, fiber.StatusBadRequest, get("u1", "42"))
+
+	req := httptest.NewRequest("GET", "/api/notifications?unread=true&limit=10", nil)
+	req.Header.Set("X-User-ID", "u1")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var list models.NotificationList
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, int64(2), list.Unread)
+	assert.True(t, svc.unread)
+	assert.Equal(t, 10, svc.limit)
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/service"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SearchAnalysisHandler struct {
+	svc  service.SearchAnalysisService
+	jobs service.JobService
+}
+
+func NewSearchAnalysisHandler(svc service.SearchAnalysisService, jobs service.JobService) *SearchAnalysisHandler {
+	return &SearchAnalysisHandler{svc: svc, jobs: jobs}
+}
+
+func (h *SearchAnalysisHandler) ListSynonymSets(c *fiber.Ctx) error {
+	sets, err := h.svc.ListSynonymSets(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(sets)
+}
+
+func (h *SearchAnalysisHandler) GetSynonymSet(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	set, err := h.svc.GetSynonymSet(c.UserContext(), id)
+	if err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.JSON(set)
+}
+
+func (h *SearchAnalysisHandler) CreateSynonymSet(c *fiber.Ctx) error {
+	set := new(models.SynonymSet)
+	if err := c.BodyParser(set); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.svc.CreateSynonymSet(c.UserContext(), set); err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(set)
+}
+
+func (h *SearchAnalysisHandler) UpdateSynonymSet(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	set := new(models.SynonymSet)
+	if err := c.BodyParser(set); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	set.ID = id
+	if err := h.svc.UpdateSynonymSet(c.UserContext(), set); err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.JSON(set)
+}
+
+func (h *SearchAnalysisHandler) DeleteSynonymSet(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := h.svc.DeleteSynonymSet(c.UserContext(), id); err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *SearchAnalysisHandler) ListStopwordLists(c *fiber.Ctx) error {
+	lists, err := h.svc.ListStopwordLists(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(lists)
+}
+
+func (h *SearchAnalysisHandler) GetStopwordList(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	list, err := h.svc.GetStopwordList(c.UserContext(), id)
+	if err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.JSON(list)
+}
+
+func (h *SearchAnalysisHandler) CreateStopwordList(c *fiber.Ctx) error {
+	list := new(models.StopwordList)
+	if err := c.BodyParser(list); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.svc.CreateStopwordList(c.UserContext(), list); err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(list)
+}
+
+func (h *SearchAnalysisHandler) UpdateStopwordList(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	list := new(models.StopwordList)
+	if err := c.BodyParser(list); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	list.ID = id
+	if err := h.svc.UpdateStopwordList(c.UserContext(), list); err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.JSON(list)
+}
+
+func (h *SearchAnalysisHandler) DeleteStopwordList(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !primitive.IsValidObjectID(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	if err := h.svc.DeleteStopwordList(c.UserContext(), id); err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Reload applies the stored synonyms and stopwords to the live books index.
+// New stopwords need a rebuild of the index, which is queued as a reindex
+// job and answered with 202 Accepted.
+func (h *SearchAnalysisHandler) Reload(c *fiber.Ctx) error {
+	result, err := h.svc.Reload(c.UserContext(), "")
+	if err != nil {
+		return writeSearchAnalysisError(c, err)
+	}
+	if !result.RebuildRequired {
+		return c.JSON(result)
+	}
+
+	job, err := h.jobs.Submit(c.UserContext(), models.JobKindReindex, models.JobParams{Rebuild: true})
+	if errors.Is(err, service.ErrJobInProgress) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "synonyms reloaded, but the new stopwords need a rebuild and " + err.Error() + "; reload again once it has finished",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result.RebuildJob = job
+	c.Location("/api/jobs/" + job.ID.Hex())
+	return c.Status(fiber.StatusAccepted).JSON(result)
+}
+
+func writeSearchAnalysisError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidSearchAnalysis):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, repository.ErrSynonymSetNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Synonym set not found"})
+	case errors.Is(err, repository.ErrStopwordListNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stopword list not found"})
+	case errors.Is(err, service.ErrSearchAnalysisUnsupported):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"go-elastic/models"
+	"go-elastic/service"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staleStopwords reloads synonyms and reports whether the live index has
+// out-of-date stopwords
+type staleStopwords struct {
+	service.SearchAnalysisService
+	stale bool
+}
+
+func (s *staleStopwords) Reload(ctx context.Context, index string) (*models.SearchAnalysisReload, error) {
+	return &models.SearchAnalysisReload{Index: "books_v8", SynonymRules: 2, RebuildRequired: s.stale}, nil
+}
+
+func TestSearchAnalysisHandler_Reload(t *testing.T) {
+	analysis := &staleStopwords{}
+	jobs := &memJobs{}
+	app := fiber.New()
+	app.Post("/api/admin/search/reload", NewSearchAnalysisHandler(analysis, jobs).Reload)
+
+	reload := func() (int, models.SearchAnalysisReload) {
+		resp, err := app.Test(httptest.NewRequest("POST", "/api/admin/search/reload", nil))
+		require.NoError(t, err)
+		var result models.SearchAnalysisReload
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.StatusCode, result
+	}
+
+	status, result := reload()
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Nil(t, result.RebuildJob)
+	assert.Empty(t, jobs.jobs)
+
+	analysis.stale = true
+	status, result = reload()
+	assert.Equal(t, fiber.StatusAccepted, status, "new stopwords are applied by a rebuild")
+	require.NotNil(t, result.RebuildJob)
+	require.Len(t, jobs.jobs, 1)
+	assert.Equal(t, models.JobKindReindex, jobs.jobs[0].Kind)
+	assert.Equal(t, models.JobParams{Rebuild: true}, jobs.jobs[0].Params)
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"go-elastic/models"
+	"go-elastic/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SearchAnalyticsHandler struct {
+	svc service.SearchAnalyticsService
+}
+
+func NewSearchAnalyticsHandler(svc service.SearchAnalyticsService) *SearchAnalyticsHandler {
+	return &SearchAnalyticsHandler{svc: svc}
+}
+
+// RecordClick logs that a user opened a book from search results. It is
+// accepted as soon as it is valid; the event is written in the background.
+func (h *SearchAnalyticsHandler) RecordClick(c *fiber.Ctx) error {
+	click := new(models.SearchClick)
+	if err := c.BodyParser(click); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Cannot parse JSON",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.svc.RecordClick(c.UserContext(), *click, requestID(c), userID(c)); err != nil {
+		return writeAnalyticsError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+func (h *SearchAnalyticsHandler) TopQueries(c *fiber.Ctx) error {
+	opts, err := parseReportOptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report, err := h.svc.TopQueries(c.UserContext(), opts)
+	if err != nil {
+		return writeAnalyticsError(c, err)
+	}
+
+	return c.JSON(report)
+}
+
+func (h *SearchAnalyticsHandler) ZeroResultQueries(c *fiber.Ctx) error {
+	opts, err := parseReportOptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report, err := h.svc.ZeroResultQueries(c.UserContext(), opts)
+	if err != nil {
+		return writeAnalyticsError(c, err)
+	}
+
+	return c.JSON(report)
+}
+
+func (h *SearchAnalyticsHandler) ClickThrough(c *fiber.Ctx) error {
+	opts, err := parseReportOptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if opts.MinSearches, err = queryInt(c, "min_searches"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report, err := h.svc.ClickThrough(c.UserContext(), opts)
+	if err != nil {
+		return writeAnalyticsError(c, err)
+	}
+
+	return c.JSON(report)
+}
+
+// parseReportOptions reads the from, to and size parameters. A bare to date
+// covers the whole day.
+func parseReportOptions(c *fiber.Ctx) (models.QueryReportOptions, error) {
+	var opts models.QueryReportOptions
+	var err error
+	if opts.From, err = queryDate(c, "from", false); err != nil {
+		return opts, err
+	}
+	if opts.To, err = queryDate(c, "to", true); err != nil {
+		return opts, err
+	}
+	if opts.Size, err = queryInt(c, "size"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func writeAnalyticsError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, service.ErrInvalidAnalytics) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
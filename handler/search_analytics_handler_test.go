@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/service"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAnalytics implements service.SearchAnalyticsService, recording the
+// searches and clicks it is given
+type fakeAnalytics struct {
+	searches []models.SearchEvent
+	clicks   []models.SearchClick
+	opts     models.QueryReportOptions
+}
+
+func (f *fakeAnalytics) RecordSearch(ctx context.Context, event models.SearchEvent) {
+	f.searches = append(f.searches, event)
+}
+
+func (f *fakeAnalytics) RecordClick(ctx context.Context, click models.SearchClick, requestID, userID string) error {
+	if click.BookID == "" {
+		return service.ErrInvalidAnalytics
+	}
+	f.clicks = append(f.clicks, click)
+	return nil
+}
+
+func (f *fakeAnalytics) TopQueries(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	f.opts = opts
+	return &models.QueryReport{}, nil
+}
+
+func (f *fakeAnalytics) ZeroResultQueries(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	f.opts = opts
+	return &models.QueryReport{}, nil
+}
+
+func (f *fakeAnalytics) ClickThrough(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	f.opts = opts
+	return nil, errors.New("es down")
+}
+
+func TestBookHandler_SearchBooksRecordsAnalytics(t *testing.T) {
+	analytics := &fakeAnalytics{}
+	h := NewBookHandler(&fakeBookService{total: 7}, analytics)
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("request_id", "req-1")
+		return c.Next()
+	})
+	app.Get("/api/books/search", h.SearchBooks)
+
+	req := httptest.NewRequest("GET", "/api/books/search?q=Go&type=title&language=Thai&per_page=5", nil)
+	req.Header.Set("X-User-ID", "user-7")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	require.Len(t, analytics.searches, 1)
+	event := analytics.searches[0]
+	assert.Equal(t, "Go", event.Query)
+	assert.Equal(t, "title", event.Type)
+	assert.Equal(t, "req-1", event.RequestID)
+	assert.Equal(t, "user-7", event.UserID)
+	assert.Equal(t, &models.SearchFilters{Languages: []string{"Thai"}}, event.Filters)
+	assert.Equal(t, int64(7), event.Hits)
+	assert.Equal(t, 5, event.PerPage)
+	assert.Equal(t, fiber.StatusOK, event.Status)
+	assert.Empty(t, event.Error)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/books/search?q=go&pages_min=x", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	require.Len(t, analytics.searches, 2, "rejected searches are logged too")
+	assert.Equal(t, "go", analytics.searches[1].Query)
+	assert.Equal(t, fiber.StatusBadRequest, analytics.searches[1].Status)
+	assert.NotEmpty(t, analytics.searches[1].Error)
+}
+
+func TestSearchAnalyticsHandler(t *testing.T) {
+	analytics := &fakeAnalytics{}
+	h := NewSearchAnalyticsHandler(analytics)
+	app := fiber.New()
+	app.Post("/api/books/search/clicks", h.RecordClick)
+	app.Get("/top", h.TopQueries)
+	app.Get("/zero", h.ZeroResultQueries)
+	app.Get("/ctr", h.ClickThrough)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"click ok", "POST", "/api/books/search/clicks", `{"search_id":"r","query":"go","book_id":"507f1f77bcf86cd799439011","position":1}`, fiber.StatusAccepted},
+		{"click invalid", "POST", "/api/books/search/clicks", `{"search_id":"r"}`, fiber.StatusBadRequest},
+		{"click bad json", "POST", "/api/books/search/clicks", `{`, fiber.StatusBadRequest},
+		{"top ok", "GET", "/top?from=2024-01-01&to=2024-01-31&size=5", "", fiber.StatusOK},
+		{"top bad date", "GET", "/top?from=yesterday", "", fiber.StatusBadRequest},
+		{"zero bad size", "GET", "/zero?size=x", "", fiber.StatusBadRequest},
+		{"ctr bad min", "GET", "/ctr?min_searches=-1", "", fiber.StatusBadRequest},
+		{"ctr error", "GET", "/ctr", "", fiber.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+
+	require.Len(t, analytics.clicks, 1)
+	_, err := app.Test(httptest.NewRequest("GET", "/top?to=2024-01-31", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 23, analytics.opts.To.Hour(), "a bare to date covers the day")
+}
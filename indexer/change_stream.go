@@ -0,0 +1,199 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mode selects how book writes reach the search index.
+type Mode string
+
+const (
+	// ModeOutbox records every repository write in the outbox collection and
+	// relays it to Elasticsearch.
+	ModeOutbox Mode = "outbox"
+	// ModeChangeStream tails the books collection instead, so writes made
+	// directly to MongoDB by other tools are indexed too.
+	ModeChangeStream Mode = "changestream"
+)
+
+// LoadMode reads SEARCH_INDEX_MODE, defaulting to ModeOutbox
+func LoadMode() Mode {
+	if Mode(os.Getenv("SEARCH_INDEX_MODE")) == ModeChangeStream {
+		return ModeChangeStream
+	}
+	return ModeOutbox
+}
+
+// changeStreamTokenName is the key the resume token is stored under
+const changeStreamTokenName = "books_search_indexer"
+
+// errHistoryLost is the server error code for a resume token that has fallen
+// off the oplog.
+const errHistoryLost = 286
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *models.Book `bson:"fullDocument"`
+}
+
+// changeStream is the part of a *mongo.ChangeStream the indexer reads
+type changeStream interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	ResumeToken() bson.Raw
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// ChangeStreamIndexer applies inserts, updates, replaces and deletes on the
+// books collection to the search index. The resume token is saved after each
+// applied event, so a restart continues where the previous run stopped.
+type ChangeStreamIndexer struct {
+	// open watches the books collection
+	open   func(ctx context.Context, pipeline mongo.Pipeline, opts *options.ChangeStreamOptions) (changeStream, error)
+	tokens repository.ResumeTokenRepository
+	index  repository.BookIndex
+	cfg    RelayConfig
+	log    *logrus.Logger
+}
+
+func NewChangeStreamIndexer(collection *mongo.Collection, tokens repository.ResumeTokenRepository, index repository.BookIndex, cfg RelayConfig, log *logrus.Logger) *ChangeStreamIndexer {
+	open := func(ctx context.Context, pipeline mongo.Pipeline, opts *options.ChangeStreamOptions) (changeStream, error) {
+		stream, err := collection.Watch(ctx, pipeline, opts)
+		if err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+	return &ChangeStreamIndexer{
+		open:   open,
+		tokens: tokens,
+		index:  index,
+		cfg:    cfg,
+		log:    log,
+	}
+}
+
+// Run watches the collection until ctx is cancelled, reopening the stream
+// from the last saved token after errors.
+func (i *ChangeStreamIndexer) Run(ctx context.Context) {
+	i.log.Info("change_stream_indexer_started")
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		err := i.watch(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == errHistoryLost {
+			// Events between the saved token and now are gone; run the
+			// reconciliation job to repair anything that was missed.
+			i.log.WithError(err).Error("change_stream_history_lost")
+			if err := i.tokens.Clear(ctx, changeStreamTokenName); err != nil {
+				i.log.WithError(err).Error("change_stream_token_clear_failed")
+			}
+			attempt = 0
+			continue
+		}
+
+		delay := Backoff(attempt, i.cfg.BaseBackoff, i.cfg.MaxBackoff)
+		i.log.WithError(err).WithField("retry_in", delay.String()).Warn("change_stream_interrupted")
+		if !sleep(ctx, delay) {
+			break
+		}
+	}
+	i.log.Info("change_stream_indexer_stopped")
+}
+
+func (i *ChangeStreamIndexer) watch(ctx context.Context) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	token, err := i.tokens.Load(ctx, changeStreamTokenName)
+	if err != nil {
+		return err
+	}
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		}}},
+	}
+	stream, err := i.open(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			i.log.WithError(err).Warn("change_stream_event_skipped")
+		} else if !i.apply(ctx, &event) {
+			return ctx.Err()
+		}
+
+		if err := i.tokens.Save(ctx, changeStreamTokenName, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+// apply retries the event until it reaches the index, so events are never
+// applied out of order. It returns false only when ctx is cancelled.
+func (i *ChangeStreamIndexer) apply(ctx context.Context, event *changeEvent) bool {
+	id := event.DocumentKey.ID.Hex()
+	for attempt := 1; ; attempt++ {
+		var err error
+		if event.OperationType == "delete" || event.FullDocument == nil {
+			// An update whose document was deleted before the lookup has
+			// no full document; a delete event for it follows.
+			err = i.index.Delete(ctx, id)
+		} else {
+			err = i.index.Index(ctx, event.FullDocument)
+		}
+		if err == nil {
+			return true
+		}
+
+		delay := Backoff(attempt, i.cfg.BaseBackoff, i.cfg.MaxBackoff)
+		i.log.WithError(err).WithFields(logrus.Fields{
+			"book_id":   id,
+			"operation": event.OperationType,
+			"attempts":  attempt,
+			"retry_in":  delay.String(),
+		}).Warn("change_stream_apply_failed")
+		if !sleep(ctx, delay) {
+			return false
+		}
+	}
+}
+
+// sleep waits for d and reports false if ctx was cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
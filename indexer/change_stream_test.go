@@ -0,0 +1,163 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sliceStream replays raw events, with a resume token numbered after each
+type sliceStream struct {
+	events []bson.Raw
+	next   int
+	err    error
+}
+
+func (s *sliceStream) Next(ctx context.Context) bool {
+	if s.next == len(s.events) {
+		return false
+	}
+	s.next++
+	return true
+}
+
+func (s *sliceStream) Decode(val interface{}) error {
+	return bson.Unmarshal(s.events[s.next-1], val)
+}
+
+func (s *sliceStream) ResumeToken() bson.Raw {
+	return streamToken(s.next)
+}
+
+func (s *sliceStream) Err() error                      { return s.err }
+func (s *sliceStream) Close(ctx context.Context) error { return nil }
+
+func streamToken(n int) bson.Raw {
+	raw, _ := bson.Marshal(bson.M{"_data": strconv.Itoa(n)})
+	return raw
+}
+
+// memTokens keeps one resume token and records every save and clear
+type memTokens struct {
+	token   bson.Raw
+	saved   []bson.Raw
+	cleared int
+}
+
+func (t *memTokens) Load(ctx context.Context, name string) (bson.Raw, error) {
+	return t.token, nil
+}
+
+func (t *memTokens) Save(ctx context.Context, name string, token bson.Raw) error {
+	t.token = token
+	t.saved = append(t.saved, token)
+	return nil
+}
+
+func (t *memTokens) Clear(ctx context.Context, name string) error {
+	t.token = nil
+	t.cleared++
+	return nil
+}
+
+// flakyIndex fails the first fails writes
+type flakyIndex struct {
+	memIndex
+	fails int
+}
+
+func (i *flakyIndex) Index(ctx context.Context, book *models.Book) error {
+	if i.fails > 0 {
+		i.fails--
+		return errors.New("es down")
+	}
+	return i.memIndex.Index(ctx, book)
+}
+
+func changeEventRaw(t *testing.T, op string, id primitive.ObjectID, book *models.Book) bson.Raw {
+	t.Helper()
+	event := bson.M{"operationType": op, "documentKey": bson.M{"_id": id}}
+	if book != nil {
+		event["fullDocument"] = book
+	}
+	raw, err := bson.Marshal(event)
+	require.NoError(t, err)
+	return raw
+}
+
+func newTestChangeStreamIndexer(tokens repository.ResumeTokenRepository, index repository.BookIndex, open func(ctx context.Context, pipeline mongo.Pipeline, opts *options.ChangeStreamOptions) (changeStream, error)) *ChangeStreamIndexer {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	i := NewChangeStreamIndexer(nil, tokens, index, RelayConfig{BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, log)
+	i.open = open
+	return i
+}
+
+func TestChangeStreamIndexer_Watch(t *testing.T) {
+	inserted := &models.Book{ID: primitive.NewObjectID(), Title: "Inserted"}
+	updated := &models.Book{ID: primitive.NewObjectID(), Title: "Updated"}
+	replaced := &models.Book{ID: primitive.NewObjectID(), Title: "Replaced"}
+	deletedID := primitive.NewObjectID()
+	vanishedID := primitive.NewObjectID()
+
+	stream := &sliceStream{events: []bson.Raw{
+		changeEventRaw(t, "insert", inserted.ID, inserted),
+		changeEventRaw(t, "update", updated.ID, updated),
+		changeEventRaw(t, "replace", replaced.ID, replaced),
+		changeEventRaw(t, "delete", deletedID, nil),
+		// updated, then deleted before the full document was looked up
+		changeEventRaw(t, "update", vanishedID, nil),
+		bson.Raw{0x05, 0x00},
+	}}
+	tokens := &memTokens{token: streamToken(0)}
+	index := &flakyIndex{fails: 2}
+	var resumedAfter interface{}
+	indexer := newTestChangeStreamIndexer(tokens, index, func(ctx context.Context, pipeline mongo.Pipeline, opts *options.ChangeStreamOptions) (changeStream, error) {
+		resumedAfter = opts.ResumeAfter
+		return stream, nil
+	})
+
+	require.NoError(t, indexer.watch(context.Background()))
+
+	assert.Equal(t, streamToken(0), resumedAfter, "the stream resumes after the saved token")
+	assert.Equal(t, []string{inserted.ID.Hex(), updated.ID.Hex(), replaced.ID.Hex()}, index.indexed, "a failed write is retried in order")
+	assert.Equal(t, []string{deletedID.Hex(), vanishedID.Hex()}, index.deleted)
+	require.Len(t, tokens.saved, len(stream.events), "the token is saved after every event, even one that cannot be decoded")
+	for n, token := range tokens.saved {
+		assert.Equal(t, streamToken(n+1), token)
+	}
+}
+
+func TestChangeStreamIndexer_RunClearsLostHistory(t *testing.T) {
+	tokens := &memTokens{token: streamToken(7)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var resumedAfter []interface{}
+	indexer := newTestChangeStreamIndexer(tokens, &memIndex{}, func(ctx context.Context, pipeline mongo.Pipeline, opts *options.ChangeStreamOptions) (changeStream, error) {
+		resumedAfter = append(resumedAfter, opts.ResumeAfter)
+		if len(resumedAfter) == 1 {
+			return nil, mongo.CommandError{Code: errHistoryLost, Message: "resume point may no longer be in the oplog"}
+		}
+		cancel()
+		return nil, ctx.Err()
+	})
+
+	indexer.Run(ctx)
+
+	assert.Equal(t, 1, tokens.cleared)
+	assert.Equal(t, []interface{}{streamToken(7), nil}, resumedAfter, "the stream is reopened from now")
+}
@@ -0,0 +1,94 @@
+package indexer
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HealthConfig controls how often Elasticsearch is checked and how long a
+// check may take before it counts as failed
+type HealthConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// LoadHealthConfig reads the health check settings from the environment,
+// falling back to defaults for anything unset or invalid.
+func LoadHealthConfig() HealthConfig {
+	return HealthConfig{
+		Interval: envDuration("ES_HEALTH_INTERVAL", 5*time.Second),
+		Timeout:  envDuration("ES_HEALTH_TIMEOUT", 2*time.Second),
+	}
+}
+
+// HealthMonitor tracks whether Elasticsearch is reachable. Searches read
+// Healthy on every request, so it is a single atomic load.
+type HealthMonitor struct {
+	check     func(ctx context.Context) error
+	cfg       HealthConfig
+	log       *logrus.Logger
+	healthy   atomic.Bool
+	onRecover func()
+}
+
+// NewHealthMonitor returns a monitor that starts out healthy or not, as found
+// by the connection check at startup.
+func NewHealthMonitor(check func(ctx context.Context) error, healthy bool, cfg HealthConfig, log *logrus.Logger) *HealthMonitor {
+	m := &HealthMonitor{check: check, cfg: cfg, log: log}
+	m.healthy.Store(healthy)
+	return m
+}
+
+// Healthy reports the result of the last check
+func (m *HealthMonitor) Healthy() bool {
+	return m.healthy.Load()
+}
+
+// OnRecover sets a function to run, on the monitor's goroutine, every time
+// Elasticsearch becomes reachable again. It must be called before Run.
+func (m *HealthMonitor) OnRecover(fn func()) {
+	m.onRecover = fn
+}
+
+// Run checks Elasticsearch every interval until ctx is cancelled
+func (m *HealthMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	m.log.WithField("healthy", m.Healthy()).Info("elasticsearch_health_monitor_started")
+	for {
+		select {
+		case <-ctx.Done():
+			m.log.Info("elasticsearch_health_monitor_stopped")
+			return
+		case <-ticker.C:
+			m.probe(ctx)
+		}
+	}
+}
+
+// probe runs one check and logs and acts on a change of state
+func (m *HealthMonitor) probe(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	err := m.check(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	healthy := err == nil
+	if m.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if !healthy {
+		m.log.WithError(err).Warn("elasticsearch_unavailable")
+		return
+	}
+	m.log.Info("elasticsearch_recovered")
+	if m.onRecover != nil {
+		m.onRecover()
+	}
+}
@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthMonitor_Probe(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	var checkErr error
+	check := func(ctx context.Context) error { return checkErr }
+	m := NewHealthMonitor(check, false, HealthConfig{Timeout: time.Second}, log)
+	recovered := 0
+	m.OnRecover(func() { recovered++ })
+
+	assert.False(t, m.Healthy())
+	m.probe(context.Background())
+	assert.True(t, m.Healthy())
+	assert.Equal(t, 1, recovered)
+
+	m.probe(context.Background())
+	assert.Equal(t, 1, recovered, "staying healthy is not a recovery")
+
+	checkErr = errors.New("connection refused")
+	m.probe(context.Background())
+	assert.False(t, m.Healthy())
+
+	checkErr = nil
+	m.probe(context.Background())
+	assert.True(t, m.Healthy())
+	assert.Equal(t, 2, recovered)
+}
+
+func TestHealthMonitor_ProbeTimeout(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m := NewHealthMonitor(hang, true, HealthConfig{Timeout: 10 * time.Millisecond}, log)
+	m.probe(context.Background())
+	assert.False(t, m.Healthy(), "a check that does not answer in time fails")
+}
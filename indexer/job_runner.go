@@ -0,0 +1,283 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// jobWriteTimeout bounds the writes that record a job's outcome once the
+// runner is stopping
+const jobWriteTimeout = 5 * time.Second
+
+// JobConfig controls the job worker pool. A worker renews the lease of its
+// job every third of Lease; a job whose lease runs out is recovered.
+type JobConfig struct {
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+}
+
+// LoadJobConfig reads the job worker settings from the environment, falling
+// back to defaults for anything unset or invalid.
+func LoadJobConfig() JobConfig {
+	return JobConfig{
+		Workers:      envInt("JOB_WORKERS", 2),
+		PollInterval: envDuration("JOB_POLL_INTERVAL", time.Second),
+		Lease:        envDuration("JOB_LEASE", time.Minute),
+		MaxAttempts:  envInt("JOB_MAX_ATTEMPTS", 3),
+	}
+}
+
+// JobFunc runs a job and returns its result, which is stored as JSON. It may
+// call progress with the percentage done as it goes.
+type JobFunc func(ctx context.Context, job *models.Job, progress func(percent float64)) (interface{}, error)
+
+// JobKind is how the runner handles one kind of job
+type JobKind struct {
+	Run JobFunc
+	// Resumable kinds are safe to start over from the beginning, so a job
+	// whose worker stopped is queued again, up to MaxAttempts times. Jobs of
+	// other kinds fail.
+	Resumable bool
+	// Cleanup, if set, runs once a job of the kind has succeeded or failed
+	Cleanup func(ctx context.Context, job *models.Job) error
+}
+
+// JobRunner runs queued jobs on a fixed number of workers. Jobs are claimed
+// from MongoDB, so several processes can share the queue, and jobs left
+// running by a process that stopped are recovered by any runner.
+type JobRunner struct {
+	jobs  repository.JobRepository
+	kinds map[string]JobKind
+	cfg   JobConfig
+	log   *logrus.Logger
+	id    string
+}
+
+func NewJobRunner(jobs repository.JobRepository, kinds map[string]JobKind, cfg JobConfig, log *logrus.Logger) *JobRunner {
+	return &JobRunner{
+		jobs:  jobs,
+		kinds: kinds,
+		cfg:   cfg,
+		log:   log,
+		id:    primitive.NewObjectID().Hex(),
+	}
+}
+
+// Run starts the workers and recovers expired jobs until ctx is cancelled,
+// then waits for the workers to stop. Jobs still running are stopped and
+// queued again or failed.
+func (r *JobRunner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < r.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			r.work(ctx, worker)
+		}(fmt.Sprintf("%s-%d", r.id, i))
+	}
+
+	ticker := time.NewTicker(r.cfg.Lease / 2)
+	defer ticker.Stop()
+
+	r.log.WithField("workers", r.cfg.Workers).Info("job_runner_started")
+	for {
+		r.recoverExpired(ctx)
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			r.log.Info("job_runner_stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// work claims and runs jobs one at a time until ctx is cancelled
+func (r *JobRunner) work(ctx context.Context, worker string) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			job, err := r.jobs.ClaimNext(ctx, worker, r.cfg.Lease)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.log.WithError(err).Error("job_claim_failed")
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			r.run(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run runs a claimed job, renewing its lease meanwhile, and records the
+// outcome
+func (r *JobRunner) run(ctx context.Context, job *models.Job) {
+	log := r.log.WithFields(logrus.Fields{"job_id": job.ID.Hex(), "kind": job.Kind, "attempt": job.Attempts})
+	kind, ok := r.kinds[job.Kind]
+	if !ok {
+		job.Status = models.JobStatusFailed
+		job.Error = fmt.Sprintf("unknown job kind %q", job.Kind)
+		r.finish(job, kind, log)
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var progress atomic.Uint64
+	var leaseLost atomic.Bool
+	var heartbeats sync.WaitGroup
+	heartbeats.Add(1)
+	go func() {
+		defer heartbeats.Done()
+		if err := r.heartbeat(jobCtx, *job, &progress); errors.Is(err, repository.ErrJobLeaseLost) {
+			leaseLost.Store(true)
+			cancel()
+		}
+	}()
+
+	log.Info("job_started")
+	result, err := callJob(jobCtx, kind.Run, job, func(percent float64) {
+		progress.Store(math.Float64bits(math.Min(math.Max(percent, 0), 100)))
+	})
+	cancel()
+	heartbeats.Wait()
+	job.Progress = math.Float64frombits(progress.Load())
+
+	switch {
+	case leaseLost.Load():
+		log.Warn("job_lease_lost")
+		return
+	case ctx.Err() != nil:
+		r.interrupt(job, kind, "the server stopped")
+	case err != nil:
+		job.Status = models.JobStatusFailed
+		job.Error = err.Error()
+	default:
+		job.Result, err = json.Marshal(result)
+		if err != nil {
+			job.Status = models.JobStatusFailed
+			job.Error = fmt.Sprintf("error encoding result: %v", err)
+			break
+		}
+		job.Status = models.JobStatusSucceeded
+		job.Progress = 100
+	}
+	r.finish(job, kind, log)
+}
+
+// callJob runs fn, turning a panic into an error so that one bad job does
+// not stop the server
+func callJob(ctx context.Context, fn JobFunc, job *models.Job, progress func(float64)) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return fn(ctx, job, progress)
+}
+
+// heartbeat renews the lease and records progress until ctx is done. It
+// returns the error that stopped it, if any.
+func (r *JobRunner) heartbeat(ctx context.Context, job models.Job, progress *atomic.Uint64) error {
+	ticker := time.NewTicker(r.cfg.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		job.Progress = math.Float64frombits(progress.Load())
+		err := r.jobs.Heartbeat(ctx, &job, r.cfg.Lease)
+		if errors.Is(err, repository.ErrJobLeaseLost) {
+			return err
+		}
+		if err != nil && ctx.Err() == nil {
+			r.log.WithError(err).WithField("job_id", job.ID.Hex()).Warn("job_heartbeat_failed")
+		}
+	}
+}
+
+// interrupt queues a job to start over or fails it, after its worker stopped
+// before the job finished
+func (r *JobRunner) interrupt(job *models.Job, kind JobKind, why string) {
+	if kind.Resumable && job.Attempts < r.cfg.MaxAttempts {
+		job.Status = models.JobStatusQueued
+		job.Error = ""
+		job.Progress = 0
+		return
+	}
+	job.Status = models.JobStatusFailed
+	job.Error = fmt.Sprintf("interrupted after %d attempt(s): %s before the job finished", job.Attempts, why)
+}
+
+// finish records the outcome of a job and cleans up after it once it has
+// succeeded or failed. The job's context may be gone, so it writes with its
+// own timeout.
+func (r *JobRunner) finish(job *models.Job, kind JobKind, log *logrus.Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobWriteTimeout)
+	defer cancel()
+
+	if err := r.jobs.Finish(ctx, job); err != nil {
+		log.WithError(err).Error("job_finish_failed")
+		return
+	}
+	switch job.Status {
+	case models.JobStatusQueued:
+		log.Warn("job_requeued")
+	case models.JobStatusFailed:
+		log.WithField("error", job.Error).Error("job_failed")
+	default:
+		log.Info("job_succeeded")
+	}
+
+	if job.Finished() && kind.Cleanup != nil {
+		if err := kind.Cleanup(ctx, job); err != nil {
+			log.WithError(err).Warn("job_cleanup_failed")
+		}
+	}
+}
+
+// recoverExpired queues again or fails the jobs of workers that stopped
+// without finishing them, such as in a crash or restart
+func (r *JobRunner) recoverExpired(ctx context.Context) {
+	jobs, err := r.jobs.ListExpired(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.log.WithError(err).Error("job_recovery_failed")
+		}
+		return
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		kind := r.kinds[job.Kind]
+		r.interrupt(job, kind, "its worker stopped")
+		r.finish(job, kind, r.log.WithFields(logrus.Fields{"job_id": job.ID.Hex(), "kind": job.Kind, "attempt": job.Attempts}))
+	}
+}
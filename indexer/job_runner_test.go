@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memJobRepo records the last outcome written for each job and returns a
+// fixed list of expired jobs
+type memJobRepo struct {
+	repository.JobRepository
+	mu       sync.Mutex
+	finished map[primitive.ObjectID]models.Job
+	expired  []models.Job
+}
+
+func (r *memJobRepo) Heartbeat(ctx context.Context, job *models.Job, lease time.Duration) error {
+	return nil
+}
+
+func (r *memJobRepo) Finish(ctx context.Context, job *models.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished[job.ID] = *job
+	return nil
+}
+
+func (r *memJobRepo) ListExpired(ctx context.Context) ([]models.Job, error) {
+	return r.expired, nil
+}
+
+func newTestRunner(kinds map[string]JobKind) (*JobRunner, *memJobRepo) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	repo := &memJobRepo{finished: map[primitive.ObjectID]models.Job{}}
+	cfg := JobConfig{Workers: 1, PollInterval: time.Second, Lease: time.Minute, MaxAttempts: 3}
+	return NewJobRunner(repo, kinds, cfg, log), repo
+}
+
+func claimedJob(kind string, attempts int) *models.Job {
+	return &models.Job{ID: primitive.NewObjectID(), Kind: kind, Status: models.JobStatusRunning, Attempts: attempts, Worker: "w"}
+}
+
+func TestJobRunner_Run(t *testing.T) {
+	var cleaned []string
+	cleanup := func(ctx context.Context, job *models.Job) error {
+		cleaned = append(cleaned, job.Status)
+		return nil
+	}
+	runner, repo := newTestRunner(map[string]JobKind{
+		"ok": {
+			Run: func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+				progress(40)
+				return map[string]int{"created": 2}, nil
+			},
+			Cleanup: cleanup,
+		},
+		"broken": {
+			Run: func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+				progress(250)
+				return nil, errors.New("mongo down")
+			},
+			Cleanup: cleanup,
+		},
+		"panics": {
+			Run: func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+				panic("nil map")
+			},
+		},
+	})
+
+	ok, broken, panics, unknown := claimedJob("ok", 1), claimedJob("broken", 1), claimedJob("panics", 1), claimedJob("export", 1)
+	for _, job := range []*models.Job{ok, broken, panics, unknown} {
+		runner.run(context.Background(), job)
+	}
+
+	done := repo.finished[ok.ID]
+	assert.Equal(t, models.JobStatusSucceeded, done.Status)
+	assert.JSONEq(t, `{"created": 2}`, string(done.Result))
+	assert.Equal(t, 100.0, done.Progress)
+
+	failed := repo.finished[broken.ID]
+	assert.Equal(t, models.JobStatusFailed, failed.Status)
+	assert.Equal(t, "mongo down", failed.Error)
+	assert.Equal(t, 100.0, failed.Progress, "progress is capped")
+	assert.Nil(t, failed.Result)
+
+	assert.Equal(t, "job panicked: nil map", repo.finished[panics.ID].Error)
+	assert.Equal(t, `unknown job kind "export"`, repo.finished[unknown.ID].Error)
+	assert.Equal(t, []string{models.JobStatusSucceeded, models.JobStatusFailed}, cleaned)
+}
+
+func TestJobRunner_Shutdown(t *testing.T) {
+	// Each job stops the server halfway through
+	var stop context.CancelFunc
+	run := func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+		progress(50)
+		stop()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	runner, repo := newTestRunner(map[string]JobKind{
+		"reindex": {Run: run, Resumable: true},
+		"import":  {Run: run},
+	})
+
+	for _, job := range []*models.Job{claimedJob("reindex", 1), claimedJob("import", 1)} {
+		var ctx context.Context
+		ctx, stop = context.WithCancel(context.Background())
+		runner.run(ctx, job)
+		stop()
+
+		finished := repo.finished[job.ID]
+		if job.Kind == "reindex" {
+			assert.Equal(t, models.JobStatusQueued, finished.Status, "a resumable job starts over after a restart")
+			assert.Empty(t, finished.Error)
+			assert.Zero(t, finished.Progress)
+			continue
+		}
+		assert.Equal(t, models.JobStatusFailed, finished.Status)
+		assert.Equal(t, "interrupted after 1 attempt(s): the server stopped before the job finished", finished.Error)
+		assert.Equal(t, 50.0, finished.Progress)
+	}
+}
+
+func TestJobRunner_RecoverExpired(t *testing.T) {
+	var cleaned []primitive.ObjectID
+	runner, repo := newTestRunner(map[string]JobKind{
+		"reindex": {Resumable: true},
+		"import": {Cleanup: func(ctx context.Context, job *models.Job) error {
+			cleaned = append(cleaned, job.ID)
+			return nil
+		}},
+	})
+	resumable, exhausted, imp := claimedJob("reindex", 1), claimedJob("reindex", 3), claimedJob("import", 1)
+	repo.expired = []models.Job{*resumable, *exhausted, *imp}
+
+	runner.recoverExpired(context.Background())
+
+	assert.Equal(t, models.JobStatusQueued, repo.finished[resumable.ID].Status)
+	assert.Equal(t, models.JobStatusFailed, repo.finished[exhausted.ID].Status, "resumable jobs give up after MaxAttempts")
+	assert.Equal(t, "interrupted after 3 attempt(s): its worker stopped before the job finished", repo.finished[exhausted.ID].Error)
+	require.Equal(t, models.JobStatusFailed, repo.finished[imp.ID].Status)
+	assert.Equal(t, []primitive.ObjectID{imp.ID}, cleaned)
+}
@@ -0,0 +1,162 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RelayConfig controls how often the outbox is polled and how failed
+// deliveries are retried.
+type RelayConfig struct {
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// LoadRelayConfig reads the relay settings from the environment, falling back
+// to defaults for anything unset or invalid.
+func LoadRelayConfig() RelayConfig {
+	return RelayConfig{
+		PollInterval: envDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		Lease:        envDuration("OUTBOX_LEASE", 30*time.Second),
+		MaxAttempts:  envInt("OUTBOX_MAX_ATTEMPTS", 10),
+		BaseBackoff:  envDuration("OUTBOX_BASE_BACKOFF", 2*time.Second),
+		MaxBackoff:   envDuration("OUTBOX_MAX_BACKOFF", 10*time.Minute),
+	}
+}
+
+// OutboxRelay delivers pending outbox entries to Elasticsearch. It always
+// indexes the current state of the book from MongoDB, so entries may be
+// applied more than once without harm.
+type OutboxRelay struct {
+	outbox repository.OutboxRepository
+	books  repository.BookRepository
+	index  repository.BookIndex
+	cfg    RelayConfig
+	log    *logrus.Logger
+}
+
+func NewOutboxRelay(outbox repository.OutboxRepository, books repository.BookRepository, index repository.BookIndex, cfg RelayConfig, log *logrus.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		outbox: outbox,
+		books:  books,
+		index:  index,
+		cfg:    cfg,
+		log:    log,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled. Each tick drains every entry
+// that is currently due.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	r.log.Info("outbox_relay_started")
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			r.log.Info("outbox_relay_stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *OutboxRelay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		entry, err := r.outbox.ClaimNext(ctx, r.cfg.Lease)
+		if err != nil {
+			r.log.WithError(err).Error("outbox_claim_failed")
+			return
+		}
+		if entry == nil {
+			return
+		}
+
+		if err := r.deliver(ctx, entry); err != nil {
+			r.fail(ctx, entry, err)
+			continue
+		}
+		if err := r.outbox.Complete(ctx, entry.ID); err != nil {
+			r.log.WithError(err).WithField("outbox_id", entry.ID.Hex()).Error("outbox_complete_failed")
+		}
+	}
+}
+
+func (r *OutboxRelay) deliver(ctx context.Context, entry *models.OutboxEntry) error {
+	id := entry.BookID.Hex()
+	if entry.Operation == models.OutboxOpDelete {
+		return r.index.Delete(ctx, id)
+	}
+
+	book, err := r.books.FindByID(ctx, id)
+	if errors.Is(err, repository.ErrBookNotFound) {
+		// Deleted after this entry was written; a delete entry follows.
+		return r.index.Delete(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	return r.index.Index(ctx, book)
+}
+
+func (r *OutboxRelay) fail(ctx context.Context, entry *models.OutboxEntry, cause error) {
+	attempts := entry.Attempts + 1
+	dead := attempts >= r.cfg.MaxAttempts
+	next := time.Now().Add(Backoff(attempts, r.cfg.BaseBackoff, r.cfg.MaxBackoff))
+
+	fields := logrus.Fields{
+		"outbox_id": entry.ID.Hex(),
+		"book_id":   entry.BookID.Hex(),
+		"operation": entry.Operation,
+		"attempts":  attempts,
+	}
+	if dead {
+		r.log.WithFields(fields).WithError(cause).Error("outbox_entry_dead_lettered")
+	} else {
+		r.log.WithFields(fields).WithError(cause).Warn("outbox_delivery_failed")
+	}
+
+	if err := r.outbox.Fail(ctx, entry.ID, attempts, cause.Error(), next, dead); err != nil {
+		r.log.WithFields(fields).WithError(err).Error("outbox_fail_update_failed")
+	}
+}
+
+// Backoff returns the delay before the given attempt is retried: base doubled
+// for every previous attempt, capped at max.
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memOutbox hands out its entries in order and records what became of them
+type memOutbox struct {
+	repository.OutboxRepository
+	pending   []*models.OutboxEntry
+	completed []primitive.ObjectID
+	failed    []primitive.ObjectID
+}
+
+func (o *memOutbox) ClaimNext(ctx context.Context, lease time.Duration) (*models.OutboxEntry, error) {
+	if len(o.pending) == 0 {
+		return nil, nil
+	}
+	entry := o.pending[0]
+	o.pending = o.pending[1:]
+	return entry, nil
+}
+
+func (o *memOutbox) Complete(ctx context.Context, id primitive.ObjectID) error {
+	o.completed = append(o.completed, id)
+	return nil
+}
+
+func (o *memOutbox) Fail(ctx context.Context, id primitive.ObjectID, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error {
+	o.failed = append(o.failed, id)
+	return nil
+}
+
+// memBooks finds the books it holds, as the repository does
+type memBooks struct {
+	repository.BookRepository
+	books map[string]*models.Book
+}
+
+func (b *memBooks) FindByID(ctx context.Context, id string) (*models.Book, error) {
+	book, ok := b.books[id]
+	if !ok {
+		return nil, repository.ErrBookNotFound
+	}
+	return book, nil
+}
+
+// memIndex records the IDs indexed and deleted
+type memIndex struct {
+	repository.BookIndex
+	indexed []string
+	deleted []string
+}
+
+func (i *memIndex) Index(ctx context.Context, book *models.Book) error {
+	i.indexed = append(i.indexed, book.ID.Hex())
+	return nil
+}
+
+func (i *memIndex) Delete(ctx context.Context, id string) error {
+	i.deleted = append(i.deleted, id)
+	return nil
+}
+
+func TestOutboxRelay_Drain(t *testing.T) {
+	kept := &models.Book{ID: primitive.NewObjectID(), Title: "Kept"}
+	goneID := primitive.NewObjectID()
+	indexKept := &models.OutboxEntry{ID: primitive.NewObjectID(), BookID: kept.ID, Operation: models.OutboxOpIndex}
+	indexGone := &models.OutboxEntry{ID: primitive.NewObjectID(), BookID: goneID, Operation: models.OutboxOpIndex}
+
+	outbox := &memOutbox{pending: []*models.OutboxEntry{indexKept, indexGone}}
+	index := &memIndex{}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	relay := NewOutboxRelay(outbox, &memBooks{books: map[string]*models.Book{kept.ID.Hex(): kept}}, index, RelayConfig{MaxAttempts: 3}, log)
+
+	relay.drain(context.Background())
+
+	assert.Equal(t, []string{kept.ID.Hex()}, index.indexed)
+	assert.Equal(t, []string{goneID.Hex()}, index.deleted, "an index entry for a deleted book deletes it from the index")
+	assert.Equal(t, []primitive.ObjectID{indexKept.ID, indexGone.ID}, outbox.completed)
+	assert.Empty(t, outbox.failed)
+}
+
+func TestBackoff(t *testing.T) {
+	base := 2 * time.Second
+	max := time.Minute
+
+	assert.Equal(t, 2*time.Second, Backoff(1, base, max))
+	assert.Equal(t, 4*time.Second, Backoff(2, base, max))
+	assert.Equal(t, 32*time.Second, Backoff(5, base, max))
+	assert.Equal(t, time.Minute, Backoff(6, base, max))
+	assert.Equal(t, time.Minute, Backoff(60, base, max))
+}
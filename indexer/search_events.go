@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SearchEventConfig controls how search analytics events are buffered
+type SearchEventConfig struct {
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// LoadSearchEventConfig reads the analytics buffer settings from the
+// environment, falling back to defaults for anything unset or invalid.
+func LoadSearchEventConfig() SearchEventConfig {
+	return SearchEventConfig{
+		BufferSize:    envInt("SEARCH_EVENTS_BUFFER", 10000),
+		BatchSize:     envInt("SEARCH_EVENTS_BATCH_SIZE", 500),
+		FlushInterval: envDuration("SEARCH_EVENTS_FLUSH_INTERVAL", time.Second),
+	}
+}
+
+// SearchEventWriter indexes search analytics events in batches. Record never
+// blocks: when the buffer is full, because Elasticsearch is slow or down,
+// events are dropped and counted rather than holding up searches.
+type SearchEventWriter struct {
+	repo    repository.SearchEventRepository
+	cfg     SearchEventConfig
+	log     *logrus.Logger
+	events  chan models.SearchEvent
+	dropped atomic.Int64
+}
+
+func NewSearchEventWriter(repo repository.SearchEventRepository, cfg SearchEventConfig, log *logrus.Logger) *SearchEventWriter {
+	return &SearchEventWriter{
+		repo:   repo,
+		cfg:    cfg,
+		log:    log,
+		events: make(chan models.SearchEvent, cfg.BufferSize),
+	}
+}
+
+// Record queues an event for indexing
+func (w *SearchEventWriter) Record(event models.SearchEvent) {
+	select {
+	case w.events <- event:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Run writes queued events until ctx is cancelled, flushing whenever a batch
+// is full or the flush interval passes. Events still queued at shutdown are
+// written before it returns.
+func (w *SearchEventWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	w.log.Info("search_event_writer_started")
+	batch := make([]models.SearchEvent, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case event := <-w.events:
+			batch = append(batch, event)
+			if len(batch) >= w.cfg.BatchSize {
+				batch = w.flush(ctx, batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(ctx, batch)
+		case <-ctx.Done():
+			w.drain(batch)
+			w.log.Info("search_event_writer_stopped")
+			return
+		}
+	}
+}
+
+// drain writes the pending batch and everything left in the buffer, with a
+// short deadline of its own since ctx is already done
+func (w *SearchEventWriter) drain(batch []models.SearchEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for {
+		select {
+		case event := <-w.events:
+			batch = append(batch, event)
+			if len(batch) >= w.cfg.BatchSize {
+				batch = w.flush(ctx, batch)
+			}
+		default:
+			w.flush(ctx, batch)
+			return
+		}
+	}
+}
+
+// flush writes a batch and returns it emptied for reuse. A failed batch is
+// dropped: analytics are best effort and retrying would only grow the backlog.
+func (w *SearchEventWriter) flush(ctx context.Context, batch []models.SearchEvent) []models.SearchEvent {
+	if dropped := w.dropped.Swap(0); dropped > 0 {
+		w.log.WithField("dropped", dropped).Warn("search_events_dropped")
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := w.repo.Insert(ctx, batch); err != nil {
+		w.log.WithError(err).WithField("events", len(batch)).Error("search_events_write_failed")
+	}
+	return batch[:0]
+}
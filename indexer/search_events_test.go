@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventStore records the batches it is asked to insert
+type eventStore struct {
+	repository.SearchEventRepository
+	mu      sync.Mutex
+	batches [][]models.SearchEvent
+	err     error
+}
+
+func (s *eventStore) Insert(ctx context.Context, events []models.SearchEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]models.SearchEvent{}, events...))
+	return s.err
+}
+
+func (s *eventStore) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, b := range s.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func quietLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func TestSearchEventWriter_Batches(t *testing.T) {
+	store := &eventStore{}
+	w := NewSearchEventWriter(store, SearchEventConfig{BufferSize: 10, BatchSize: 2, FlushInterval: time.Hour}, quietLogger())
+	for _, q := range []string{"a", "b", "c"} {
+		w.Record(models.SearchEvent{Query: q})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return len(store.sizes()) == 1 }, time.Second, time.Millisecond, "a full batch is written at once")
+
+	cancel()
+	<-done
+	assert.Equal(t, []int{2, 1}, store.sizes(), "the rest is written on shutdown")
+}
+
+func TestSearchEventWriter_DropsWhenFull(t *testing.T) {
+	store := &eventStore{err: errors.New("es down")}
+	w := NewSearchEventWriter(store, SearchEventConfig{BufferSize: 2, BatchSize: 10, FlushInterval: time.Hour}, quietLogger())
+	for i := 0; i < 5; i++ {
+		w.Record(models.SearchEvent{})
+	}
+	assert.Equal(t, int64(3), w.dropped.Load(), "Record never blocks")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.Run(ctx)
+	assert.Equal(t, []int{2}, store.sizes())
+	assert.Zero(t, w.dropped.Load(), "the drop count is reported and reset")
+}
@@ -0,0 +1,132 @@
+package indexer
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/webhook"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WebhookConfig controls how often queued webhooks are polled, how long one
+// call may take and how failed calls are retried.
+type WebhookConfig struct {
+	PollInterval time.Duration
+	Timeout      time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// LoadWebhookConfig reads the webhook settings from the environment, falling
+// back to defaults for anything unset or invalid.
+func LoadWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		PollInterval: envDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		Timeout:      envDuration("WEBHOOK_TIMEOUT", 5*time.Second),
+		Lease:        envDuration("WEBHOOK_LEASE", 30*time.Second),
+		MaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		BaseBackoff:  envDuration("WEBHOOK_BASE_BACKOFF", 5*time.Second),
+		MaxBackoff:   envDuration("WEBHOOK_MAX_BACKOFF", time.Hour),
+	}
+}
+
+// WebhookDispatcher POSTs the notifications queued for a webhook, apart from
+// indexing so that a slow or failing endpoint only delays its own
+// notifications. A notification may be delivered more than once if the
+// outcome cannot be recorded.
+type WebhookDispatcher struct {
+	notifications repository.NotificationRepository
+	sender        webhook.Sender
+	cfg           WebhookConfig
+	log           *logrus.Logger
+}
+
+func NewWebhookDispatcher(notifications repository.NotificationRepository, sender webhook.Sender, cfg WebhookConfig, log *logrus.Logger) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		notifications: notifications,
+		sender:        sender,
+		cfg:           cfg,
+		log:           log,
+	}
+}
+
+// Run polls the queue until ctx is cancelled. Each tick drains every webhook
+// that is currently due.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	d.log.Info("webhook_dispatcher_started")
+	for {
+		d.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			d.log.Info("webhook_dispatcher_stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := d.notifications.ClaimWebhook(ctx, d.cfg.Lease)
+		if err != nil {
+			d.log.WithError(err).Error("webhook_claim_failed")
+			return
+		}
+		if n == nil {
+			return
+		}
+		d.deliver(ctx, n)
+	}
+}
+
+// deliver POSTs n to its webhook once and records the outcome, scheduling a
+// retry if the call failed and attempts are left
+func (d *WebhookDispatcher) deliver(ctx context.Context, n *models.Notification) {
+	delivery := *n.Webhook
+	delivery.Attempts++
+	delivery.AttemptedAt = time.Now()
+	delivery.LockedUntil = time.Time{}
+
+	// The receiver gets the notification, not the state of its delivery
+	payload := *n
+	payload.Webhook = nil
+	sendCtx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	status, err := d.sender.Send(sendCtx, delivery.URL, payload)
+	cancel()
+
+	delivery.Status = status
+	delivery.Error = ""
+	delivery.Pending = false
+	delivery.NextAttemptAt = time.Time{}
+
+	fields := logrus.Fields{
+		"notification_id": n.ID.Hex(),
+		"saved_search_id": n.SavedSearchID.Hex(),
+		"attempts":        delivery.Attempts,
+		"status":          status,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		if delivery.Attempts < d.cfg.MaxAttempts {
+			delivery.Pending = true
+			delivery.NextAttemptAt = time.Now().Add(Backoff(delivery.Attempts, d.cfg.BaseBackoff, d.cfg.MaxBackoff))
+			d.log.WithFields(fields).WithError(err).Warn("webhook_delivery_failed")
+		} else {
+			d.log.WithFields(fields).WithError(err).Error("webhook_delivery_abandoned")
+		}
+	}
+
+	// Recorded with a fresh context so that a delivery interrupted by
+	// shutdown is still rescheduled
+	if err := d.notifications.SetWebhook(context.WithoutCancel(ctx), n.ID, delivery); err != nil {
+		d.log.WithFields(fields).WithError(err).Error("webhook_record_failed")
+	}
+}
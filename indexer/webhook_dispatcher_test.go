@@ -0,0 +1,119 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memWebhookQueue hands out its queued notifications in order and records
+// every outcome
+type memWebhookQueue struct {
+	repository.NotificationRepository
+	queued   []*models.Notification
+	recorded []models.WebhookDelivery
+}
+
+func (q *memWebhookQueue) ClaimWebhook(ctx context.Context, lease time.Duration) (*models.Notification, error) {
+	if len(q.queued) == 0 {
+		return nil, nil
+	}
+	n := q.queued[0]
+	q.queued = q.queued[1:]
+	return n, nil
+}
+
+func (q *memWebhookQueue) SetWebhook(ctx context.Context, id primitive.ObjectID, delivery models.WebhookDelivery) error {
+	q.recorded = append(q.recorded, delivery)
+	return nil
+}
+
+// fakeSender answers with the status set for each URL, failing on anything
+// but 2xx
+type fakeSender struct {
+	status   map[string]int
+	payloads []interface{}
+}
+
+func (s *fakeSender) Send(ctx context.Context, url string, payload interface{}) (int, error) {
+	s.payloads = append(s.payloads, payload)
+	status := s.status[url]
+	if status < 200 || status > 299 {
+		return status, errors.New("webhook responded " + http.StatusText(status))
+	}
+	return status, nil
+}
+
+func newTestWebhookDispatcher(queue *memWebhookQueue, sender *fakeSender) *WebhookDispatcher {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	cfg := WebhookConfig{Timeout: time.Second, MaxAttempts: 3, BaseBackoff: time.Minute, MaxBackoff: time.Hour}
+	return NewWebhookDispatcher(queue, sender, cfg, log)
+}
+
+func queuedNotification(url string, attempts int) *models.Notification {
+	return &models.Notification{
+		ID:            primitive.NewObjectID(),
+		SavedSearchID: primitive.NewObjectID(),
+		Book:          models.NotifiedBook{ID: primitive.NewObjectID(), Title: "Learning Go"},
+		Webhook: &models.WebhookDelivery{
+			URL:           url,
+			Pending:       true,
+			Attempts:      attempts,
+			NextAttemptAt: time.Now(),
+			LockedUntil:   time.Now().Add(time.Minute),
+		},
+	}
+}
+
+func TestWebhookDispatcher_Drain(t *testing.T) {
+	ok := queuedNotification("https://hooks.example.com/ok", 0)
+	down := queuedNotification("https://hooks.example.com/down", 0)
+	lastTry := queuedNotification("https://hooks.example.com/down", 2)
+
+	queue := &memWebhookQueue{queued: []*models.Notification{ok, down, lastTry}}
+	sender := &fakeSender{status: map[string]int{
+		"https://hooks.example.com/ok":   http.StatusNoContent,
+		"https://hooks.example.com/down": http.StatusBadGateway,
+	}}
+	before := time.Now()
+	newTestWebhookDispatcher(queue, sender).drain(context.Background())
+
+	require.Len(t, sender.payloads, 3)
+	sent := sender.payloads[0].(models.Notification)
+	assert.Equal(t, ok.ID, sent.ID)
+	assert.Equal(t, "Learning Go", sent.Book.Title)
+	assert.Nil(t, sent.Webhook, "the delivery state is not sent")
+
+	require.Len(t, queue.recorded, 3)
+	delivered := queue.recorded[0]
+	assert.False(t, delivered.Pending)
+	assert.Equal(t, 1, delivered.Attempts)
+	assert.Equal(t, http.StatusNoContent, delivered.Status)
+	assert.Empty(t, delivered.Error)
+	assert.True(t, delivered.LockedUntil.IsZero(), "the lease is released")
+
+	retried := queue.recorded[1]
+	assert.True(t, retried.Pending, "a failed call is retried")
+	assert.Equal(t, 1, retried.Attempts)
+	assert.Equal(t, http.StatusBadGateway, retried.Status)
+	assert.Contains(t, retried.Error, "Bad Gateway")
+	assert.False(t, retried.NextAttemptAt.Before(before.Add(time.Minute)), "after a backoff")
+	assert.True(t, retried.LockedUntil.IsZero())
+
+	abandoned := queue.recorded[2]
+	assert.False(t, abandoned.Pending, "the last attempt is not retried")
+	assert.Equal(t, 3, abandoned.Attempts)
+	assert.Contains(t, abandoned.Error, "Bad Gateway")
+	assert.True(t, abandoned.NextAttemptAt.IsZero())
+}
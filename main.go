@@ -4,16 +4,37 @@ import (
 	"context"
 	"go-elastic/database"
 	"go-elastic/handler"
+	"go-elastic/indexer"
+	"go-elastic/models"
 	"go-elastic/repository"
 	"go-elastic/service"
+	"go-elastic/webhook"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/contrib/otelfiber"
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
 )
 
+// shutdownTimeout is how long open requests get to finish after SIGINT or
+// SIGTERM
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	// Deferred first so it runs last, after the other deferred cleanups
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	// ---- Load .env file ----
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using defaults")
@@ -27,25 +48,12 @@ func main() {
 	defer database.CloseDB()
 
 	// ---- Elasticsearch ----
-	database.InitElasticsearch()
-	// Create books index
-	bookMapping := `{
-		"mappings": {
-			"properties": {
-				"title": {"type": "text"},
-				"author": {"type": "text"},
-				"isbn": {"type": "keyword"},
-				"description": {"type": "text"},
-				"publisher": {"type": "text"},
-				"publish_date": {"type": "date"},
-				"pages": {"type": "integer"},
-				"language": {"type": "keyword"},
-				"created_at": {"type": "date"},
-				"updated_at": {"type": "date"}
-			}
-		}
-	}`
-	database.CreateIndexIfNotExists("books", bookMapping)
+	// The app starts without Elasticsearch; searches fall back to MongoDB
+	// until the health monitor sees it come back.
+	esErr := database.InitElasticsearch()
+	if esErr != nil {
+		logger.WithError(esErr).Warn("elasticsearch_unavailable")
+	}
 
 	// ---- Dependency Injection (Three-tier) ----
 	userCollection := database.DB.Collection("users")
@@ -53,17 +61,134 @@ func main() {
 	userSvc := service.NewUserService(userRepo)
 	userHandler := handler.NewUserHandler(userSvc)
 
+	outboxRepo := repository.NewOutboxRepository(database.DB.Collection("outbox"))
+	if err := outboxRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.WithError(err).Error("outbox_index_setup_failed")
+	}
+	outboxSvc := service.NewOutboxService(outboxRepo)
+	outboxHandler := handler.NewOutboxHandler(outboxSvc)
+
+	// In change stream mode the repository writes books only; the indexer
+	// picks the changes up from MongoDB.
+	indexMode := indexer.LoadMode()
+	bookOutbox := outboxRepo
+	if indexMode == indexer.ModeChangeStream {
+		bookOutbox = nil
+	}
+
+	// ---- Background workers ----
+	// Workers stop when the server does, after it has finished its requests;
+	// main waits for them, so they can drain buffers and release jobs.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx)
+		}()
+	}
+
+	// Search analytics are buffered and written in batches off the request path
+	searchEventRepo := repository.NewSearchEventRepository()
+	searchEventWriter := indexer.NewSearchEventWriter(searchEventRepo, indexer.LoadSearchEventConfig(), logger)
+	runWorker(searchEventWriter.Run)
+	searchAnalyticsSvc := service.NewSearchAnalyticsService(searchEventRepo, searchEventWriter)
+	searchAnalyticsHandler := handler.NewSearchAnalyticsHandler(searchAnalyticsSvc)
+
 	bookCollection := database.DB.Collection("books")
-	bookRepo := repository.NewBookRepository(bookCollection)
-	bookSvc := service.NewBookService(bookRepo)
-	bookHandler := handler.NewBookHandler(bookSvc)
+	bookRepo := repository.NewBookRepository(bookCollection, bookOutbox)
+	if err := bookRepo.EnsureTextIndex(context.Background()); err != nil {
+		logger.WithError(err).Error("book_text_index_setup_failed")
+	}
+	esHealth := indexer.NewHealthMonitor(database.PingElasticsearch, esErr == nil, indexer.LoadHealthConfig(), logger)
+	searchCfg := service.LoadSearchConfig()
+	searchCfg.Health = esHealth
+	bookSvc := service.NewBookService(bookRepo, searchCfg)
+	bookHandler := handler.NewBookHandler(bookSvc, searchAnalyticsSvc)
+
+	bookIndex := repository.NewBookIndex(searchCfg.Embedder)
+	relayCfg := indexer.LoadRelayConfig()
+
+	// Saved searches are percolated with every book the relay or change
+	// stream indexes; a new percolator index is filled from MongoDB.
+	savedSearchRepo := repository.NewSavedSearchRepository(database.DB.Collection("saved_searches"))
+	notificationRepo := repository.NewNotificationRepository(database.DB.Collection("notifications"))
+	if err := notificationRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.WithError(err).Error("notification_index_setup_failed")
+	}
+	savedSearchIndex := database.SavedSearchIndexName(database.LatestBookMappingVersion())
+	savedSearchSvc := service.NewSavedSearchService(savedSearchRepo, repository.NewSavedSearchIndex(savedSearchIndex),
+		notificationRepo, searchCfg)
+	savedSearchHandler := handler.NewSavedSearchHandler(savedSearchSvc)
+
+	// Notifications for a webhook are queued with the notification and
+	// POSTed by their own worker, retrying failed calls
+	webhookCfg := indexer.LoadWebhookConfig()
+	webhookDispatcher := indexer.NewWebhookDispatcher(notificationRepo, webhook.NewSender(webhookCfg.Timeout), webhookCfg, logger)
+	runWorker(webhookDispatcher.Run)
+
+	// Indices are set up again whenever Elasticsearch comes back, in case it
+	// came back empty.
+	setupIndices := func() { setupSearchIndices(logger, savedSearchSvc) }
+	if esHealth.Healthy() {
+		setupIndices()
+	}
+	esHealth.OnRecover(setupIndices)
+	runWorker(esHealth.Run)
+
+	alertingIndex := service.NewAlertingBookIndex(bookIndex, savedSearchSvc)
+
+	// The relay also runs in change stream mode to drain entries written
+	// before the switch.
+	relay := indexer.NewOutboxRelay(outboxRepo, bookRepo, alertingIndex, relayCfg, logger)
+	runWorker(relay.Run)
+
+	if indexMode == indexer.ModeChangeStream {
+		tokenRepo := repository.NewResumeTokenRepository(database.DB.Collection("indexer_state"))
+		changeStream := indexer.NewChangeStreamIndexer(bookCollection, tokenRepo, alertingIndex, relayCfg, logger)
+		runWorker(changeStream.Run)
+	}
+	logger.WithField("mode", indexMode).Info("search_indexing_configured")
+
+	// Imports, reindexes and repairs run as jobs, queued in MongoDB and run
+	// by a fixed pool of workers; import bodies wait in GridFS.
+	jobRepo := repository.NewJobRepository(database.DB.Collection("jobs"))
+	if err := jobRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.WithError(err).Error("job_index_setup_failed")
+	}
+	jobSvc := service.NewJobService(jobRepo)
+	jobHandler := handler.NewJobHandler(jobSvc)
+	uploadRepo := repository.NewUploadRepository(database.DB)
+
+	reconcileSvc := service.NewReconcileService(bookRepo, bookIndex)
+	reconcileHandler := handler.NewReconcileHandler(reconcileSvc, jobSvc)
+
+	analysisRepo := repository.NewSearchAnalysisRepository(database.DB.Collection("synonym_sets"), database.DB.Collection("stopword_lists"))
+	analysisSvc := service.NewSearchAnalysisService(analysisRepo)
+	searchAnalysisHandler := handler.NewSearchAnalysisHandler(analysisSvc, jobSvc)
+
+	// Imports index in bulk and so, like reindexing, do not notify saved
+	// searches. Each batch is queued in the outbox with its insert, for the
+	// relay to retry rejected books or finish an interrupted batch.
+	bookImportSvc := service.NewBookImportService(bookRepo, bookIndex, bookOutbox, uploadRepo, jobSvc, service.DefaultImportBatchSize)
+	bookImportHandler := handler.NewBookImportHandler(bookImportSvc)
+
+	bookIndexSvc := service.NewBookIndexService(bookRepo, reconcileSvc, analysisSvc, searchCfg.Embedder, service.LoadKeepBookIndices())
+	bookIndexHandler := handler.NewBookIndexHandler(bookIndexSvc, jobSvc)
+
+	jobRunner := indexer.NewJobRunner(jobRepo, jobKinds(bookImportSvc, bookIndexSvc, reconcileSvc), indexer.LoadJobConfig(), logger)
+	runWorker(jobRunner.Run)
 
 	// ---- Tracer ----
 	shutdown := InitTracer()
 	defer shutdown(context.Background())
 
 	// ---- Fiber ----
-	app := fiber.New()
+	// Request bodies over the body limit are streamed to the handler, so
+	// bulk imports can be larger than it. SetupRoutes holds every other
+	// route to the limit with BodyLimitMiddleware.
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
 
 	// ---- Middlewares (ORDER MATTERS) ----
 	app.Use(RecoveryMiddleware(logger))
@@ -75,8 +200,93 @@ func main() {
 	app.Use(LoggerMiddleware(logger))
 
 	// ---- Routes ----
-	SetupRoutes(app, logger, userHandler, bookHandler)
+	SetupRoutes(app, logger, userHandler, bookHandler, bookImportHandler, outboxHandler, reconcileHandler, bookIndexHandler, searchAnalysisHandler, searchAnalyticsHandler, savedSearchHandler, jobHandler)
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- app.Listen(":8080") }()
 	logger.Info("server starting on :8080")
-	logger.Fatal(app.Listen(":8080"))
+
+	select {
+	case err := <-listenErr:
+		logger.WithError(err).Error("server_failed")
+		exitCode = 1
+	case <-signals.Done():
+		logger.Info("server_stopping")
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			logger.WithError(err).Warn("server_shutdown_failed")
+		}
+	}
+
+	stopWorkers()
+	workers.Wait()
+	logger.Info("server_stopped")
+}
+
+// setupSearchIndices makes sure the Elasticsearch indices exist, and fills a
+// newly created saved search index from MongoDB
+func setupSearchIndices(logger *logrus.Logger, savedSearches service.SavedSearchService) {
+	// Books live in versioned indices behind the books/books_write aliases;
+	// mappings are in database/mappings.
+	liveIndex, err := database.EnsureBookIndex()
+	if err != nil {
+		logger.WithError(err).Error("book_index_setup_failed")
+	} else if database.BookIndexVersion(liveIndex) < database.LatestBookMappingVersion() {
+		logger.WithFields(logrus.Fields{
+			"live_index":     liveIndex,
+			"latest_version": database.LatestBookMappingVersion(),
+			"hint":           "go run ./cmd/reindex",
+		}).Warn("book_index_outdated")
+	}
+	if err := database.EnsureSearchEventsIndex(); err != nil {
+		logger.WithError(err).Error("search_events_index_setup_failed")
+	}
+
+	savedSearchIndex, created, err := database.EnsureSavedSearchIndex()
+	if err != nil {
+		logger.WithError(err).Error("saved_search_index_setup_failed")
+		return
+	}
+	if created {
+		registered, err := savedSearches.RegisterAll(context.Background())
+		if err != nil {
+			logger.WithError(err).Error("saved_search_registration_failed")
+		} else if err := database.DeleteOldSavedSearchIndices(); err != nil {
+			logger.WithError(err).Warn("saved_search_index_cleanup_failed")
+		}
+		logger.WithFields(logrus.Fields{"index": savedSearchIndex, "registered": registered}).Info("saved_search_index_created")
+	}
+}
+
+// jobKinds maps each job kind to the service that runs it. Reindexing and
+// reconciling start from scratch anyway, so an interrupted one is run again;
+// an interrupted import has stored part of its books and fails instead.
+func jobKinds(imports service.BookImportService, indices service.BookIndexService, reconcile service.ReconcileService) map[string]indexer.JobKind {
+	return map[string]indexer.JobKind{
+		models.JobKindImport: {
+			Run: func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+				return imports.ImportUpload(ctx, job.Params, progress)
+			},
+			Cleanup: func(ctx context.Context, job *models.Job) error {
+				return imports.DeleteUpload(ctx, job.Params)
+			},
+		},
+		models.JobKindReindex: {
+			Run: func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+				if job.Params.Rebuild {
+					return indices.Rebuild(ctx, progress)
+				}
+				return indices.Reindex(ctx, job.Params.Version, progress)
+			},
+			Resumable: true,
+		},
+		models.JobKindReconcile: {
+			Run: func(ctx context.Context, job *models.Job, progress func(float64)) (interface{}, error) {
+				return reconcile.Reconcile(ctx, true, progress)
+			},
+			Resumable: true,
+		},
+	}
 }
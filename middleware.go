@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -56,9 +57,45 @@ func LoggerMiddleware(log *logrus.Logger) fiber.Handler {
 }
 func CORSMiddleware() fiber.Handler {
 	return cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization,X-Request-ID",
-		MaxAge:       3600,
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,PUT,DELETE,PATCH,OPTIONS",
+		AllowHeaders:  "Origin,Content-Type,Accept,Authorization,X-Request-ID,X-User-ID",
+		ExposeHeaders: "X-Request-ID,X-Total-Count,Link,X-Search-Degraded",
+		MaxAge:        3600,
 	})
-}
\ No newline at end of file
+}
+
+// BodyLimitMiddleware answers 413 for request bodies over limit bytes. The
+// app streams request bodies so that bulk imports can be larger than its
+// body limit, and a streamed body is read whole by c.Body() and BodyParser
+// whatever its size, so routes registered after this are held to the limit
+// here instead.
+func BodyLimitMiddleware(limit int) fiber.Handler {
+	tooLarge := func(c *fiber.Ctx) error {
+		// The rest of the body is left unread on the connection
+		c.Context().SetConnectionClose()
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "Request body too large"})
+	}
+
+	return func(c *fiber.Ctx) error {
+		req := c.Request()
+		if req.Header.ContentLength() > limit {
+			return tooLarge(c)
+		}
+		if !req.IsBodyStream() {
+			return c.Next()
+		}
+
+		// Chunked bodies have no length up front, so read one byte past the
+		// limit to tell.
+		body, err := io.ReadAll(io.LimitReader(req.BodyStream(), int64(limit)+1))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot read request body"})
+		}
+		if len(body) > limit {
+			return tooLarge(c)
+		}
+		req.SetBody(body)
+		return c.Next()
+	}
+}
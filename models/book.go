@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,4 +19,38 @@ type Book struct {
 	Language    string             `bson:"language,omitempty" json:"language,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt   time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	// Extra holds the fields of an imported MARC, ONIX or BibTeX record that
+	// Book does not model, keyed by format and field, so that exporting the
+	// book in that format writes them back unchanged. It is not indexed.
+	Extra map[string][]string `bson:"extra,omitempty" json:"extra,omitempty"`
+}
+
+// ErrBookRequiredFields is returned by Validate for a book without a title
+// or author
+var ErrBookRequiredFields = errors.New("Title and Author are required")
+
+// Validate checks the fields every created or replaced book must have
+func (b *Book) Validate() error {
+	if b.Title == "" || b.Author == "" {
+		return ErrBookRequiredFields
+	}
+	return nil
+}
+
+// BookPatch is a partial update of a book. Nil fields are left unchanged.
+type BookPatch struct {
+	Title       *string    `json:"title"`
+	Author      *string    `json:"author"`
+	ISBN        *string    `json:"isbn"`
+	Description *string    `json:"description"`
+	Publisher   *string    `json:"publisher"`
+	PublishDate *time.Time `json:"publish_date"`
+	Pages       *int       `json:"pages"`
+	Language    *string    `json:"language"`
+}
+
+// IsEmpty reports whether the patch changes no fields.
+func (p *BookPatch) IsEmpty() bool {
+	return p.Title == nil && p.Author == nil && p.ISBN == nil && p.Description == nil &&
+		p.Publisher == nil && p.PublishDate == nil && p.Pages == nil && p.Language == nil
 }
@@ -0,0 +1,40 @@
+package models
+
+// Import row statuses
+const (
+	ImportRowCreated = "created"
+	// ImportRowInvalid rows could not be read as a book or failed validation
+	ImportRowInvalid = "invalid"
+	// ImportRowFailed rows were valid but MongoDB did not store them
+	ImportRowFailed = "failed"
+)
+
+// ImportRow is the outcome of a record of an imported file that was not
+// created and indexed
+type ImportRow struct {
+	// Row is the line number for NDJSON and CSV, and the position in the
+	// array for JSON
+	Row    int    `json:"row"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Indexed is false for a created book that Elasticsearch did not take;
+	// it is indexed later by the outbox relay or change stream indexer.
+	Indexed bool   `json:"indexed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ImportReport is the result of a bulk import
+type ImportReport struct {
+	Total   int `json:"total"`
+	Created int `json:"created"`
+	Indexed int `json:"indexed"`
+	Invalid int `json:"invalid"`
+	Failed  int `json:"failed"`
+	// Error is set when the import stopped before the end of the file, such
+	// as on malformed JSON. Rows before it are imported.
+	Error string `json:"error,omitempty"`
+	// Rows lists the records that were not created and indexed, in file
+	// order, up to a limit; RowsOmitted counts those past it.
+	Rows        []ImportRow `json:"rows"`
+	RowsOmitted int         `json:"rows_omitted,omitempty"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// MappingChange is one field that differs between a mapping file and the live
+// index. Field uses dotted paths; multi-fields appear as e.g. "author.keyword".
+// An empty Have is a field the live index lacks; an empty Want is a field that
+// only the live index has.
+type MappingChange struct {
+	Field string `json:"field"`
+	Want  string `json:"want,omitempty"`
+	Have  string `json:"have,omitempty"`
+}
+
+// BookIndexStatus describes the index behind the books aliases and how its
+// mapping compares with the latest mapping file.
+type BookIndexStatus struct {
+	LiveIndex     string          `json:"live_index"`
+	LiveVersion   int             `json:"live_version"`
+	LatestVersion int             `json:"latest_version"`
+	Versions      []int           `json:"versions"`
+	UpToDate      bool            `json:"up_to_date"`
+	Changes       []MappingChange `json:"changes"`
+}
+
+// ReindexResult reports a rebuild of the books index from MongoDB
+type ReindexResult struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	FromIndex  string    `json:"from_index"`
+	ToIndex    string    `json:"to_index"`
+	Version    int       `json:"version"`
+	Indexed    int       `json:"indexed"`
+	Failed     int       `json:"failed"`
+	Errors     []string  `json:"errors,omitempty"`
+	// DeletedIndices are the earlier indices removed after the alias swap,
+	// past the ones kept for rollback. CleanupError is set if removing them
+	// failed; the new index is live regardless.
+	DeletedIndices []string `json:"deleted_indices,omitempty"`
+	CleanupError   string   `json:"cleanup_error,omitempty"`
+	// CatchUp is the reconciliation run after the alias swap, which applies
+	// writes that reached the old index while the new one was being built.
+	CatchUp *DriftReport `json:"catch_up,omitempty"`
+}
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"go-elastic/querylang"
+	"strings"
+	"time"
+)
+
+// Search types accepted by BookService.SearchBooks. SearchTypeQuery takes a
+// querylang query with field prefixes and boolean operators.
+const (
+	SearchTypeTitle  = "title"
+	SearchTypeAuthor = "author"
+	SearchTypeMulti  = "multi"
+	SearchTypeQuery  = "query"
+)
+
+// Search modes. Keyword search ranks by BM25 term matching, semantic search
+// by vector similarity to the query (kNN), and hybrid search fuses both
+// rankings.
+const (
+	SearchModeKeyword  = "keyword"
+	SearchModeSemantic = "semantic"
+	SearchModeHybrid   = "hybrid"
+)
+
+// Match modes for the free-text query
+const (
+	MatchBestFields = ""
+	MatchPhrase     = "phrase"
+	MatchPrefix     = "prefix"
+)
+
+// Sort keys accepted by book listing and search. Relevance is only valid for
+// search.
+const (
+	SortRelevance   = "relevance"
+	SortTitle       = "title"
+	SortPublishDate = "publish_date"
+	SortPages       = "pages"
+	SortCreatedAt   = "created_at"
+)
+
+// SortField is a sort key and direction
+type SortField struct {
+	Key  string
+	Desc bool
+}
+
+// ParseSortField parses "key" (ascending) or "-key" (descending). It does
+// not check that the key is known.
+func ParseSortField(raw string) SortField {
+	if strings.HasPrefix(raw, "-") {
+		return SortField{Key: raw[1:], Desc: true}
+	}
+	return SortField{Key: raw}
+}
+
+// BookSearch is a search request against the books index
+type BookSearch struct {
+	Type  string
+	Mode  string
+	Query string
+	Match string
+	// Fields are the fields to query, with optional boosts ("title^3").
+	// The service fills them in from Type and the search configuration.
+	Fields []string
+	// Expression is the parsed Query for SearchTypeQuery. Terms without a
+	// field prefix search Fields.
+	Expression         querylang.Expr
+	MinimumShouldMatch string
+	// Fuzziness is the edit distance tolerated per term: "AUTO", "0", "1"
+	// or "2". It only applies to best-fields matching; "0" turns it off.
+	Fuzziness string
+	Filters   BookFilters
+
+	Page    int
+	PerPage int
+	Sort    SortField
+	// Cursor continues from the last hit of a previous page (search_after)
+	// and replaces Page for deep pagination.
+	Cursor string
+
+	// Highlight asks for the matched fragments of title, author and
+	// description.
+	Highlight bool
+
+	// Like finds books similar to this one instead of matching Query. The
+	// book itself is left out of the results.
+	Like *Book
+
+	// Vector is the embedding of Query for SearchModeSemantic. The service
+	// fills it in; it replaces the text query with a kNN search.
+	Vector []float32
+}
+
+// BookFilters narrow a search without affecting relevance. Zero values are
+// unset.
+type BookFilters struct {
+	Languages       []string
+	Authors         []string
+	Publishers      []string
+	PagesMin        int
+	PagesMax        int
+	PublishedAfter  time.Time
+	PublishedBefore time.Time
+}
+
+// IsEmpty reports whether no filter is set
+func (f BookFilters) IsEmpty() bool {
+	return len(f.Languages) == 0 && len(f.Authors) == 0 && len(f.Publishers) == 0 && f.PagesMin == 0 && f.PagesMax == 0 &&
+		f.PublishedAfter.IsZero() && f.PublishedBefore.IsZero()
+}
+
+// SearchFilters is the stored form of BookFilters, used where filters are
+// saved or logged rather than applied.
+type SearchFilters struct {
+	Languages       []string   `bson:"languages,omitempty" json:"languages,omitempty"`
+	Authors         []string   `bson:"authors,omitempty" json:"authors,omitempty"`
+	Publishers      []string   `bson:"publishers,omitempty" json:"publishers,omitempty"`
+	PagesMin        int        `bson:"pages_min,omitempty" json:"pages_min,omitempty"`
+	PagesMax        int        `bson:"pages_max,omitempty" json:"pages_max,omitempty"`
+	PublishedAfter  *time.Time `bson:"published_after,omitempty" json:"published_after,omitempty"`
+	PublishedBefore *time.Time `bson:"published_before,omitempty" json:"published_before,omitempty"`
+}
+
+// NewSearchFilters copies filters into their stored form. It returns nil
+// when no filter is set.
+func NewSearchFilters(f BookFilters) *SearchFilters {
+	if f.IsEmpty() {
+		return nil
+	}
+	filters := &SearchFilters{
+		Languages:  f.Languages,
+		Authors:    f.Authors,
+		Publishers: f.Publishers,
+		PagesMin:   f.PagesMin,
+		PagesMax:   f.PagesMax,
+	}
+	if !f.PublishedAfter.IsZero() {
+		filters.PublishedAfter = &f.PublishedAfter
+	}
+	if !f.PublishedBefore.IsZero() {
+		filters.PublishedBefore = &f.PublishedBefore
+	}
+	return filters
+}
+
+// BookFilters returns the filters to apply. A nil SearchFilters has none.
+func (f *SearchFilters) BookFilters() BookFilters {
+	if f == nil {
+		return BookFilters{}
+	}
+	filters := BookFilters{
+		Languages:  f.Languages,
+		Authors:    f.Authors,
+		Publishers: f.Publishers,
+		PagesMin:   f.PagesMin,
+		PagesMax:   f.PagesMax,
+	}
+	if f.PublishedAfter != nil {
+		filters.PublishedAfter = *f.PublishedAfter
+	}
+	if f.PublishedBefore != nil {
+		filters.PublishedBefore = *f.PublishedBefore
+	}
+	return filters
+}
+
+// BookSearchResult is the envelope returned by book search
+type BookSearchResult struct {
+	Hits    []BookHit `json:"hits"`
+	Total   int64     `json:"total"`
+	Page    int       `json:"page,omitempty"`
+	PerPage int       `json:"per_page"`
+	// NextCursor fetches the page after this one; it is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// DidYouMean lists spelling corrections of the query when it found few
+	// or no books.
+	DidYouMean []SpellingSuggestion `json:"did_you_mean,omitempty"`
+	Facets     *BookFacets          `json:"facets,omitempty"`
+	// Degraded is set when Elasticsearch was unavailable and the books were
+	// found with a plain MongoDB text search instead.
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// BookHit is one search result. Score is null when results are sorted by
+// something other than relevance. Highlights maps a field name to the
+// matched fragments, with matches wrapped in <em> tags and the rest of the
+// text HTML-escaped; it is only set when highlighting was requested.
+type BookHit struct {
+	Book       Book                `json:"book"`
+	Score      *float64            `json:"score"`
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// BookListOptions pages and sorts a listing of the books collection
+type BookListOptions struct {
+	Page    int
+	PerPage int
+	Sort    SortField
+}
+
+// BookList is one page of the books collection
+type BookList struct {
+	Books   []Book
+	Total   int64
+	Page    int
+	PerPage int
+}
+
+// BookFacets holds facet counts over all books matching a search. Each
+// bucket value can be passed back as the matching search filter.
+type BookFacets struct {
+	Languages    []FacetBucket `json:"languages"`
+	Publishers   []FacetBucket `json:"publishers"`
+	Authors      []FacetBucket `json:"authors"`
+	Pages        []RangeBucket `json:"pages"`
+	PublishYears []FacetBucket `json:"publish_years"`
+}
+
+// FacetBucket is a facet value and the number of matching books
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// RangeBucket is an inclusive numeric range and the number of matching books
+type RangeBucket struct {
+	Min   int   `json:"min"`
+	Max   int   `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// BookSuggestions are autocomplete candidates for a partially typed query
+type BookSuggestions struct {
+	Titles  []Suggestion `json:"titles"`
+	Authors []Suggestion `json:"authors"`
+}
+
+// Suggestion is one autocomplete candidate. Title suggestions carry the ID
+// of the book they come from; author suggestions are deduplicated and do not.
+type Suggestion struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+	ID    string  `json:"id,omitempty"`
+}
+
+// SpellingSuggestion is a corrected query. Highlighted marks the corrected
+// words with <em> tags.
+type SpellingSuggestion struct {
+	Text        string  `json:"text"`
+	Highlighted string  `json:"highlighted"`
+	Score       float64 `json:"score"`
+}
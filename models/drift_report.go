@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// DriftSet counts documents in one drift category. IDs is capped; Count is
+// always the full number.
+type DriftSet struct {
+	Count int      `json:"count"`
+	IDs   []string `json:"ids"`
+}
+
+// DriftReport is the result of comparing the MongoDB books collection with
+// the Elasticsearch books index.
+type DriftReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	MongoCount int       `json:"mongo_count"`
+	IndexCount int       `json:"index_count"`
+	// Missing books are in MongoDB but not in the index.
+	Missing DriftSet `json:"missing"`
+	// Stale books are indexed with an older updated_at than MongoDB has.
+	Stale DriftSet `json:"stale"`
+	// Orphaned documents are in the index but no longer in MongoDB.
+	Orphaned DriftSet `json:"orphaned"`
+
+	Repair       bool     `json:"repair"`
+	Repaired     int      `json:"repaired"`
+	RepairErrors []string `json:"repair_errors,omitempty"`
+}
+
+// InSync reports whether no drift was found
+func (r *DriftReport) InSync() bool {
+	return r.Missing.Count == 0 && r.Stale.Count == 0 && r.Orphaned.Count == 0
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job kinds
+const (
+	JobKindImport    = "import"
+	JobKindReindex   = "reindex"
+	JobKindReconcile = "reconcile"
+)
+
+// Job statuses. A job is queued until a worker claims it, and running until
+// it succeeds or fails. A running job whose worker stops is queued again or
+// failed, depending on whether its kind can safely start over.
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// JobParams are the inputs of a job. Each kind uses some of them.
+type JobParams struct {
+	// Format and Upload are the file format and the GridFS file of an import
+	Format string             `bson:"format,omitempty" json:"format,omitempty"`
+	Upload primitive.ObjectID `bson:"upload,omitempty" json:"-"`
+	// Version is the mapping version of a reindex, 0 for the latest
+	Version int `bson:"version,omitempty" json:"version,omitempty"`
+	// Rebuild makes a reindex build the live version again instead, to
+	// apply new stopwords
+	Rebuild bool `bson:"rebuild,omitempty" json:"rebuild,omitempty"`
+}
+
+// Job is a long-running operation run by the job workers
+type Job struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind   string             `bson:"kind" json:"kind"`
+	Status string             `bson:"status" json:"status"`
+	Params JobParams          `bson:"params" json:"params"`
+	// Progress is the percentage done, from 0 to 100
+	Progress float64 `bson:"progress" json:"progress"`
+	// Result is what a succeeded job returned, such as an import report. It
+	// is stored as JSON so that any kind's result reads back as it was.
+	Result json.RawMessage `bson:"result,omitempty" json:"result,omitempty"`
+	Error  string          `bson:"error,omitempty" json:"error,omitempty"`
+	// Attempts counts the times a worker has claimed the job
+	Attempts   int        `bson:"attempts" json:"attempts"`
+	Worker     string     `bson:"worker,omitempty" json:"-"`
+	LeaseUntil time.Time  `bson:"lease_until,omitempty" json:"-"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time  `bson:"updated_at" json:"updated_at"`
+	StartedAt  *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has succeeded or failed
+func (j *Job) Finished() bool {
+	return j.Status == JobStatusSucceeded || j.Status == JobStatusFailed
+}
+
+// JobListOptions narrows a job listing. Empty fields match every job.
+type JobListOptions struct {
+	Kind   string
+	Status string
+	Limit  int64
+}
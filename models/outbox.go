@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outbox operations describe what the relay must apply to the search index.
+const (
+	OutboxOpIndex  = "index"
+	OutboxOpDelete = "delete"
+)
+
+// Outbox statuses. Delivered entries are removed from the collection, so an
+// entry is either still pending delivery or dead-lettered after too many
+// failed attempts.
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusDead    = "dead"
+)
+
+// OutboxEntry records a search index change that was committed to MongoDB in
+// the same transaction as the book write and still has to reach Elasticsearch.
+type OutboxEntry struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BookID        primitive.ObjectID `bson:"book_id" json:"book_id"`
+	Operation     string             `bson:"operation" json:"operation"`
+	Status        string             `bson:"status" json:"status"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	LastError     string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   time.Time          `bson:"locked_until,omitempty" json:"-"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SavedSearch is a search a user wants to be alerted about. Query, Type,
+// Match and Filters mean the same as the search parameters of the same
+// names; the search is always a keyword search.
+type SavedSearch struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID  string             `bson:"user_id" json:"user_id"`
+	Name    string             `bson:"name" json:"name"`
+	Query   string             `bson:"query,omitempty" json:"query,omitempty"`
+	Type    string             `bson:"type" json:"type"`
+	Match   string             `bson:"match,omitempty" json:"match,omitempty"`
+	Filters *SearchFilters     `bson:"filters,omitempty" json:"filters,omitempty"`
+	// WebhookURL, when set, is POSTed every notification of this search.
+	WebhookURL string    `bson:"webhook_url,omitempty" json:"webhook_url,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// BookSearch returns the search the saved search stands for
+func (s *SavedSearch) BookSearch() BookSearch {
+	return BookSearch{
+		Type:    s.Type,
+		Mode:    SearchModeKeyword,
+		Query:   s.Query,
+		Match:   s.Match,
+		Filters: s.Filters.BookFilters(),
+	}
+}
+
+// Notification tells a user that a book matched one of their saved
+// searches. A book is notified once per saved search, however often it is
+// updated afterwards.
+type Notification struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID          string             `bson:"user_id" json:"user_id"`
+	SavedSearchID   primitive.ObjectID `bson:"saved_search_id" json:"saved_search_id"`
+	SavedSearchName string             `bson:"saved_search_name" json:"saved_search_name"`
+	Book            NotifiedBook       `bson:"book" json:"book"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	ReadAt          *time.Time         `bson:"read_at,omitempty" json:"read_at,omitempty"`
+	// Webhook is the delivery of the notification to the saved search's
+	// webhook, if it has one.
+	Webhook *WebhookDelivery `bson:"webhook,omitempty" json:"webhook,omitempty"`
+}
+
+// NotifiedBook is the part of a book a notification carries
+type NotifiedBook struct {
+	ID       primitive.ObjectID `bson:"id" json:"id"`
+	Title    string             `bson:"title" json:"title"`
+	Author   string             `bson:"author" json:"author"`
+	Language string             `bson:"language,omitempty" json:"language,omitempty"`
+}
+
+// WebhookDelivery queues a notification for its webhook and records the
+// last attempt to POST it. It is pending until an attempt succeeds or the
+// retries run out.
+type WebhookDelivery struct {
+	URL           string    `bson:"url" json:"url"`
+	Pending       bool      `bson:"pending" json:"pending"`
+	Attempts      int       `bson:"attempts" json:"attempts"`
+	Status        int       `bson:"status,omitempty" json:"status,omitempty"`
+	Error         string    `bson:"error,omitempty" json:"error,omitempty"`
+	AttemptedAt   time.Time `bson:"attempted_at,omitempty" json:"attempted_at,omitempty"`
+	NextAttemptAt time.Time `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	LockedUntil   time.Time `bson:"locked_until,omitempty" json:"-"`
+}
+
+// NotificationList is a page of a user's notifications
+type NotificationList struct {
+	Notifications []Notification `json:"notifications"`
+	Unread        int64          `json:"unread"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SynonymSet is a named group of synonym rules in Solr format. A rule lists
+// equivalent terms ("sci-fi, science fiction") or rewrites the terms on the
+// left to those on the right ("vol => volume").
+type SynonymSet struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Rules     []string           `bson:"rules" json:"rules"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// StopwordList is a named list of words that searches ignore
+type StopwordList struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Words     []string           `bson:"words" json:"words"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SearchAnalysisReload reports the synonyms and stopwords applied to an index
+type SearchAnalysisReload struct {
+	Index        string `json:"index"`
+	SynonymRules int    `json:"synonym_rules"`
+	Stopwords    int    `json:"stopwords"`
+	// StopwordsChanged is set when the stopwords of an index not yet
+	// behind the aliases were updated.
+	StopwordsChanged bool `json:"stopwords_changed"`
+	// RebuildRequired is set when the stored stopwords differ from those of
+	// the live index, which only a rebuild can change. RebuildJob is the job
+	// queued to rebuild it.
+	RebuildRequired bool      `json:"rebuild_required"`
+	RebuildJob      *Job      `json:"rebuild_job,omitempty"`
+	ReloadedAt      time.Time `json:"reloaded_at"`
+}
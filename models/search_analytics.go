@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+// Search analytics event kinds
+const (
+	SearchEventSearch = "search"
+	SearchEventClick  = "click"
+)
+
+// SearchEvent is one search or result click, stored in the search_events
+// index. Search events describe the request and its outcome; click events
+// name the search they came from and the book that was opened.
+type SearchEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+
+	// Query is the q parameter as sent; QueryNormalized is lower-cased with
+	// whitespace collapsed, so "Go  Programming" and "go programming" are
+	// counted together. Filter-only searches have neither.
+	Query           string         `json:"query,omitempty"`
+	QueryNormalized string         `json:"query_normalized,omitempty"`
+	Type            string         `json:"type,omitempty"`
+	Mode            string         `json:"mode,omitempty"`
+	Match           string         `json:"match,omitempty"`
+	Filters         *SearchFilters `json:"filters,omitempty"`
+	Page            int            `json:"page,omitempty"`
+	PerPage         int            `json:"per_page,omitempty"`
+	Hits            int64          `json:"hits"`
+	LatencyMS       float64        `json:"latency_ms,omitempty"`
+	// Status is the HTTP status of the search; failed searches have an Error.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// SearchID is the request_id of the search a click came from.
+	SearchID string `json:"search_id,omitempty"`
+	BookID   string `json:"book_id,omitempty"`
+	// Position is the 1-based rank of the clicked book in the results.
+	Position int `json:"position,omitempty"`
+}
+
+// SearchClick is a request to record that a user opened a search result
+type SearchClick struct {
+	SearchID string `json:"search_id"`
+	Query    string `json:"query"`
+	BookID   string `json:"book_id"`
+	Position int    `json:"position"`
+}
+
+// AnalyticsWindow is the time range an analytics report covers
+type AnalyticsWindow struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// QueryStats summarizes the searches for one normalized query and the
+// clicks on their results
+type QueryStats struct {
+	Query        string    `json:"query"`
+	Searches     int64     `json:"searches"`
+	ZeroResults  int64     `json:"zero_results"`
+	AvgHits      float64   `json:"avg_hits"`
+	AvgLatencyMS float64   `json:"avg_latency_ms"`
+	Users        int64     `json:"users"`
+	LastSearched time.Time `json:"last_searched"`
+	Clicks       int64     `json:"clicks"`
+	// ClickedSearches counts searches with at least one click, and
+	// ClickThroughRate is their share of Searches.
+	ClickedSearches  int64   `json:"clicked_searches"`
+	ClickThroughRate float64 `json:"click_through_rate"`
+	// AvgClickPosition is the mean rank of the clicked books.
+	AvgClickPosition float64 `json:"avg_click_position"`
+}
+
+// QueryReport lists query statistics over a window. The totals cover every
+// search in the window, not just those of the listed queries.
+type QueryReport struct {
+	AnalyticsWindow
+	Searches         int64        `json:"searches"`
+	ZeroResults      int64        `json:"zero_results"`
+	ClickedSearches  int64        `json:"clicked_searches"`
+	ClickThroughRate float64      `json:"click_through_rate"`
+	Queries          []QueryStats `json:"queries"`
+}
+
+// QueryReportOptions select the queries of a QueryReport
+type QueryReportOptions struct {
+	AnalyticsWindow
+	Size int
+	// ZeroResultsOnly limits the statistics to searches that found nothing.
+	ZeroResultsOnly bool
+	// MinSearches leaves out queries searched fewer times.
+	MinSearches int
+}
@@ -0,0 +1,90 @@
+// Package querylang parses the book search query language, e.g.
+//
+//	author:"John Doe" AND language:Japanese AND pages:>200 -title:draft
+//
+// into an expression tree. Only the fields in Fields can be searched, and
+// the number and nesting of clauses are capped, so a query can never reach
+// fields or query types the API does not expose.
+package querylang
+
+// Kind is how a field is matched
+type Kind int
+
+const (
+	// KindText fields are analyzed full text; values match words
+	KindText Kind = iota
+	// KindKeyword fields match the exact value
+	KindKeyword
+	// KindInteger fields take integers and comparisons
+	KindInteger
+	// KindDate fields take YYYY, YYYY-MM or YYYY-MM-DD and comparisons
+	KindDate
+)
+
+// Fields are the searchable fields and how they are matched
+var Fields = map[string]Kind{
+	"title":        KindText,
+	"author":       KindText,
+	"description":  KindText,
+	"publisher":    KindText,
+	"isbn":         KindKeyword,
+	"language":     KindKeyword,
+	"pages":        KindInteger,
+	"publish_date": KindDate,
+	"created_at":   KindDate,
+	"updated_at":   KindDate,
+}
+
+// Expr is a node of a parsed query
+type Expr interface {
+	expr()
+}
+
+// And matches books that match every clause
+type And struct {
+	Clauses []Expr
+}
+
+// Or matches books that match at least one clause
+type Or struct {
+	Clauses []Expr
+}
+
+// Not matches books that do not match the clause
+type Not struct {
+	Clause Expr
+}
+
+// Match is a full-text match. An empty Field means the default search
+// fields. Prefix is set for a trailing * and matches words starting with
+// Text.
+type Match struct {
+	Field  string
+	Text   string
+	Phrase bool
+	Prefix bool
+}
+
+// Term matches an exact keyword or integer value, or a keyword prefix
+type Term struct {
+	Field  string
+	Value  string
+	Prefix bool
+}
+
+// Range bounds an integer or date field. Empty bounds are open; dates are
+// normalized to YYYY-MM-DD.
+type Range struct {
+	Field string
+	GT    string
+	GTE   string
+	LT    string
+	LTE   string
+}
+
+func (And) expr()   {}
+func (Or) expr()    {}
+func (Not) expr()   {}
+func (Match) expr() {}
+func (Term) expr()  {}
+func (Range) expr() {}
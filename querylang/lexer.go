@@ -0,0 +1,131 @@
+package querylang
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokPhrase
+	tokColon
+	tokCompare
+	tokLParen
+	tokRParen
+	tokMinus
+	tokAnd
+	tokOr
+	tokNot
+)
+
+// token is a lexed token. Pos is the 1-based character position of its
+// first character in the input.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// SyntaxError reports where a query could not be parsed. Pos is the 1-based
+// character (not byte) position in the query.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// isSpecial reports whether r ends a word
+func isSpecial(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`():"<>`, r)
+}
+
+// lex splits a query into tokens. AND, OR and NOT are operators only in
+// upper case; a leading - negates the term it is attached to.
+func lex(input string) ([]token, error) {
+	runes := []rune(input)
+	var tokens []token
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: pos})
+			i++
+		case r == ':':
+			tokens = append(tokens, token{kind: tokColon, text: ":", pos: pos})
+			i++
+		case r == '<' || r == '>':
+			op := string(r)
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				op += "="
+				i++
+			}
+			tokens = append(tokens, token{kind: tokCompare, text: op, pos: pos})
+		case r == '-':
+			tokens = append(tokens, token{kind: tokMinus, text: "-", pos: pos})
+			i++
+		case r == '"':
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				c := runes[i]
+				if c == '\\' && i+1 < len(runes) {
+					b.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				i++
+				if c == '"' {
+					closed = true
+					break
+				}
+				b.WriteRune(c)
+			}
+			if !closed {
+				return nil, &SyntaxError{Pos: pos, Msg: "unterminated quoted phrase"}
+			}
+			tokens = append(tokens, token{kind: tokPhrase, text: b.String(), pos: pos})
+		default:
+			start := i
+			for i < len(runes) && !isSpecial(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			kind := tokWord
+			switch word {
+			case "AND":
+				kind = tokAnd
+			case "OR":
+				kind = tokOr
+			case "NOT":
+				kind = tokNot
+			}
+			tokens = append(tokens, token{kind: kind, text: word, pos: pos})
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(runes) + 1}), nil
+}
@@ -0,0 +1,438 @@
+package querylang
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits that keep a single query cheap to run
+const (
+	// MaxClauses is the number of terms, phrases and comparisons a query
+	// may contain.
+	MaxClauses = 32
+	// MaxDepth is how deeply parentheses and negations may nest
+	MaxDepth = 8
+	// MinPrefixLength is the number of characters required before a
+	// trailing *, so that a prefix cannot expand to most of the index.
+	MinPrefixLength = 3
+)
+
+// Parse parses a query. The grammar, loosest binding first:
+//
+//	query   = or
+//	or      = and { "OR" and }
+//	and     = unary { [ "AND" ] unary }
+//	unary   = ( "NOT" | "-" ) unary | primary
+//	primary = "(" or ")" | field ":" [ "<" | "<=" | ">" | ">=" ] value | value
+//	value   = word | word "*" | "\"" phrase "\"" | from ".." to
+//
+// Terms side by side are ANDed. Operators are upper case; field names are
+// not case sensitive.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, &SyntaxError{Pos: 1, Msg: "empty query"}
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens  []token
+	next    int
+	clauses int
+	depth   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokRParen {
+		return &SyntaxError{Pos: t.pos, Msg: `unexpected ")" without matching "("`}
+	}
+	return &SyntaxError{Pos: t.pos, Msg: "unexpected " + t.String()}
+}
+
+// startsTerm reports whether t can begin an operand of AND
+func startsTerm(t token) bool {
+	switch t.kind {
+	case tokWord, tokPhrase, tokLParen, tokMinus, tokNot:
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []Expr{first}
+	for p.peek().kind == tokOr {
+		op := p.advance()
+		if !startsTerm(p.peek()) {
+			return nil, &SyntaxError{Pos: op.pos, Msg: "OR must be followed by a term"}
+		}
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, next)
+	}
+
+	if len(clauses) == 1 {
+		return first, nil
+	}
+	return Or{Clauses: clauses}, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []Expr{first}
+	for {
+		if p.peek().kind == tokAnd {
+			op := p.advance()
+			if !startsTerm(p.peek()) {
+				return nil, &SyntaxError{Pos: op.pos, Msg: "AND must be followed by a term"}
+			}
+		} else if !startsTerm(p.peek()) {
+			break
+		}
+
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, next)
+	}
+
+	if len(clauses) == 1 {
+		return first, nil
+	}
+	return And{Clauses: clauses}, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.peek()
+	if t.kind != tokNot && t.kind != tokMinus {
+		return p.parsePrimary()
+	}
+
+	p.advance()
+	if !startsTerm(p.peek()) {
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("%s must be followed by a term", t.text)}
+	}
+	if err := p.enter(t); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	clause, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return Not{Clause: clause}, nil
+}
+
+func (p *parser) enter(t token) error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("query is nested more than %d levels deep", MaxDepth)}
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokLParen:
+		if err := p.enter(t); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		if p.peek().kind == tokRParen {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "empty parentheses"}
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing.kind != tokRParen {
+			if closing.kind == tokEOF {
+				return nil, &SyntaxError{Pos: t.pos, Msg: `missing ")" for this "("`}
+			}
+			return nil, p.unexpected(closing)
+		}
+		p.advance()
+		return expr, nil
+
+	case tokWord:
+		if p.peek().kind == tokColon {
+			return p.parseField(t)
+		}
+		if err := p.count(t); err != nil {
+			return nil, err
+		}
+		text, prefix, err := splitPrefix(t)
+		if err != nil {
+			return nil, err
+		}
+		return Match{Text: text, Prefix: prefix}, nil
+
+	case tokPhrase:
+		if err := p.count(t); err != nil {
+			return nil, err
+		}
+		return Match{Text: t.text, Phrase: true}, nil
+
+	case tokEOF:
+		return nil, &SyntaxError{Pos: t.pos, Msg: "unexpected end of query"}
+	default:
+		return nil, p.unexpected(t)
+	}
+}
+
+// count records a clause and enforces MaxClauses
+func (p *parser) count(t token) error {
+	p.clauses++
+	if p.clauses > MaxClauses {
+		return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("query has more than %d terms", MaxClauses)}
+	}
+	return nil
+}
+
+// parseField parses the rest of field:value after the field name
+func (p *parser) parseField(name token) (Expr, error) {
+	field := strings.ToLower(name.text)
+	kind, ok := Fields[field]
+	if !ok {
+		return nil, &SyntaxError{Pos: name.pos, Msg: fmt.Sprintf("unknown field %q, expected one of %s", name.text, fieldList())}
+	}
+	p.advance() // the colon
+
+	var op token
+	if p.peek().kind == tokCompare {
+		op = p.advance()
+	}
+
+	value := p.peek()
+	if value.kind != tokWord && value.kind != tokPhrase {
+		return nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("expected a value for %s", field)}
+	}
+	p.advance()
+	if err := p.count(name); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case KindText, KindKeyword:
+		if op.text != "" {
+			return nil, &SyntaxError{Pos: op.pos, Msg: fmt.Sprintf("%s does not support %s comparisons", field, op.text)}
+		}
+		return textOrKeyword(field, kind, value)
+	case KindInteger:
+		return integer(field, op.text, value)
+	default:
+		return date(field, op.text, value)
+	}
+}
+
+func textOrKeyword(field string, kind Kind, value token) (Expr, error) {
+	if value.kind == tokPhrase {
+		if kind == KindKeyword {
+			return Term{Field: field, Value: value.text}, nil
+		}
+		return Match{Field: field, Text: value.text, Phrase: true}, nil
+	}
+
+	text, prefix, err := splitPrefix(value)
+	if err != nil {
+		return nil, err
+	}
+	if kind == KindKeyword {
+		return Term{Field: field, Value: text, Prefix: prefix}, nil
+	}
+	return Match{Field: field, Text: text, Prefix: prefix}, nil
+}
+
+// splitPrefix strips a trailing * from a word. A * anywhere else, or after
+// fewer than MinPrefixLength characters, is an error.
+func splitPrefix(t token) (string, bool, error) {
+	text := strings.TrimSuffix(t.text, "*")
+	prefix := text != t.text
+	if i := strings.Index(text, "*"); i >= 0 {
+		return "", false, &SyntaxError{Pos: t.pos + utf8.RuneCountInString(text[:i]), Msg: "* is only allowed at the end of a word"}
+	}
+	if prefix && utf8.RuneCountInString(text) < MinPrefixLength {
+		return "", false, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("a prefix needs at least %d characters before *", MinPrefixLength)}
+	}
+	return text, prefix, nil
+}
+
+func integer(field, op string, value token) (Expr, error) {
+	parse := func(s string, pos int) (string, error) {
+		if _, err := strconv.Atoi(s); err != nil {
+			return "", &SyntaxError{Pos: pos, Msg: fmt.Sprintf("%s expects a whole number, got %q", field, s)}
+		}
+		return s, nil
+	}
+
+	if value.kind == tokPhrase {
+		return nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("%s expects a whole number, got a phrase", field)}
+	}
+
+	if from, to, ok := strings.Cut(value.text, ".."); ok && op == "" {
+		r := Range{Field: field}
+		var err error
+		if from != "" {
+			if r.GTE, err = parse(from, value.pos); err != nil {
+				return nil, err
+			}
+		}
+		if to != "" {
+			if r.LTE, err = parse(to, value.pos+utf8.RuneCountInString(from)+2); err != nil {
+				return nil, err
+			}
+		}
+		if from == "" && to == "" {
+			return nil, &SyntaxError{Pos: value.pos, Msg: "a range needs at least one bound"}
+		}
+		return r, nil
+	}
+
+	n, err := parse(value.text, value.pos)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case ">":
+		return Range{Field: field, GT: n}, nil
+	case ">=":
+		return Range{Field: field, GTE: n}, nil
+	case "<":
+		return Range{Field: field, LT: n}, nil
+	case "<=":
+		return Range{Field: field, LTE: n}, nil
+	default:
+		return Term{Field: field, Value: n}, nil
+	}
+}
+
+// date turns a year, month or day into a range over that period.
+// publish_date:2020 means any day in 2020 and publish_date:>2020 means
+// from 2021 on.
+func date(field, op string, value token) (Expr, error) {
+	if value.kind == tokPhrase {
+		return nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("%s expects a date, got a phrase", field)}
+	}
+
+	if from, to, ok := strings.Cut(value.text, ".."); ok && op == "" {
+		r := Range{Field: field}
+		if from != "" {
+			start, _, err := parsePeriod(field, from, value.pos)
+			if err != nil {
+				return nil, err
+			}
+			r.GTE = start
+		}
+		if to != "" {
+			_, end, err := parsePeriod(field, to, value.pos+utf8.RuneCountInString(from)+2)
+			if err != nil {
+				return nil, err
+			}
+			r.LT = end
+		}
+		if from == "" && to == "" {
+			return nil, &SyntaxError{Pos: value.pos, Msg: "a range needs at least one bound"}
+		}
+		return r, nil
+	}
+
+	start, end, err := parsePeriod(field, value.text, value.pos)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case ">":
+		return Range{Field: field, GTE: end}, nil
+	case ">=":
+		return Range{Field: field, GTE: start}, nil
+	case "<":
+		return Range{Field: field, LT: start}, nil
+	case "<=":
+		return Range{Field: field, LT: end}, nil
+	default:
+		return Range{Field: field, GTE: start, LT: end}, nil
+	}
+}
+
+// parsePeriod returns the first day of a YYYY, YYYY-MM or YYYY-MM-DD period
+// and the first day after it.
+func parsePeriod(field, s string, pos int) (string, string, error) {
+	const day = "2006-01-02"
+	layouts := []struct {
+		layout     string
+		years      int
+		months     int
+		days       int
+		wantLength int
+	}{
+		{"2006", 1, 0, 0, 4},
+		{"2006-01", 0, 1, 0, 7},
+		{day, 0, 0, 1, 10},
+	}
+	for _, l := range layouts {
+		if len(s) != l.wantLength {
+			continue
+		}
+		if t, err := time.Parse(l.layout, s); err == nil {
+			return t.Format(day), t.AddDate(l.years, l.months, l.days).Format(day), nil
+		}
+	}
+	return "", "", &SyntaxError{Pos: pos, Msg: fmt.Sprintf("%s expects a date as YYYY, YYYY-MM or YYYY-MM-DD, got %q", field, s)}
+}
+
+func fieldList() string {
+	names := make([]string, 0, len(Fields))
+	for name := range Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
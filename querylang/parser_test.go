@@ -0,0 +1,120 @@
+package querylang
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Expr
+	}{
+		{"bare word", "golang", Match{Text: "golang"}},
+		{"bare phrase", `"clean code"`, Match{Text: "clean code", Phrase: true}},
+		{"prefix", "progr*", Match{Text: "progr", Prefix: true}},
+		{"text field phrase", `author:"John Doe"`, Match{Field: "author", Text: "John Doe", Phrase: true}},
+		{"field names ignore case", "Title:go", Match{Field: "title", Text: "go"}},
+		{"keyword", "language:Japanese", Term{Field: "language", Value: "Japanese"}},
+		{"keyword prefix", "isbn:978*", Term{Field: "isbn", Value: "978", Prefix: true}},
+		{"integer", "pages:300", Term{Field: "pages", Value: "300"}},
+		{"integer comparison", "pages:>200", Range{Field: "pages", GT: "200"}},
+		{"integer range", "pages:100..300", Range{Field: "pages", GTE: "100", LTE: "300"}},
+		{"open integer range", "pages:..300", Range{Field: "pages", LTE: "300"}},
+		{"year", "publish_date:2020", Range{Field: "publish_date", GTE: "2020-01-01", LT: "2021-01-01"}},
+		{"after month", "publish_date:>2020-02", Range{Field: "publish_date", GTE: "2020-03-01"}},
+		{"until day", "created_at:<=2020-02-29", Range{Field: "created_at", LT: "2020-03-01"}},
+		{"date range", "publish_date:2019..2020-06", Range{Field: "publish_date", GTE: "2019-01-01", LT: "2020-07-01"}},
+		{"escaped quote", `title:"say \"hi\""`, Match{Field: "title", Text: `say "hi"`, Phrase: true}},
+		{
+			"request example",
+			`author:"John Doe" AND language:Japanese AND pages:>200 -title:draft`,
+			And{Clauses: []Expr{
+				Match{Field: "author", Text: "John Doe", Phrase: true},
+				Term{Field: "language", Value: "Japanese"},
+				Range{Field: "pages", GT: "200"},
+				Not{Clause: Match{Field: "title", Text: "draft"}},
+			}},
+		},
+		{
+			"AND binds tighter than OR",
+			"go OR rust AND NOT c",
+			Or{Clauses: []Expr{
+				Match{Text: "go"},
+				And{Clauses: []Expr{Match{Text: "rust"}, Not{Clause: Match{Text: "c"}}}},
+			}},
+		},
+		{
+			"parentheses",
+			"(language:Thai OR language:Japanese) pages:<100",
+			And{Clauses: []Expr{
+				Or{Clauses: []Expr{Term{Field: "language", Value: "Thai"}, Term{Field: "language", Value: "Japanese"}}},
+				Range{Field: "pages", LT: "100"},
+			}},
+		},
+		{"lower case operators are words", "war and peace", And{Clauses: []Expr{Match{Text: "war"}, Match{Text: "and"}, Match{Text: "peace"}}}},
+		{"hyphen inside a word", "e-book", Match{Text: "e-book"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		pos   int
+		msg   string
+	}{
+		{"empty", "   ", 1, "empty query"},
+		{"unknown field", "go colour:red", 4, `unknown field "colour"`},
+		{"missing value", "title: AND go", 8, "expected a value for title"},
+		{"unterminated phrase", `author:"John Doe`, 8, "unterminated quoted phrase"},
+		{"missing closing paren", "go (a OR b", 4, `missing ")"`},
+		{"stray closing paren", "go)", 3, `unexpected ")"`},
+		{"dangling AND", "go AND", 4, "AND must be followed by a term"},
+		{"dangling NOT", "go -", 4, "- must be followed by a term"},
+		{"leading OR", "OR go", 1, `unexpected "OR"`},
+		{"comparison on text", "title:>go", 7, "title does not support > comparisons"},
+		{"bad number", "pages:>many", 8, `pages expects a whole number, got "many"`},
+		{"bad range end", "pages:10..x", 11, `got "x"`},
+		{"bad date", "publish_date:2020-13", 14, "publish_date expects a date"},
+		{"short prefix", "go*", 1, "at least 3 characters"},
+		{"inner wildcard", "pro*ing", 4, "* is only allowed at the end of a word"},
+		{"positions count characters", "ภาษา colour:red", 6, `unknown field "colour"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			var syntaxErr *SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.pos, syntaxErr.Pos)
+			assert.Contains(t, syntaxErr.Msg, tt.msg)
+		})
+	}
+}
+
+func TestParse_Limits(t *testing.T) {
+	_, err := Parse(strings.Repeat("go ", MaxClauses))
+	require.NoError(t, err)
+
+	_, err = Parse(strings.Repeat("go ", MaxClauses+1))
+	var syntaxErr *SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Contains(t, syntaxErr.Msg, "more than")
+
+	_, err = Parse(strings.Repeat("(", MaxDepth+1) + "go" + strings.Repeat(")", MaxDepth+1))
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Contains(t, syntaxErr.Msg, "nested")
+}
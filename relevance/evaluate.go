@@ -0,0 +1,120 @@
+package relevance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/service"
+	"io"
+)
+
+// Searcher runs book searches; service.BookService satisfies it
+type Searcher interface {
+	SearchBooks(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error)
+}
+
+// Config is a search configuration to evaluate. Empty fields keep the value
+// from the environment (service.LoadSearchConfig) or the search default.
+type Config struct {
+	Name string `json:"name"`
+	// Type, Mode and Match are sent with every search, as the type, mode
+	// and match query parameters would be. Type defaults to multi.
+	Type  string `json:"type,omitempty"`
+	Mode  string `json:"mode,omitempty"`
+	Match string `json:"match,omitempty"`
+	// Fields, MinimumShouldMatch and Fuzziness override the matching
+	// SearchConfig settings.
+	Fields             []string `json:"fields,omitempty"`
+	MinimumShouldMatch string   `json:"minimum_should_match,omitempty"`
+	Fuzziness          string   `json:"fuzziness,omitempty"`
+}
+
+// ReadConfig decodes a JSON configuration
+func ReadConfig(r io.Reader) (Config, error) {
+	var cfg Config
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("invalid search configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// Apply returns base with the settings the configuration overrides
+func (c Config) Apply(base service.SearchConfig) service.SearchConfig {
+	if len(c.Fields) > 0 {
+		base.Fields = c.Fields
+	}
+	if c.MinimumShouldMatch != "" {
+		base.MinimumShouldMatch = c.MinimumShouldMatch
+	}
+	if c.Fuzziness != "" {
+		base.Fuzziness = c.Fuzziness
+	}
+	return base
+}
+
+// QueryResult is the score of one judged query. Error is set when the
+// search failed; the query then scores 0 so a configuration cannot improve
+// its mean by failing.
+type QueryResult struct {
+	Query string `json:"query"`
+	Metrics
+	Total int64  `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the evaluation of one configuration over a judgment list
+type Report struct {
+	Config  string        `json:"config"`
+	K       int           `json:"k"`
+	Queries []QueryResult `json:"queries"`
+	Mean    Metrics       `json:"mean"`
+	Failed  int           `json:"failed"`
+}
+
+// Evaluate runs every judged query as the first page of k results and
+// scores the rankings. It only returns an error if ctx is done.
+func Evaluate(ctx context.Context, searcher Searcher, cfg Config, judgments []Judgment, k int) (*Report, error) {
+	report := &Report{Config: cfg.Name, K: k, Queries: make([]QueryResult, 0, len(judgments))}
+	all := make([]Metrics, 0, len(judgments))
+
+	for _, j := range judgments {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		params := models.BookSearch{
+			Type:    cfg.Type,
+			Mode:    cfg.Mode,
+			Match:   cfg.Match,
+			Query:   j.Query,
+			Page:    1,
+			PerPage: k,
+		}
+		if params.Type == "" {
+			params.Type = models.SearchTypeMulti
+		}
+		if j.Type != "" {
+			params.Type = j.Type
+		}
+
+		qr := QueryResult{Query: j.Query}
+		result, err := searcher.SearchBooks(ctx, params)
+		if err != nil {
+			qr.Error = err.Error()
+			report.Failed++
+		} else {
+			ranked := make([]string, len(result.Hits))
+			for i, hit := range result.Hits {
+				ranked[i] = hit.Book.ID.Hex()
+			}
+			qr.Metrics = Score(ranked, j.Ratings, k)
+			qr.Total = result.Total
+		}
+		report.Queries = append(report.Queries, qr)
+		all = append(all, qr.Metrics)
+	}
+
+	report.Mean = Mean(all)
+	return report, nil
+}
@@ -0,0 +1,62 @@
+// Package relevance measures how well book search ranks the books people
+// expect. A judgment list grades the books that should come back for a set
+// of queries; Evaluate runs those queries through the book service and
+// scores each ranking against the grades.
+package relevance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Judgment grades the books that are relevant to one query. Grades are 0
+// (not relevant) to 3 (perfect match); books that are not listed count as 0.
+type Judgment struct {
+	Query string `json:"query"`
+	// Type overrides the search type of the configuration, e.g. "query" for
+	// a query language expression.
+	Type string `json:"type,omitempty"`
+	// Ratings maps a book ID to its grade.
+	Ratings map[string]int `json:"ratings"`
+}
+
+// MaxGrade is the highest grade a judgment may give
+const MaxGrade = 3
+
+// ReadJudgments decodes a JSON array of judgments and checks that every
+// query has at least one relevant book and valid grades.
+func ReadJudgments(r io.Reader) ([]Judgment, error) {
+	var judgments []Judgment
+	if err := json.NewDecoder(r).Decode(&judgments); err != nil {
+		return nil, fmt.Errorf("invalid judgment file: %w", err)
+	}
+	if len(judgments) == 0 {
+		return nil, fmt.Errorf("invalid judgment file: no queries")
+	}
+
+	for i, j := range judgments {
+		if strings.TrimSpace(j.Query) == "" {
+			return nil, fmt.Errorf("judgment %d: query is required", i+1)
+		}
+		relevant := 0
+		for id, grade := range j.Ratings {
+			if !primitive.IsValidObjectID(id) {
+				return nil, fmt.Errorf("judgment %d (%q): %q is not a book ID", i+1, j.Query, id)
+			}
+			if grade < 0 || grade > MaxGrade {
+				return nil, fmt.Errorf("judgment %d (%q): grade %d for %s is outside 0-%d", i+1, j.Query, grade, id, MaxGrade)
+			}
+			if grade > 0 {
+				relevant++
+			}
+		}
+		if relevant == 0 {
+			return nil, fmt.Errorf("judgment %d (%q): no book has a grade above 0", i+1, j.Query)
+		}
+	}
+	return judgments, nil
+}
@@ -0,0 +1,94 @@
+package relevance
+
+import (
+	"math"
+	"sort"
+)
+
+// Metrics scores a ranking against a judgment, looking at the first k hits.
+// Every metric is between 0 and 1, higher is better.
+type Metrics struct {
+	// Precision is the share of the first k hits that are relevant.
+	Precision float64 `json:"precision"`
+	// Recall is the share of the relevant books found in the first k hits.
+	Recall float64 `json:"recall"`
+	// ReciprocalRank is 1/rank of the first relevant hit, or 0 if none of
+	// the first k is relevant. Its mean over queries is MRR.
+	ReciprocalRank float64 `json:"reciprocal_rank"`
+	// NDCG is the normalized discounted cumulative gain: graded hits count
+	// for more near the top, relative to the best possible ordering.
+	NDCG float64 `json:"ndcg"`
+}
+
+// Score computes the metrics of ranked, a list of book IDs best first.
+// Books with a grade above 0 are relevant.
+func Score(ranked []string, ratings map[string]int, k int) Metrics {
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+
+	var m Metrics
+	found, dcg := 0, 0.0
+	for i, id := range ranked {
+		grade := ratings[id]
+		if grade <= 0 {
+			continue
+		}
+		found++
+		if m.ReciprocalRank == 0 {
+			m.ReciprocalRank = 1 / float64(i+1)
+		}
+		dcg += gain(grade, i)
+	}
+
+	relevant := 0
+	grades := make([]int, 0, len(ratings))
+	for _, grade := range ratings {
+		if grade > 0 {
+			relevant++
+			grades = append(grades, grade)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(grades)))
+	idcg := 0.0
+	for i := 0; i < len(grades) && i < k; i++ {
+		idcg += gain(grades[i], i)
+	}
+
+	if k > 0 {
+		m.Precision = float64(found) / float64(k)
+	}
+	if relevant > 0 {
+		m.Recall = float64(found) / float64(relevant)
+	}
+	if idcg > 0 {
+		m.NDCG = dcg / idcg
+	}
+	return m
+}
+
+// gain is the discounted gain of a grade at a 0-based rank. The exponential
+// gain makes a perfect match worth much more than a partial one.
+func gain(grade, rank int) float64 {
+	return (math.Pow(2, float64(grade)) - 1) / math.Log2(float64(rank+2))
+}
+
+// Mean averages metrics over queries
+func Mean(all []Metrics) Metrics {
+	var mean Metrics
+	if len(all) == 0 {
+		return mean
+	}
+	for _, m := range all {
+		mean.Precision += m.Precision
+		mean.Recall += m.Recall
+		mean.ReciprocalRank += m.ReciprocalRank
+		mean.NDCG += m.NDCG
+	}
+	n := float64(len(all))
+	mean.Precision /= n
+	mean.Recall /= n
+	mean.ReciprocalRank /= n
+	mean.NDCG /= n
+	return mean
+}
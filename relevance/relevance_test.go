@@ -0,0 +1,145 @@
+package relevance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/service"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	bookA = "000000000000000000000001"
+	bookB = "000000000000000000000002"
+	bookC = "000000000000000000000003"
+	bookD = "000000000000000000000004"
+)
+
+func TestScore(t *testing.T) {
+	ratings := map[string]int{bookA: 3, bookB: 1, bookC: 0, bookD: 2}
+
+	m := Score([]string{bookC, bookA, bookB}, ratings, 3)
+	assert.InDelta(t, 2.0/3, m.Precision, 1e-9)
+	assert.InDelta(t, 2.0/3, m.Recall, 1e-9, "book D was not found")
+	assert.InDelta(t, 0.5, m.ReciprocalRank, 1e-9)
+	dcg := 7/1.584962500721156 + 1/2.0
+	idcg := 7/1.0 + 3/1.584962500721156 + 1/2.0
+	assert.InDelta(t, dcg/idcg, m.NDCG, 1e-9)
+
+	m = Score([]string{bookA, bookD, bookB, bookC}, ratings, 3)
+	assert.InDelta(t, 1.0, m.NDCG, 1e-9, "ideal order")
+	assert.InDelta(t, 1.0, m.Recall, 1e-9)
+	assert.InDelta(t, 1.0, m.ReciprocalRank, 1e-9)
+
+	m = Score([]string{bookC}, ratings, 5)
+	assert.Equal(t, Metrics{}, m, "nothing relevant")
+	assert.InDelta(t, 0.2, Score([]string{bookA}, ratings, 5).Precision, 1e-9, "short rankings still divide by k")
+}
+
+func TestMean(t *testing.T) {
+	mean := Mean([]Metrics{{Precision: 1, NDCG: 0.5}, {Precision: 0, NDCG: 1}})
+	assert.Equal(t, Metrics{Precision: 0.5, NDCG: 0.75}, mean)
+	assert.Equal(t, Metrics{}, Mean(nil))
+}
+
+func TestReadJudgments(t *testing.T) {
+	judgments, err := ReadJudgments(strings.NewReader(`[{"query": "go", "ratings": {"000000000000000000000001": 3}}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []Judgment{{Query: "go", Ratings: map[string]int{bookA: 3}}}, judgments)
+
+	for name, body := range map[string]string{
+		"not json":    `{`,
+		"empty":       `[]`,
+		"no query":    `[{"ratings": {"000000000000000000000001": 3}}]`,
+		"bad id":      `[{"query": "go", "ratings": {"book-1": 3}}]`,
+		"bad grade":   `[{"query": "go", "ratings": {"000000000000000000000001": 4}}]`,
+		"no relevant": `[{"query": "go", "ratings": {"000000000000000000000001": 0}}]`,
+	} {
+		_, err := ReadJudgments(strings.NewReader(body))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestConfig_Apply(t *testing.T) {
+	base := service.SearchConfig{Fields: []string{"title^3"}, MinimumShouldMatch: "75%", Fuzziness: "AUTO", DidYouMeanBelow: 3}
+
+	assert.Equal(t, base, Config{Name: "current"}.Apply(base))
+	got := Config{Fields: []string{"title^5", "description"}, Fuzziness: "0"}.Apply(base)
+	assert.Equal(t, []string{"title^5", "description"}, got.Fields)
+	assert.Equal(t, "0", got.Fuzziness)
+	assert.Equal(t, "75%", got.MinimumShouldMatch)
+}
+
+// fakeSearcher returns fixed rankings per query
+type fakeSearcher struct {
+	rankings map[string][]string
+	got      []models.BookSearch
+}
+
+func (f *fakeSearcher) SearchBooks(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	f.got = append(f.got, params)
+	ids, ok := f.rankings[params.Query]
+	if !ok {
+		return nil, errors.New("invalid search: boom")
+	}
+	result := &models.BookSearchResult{Total: int64(len(ids))}
+	for _, id := range ids {
+		oid, _ := primitive.ObjectIDFromHex(id)
+		result.Hits = append(result.Hits, models.BookHit{Book: models.Book{ID: oid}})
+	}
+	return result, nil
+}
+
+func TestEvaluate(t *testing.T) {
+	judgments := []Judgment{
+		{Query: "go", Ratings: map[string]int{bookA: 3}},
+		{Query: `author:"Rob Pike"`, Type: "query", Ratings: map[string]int{bookB: 2}},
+		{Query: "broken", Ratings: map[string]int{bookC: 1}},
+	}
+	searcher := &fakeSearcher{rankings: map[string][]string{
+		"go":                {bookA, bookB},
+		`author:"Rob Pike"`: {bookA, bookB},
+	}}
+
+	report, err := Evaluate(context.Background(), searcher, Config{Name: "semantic", Mode: "semantic"}, judgments, 2)
+	require.NoError(t, err)
+
+	require.Len(t, searcher.got, 3)
+	assert.Equal(t, models.BookSearch{Type: "multi", Mode: "semantic", Query: "go", Page: 1, PerPage: 2}, searcher.got[0])
+	assert.Equal(t, "query", searcher.got[1].Type, "the judgment overrides the type")
+
+	assert.Equal(t, "semantic", report.Config)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, "invalid search: boom", report.Queries[2].Error)
+	assert.InDelta(t, 1.0, report.Queries[0].ReciprocalRank, 1e-9)
+	assert.InDelta(t, 0.5, report.Queries[1].ReciprocalRank, 1e-9)
+	assert.InDelta(t, 0.5, report.Mean.ReciprocalRank, 1e-9, "failed queries score 0")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Evaluate(ctx, searcher, Config{}, judgments, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWriteTable(t *testing.T) {
+	a := &Report{Config: "baseline", K: 5, Queries: []QueryResult{{Query: "go", Metrics: Metrics{Precision: 0.2}}}, Mean: Metrics{Precision: 0.2}}
+	b := &Report{Config: "tuned", K: 5, Queries: []QueryResult{{Query: "go", Error: "invalid search: boom"}}, Failed: 1}
+
+	var out bytes.Buffer
+	require.NoError(t, WriteTable(&out, a, b))
+	text := out.String()
+	assert.Contains(t, text, "baseline")
+	assert.Contains(t, text, "nDCG@5")
+	assert.Contains(t, text, "-0.200", "change from the first configuration")
+	assert.Contains(t, text, `error: tuned: "go": invalid search: boom`)
+
+	out.Reset()
+	require.NoError(t, WriteTable(&out, a))
+	assert.NotContains(t, out.String(), "change")
+}
@@ -0,0 +1,99 @@
+package relevance
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteTable prints the reports side by side: the metrics of every query
+// under each configuration, then the means. With two reports the summary
+// also shows the change from the first to the second. The reports must
+// come from the same judgment list.
+func WriteTable(w io.Writer, reports ...*Report) error {
+	if len(reports) == 0 {
+		return nil
+	}
+	k := reports[0].K
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	header := []string{"query"}
+	for range reports {
+		header = append(header, fmt.Sprintf("P@%d", k), fmt.Sprintf("R@%d", k), "RR", fmt.Sprintf("nDCG@%d", k), "|")
+	}
+	fmt.Fprintf(tw, "%s\t", "")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t\t\t\t|\t", r.Config)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+
+	var failures []string
+	for i, q := range reports[0].Queries {
+		row := []string{q.Query}
+		for _, r := range reports {
+			qr := r.Queries[i]
+			if qr.Error != "" {
+				failures = append(failures, fmt.Sprintf("%s: %q: %s", r.Config, qr.Query, qr.Error))
+				row = append(row, "error", "-", "-", "-", "|")
+				continue
+			}
+			row = append(row, metricCells(qr.Metrics)...)
+			row = append(row, "|")
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header = []string{"mean"}
+	for _, r := range reports {
+		header = append(header, r.Config)
+	}
+	if len(reports) == 2 {
+		header = append(header, "change")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+
+	names := []string{fmt.Sprintf("P@%d", k), fmt.Sprintf("R@%d", k), "MRR", fmt.Sprintf("nDCG@%d", k)}
+	for m, name := range names {
+		row := []string{name}
+		for _, r := range reports {
+			row = append(row, fmt.Sprintf("%.3f", metricValues(r.Mean)[m]))
+		}
+		if len(reports) == 2 {
+			row = append(row, fmt.Sprintf("%+.3f", metricValues(reports[1].Mean)[m]-metricValues(reports[0].Mean)[m]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	row := []string{"failed"}
+	for _, r := range reports {
+		row = append(row, fmt.Sprintf("%d", r.Failed))
+	}
+	fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, f := range failures {
+		fmt.Fprintln(w, "error:", f)
+	}
+	return nil
+}
+
+func metricValues(m Metrics) []float64 {
+	return []float64{m.Precision, m.Recall, m.ReciprocalRank, m.NDCG}
+}
+
+func metricCells(m Metrics) []string {
+	values := metricValues(m)
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = fmt.Sprintf("%.3f", v)
+	}
+	return cells
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"go-elastic/models"
+	"strings"
+)
+
+// bookDocument is the Elasticsearch document for a book: the book itself
+// plus fields derived from it that only exist in the index.
+type bookDocument struct {
+	*models.Book
+	TitleSuggest  *completionInput `json:"title_suggest,omitempty"`
+	AuthorSuggest *completionInput `json:"author_suggest,omitempty"`
+
+	// Per-language copies of title and description, analyzed with the
+	// language's tokenizer, stemmer and stopwords. Only the book's own
+	// language is filled in.
+	TitleEN       string `json:"title_en,omitempty"`
+	TitleJA       string `json:"title_ja,omitempty"`
+	TitleTH       string `json:"title_th,omitempty"`
+	DescriptionEN string `json:"description_en,omitempty"`
+	DescriptionJA string `json:"description_ja,omitempty"`
+	DescriptionTH string `json:"description_th,omitempty"`
+
+	// Embedding is the vector of embeddingText for semantic search. It is
+	// left out when there is no embedder or the text has no content.
+	Embedding []float32 `json:"embedding,omitempty"`
+
+	// Extra hides Book.Extra, which is never set here: the fields of
+	// imported records that a book does not model are only kept in MongoDB,
+	// so they cannot add to the index mapping.
+	Extra map[string][]string `json:"extra,omitempty"`
+}
+
+// completionInput is the value of a completion suggester field
+type completionInput struct {
+	Input []string `json:"input"`
+}
+
+// newBookDocument builds the indexed form of a book. Authors are suggested
+// one name at a time, so "Alan Donovan, Brian Kernighan" completes from
+// either name. Title and description are also copied to the fields of the
+// book's language.
+func newBookDocument(book *models.Book) bookDocument {
+	doc := bookDocument{Book: book}
+	if title := strings.TrimSpace(book.Title); title != "" {
+		doc.TitleSuggest = &completionInput{Input: []string{title}}
+	}
+	if names := splitAuthors(book.Author); len(names) > 0 {
+		doc.AuthorSuggest = &completionInput{Input: names}
+	}
+
+	switch languageCode(book.Language) {
+	case "en":
+		doc.TitleEN, doc.DescriptionEN = book.Title, book.Description
+	case "ja":
+		doc.TitleJA, doc.DescriptionJA = book.Title, book.Description
+	case "th":
+		doc.TitleTH, doc.DescriptionTH = book.Title, book.Description
+	}
+	return doc
+}
+
+// splitAuthors splits a comma or "and" separated author list into names
+func splitAuthors(author string) []string {
+	var names []string
+	for _, part := range strings.Split(author, ",") {
+		for _, name := range strings.Split(part, " and ") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// embeddingText is the text of a book that semantic search compares queries
+// with.
+func embeddingText(book *models.Book) string {
+	if book.Description == "" {
+		return book.Title
+	}
+	return book.Title + ". " + book.Description
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"encoding/json"
+	"go-elastic/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBookDocument_LeavesOutExtra(t *testing.T) {
+	book := &models.Book{Title: "Dune", Extra: map[string][]string{"marc:650": {`\0$aScience fiction.`}}}
+
+	raw, err := json.Marshal(newBookDocument(book))
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &doc))
+
+	assert.Equal(t, "Dune", doc["title"])
+	assert.NotContains(t, doc, "extra")
+	assert.NotEmpty(t, book.Extra, "the book itself is left alone")
+}
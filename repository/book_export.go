@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportPageSize is how many books each page of a SearchEach holds
+const exportPageSize = 1000
+
+// pitKeepAlive is how long a point in time is kept open between two pages
+const pitKeepAlive = "1m"
+
+// SearchEach streams every book matching a search to fn, in the search's
+// sort order, without a result window. It pages with search_after over a
+// point in time of the books read alias, so books written meanwhile neither
+// shift pages nor show up halfway, and a reindex switching the alias does
+// not affect it. Each page of hits is then loaded from MongoDB, which has
+// the fields the index leaves out, such as Book.Extra; a book deleted since
+// the point in time was opened is skipped. Iteration stops at the first
+// error returned by fn.
+func (r *bookRepository) SearchEach(ctx context.Context, params models.BookSearch, fn func(book *models.Book) error) error {
+	pitID, err := openPointInTime(ctx)
+	if err != nil {
+		return err
+	}
+	// The point in time expires on its own, but closing it frees its
+	// resources now, even when ctx is done.
+	defer func() { closePointInTime(pitID) }()
+
+	query := buildSearchQuery(params)
+	delete(query, "from")
+	query["size"] = exportPageSize
+	query["track_total_hits"] = false
+	query["_source"] = false
+
+	for {
+		query["pit"] = map[string]interface{}{"id": pitID, "keep_alive": pitKeepAlive}
+		response, err := searchPointInTime(ctx, query)
+		if err != nil {
+			return err
+		}
+		if response.PitID != "" {
+			pitID = response.PitID
+		}
+
+		hits := response.Hits.Hits
+		ids := make([]string, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit.ID
+		}
+		if err := r.eachByID(ctx, ids, fn); err != nil {
+			return err
+		}
+		if len(hits) < exportPageSize {
+			return nil
+		}
+		query["search_after"] = hits[len(hits)-1].Sort
+	}
+}
+
+// eachByID streams the books with the given IDs to fn, in the order of ids.
+// IDs with no book are skipped.
+func (r *bookRepository) eachByID(ctx context.Context, ids []string, fn func(book *models.Book) error) error {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+	if len(objectIDs) == 0 {
+		return nil
+	}
+
+	cursor, err := r.mongoCollection.Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return err
+	}
+	var books []*models.Book
+	if err := cursor.All(ctx, &books); err != nil {
+		return err
+	}
+
+	byID := make(map[primitive.ObjectID]*models.Book, len(books))
+	for _, book := range books {
+		byID[book.ID] = book
+	}
+	for _, id := range objectIDs {
+		if book, ok := byID[id]; ok {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func openPointInTime(ctx context.Context) (string, error) {
+	req := esapi.OpenPointInTimeRequest{
+		Index:     []string{database.BooksReadAlias},
+		KeepAlive: pitKeepAlive,
+	}
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return "", fmt.Errorf("%w: error opening point in time: %w", ErrSearchUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", searchError(res)
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("error parsing response body: %w", err)
+	}
+	return response.ID, nil
+}
+
+// searchPointInTime runs one page of a point-in-time search. The index comes
+// from the point in time, so the request names none.
+func searchPointInTime(ctx context.Context, query map[string]interface{}) (*searchResponse, error) {
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling query: %w", err)
+	}
+
+	req := esapi.SearchRequest{Body: bytes.NewReader(queryJSON)}
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error executing search request: %w", ErrSearchUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, searchError(res)
+	}
+
+	var response searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing response body: %w", err)
+	}
+	return &response, nil
+}
+
+func closePointInTime(pitID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"id": pitID})
+	if err != nil {
+		return
+	}
+	req := esapi.ClosePointInTimeRequest{Body: bytes.NewReader(body)}
+	res, err := req.Do(ctx, database.ESClient)
+	if err == nil {
+		res.Body.Close()
+	}
+}
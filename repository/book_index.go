@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/embedding"
+	"go-elastic/models"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// scanPageSize is the number of documents fetched per page by Scan
+const scanPageSize = 1000
+
+// BookIndex applies document changes to the Elasticsearch books index.
+type BookIndex interface {
+	Index(ctx context.Context, book *models.Book) error
+	// BulkIndex indexes books with the _bulk API. It returns the failures
+	// keyed by book ID; the error is only set if the request itself failed.
+	BulkIndex(ctx context.Context, books []models.Book) (map[string]error, error)
+	Delete(ctx context.Context, id string) error
+	// Scan pages through every document in the index and passes its ID and
+	// updated_at to fn. Iteration stops at the first error returned by fn.
+	Scan(ctx context.Context, fn func(id string, updatedAt time.Time) error) error
+}
+
+type bookIndex struct {
+	writeTarget string
+	readTarget  string
+	embedder    embedding.Embedder
+}
+
+// NewBookIndex returns a BookIndex that writes through the write alias and
+// scans through the read alias. Documents get an embedding for semantic
+// search from embedder, which may be nil to index without one.
+func NewBookIndex(embedder embedding.Embedder) BookIndex {
+	return &bookIndex{
+		writeTarget: database.BooksWriteAlias,
+		readTarget:  database.BooksReadAlias,
+		embedder:    embedder,
+	}
+}
+
+// NewBookIndexFor returns a BookIndex bound to one physical index, for
+// building an index before the aliases point at it.
+func NewBookIndexFor(indexName string, embedder embedding.Embedder) BookIndex {
+	return &bookIndex{
+		writeTarget: indexName,
+		readTarget:  indexName,
+		embedder:    embedder,
+	}
+}
+
+// documents builds the indexed form of books, embedding them in one batch
+func (i *bookIndex) documents(ctx context.Context, books []*models.Book) ([]bookDocument, error) {
+	docs := make([]bookDocument, len(books))
+	texts := make([]string, len(books))
+	for idx, book := range books {
+		docs[idx] = newBookDocument(book)
+		texts[idx] = embeddingText(book)
+	}
+	if i.embedder == nil {
+		return docs, nil
+	}
+
+	vectors, err := i.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding books: %w", err)
+	}
+	for idx, vector := range vectors {
+		if !embedding.IsZero(vector) {
+			docs[idx].Embedding = vector
+		}
+	}
+	return docs, nil
+}
+
+// Index writes the full book document, replacing any previous version
+func (i *bookIndex) Index(ctx context.Context, book *models.Book) error {
+	docs, err := i.documents(ctx, []*models.Book{book})
+	if err != nil {
+		return err
+	}
+	bookJSON, err := json.Marshal(docs[0])
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IndexRequest{
+		Index:      i.writeTarget,
+		DocumentID: book.ID.Hex(),
+		Body:       bytes.NewReader(bookJSON),
+	}
+
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error indexing document: %s", res.String())
+	}
+	return nil
+}
+
+func (i *bookIndex) BulkIndex(ctx context.Context, books []models.Book) (map[string]error, error) {
+	failures := make(map[string]error)
+	if len(books) == 0 {
+		return failures, nil
+	}
+
+	ptrs := make([]*models.Book, len(books))
+	for idx := range books {
+		ptrs[idx] = &books[idx]
+	}
+	docs, err := i.documents(ctx, ptrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for idx := range books {
+		action := map[string]interface{}{
+			"index": map[string]interface{}{"_index": i.writeTarget, "_id": books[idx].ID.Hex()},
+		}
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(docs[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	req := esapi.BulkRequest{Body: &body}
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error bulk indexing: %s", res.String())
+	}
+
+	var response struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if !response.Errors {
+		return failures, nil
+	}
+
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				failures[result.ID] = fmt.Errorf("%s: %s", result.Error.Type, result.Error.Reason)
+			}
+		}
+	}
+	return failures, nil
+}
+
+// Delete removes a book document. A document that is already missing from
+// the index is not treated as an error.
+func (i *bookIndex) Delete(ctx context.Context, id string) error {
+	req := esapi.DeleteRequest{
+		Index:      i.writeTarget,
+		DocumentID: id,
+	}
+
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error deleting document: %s", res.String())
+	}
+	return nil
+}
+
+// Scan uses a point in time with search_after, so the pages form a consistent
+// snapshot and are not limited by index.max_result_window.
+func (i *bookIndex) Scan(ctx context.Context, fn func(id string, updatedAt time.Time) error) error {
+	pitID, err := i.openPointInTime(ctx)
+	if err != nil {
+		return err
+	}
+	defer i.closePointInTime(pitID)
+
+	var searchAfter []interface{}
+	for {
+		query := map[string]interface{}{
+			"size":    scanPageSize,
+			"_source": []string{"updated_at"},
+			"pit":     map[string]interface{}{"id": pitID, "keep_alive": "1m"},
+			"sort":    []interface{}{map[string]interface{}{"_shard_doc": "asc"}},
+		}
+		if searchAfter != nil {
+			query["search_after"] = searchAfter
+		}
+
+		queryJSON, err := json.Marshal(query)
+		if err != nil {
+			return err
+		}
+
+		req := esapi.SearchRequest{Body: bytes.NewReader(queryJSON)}
+		res, err := req.Do(ctx, database.ESClient)
+		if err != nil {
+			return err
+		}
+
+		var response struct {
+			PitID string `json:"pit_id"`
+			Hits  struct {
+				Hits []struct {
+					ID     string `json:"_id"`
+					Source struct {
+						UpdatedAt time.Time `json:"updated_at"`
+					} `json:"_source"`
+					Sort []interface{} `json:"sort"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return fmt.Errorf("error scanning index: %s", res.String())
+		}
+		err = json.NewDecoder(res.Body).Decode(&response)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		hits := response.Hits.Hits
+		if len(hits) == 0 {
+			return nil
+		}
+		for _, hit := range hits {
+			if err := fn(hit.ID, hit.Source.UpdatedAt); err != nil {
+				return err
+			}
+		}
+
+		if response.PitID != "" {
+			pitID = response.PitID
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+func (i *bookIndex) openPointInTime(ctx context.Context) (string, error) {
+	es := database.ESClient
+	res, err := es.OpenPointInTime([]string{i.readTarget}, "1m", es.OpenPointInTime.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", fmt.Errorf("error opening point in time: %s", res.String())
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return "", err
+	}
+	return response.ID, nil
+}
+
+// closePointInTime releases the point in time early; it expires on its own
+// after keep_alive, so failures are ignored.
+func (i *bookIndex) closePointInTime(pitID string) {
+	es := database.ESClient
+	body := strings.NewReader(fmt.Sprintf(`{"id":%q}`, pitID))
+	res, err := es.ClosePointInTime(es.ClosePointInTime.WithBody(body))
+	if err == nil {
+		res.Body.Close()
+	}
+}
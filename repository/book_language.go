@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"go-elastic/models"
+	"strings"
+)
+
+// analyzedLanguages are the language codes that have their own analyzers in
+// the index (books_en, books_th and books_ja in the mapping).
+var analyzedLanguages = []string{"en", "ja", "th"}
+
+// languageCodes maps Book.Language values, in lower case, to the language
+// codes in analyzedLanguages.
+var languageCodes = map[string]string{
+	"en": "en", "eng": "en", "english": "en",
+	"ja": "ja", "jpn": "ja", "japanese": "ja", "日本語": "ja",
+	"th": "th", "tha": "th", "thai": "th", "ไทย": "th", "ภาษาไทย": "th",
+}
+
+// languageAnalyzedFields are the text fields copied into a per-language
+// field such as title_th. They are separate fields rather than multi-fields
+// because a multi-field is filled for every book, whatever its language.
+var languageAnalyzedFields = []string{"title", "description"}
+
+// languageCode returns the analyzed language code for a Book.Language value,
+// or "" if the language has no analyzer of its own.
+func languageCode(language string) string {
+	return languageCodes[strings.ToLower(strings.TrimSpace(language))]
+}
+
+// languageField returns the name of the per-language variant of a field
+func languageField(field, code string) string {
+	return field + "_" + code
+}
+
+// searchLanguages returns the language codes a search queries. A language
+// filter narrows them to the filtered languages; otherwise all are queried,
+// which is cheap because each book only has the fields of its own language.
+func searchLanguages(f models.BookFilters) []string {
+	if len(f.Languages) == 0 {
+		return analyzedLanguages
+	}
+
+	var codes []string
+	seen := make(map[string]bool)
+	for _, language := range f.Languages {
+		if code := languageCode(language); code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// withLanguageFields adds the per-language variants of the language analyzed
+// fields in fields, keeping their boosts: title^3 also searches title_en^3,
+// title_ja^3 and title_th^3.
+func withLanguageFields(fields []string, codes []string) []string {
+	if len(fields) == 0 {
+		return fields
+	}
+
+	expanded := append([]string{}, fields...)
+	for _, field := range fields {
+		name, boost, _ := strings.Cut(field, "^")
+		if !isLanguageAnalyzed(name) {
+			continue
+		}
+		for _, code := range codes {
+			variant := languageField(name, code)
+			if boost != "" {
+				variant += "^" + boost
+			}
+			expanded = append(expanded, variant)
+		}
+	}
+	return expanded
+}
+
+func isLanguageAnalyzed(field string) bool {
+	for _, f := range languageAnalyzedFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// foldLanguageHighlights reports highlights of per-language fields under the
+// field they were copied from, so clients only see title and description.
+// A highlight of the field itself wins.
+func foldLanguageHighlights(highlights map[string][]string) map[string][]string {
+	for _, field := range languageAnalyzedFields {
+		for _, code := range analyzedLanguages {
+			variant := languageField(field, code)
+			fragments, ok := highlights[variant]
+			if !ok {
+				continue
+			}
+			delete(highlights, variant)
+			if _, ok := highlights[field]; !ok {
+				highlights[field] = fragments
+			}
+		}
+	}
+	return highlights
+}
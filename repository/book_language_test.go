@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"encoding/json"
+	"go-elastic/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLanguageCode(t *testing.T) {
+	assert.Equal(t, "th", languageCode(" Thai "))
+	assert.Equal(t, "th", languageCode("ภาษาไทย"))
+	assert.Equal(t, "ja", languageCode("JAPANESE"))
+	assert.Equal(t, "ja", languageCode("日本語"))
+	assert.Equal(t, "en", languageCode("eng"))
+	assert.Equal(t, "", languageCode("Klingon"))
+}
+
+func TestNewBookDocument_LanguageFields(t *testing.T) {
+	raw, err := json.Marshal(newBookDocument(&models.Book{Title: "สวัสดี", Description: "หนังสือ", Language: "Thai"}))
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &doc))
+
+	assert.Equal(t, "สวัสดี", doc["title_th"])
+	assert.Equal(t, "หนังสือ", doc["description_th"])
+	for _, field := range []string{"title_en", "title_ja", "description_en", "description_ja"} {
+		assert.NotContains(t, doc, field, "only the book's own language is filled in")
+	}
+
+	raw, err = json.Marshal(newBookDocument(&models.Book{Title: "Go", Language: "Klingon"}))
+	require.NoError(t, err)
+	for _, code := range analyzedLanguages {
+		assert.NotContains(t, string(raw), `"title_`+code+`"`)
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"go-elastic/models"
+	"go-elastic/querylang"
+)
+
+// buildExpressionQuery translates a parsed query language expression into
+// Elasticsearch query DSL. Only the query types below can be produced, so
+// users cannot reach scripts, regexps or leading wildcards through it.
+// Prefixes use match_phrase_prefix, which caps how many terms a prefix
+// expands to. Negated terms are never fuzzy, so -title:draft does not also
+// exclude "drift".
+func buildExpressionQuery(expr querylang.Expr, params models.BookSearch) map[string]interface{} {
+	exact := params
+	exact.Fuzziness = "0"
+
+	switch e := expr.(type) {
+	case querylang.And:
+		boolQuery := map[string]interface{}{}
+		var must, mustNot []interface{}
+		for _, clause := range e.Clauses {
+			if not, ok := clause.(querylang.Not); ok {
+				mustNot = append(mustNot, buildExpressionQuery(not.Clause, exact))
+			} else {
+				must = append(must, buildExpressionQuery(clause, params))
+			}
+		}
+		if len(must) > 0 {
+			boolQuery["must"] = must
+		}
+		if len(mustNot) > 0 {
+			boolQuery["must_not"] = mustNot
+		}
+		return map[string]interface{}{"bool": boolQuery}
+
+	case querylang.Or:
+		should := make([]interface{}, 0, len(e.Clauses))
+		for _, clause := range e.Clauses {
+			should = append(should, buildExpressionQuery(clause, params))
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{"should": should, "minimum_should_match": 1},
+		}
+
+	case querylang.Not:
+		return map[string]interface{}{
+			"bool": map[string]interface{}{"must_not": []interface{}{buildExpressionQuery(e.Clause, exact)}},
+		}
+
+	case querylang.Match:
+		return buildExpressionMatch(e, params)
+
+	case querylang.Term:
+		if e.Prefix {
+			return map[string]interface{}{
+				"prefix": map[string]interface{}{e.Field: map[string]interface{}{"value": e.Value, "case_insensitive": true}},
+			}
+		}
+		if querylang.Fields[e.Field] == querylang.KindKeyword {
+			return map[string]interface{}{
+				"term": map[string]interface{}{e.Field: map[string]interface{}{"value": e.Value, "case_insensitive": true}},
+			}
+		}
+		return map[string]interface{}{"term": map[string]interface{}{e.Field: e.Value}}
+
+	case querylang.Range:
+		bounds := map[string]interface{}{}
+		for op, value := range map[string]string{"gt": e.GT, "gte": e.GTE, "lt": e.LT, "lte": e.LTE} {
+			if value != "" {
+				bounds[op] = value
+			}
+		}
+		return map[string]interface{}{"range": map[string]interface{}{e.Field: bounds}}
+	}
+
+	return map[string]interface{}{"match_none": map[string]interface{}{}}
+}
+
+// buildExpressionMatch handles full-text terms. Terms without a field search
+// the configured fields the same way a type=multi search does; terms on
+// title or description also search the per-language variants of the field.
+func buildExpressionMatch(m querylang.Match, params models.BookSearch) map[string]interface{} {
+	if m.Field == "" {
+		search := params
+		search.Query = m.Text
+		switch {
+		case m.Phrase:
+			search.Match = models.MatchPhrase
+		case m.Prefix:
+			search.Match = models.MatchPrefix
+		default:
+			search.Match = models.MatchBestFields
+		}
+		return buildTextQuery(search)
+	}
+
+	if isLanguageAnalyzed(m.Field) {
+		fields := withLanguageFields([]string{m.Field}, searchLanguages(params.Filters))
+		multiMatch := map[string]interface{}{"query": m.Text, "fields": fields}
+		switch {
+		case m.Phrase:
+			multiMatch["type"] = "phrase"
+		case m.Prefix:
+			multiMatch["type"] = "phrase_prefix"
+		default:
+			multiMatch["type"] = "best_fields"
+			multiMatch["operator"] = "and"
+			if params.Fuzziness != "" && params.Fuzziness != "0" {
+				multiMatch["fuzziness"] = params.Fuzziness
+				multiMatch["prefix_length"] = 1
+			}
+		}
+		return map[string]interface{}{"multi_match": multiMatch}
+	}
+
+	switch {
+	case m.Phrase:
+		return map[string]interface{}{"match_phrase": map[string]interface{}{m.Field: m.Text}}
+	case m.Prefix:
+		return map[string]interface{}{"match_phrase_prefix": map[string]interface{}{m.Field: m.Text}}
+	}
+
+	match := map[string]interface{}{"query": m.Text, "operator": "and"}
+	if params.Fuzziness != "" && params.Fuzziness != "0" {
+		match["fuzziness"] = params.Fuzziness
+		match["prefix_length"] = 1
+	}
+	return map[string]interface{}{"match": map[string]interface{}{m.Field: match}}
+}
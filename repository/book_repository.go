@@ -1,41 +1,93 @@
 package repository
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"go-elastic/database"
 	"go-elastic/models"
 	"time"
 
-	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrBookNotFound is returned when no book matches the given ID.
+var ErrBookNotFound = errors.New("book not found")
+
 type BookRepository interface {
 	Create(ctx context.Context, book *models.Book) error
 	FindByID(ctx context.Context, id string) (*models.Book, error)
-	FindAll(ctx context.Context) ([]models.Book, error)
-	SearchByTitle(ctx context.Context, title string) ([]models.Book, error)
-	SearchByAuthor(ctx context.Context, author string) ([]models.Book, error)
+	Update(ctx context.Context, book *models.Book) error
+	Patch(ctx context.Context, id string, patch *models.BookPatch) (*models.Book, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, opts models.BookListOptions) (*models.BookList, error)
+	Each(ctx context.Context, fn func(book *models.Book) error) error
+	// Count estimates the number of books from collection metadata, for
+	// progress reporting
+	Count(ctx context.Context) (int64, error)
+	// SearchEach streams every book matching a search to fn, however many
+	// there are. Page, PerPage and Cursor are ignored.
+	SearchEach(ctx context.Context, params models.BookSearch, fn func(book *models.Book) error) error
+	Search(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error)
+	Suggest(ctx context.Context, prefix string, size int) (*models.BookSuggestions, error)
+	DidYouMean(ctx context.Context, query string) ([]models.SpellingSuggestion, error)
+	// TextSearch runs a keyword search on MongoDB, for when Elasticsearch
+	// is unavailable. It needs the index created by EnsureTextIndex.
+	TextSearch(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error)
+	EnsureTextIndex(ctx context.Context) error
+	// InsertMany inserts new books unordered. With an outbox each book
+	// inserted is queued for indexing in the same transaction, its entry
+	// leased for lease so that the caller can index the batch itself and
+	// complete the entries. It returns the failures and the outbox entries,
+	// both keyed by position in books; the error is only set if the whole
+	// batch failed.
+	InsertMany(ctx context.Context, books []*models.Book, lease time.Duration) (map[int]error, map[int]primitive.ObjectID, error)
 }
 
 type bookRepository struct {
 	mongoCollection *mongo.Collection
+	outbox          OutboxRepository
 	esClient        interface{}
 }
 
-func NewBookRepository(mongoCollection *mongo.Collection) BookRepository {
+// NewBookRepository returns a repository that writes books to MongoDB and
+// records every write in the outbox, in the same transaction, for the relay
+// to apply to Elasticsearch. outbox may be nil when the change stream indexer
+// keeps the search index in sync instead.
+func NewBookRepository(mongoCollection *mongo.Collection, outbox OutboxRepository) BookRepository {
 	return &bookRepository{
 		mongoCollection: mongoCollection,
+		outbox:          outbox,
 		esClient:        database.ESClient,
 	}
 }
 
-// Create saves book to MongoDB and indexes it in Elasticsearch
+// write runs fn and, when an outbox is configured, records op for bookID in
+// the same MongoDB transaction. Transactions need a replica set; see
+// docker-compose.yaml for a single-node setup.
+func (r *bookRepository) write(ctx context.Context, bookID primitive.ObjectID, op string, fn func(ctx context.Context) error) error {
+	if r.outbox == nil {
+		return fn(ctx)
+	}
+
+	session, err := r.mongoCollection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if err := fn(sc); err != nil {
+			return nil, err
+		}
+		return nil, r.outbox.Add(sc, bookID, op)
+	})
+	return err
+}
+
+// Create saves book to MongoDB and queues it for indexing in Elasticsearch
 func (r *bookRepository) Create(ctx context.Context, book *models.Book) error {
 	if book.ID.IsZero() {
 		book.ID = primitive.NewObjectID()
@@ -47,37 +99,107 @@ func (r *bookRepository) Create(ctx context.Context, book *models.Book) error {
 		book.UpdatedAt = time.Now()
 	}
 
-	// Insert into MongoDB
-	res, err := r.mongoCollection.InsertOne(ctx, book)
-	if err != nil {
+	return r.write(ctx, book.ID, models.OutboxOpIndex, func(ctx context.Context) error {
+		_, err := r.mongoCollection.InsertOne(ctx, book)
 		return err
+	})
+}
+
+// errBatchRejected aborts the transaction of an InsertMany batch in which
+// some books failed, so that it can be retried without them
+var errBatchRejected = errors.New("batch has rejected books")
+
+// InsertMany saves a batch of new books to MongoDB. Books that fail, such as
+// on a duplicate ID, do not stop the rest of the batch. With an outbox the
+// books and their entries are written in one transaction; a failed insert
+// aborts it, so the batch is then tried again without the books that failed.
+func (r *bookRepository) InsertMany(ctx context.Context, books []*models.Book, lease time.Duration) (map[int]error, map[int]primitive.ObjectID, error) {
+	entries := make(map[int]primitive.ObjectID)
+	if r.outbox == nil {
+		failures, err := r.insertMany(ctx, books)
+		return failures, entries, err
 	}
 
-	// Index in Elasticsearch
-	esClient := database.ESClient
-	bookJSON, err := json.Marshal(book)
+	session, err := r.mongoCollection.Database().Client().StartSession()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	defer session.EndSession(ctx)
 
-	req := esapi.IndexRequest{
-		Index:      "books",
-		DocumentID: book.ID.Hex(),
-		Body:       bytes.NewReader(bookJSON),
+	// positions are the indices in books still to be inserted
+	failures := make(map[int]error)
+	positions := make([]int, len(books))
+	for idx := range books {
+		positions[idx] = idx
 	}
-
-	resp, err := req.Do(ctx, esClient)
-	if err != nil {
-		return err
+	for len(positions) > 0 {
+		batch := make([]*models.Book, len(positions))
+		bookIDs := make([]primitive.ObjectID, len(positions))
+		for i, pos := range positions {
+			batch[i] = books[pos]
+			bookIDs[i] = books[pos].ID
+		}
+
+		var rejected map[int]error
+		var ids []primitive.ObjectID
+		_, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			var err error
+			if rejected, err = r.insertMany(sc, batch); err != nil {
+				return nil, err
+			}
+			if len(rejected) > 0 {
+				return nil, errBatchRejected
+			}
+			ids, err = r.outbox.AddLeased(sc, bookIDs, models.OutboxOpIndex, lease)
+			return nil, err
+		})
+		if errors.Is(err, errBatchRejected) {
+			kept := positions[:0]
+			for i, pos := range positions {
+				if rejectErr, ok := rejected[i]; ok {
+					failures[pos] = rejectErr
+				} else {
+					kept = append(kept, pos)
+				}
+			}
+			positions = kept
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, pos := range positions {
+			entries[pos] = ids[i]
+		}
+		break
 	}
-	defer resp.Body.Close()
+	return failures, entries, nil
+}
 
-	if resp.IsError() {
-		return err
+// insertMany inserts books unordered and returns the failures keyed by
+// position in books
+func (r *bookRepository) insertMany(ctx context.Context, books []*models.Book) (map[int]error, error) {
+	failures := make(map[int]error)
+	if len(books) == 0 {
+		return failures, nil
 	}
 
-	book.ID = res.InsertedID.(primitive.ObjectID)
-	return nil
+	docs := make([]interface{}, len(books))
+	for idx, book := range books {
+		docs[idx] = book
+	}
+	_, err := r.mongoCollection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failures[writeErr.Index] = errors.New(writeErr.Message)
+		}
+		return failures, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return failures, nil
 }
 
 // FindByID retrieves a book from MongoDB
@@ -89,123 +211,183 @@ func (r *bookRepository) FindByID(ctx context.Context, id string) (*models.Book,
 
 	var book models.Book
 	err = r.mongoCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&book)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrBookNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &book, nil
 }
 
-// FindAll retrieves all books from MongoDB
-func (r *bookRepository) FindAll(ctx context.Context) ([]models.Book, error) {
-	cursor, err := r.mongoCollection.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
+// Update replaces a book in MongoDB and queues it for re-indexing.
+// CreatedAt is preserved from the stored document and UpdatedAt is bumped.
+func (r *bookRepository) Update(ctx context.Context, book *models.Book) error {
+	return r.write(ctx, book.ID, models.OutboxOpIndex, func(ctx context.Context) error {
+		var existing models.Book
+		err := r.mongoCollection.FindOne(ctx, bson.M{"_id": book.ID}).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrBookNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		book.CreatedAt = existing.CreatedAt
+		book.UpdatedAt = time.Now()
 
-	var books []models.Book
-	err = cursor.All(ctx, &books)
-	return books, err
+		res, err := r.mongoCollection.ReplaceOne(ctx, bson.M{"_id": book.ID}, book)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return ErrBookNotFound
+		}
+		return nil
+	})
 }
 
-// SearchByTitle searches for books by title in Elasticsearch
-func (r *bookRepository) SearchByTitle(ctx context.Context, title string) ([]models.Book, error) {
-	query := map[string]interface{}{
-		"size": 100,
-		"query": map[string]interface{}{
-			"match": map[string]interface{}{
-				"title": title,
-			},
-		},
-	}
-
-	queryJSON, err := json.Marshal(query)
+// Patch applies a partial update in MongoDB and queues the book for re-indexing
+func (r *bookRepository) Patch(ctx context.Context, id string, patch *models.BookPatch) (*models.Book, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 
-	req := esapi.SearchRequest{
-		Index: []string{"books"},
-		Body:  bytes.NewReader(queryJSON),
+	set := bson.M{"updated_at": time.Now()}
+	if patch.Title != nil {
+		set["title"] = *patch.Title
 	}
-
-	res, err := req.Do(ctx, database.ESClient)
-	if err != nil {
-		return nil, err
+	if patch.Author != nil {
+		set["author"] = *patch.Author
 	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		return nil, fmt.Errorf("error searching documents: %s", res.String())
+	if patch.ISBN != nil {
+		set["isbn"] = *patch.ISBN
 	}
-
-	type ESResponse struct {
-		Hits struct {
-			Hits []struct {
-				Source models.Book `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
+	if patch.Description != nil {
+		set["description"] = *patch.Description
+	}
+	if patch.Publisher != nil {
+		set["publisher"] = *patch.Publisher
+	}
+	if patch.PublishDate != nil {
+		set["publish_date"] = *patch.PublishDate
+	}
+	if patch.Pages != nil {
+		set["pages"] = *patch.Pages
+	}
+	if patch.Language != nil {
+		set["language"] = *patch.Language
 	}
 
-	var response ESResponse
-	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+	var book models.Book
+	err = r.write(ctx, objectID, models.OutboxOpIndex, func(ctx context.Context) error {
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := r.mongoCollection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{"$set": set}, opts).Decode(&book)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrBookNotFound
+		}
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
+	return &book, nil
+}
 
-	// 4. ดึงข้อมูลออกมา
-	var books []models.Book
-	for _, hit := range response.Hits.Hits {
-		books = append(books, hit.Source)
+// Delete removes a book from MongoDB and queues its removal from Elasticsearch
+func (r *bookRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
 	}
 
-	return books, nil
+	return r.write(ctx, objectID, models.OutboxOpDelete, func(ctx context.Context) error {
+		res, err := r.mongoCollection.DeleteOne(ctx, bson.M{"_id": objectID})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return ErrBookNotFound
+		}
+		return nil
+	})
 }
 
-// SearchByAuthor searches for books by author in Elasticsearch
-func (r *bookRepository) SearchByAuthor(ctx context.Context, author string) ([]models.Book, error) {
-	query := map[string]interface{}{
-		"query": map[string]interface{}{
-			"match": map[string]interface{}{
-				"author": author,
-			},
-		},
-	}
+// mongoSortFields maps sort keys to document fields
+var mongoSortFields = map[string]string{
+	models.SortTitle:       "title",
+	models.SortPublishDate: "publish_date",
+	models.SortPages:       "pages",
+	models.SortCreatedAt:   "created_at",
+}
 
-	queryJSON, err := json.Marshal(query)
+// List returns one page of books from MongoDB and the total number of books.
+// _id breaks ties so that pages do not overlap.
+func (r *bookRepository) List(ctx context.Context, opts models.BookListOptions) (*models.BookList, error) {
+	total, err := r.mongoCollection.CountDocuments(ctx, bson.M{})
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling query: %w", err)
+		return nil, err
 	}
 
-	req := esapi.SearchRequest{
-		Index: []string{"books"},
-		Body:  bytes.NewReader(queryJSON),
+	direction := 1
+	if opts.Sort.Desc {
+		direction = -1
 	}
+	sort := bson.D{{Key: "_id", Value: direction}}
+	if field, ok := mongoSortFields[opts.Sort.Key]; ok {
+		sort = append(bson.D{{Key: field, Value: direction}}, sort...)
+	}
+
+	findOpts := options.Find().
+		SetSort(sort).
+		SetSkip(int64((opts.Page - 1) * opts.PerPage)).
+		SetLimit(int64(opts.PerPage))
 
-	res, err := req.Do(ctx, database.ESClient)
+	cursor, err := r.mongoCollection.Find(ctx, bson.M{}, findOpts)
 	if err != nil {
-		return nil, fmt.Errorf("error executing search request: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
+	defer cursor.Close(ctx)
 
-	if res.IsError() {
-		return nil, fmt.Errorf("elasticsearch returned error: %s", res.String())
-	}
-	type ESResponse struct {
-		Hits struct {
-			Hits []struct {
-				Source models.Book `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
+	books := []models.Book{}
+	if err := cursor.All(ctx, &books); err != nil {
+		return nil, err
 	}
 
-	var response ESResponse
-	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("error parsing response body: %w", err)
+	return &models.BookList{
+		Books:   books,
+		Total:   total,
+		Page:    opts.Page,
+		PerPage: opts.PerPage,
+	}, nil
+}
+
+// Each streams every book in MongoDB to fn in _id order without loading the
+// collection into memory. Iteration stops at the first error returned by fn.
+func (r *bookRepository) Each(ctx context.Context, fn func(book *models.Book) error) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(500)
+
+	cursor, err := r.mongoCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
 	}
-	var books []models.Book
-	for _, hit := range response.Hits.Hits {
-		books = append(books, hit.Source)
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var book models.Book
+		if err := cursor.Decode(&book); err != nil {
+			return err
+		}
+		if err := fn(&book); err != nil {
+			return err
+		}
 	}
+	return cursor.Err()
+}
 
-	return books, nil
+func (r *bookRepository) Count(ctx context.Context) (int64, error) {
+	return r.mongoCollection.EstimatedDocumentCount(ctx)
 }
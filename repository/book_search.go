@@ -0,0 +1,514 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/models"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ErrInvalidCursor is returned for a search cursor that is malformed or was
+// issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrSearchUnavailable is returned when Elasticsearch could not be reached or
+// failed to answer, rather than rejecting the search.
+var ErrSearchUnavailable = errors.New("search unavailable")
+
+// searchError turns an error response into an error, wrapping
+// ErrSearchUnavailable when Elasticsearch failed or was overloaded, or the
+// index was closed, rather than rejecting the request.
+func searchError(res *esapi.Response) error {
+	body := res.String()
+	if res.StatusCode >= 500 || res.StatusCode == 429 || strings.Contains(body, "index_closed_exception") {
+		return fmt.Errorf("%w: elasticsearch returned error: %s", ErrSearchUnavailable, body)
+	}
+	return fmt.Errorf("elasticsearch returned error: %s", body)
+}
+
+// esSortFields maps sort keys to index fields
+var esSortFields = map[string]string{
+	models.SortRelevance:   "_score",
+	models.SortTitle:       "title.sort",
+	models.SortPublishDate: "publish_date",
+	models.SortPages:       "pages",
+	models.SortCreatedAt:   "created_at",
+}
+
+// Facet sizes: the number of terms buckets returned per facet, and the width
+// of the page-count histogram buckets.
+const (
+	facetTermsSize     = 20
+	facetPagesInterval = 100
+)
+
+// Search runs a full-text query against the books read alias and computes
+// facet counts over every matching book with aggregations on the same query.
+func (r *bookRepository) Search(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	query := buildSearchQuery(params)
+	if params.Cursor != "" {
+		searchAfter, err := decodeCursor(params.Cursor, params.Sort)
+		if err != nil {
+			return nil, err
+		}
+		query["search_after"] = searchAfter
+		delete(query, "from")
+	}
+	query["track_total_hits"] = true
+	query["aggs"] = buildFacetAggs()
+	if params.Highlight {
+		query["highlight"] = buildHighlight()
+	}
+
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling query: %w", err)
+	}
+
+	req := esapi.SearchRequest{
+		Index: []string{database.BooksReadAlias},
+		Body:  bytes.NewReader(queryJSON),
+	}
+
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error executing search request: %w", ErrSearchUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, searchError(res)
+	}
+
+	var response searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing response body: %w", err)
+	}
+
+	result := &models.BookSearchResult{
+		Hits:    response.bookHits(),
+		Total:   response.Hits.Total.Value,
+		PerPage: params.PerPage,
+		Facets:  response.Aggregations.facets(),
+	}
+	if params.Cursor == "" {
+		result.Page = params.Page
+	}
+
+	// kNN hits have no sort values to continue from.
+	if hits := response.Hits.Hits; len(hits) > 0 && len(hits) == params.PerPage && len(params.Vector) == 0 {
+		result.NextCursor, err = encodeCursor(params.Sort, hits[len(hits)-1].Sort)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			ID        string              `json:"_id"`
+			Source    models.Book         `json:"_source"`
+			Score     *float64            `json:"_score"`
+			Highlight map[string][]string `json:"highlight"`
+			Sort      []interface{}       `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations facetAggs `json:"aggregations"`
+	// PitID is the point in time to search next, for a point-in-time search
+	PitID string `json:"pit_id"`
+}
+
+func (r searchResponse) bookHits() []models.BookHit {
+	hits := []models.BookHit{}
+	for _, hit := range r.Hits.Hits {
+		hits = append(hits, models.BookHit{
+			Book:       hit.Source,
+			Score:      hit.Score,
+			Highlights: foldLanguageHighlights(hit.Highlight),
+		})
+	}
+	return hits
+}
+
+// searchCursor is the decoded form of a search cursor: the sort it belongs
+// to and the sort values of the last hit on the previous page.
+type searchCursor struct {
+	Sort        string        `json:"s"`
+	SearchAfter []interface{} `json:"a"`
+}
+
+func sortCursorKey(sort models.SortField) string {
+	if sort.Desc {
+		return "-" + sort.Key
+	}
+	return sort.Key
+}
+
+func encodeCursor(sort models.SortField, searchAfter []interface{}) (string, error) {
+	raw, err := json.Marshal(searchCursor{Sort: sortCursorKey(sort), SearchAfter: searchAfter})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(cursor string, sort models.SortField) ([]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c searchCursor
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil || len(c.SearchAfter) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sortCursorKey(sort) {
+		return nil, fmt.Errorf("%w: it was issued for sort=%s", ErrInvalidCursor, c.Sort)
+	}
+	return c.SearchAfter, nil
+}
+
+type aggBuckets struct {
+	Buckets []struct {
+		Key         interface{} `json:"key"`
+		KeyAsString string      `json:"key_as_string"`
+		DocCount    int64       `json:"doc_count"`
+	} `json:"buckets"`
+}
+
+type facetAggs struct {
+	Languages    aggBuckets `json:"languages"`
+	Publishers   aggBuckets `json:"publishers"`
+	Authors      aggBuckets `json:"authors"`
+	Pages        aggBuckets `json:"pages"`
+	PublishYears aggBuckets `json:"publish_years"`
+}
+
+func (a facetAggs) facets() *models.BookFacets {
+	facets := &models.BookFacets{
+		Languages:    a.Languages.terms(),
+		Publishers:   a.Publishers.terms(),
+		Authors:      a.Authors.terms(),
+		Pages:        []models.RangeBucket{},
+		PublishYears: a.PublishYears.terms(),
+	}
+	for _, b := range a.Pages.Buckets {
+		min := int(toFloat(b.Key))
+		facets.Pages = append(facets.Pages, models.RangeBucket{
+			Min:   min,
+			Max:   min + facetPagesInterval - 1,
+			Count: b.DocCount,
+		})
+	}
+	return facets
+}
+
+func (a aggBuckets) terms() []models.FacetBucket {
+	buckets := []models.FacetBucket{}
+	for _, b := range a.Buckets {
+		value := b.KeyAsString
+		if value == "" {
+			value = fmt.Sprint(b.Key)
+		}
+		buckets = append(buckets, models.FacetBucket{Value: value, Count: b.DocCount})
+	}
+	return buckets
+}
+
+func toFloat(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
+
+// buildFacetAggs returns the aggregations behind BookFacets. Empty histogram
+// buckets are left out.
+func buildFacetAggs() map[string]interface{} {
+	terms := func(field string) map[string]interface{} {
+		return map[string]interface{}{
+			"terms": map[string]interface{}{"field": field, "size": facetTermsSize},
+		}
+	}
+
+	return map[string]interface{}{
+		"languages":  terms("language"),
+		"publishers": terms("publisher.keyword"),
+		"authors":    terms("author.keyword"),
+		"pages": map[string]interface{}{
+			"histogram": map[string]interface{}{
+				"field":         "pages",
+				"interval":      facetPagesInterval,
+				"min_doc_count": 1,
+			},
+		},
+		"publish_years": map[string]interface{}{
+			"date_histogram": map[string]interface{}{
+				"field":             "publish_date",
+				"calendar_interval": "year",
+				"format":            "yyyy",
+				"min_doc_count":     1,
+			},
+		},
+	}
+}
+
+// buildHighlight returns the highlight section of a search. Titles and
+// authors are short and highlighted whole; descriptions are cut into up to
+// three fragments around the matches. The html encoder escapes the source
+// text so the fragments can be inserted into a page as-is. The per-language
+// fields are highlighted like the fields they copy, since a Thai or Japanese
+// word may only match there.
+func buildHighlight() map[string]interface{} {
+	title := map[string]interface{}{"number_of_fragments": 0}
+	description := map[string]interface{}{"fragment_size": 150, "number_of_fragments": 3}
+	fields := map[string]interface{}{
+		"title":       title,
+		"author":      map[string]interface{}{"number_of_fragments": 0},
+		"description": description,
+	}
+	for _, code := range analyzedLanguages {
+		fields[languageField("title", code)] = title
+		fields[languageField("description", code)] = description
+	}
+
+	return map[string]interface{}{
+		"pre_tags":  []string{"<em>"},
+		"post_tags": []string{"</em>"},
+		"encoder":   "html",
+		"fields":    fields,
+	}
+}
+
+// buildSearchQuery turns a search request into an Elasticsearch query body.
+// The free-text part is a multi_match in a bool must clause so that boosts,
+// phrase and prefix matching behave the same whether one field or several
+// are searched; filters go in the filter clause and do not affect scoring.
+// A parsed query language expression or a more_like_this query for similar
+// books takes the place of the multi_match. A semantic search has a kNN
+// section instead of a query. Embeddings are never returned.
+func buildSearchQuery(params models.BookSearch) map[string]interface{} {
+	source := map[string]interface{}{"excludes": []string{embeddingField}}
+	if len(params.Vector) > 0 {
+		return map[string]interface{}{
+			"from":    (params.Page - 1) * params.PerPage,
+			"size":    params.PerPage,
+			"knn":     buildKNN(params),
+			"_source": source,
+		}
+	}
+
+	boolQuery := map[string]interface{}{}
+	switch {
+	case params.Like != nil:
+		addSimilarQuery(boolQuery, params.Like)
+	case params.Expression != nil:
+		boolQuery["must"] = []interface{}{buildExpressionQuery(params.Expression, params)}
+	case params.Query != "":
+		boolQuery["must"] = []interface{}{buildTextQuery(params)}
+	}
+	if filters := buildFilters(params.Filters); len(filters) > 0 {
+		boolQuery["filter"] = filters
+	}
+
+	return map[string]interface{}{
+		"from": (params.Page - 1) * params.PerPage,
+		"size": params.PerPage,
+		"sort": buildSort(params.Sort),
+		"query": map[string]interface{}{
+			"bool": boolQuery,
+		},
+		"_source": source,
+	}
+}
+
+// embeddingField is the dense_vector field holding book embeddings
+const embeddingField = "embedding"
+
+// maxNumCandidates is the Elasticsearch limit on kNN num_candidates
+const maxNumCandidates = 10000
+
+// buildKNN returns an approximate nearest neighbour search for the books
+// closest to params.Vector. k covers every hit up to the requested page, and
+// each shard considers several times as many candidates, which trades a
+// little speed for recall. Filters are applied during the search, so a
+// filtered search still returns k books when enough match.
+func buildKNN(params models.BookSearch) map[string]interface{} {
+	k := params.Page * params.PerPage
+	knn := map[string]interface{}{
+		"field":          embeddingField,
+		"query_vector":   params.Vector,
+		"k":              k,
+		"num_candidates": min(max(4*k, 100), maxNumCandidates),
+	}
+	if filters := buildFilters(params.Filters); len(filters) > 0 {
+		knn["filter"] = filters
+	}
+	return knn
+}
+
+// buildSort always ends with the id field so that every hit has a unique
+// sort position, which search_after needs.
+func buildSort(sort models.SortField) []interface{} {
+	field, ok := esSortFields[sort.Key]
+	if !ok {
+		field = "_score"
+	}
+
+	// Relevance reads best first, so its natural order is descending.
+	desc := sort.Desc
+	if field == "_score" {
+		desc = !desc
+	}
+	order := "asc"
+	if desc {
+		order = "desc"
+	}
+
+	return []interface{}{
+		map[string]interface{}{field: map[string]interface{}{"order": order}},
+		map[string]interface{}{"id": map[string]interface{}{"order": "asc"}},
+	}
+}
+
+// similarFields are the text fields compared by more_like_this
+var similarFields = []string{"title", "author", "description"}
+
+// sameLanguageBoost is how much more a similar book in the same language
+// scores than one in another language.
+const sameLanguageBoost = 2.0
+
+// addSimilarQuery matches books that share distinctive terms with book. The
+// book's current MongoDB fields are sent as an artificial document, so it
+// works even before the book has been indexed. A book in the same language
+// scores higher without excluding the others, and the book itself is
+// excluded. Books in an analyzed language are also compared on the fields
+// of that language, where words are split and stemmed properly.
+func addSimilarQuery(boolQuery map[string]interface{}, book *models.Book) {
+	fields := similarFields
+	doc := map[string]interface{}{
+		"title":       book.Title,
+		"author":      book.Author,
+		"description": book.Description,
+	}
+	if code := languageCode(book.Language); code != "" {
+		fields = withLanguageFields(similarFields, []string{code})
+		doc[languageField("title", code)] = book.Title
+		doc[languageField("description", code)] = book.Description
+	}
+
+	boolQuery["must"] = []interface{}{
+		map[string]interface{}{
+			"more_like_this": map[string]interface{}{
+				"fields": fields,
+				"like":   []interface{}{map[string]interface{}{"doc": doc}},
+				// The defaults (2 and 5) suit large collections of long
+				// documents; book descriptions are short.
+				"min_term_freq":        1,
+				"min_doc_freq":         2,
+				"max_query_terms":      25,
+				"minimum_should_match": "30%",
+			},
+		},
+	}
+	if book.Language != "" {
+		boolQuery["should"] = []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"language": map[string]interface{}{"value": book.Language, "boost": sameLanguageBoost}}},
+		}
+	}
+	boolQuery["must_not"] = []interface{}{
+		map[string]interface{}{"ids": map[string]interface{}{"values": []string{book.ID.Hex()}}},
+	}
+}
+
+// buildTextQuery matches the query against params.Fields and the
+// per-language variants of those fields for the searched languages.
+func buildTextQuery(params models.BookSearch) map[string]interface{} {
+	multiMatch := map[string]interface{}{
+		"query":  params.Query,
+		"fields": withLanguageFields(params.Fields, searchLanguages(params.Filters)),
+	}
+
+	switch params.Match {
+	case models.MatchPhrase:
+		multiMatch["type"] = "phrase"
+	case models.MatchPrefix:
+		multiMatch["type"] = "phrase_prefix"
+	default:
+		multiMatch["type"] = "best_fields"
+		multiMatch["tie_breaker"] = 0.3
+		if params.MinimumShouldMatch != "" {
+			multiMatch["minimum_should_match"] = params.MinimumShouldMatch
+		}
+		// The first character must match, which keeps the number of
+		// candidate terms small; fuzzy matches score below exact ones.
+		if params.Fuzziness != "" && params.Fuzziness != "0" {
+			multiMatch["fuzziness"] = params.Fuzziness
+			multiMatch["prefix_length"] = 1
+		}
+	}
+
+	return map[string]interface{}{"multi_match": multiMatch}
+}
+
+// buildFilters returns the filter clauses for a search. Date bounds are
+// inclusive.
+func buildFilters(f models.BookFilters) []interface{} {
+	filters := []interface{}{}
+
+	if len(f.Languages) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"terms": map[string]interface{}{"language": f.Languages},
+		})
+	}
+	if len(f.Authors) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"terms": map[string]interface{}{"author.keyword": f.Authors},
+		})
+	}
+	if len(f.Publishers) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"terms": map[string]interface{}{"publisher.keyword": f.Publishers},
+		})
+	}
+	if f.PagesMin > 0 || f.PagesMax > 0 {
+		pages := map[string]interface{}{}
+		if f.PagesMin > 0 {
+			pages["gte"] = f.PagesMin
+		}
+		if f.PagesMax > 0 {
+			pages["lte"] = f.PagesMax
+		}
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{"pages": pages},
+		})
+	}
+	if !f.PublishedAfter.IsZero() || !f.PublishedBefore.IsZero() {
+		published := map[string]interface{}{}
+		if !f.PublishedAfter.IsZero() {
+			published["gte"] = f.PublishedAfter.Format(time.RFC3339)
+		}
+		if !f.PublishedBefore.IsZero() {
+			published["lte"] = f.PublishedBefore.Format(time.RFC3339)
+		}
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{"publish_date": published},
+		})
+	}
+
+	return filters
+}
@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"encoding/json"
+	"go-elastic/models"
+	"go-elastic/querylang"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func multiMatchOf(t *testing.T, query map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	must := query["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"].([]interface{})
+	return must[0].(map[string]interface{})["multi_match"].(map[string]interface{})
+}
+
+func TestBuildSearchQuery_BestFields(t *testing.T) {
+	query := buildSearchQuery(models.BookSearch{
+		Query:              "go programming",
+		Fields:             []string{"title^3", "author"},
+		MinimumShouldMatch: "75%",
+	})
+
+	mm := multiMatchOf(t, query)
+	assert.Equal(t, "best_fields", mm["type"])
+	assert.Equal(t, []string{"title^3", "author", "title_en^3", "title_ja^3", "title_th^3"}, mm["fields"])
+	assert.Equal(t, "75%", mm["minimum_should_match"])
+}
+
+func TestBuildSearchQuery_PhraseAndPrefix(t *testing.T) {
+	phrase := multiMatchOf(t, buildSearchQuery(models.BookSearch{Query: "go", Match: models.MatchPhrase, MinimumShouldMatch: "2"}))
+	assert.Equal(t, "phrase", phrase["type"])
+	assert.NotContains(t, phrase, "minimum_should_match")
+
+	prefix := multiMatchOf(t, buildSearchQuery(models.BookSearch{Query: "go", Match: models.MatchPrefix}))
+	assert.Equal(t, "phrase_prefix", prefix["type"])
+}
+
+func TestBuildSearchQuery_Fuzziness(t *testing.T) {
+	fuzzy := multiMatchOf(t, buildSearchQuery(models.BookSearch{Query: "ken thompsn", Fuzziness: "AUTO"}))
+	assert.Equal(t, "AUTO", fuzzy["fuzziness"])
+	assert.Equal(t, 1, fuzzy["prefix_length"])
+
+	off := multiMatchOf(t, buildSearchQuery(models.BookSearch{Query: "ken thompsn", Fuzziness: "0"}))
+	assert.NotContains(t, off, "fuzziness")
+
+	phrase := multiMatchOf(t, buildSearchQuery(models.BookSearch{Query: "ken thompsn", Match: models.MatchPhrase, Fuzziness: "AUTO"}))
+	assert.NotContains(t, phrase, "fuzziness", "phrase queries do not support fuzziness")
+}
+
+func TestBuildSearchQuery_Filters(t *testing.T) {
+	query := buildSearchQuery(models.BookSearch{
+		Query:  "go",
+		Fields: []string{"title"},
+		Filters: models.BookFilters{
+			Languages:      []string{"Japanese"},
+			Authors:        []string{"Rob Pike"},
+			Publishers:     []string{"O'Reilly"},
+			PagesMin:       100,
+			PagesMax:       300,
+			PublishedAfter: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	})
+
+	boolQuery := query["query"].(map[string]interface{})["bool"].(map[string]interface{})
+	assert.Len(t, boolQuery["must"], 1)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"terms": map[string]interface{}{"language": []string{"Japanese"}}},
+		map[string]interface{}{"terms": map[string]interface{}{"author.keyword": []string{"Rob Pike"}}},
+		map[string]interface{}{"terms": map[string]interface{}{"publisher.keyword": []string{"O'Reilly"}}},
+		map[string]interface{}{"range": map[string]interface{}{"pages": map[string]interface{}{"gte": 100, "lte": 300}}},
+		map[string]interface{}{"range": map[string]interface{}{"publish_date": map[string]interface{}{"gte": "2020-01-01T00:00:00Z"}}},
+	}, boolQuery["filter"])
+}
+
+func TestBuildSearchQuery_FiltersOnly(t *testing.T) {
+	query := buildSearchQuery(models.BookSearch{Filters: models.BookFilters{PagesMax: 50}})
+
+	boolQuery := query["query"].(map[string]interface{})["bool"].(map[string]interface{})
+	assert.NotContains(t, boolQuery, "must")
+	assert.Len(t, boolQuery["filter"], 1)
+}
+
+func TestFacetAggs_Facets(t *testing.T) {
+	body := `{
+		"languages": {"buckets": [{"key": "Japanese", "doc_count": 7}]},
+		"publishers": {"buckets": []},
+		"authors": {"buckets": [{"key": "Rob Pike", "doc_count": 2}]},
+		"pages": {"buckets": [{"key": 200.0, "doc_count": 5}]},
+		"publish_years": {"buckets": [{"key": 1577836800000, "key_as_string": "2020", "doc_count": 3}]}
+	}`
+	var aggs facetAggs
+	require.NoError(t, json.Unmarshal([]byte(body), &aggs))
+
+	facets := aggs.facets()
+	assert.Equal(t, []models.FacetBucket{{Value: "Japanese", Count: 7}}, facets.Languages)
+	assert.Equal(t, []models.FacetBucket{}, facets.Publishers)
+	assert.Equal(t, []models.FacetBucket{{Value: "Rob Pike", Count: 2}}, facets.Authors)
+	assert.Equal(t, []models.RangeBucket{{Min: 200, Max: 299, Count: 5}}, facets.Pages)
+	assert.Equal(t, []models.FacetBucket{{Value: "2020", Count: 3}}, facets.PublishYears)
+}
+
+func TestBuildSort(t *testing.T) {
+	tests := []struct {
+		sort  models.SortField
+		field string
+		order string
+	}{
+		{models.SortField{Key: models.SortRelevance}, "_score", "desc"},
+		{models.SortField{Key: models.SortRelevance, Desc: true}, "_score", "asc"},
+		{models.SortField{Key: models.SortTitle}, "title.sort", "asc"},
+		{models.SortField{Key: models.SortPublishDate, Desc: true}, "publish_date", "desc"},
+	}
+
+	for _, tt := range tests {
+		sort := buildSort(tt.sort)
+		require.Len(t, sort, 2)
+		assert.Equal(t, map[string]interface{}{tt.field: map[string]interface{}{"order": tt.order}}, sort[0])
+		assert.Equal(t, map[string]interface{}{"id": map[string]interface{}{"order": "asc"}}, sort[1], "id breaks ties")
+	}
+}
+
+func TestBuildSearchQuery_Paging(t *testing.T) {
+	query := buildSearchQuery(models.BookSearch{Query: "go", Page: 3, PerPage: 20})
+	assert.Equal(t, 40, query["from"])
+	assert.Equal(t, 20, query["size"])
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	sort := models.SortField{Key: models.SortPublishDate, Desc: true}
+
+	cursor, err := encodeCursor(sort, []interface{}{float64(1577836800000), "507f1f77bcf86cd799439011"})
+	require.NoError(t, err)
+
+	searchAfter, err := decodeCursor(cursor, sort)
+	require.NoError(t, err)
+	raw, err := json.Marshal(searchAfter)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[1577836800000, "507f1f77bcf86cd799439011"]`, string(raw))
+
+	_, err = decodeCursor(cursor, models.SortField{Key: models.SortPublishDate})
+	assert.ErrorIs(t, err, ErrInvalidCursor, "cursor from another sort")
+
+	_, err = decodeCursor("not a cursor!", sort)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestSearchResponse_BookHits(t *testing.T) {
+	body := `{"hits": {"total": {"value": 2}, "hits": [
+		{"_score": 1.5, "_source": {"title": "Go in Action"}, "highlight": {"title": ["<em>Go</em> in Action"]}},
+		{"_score": null, "_source": {"title": "Learning Go"}}
+	]}}`
+	var response searchResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &response))
+
+	hits := response.bookHits()
+	require.Len(t, hits, 2)
+	assert.Equal(t, "Go in Action", hits[0].Book.Title)
+	require.NotNil(t, hits[0].Score)
+	assert.Equal(t, 1.5, *hits[0].Score)
+	assert.Equal(t, map[string][]string{"title": {"<em>Go</em> in Action"}}, hits[0].Highlights)
+	assert.Nil(t, hits[1].Score, "not scored when sorted by another field")
+	assert.Nil(t, hits[1].Highlights)
+}
+
+func TestBuildSearchQuery_Expression(t *testing.T) {
+	expr, err := querylang.Parse(`author:"John Doe" AND language:Japanese AND pages:>200 -title:draft (go OR progr*)`)
+	require.NoError(t, err)
+
+	query := buildSearchQuery(models.BookSearch{
+		Query:      "ignored once parsed",
+		Expression: expr,
+		Fields:     []string{"title^3", "author"},
+		Fuzziness:  "AUTO",
+	})
+
+	raw, err := json.Marshal(query["query"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"bool": {"must": [{"bool": {
+		"must": [
+			{"match_phrase": {"author": "John Doe"}},
+			{"term": {"language": {"value": "Japanese", "case_insensitive": true}}},
+			{"range": {"pages": {"gt": "200"}}},
+			{"bool": {"should": [
+				{"multi_match": {"query": "go", "fields": ["title^3", "author", "title_en^3", "title_ja^3", "title_th^3"], "type": "best_fields", "tie_breaker": 0.3, "fuzziness": "AUTO", "prefix_length": 1}},
+				{"multi_match": {"query": "progr", "fields": ["title^3", "author", "title_en^3", "title_ja^3", "title_th^3"], "type": "phrase_prefix"}}
+			], "minimum_should_match": 1}}
+		],
+		"must_not": [
+			{"multi_match": {"query": "draft", "fields": ["title", "title_en", "title_ja", "title_th"], "type": "best_fields", "operator": "and"}}
+		]
+	}}]}}`, string(raw))
+}
+
+func TestBuildSearchQuery_Similar(t *testing.T) {
+	id, err := primitive.ObjectIDFromHex("507f1f77bcf86cd799439011")
+	require.NoError(t, err)
+	book := &models.Book{ID: id, Title: "Go in Action", Author: "William Kennedy", Description: "Concurrency", Language: "English"}
+
+	query := buildSearchQuery(models.BookSearch{Like: book, Filters: models.BookFilters{PagesMax: 500}})
+
+	boolQuery := query["query"].(map[string]interface{})["bool"].(map[string]interface{})
+	mlt := boolQuery["must"].([]interface{})[0].(map[string]interface{})["more_like_this"].(map[string]interface{})
+	assert.Equal(t, []string{"title", "author", "description", "title_en", "description_en"}, mlt["fields"])
+	doc := mlt["like"].([]interface{})[0].(map[string]interface{})["doc"].(map[string]interface{})
+	assert.Equal(t, "Go in Action", doc["title"])
+	assert.Equal(t, "Go in Action", doc["title_en"], "compared on the English fields too")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"language": map[string]interface{}{"value": "English", "boost": sameLanguageBoost}}},
+	}, boolQuery["should"], "same language preferred")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"ids": map[string]interface{}{"values": []string{"507f1f77bcf86cd799439011"}}},
+	}, boolQuery["must_not"], "the book itself is excluded")
+	assert.Len(t, boolQuery["filter"], 1)
+}
+
+func TestBuildSearchQuery_LanguageFields(t *testing.T) {
+	mm := multiMatchOf(t, buildSearchQuery(models.BookSearch{
+		Query:   "ภาษา",
+		Fields:  []string{"title^3", "description"},
+		Filters: models.BookFilters{Languages: []string{"Thai", "Klingon"}},
+	}))
+	assert.Equal(t, []string{"title^3", "description", "title_th^3", "description_th"}, mm["fields"], "only the filtered languages")
+
+	mm = multiMatchOf(t, buildSearchQuery(models.BookSearch{
+		Query:   "qapla",
+		Fields:  []string{"title"},
+		Filters: models.BookFilters{Languages: []string{"Klingon"}},
+	}))
+	assert.Equal(t, []string{"title"}, mm["fields"], "no analyzer for the language")
+}
+
+func TestSearchResponse_FoldsLanguageHighlights(t *testing.T) {
+	body := `{"hits": {"hits": [
+		{"_source": {"title": "ภาษาไทย"}, "highlight": {"title_th": ["<em>ภาษา</em>ไทย"]}},
+		{"_source": {"title": "Running"}, "highlight": {"title": ["<em>Running</em>"], "title_en": ["<em>Running</em>"]}}
+	]}}`
+	var response searchResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &response))
+
+	hits := response.bookHits()
+	assert.Equal(t, map[string][]string{"title": {"<em>ภาษา</em>ไทย"}}, hits[0].Highlights)
+	assert.Equal(t, map[string][]string{"title": {"<em>Running</em>"}}, hits[1].Highlights)
+}
+
+func TestBuildSearchQuery_Vector(t *testing.T) {
+	query := buildSearchQuery(models.BookSearch{
+		Query:   "concurrency",
+		Vector:  []float32{0.6, 0.8},
+		Page:    3,
+		PerPage: 10,
+		Filters: models.BookFilters{PagesMax: 500},
+	})
+
+	assert.NotContains(t, query, "query")
+	assert.Equal(t, 20, query["from"])
+	assert.Equal(t, 10, query["size"])
+	assert.Equal(t, map[string]interface{}{"excludes": []string{"embedding"}}, query["_source"])
+
+	knn := query["knn"].(map[string]interface{})
+	assert.Equal(t, "embedding", knn["field"])
+	assert.Equal(t, 30, knn["k"], "every hit up to the page")
+	assert.Equal(t, 120, knn["num_candidates"])
+	assert.Len(t, knn["filter"], 1)
+
+	knn = buildSearchQuery(models.BookSearch{Vector: []float32{1}, Page: 1, PerPage: 5})["knn"].(map[string]interface{})
+	assert.Equal(t, 100, knn["num_candidates"], "a floor for small pages")
+	assert.NotContains(t, knn, "filter")
+}
+
+func TestSearchError(t *testing.T) {
+	response := func(status int, body string) *esapi.Response {
+		return &esapi.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	assert.ErrorIs(t, searchError(response(503, `{"error":{"type":"cluster_block_exception"}}`)), ErrSearchUnavailable)
+	assert.ErrorIs(t, searchError(response(429, `{}`)), ErrSearchUnavailable)
+	assert.ErrorIs(t, searchError(response(400, `{"error":{"type":"index_closed_exception"}}`)), ErrSearchUnavailable, "a closed index cannot be searched for now")
+
+	err := searchError(response(400, `{"error":{"type":"parsing_exception"}}`))
+	assert.NotErrorIs(t, err, ErrSearchUnavailable)
+	assert.ErrorContains(t, err, "parsing_exception")
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/models"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Suggest completes prefix against book titles and author names with the
+// completion suggester. It reads only the in-memory suggester structures,
+// not the documents, so it is fast enough to call on every keystroke.
+func (r *bookRepository) Suggest(ctx context.Context, prefix string, size int) (*models.BookSuggestions, error) {
+	queryJSON, err := json.Marshal(buildSuggestQuery(prefix, size))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling query: %w", err)
+	}
+
+	req := esapi.SearchRequest{
+		Index: []string{database.BooksReadAlias},
+		Body:  bytes.NewReader(queryJSON),
+	}
+
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error executing suggest request: %w", ErrSearchUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("elasticsearch returned error: %s", res.String())
+	}
+
+	var response suggestResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing response body: %w", err)
+	}
+	return response.suggestions(), nil
+}
+
+// buildSuggestQuery asks for title and author completions in one request.
+// skip_duplicates collapses books with the same title or author, and a one
+// edit fuzziness tolerates a typo once a few characters have been typed.
+func buildSuggestQuery(prefix string, size int) map[string]interface{} {
+	completion := func(field string) map[string]interface{} {
+		return map[string]interface{}{
+			"prefix": prefix,
+			"completion": map[string]interface{}{
+				"field":           field,
+				"size":            size,
+				"skip_duplicates": true,
+				"fuzzy":           map[string]interface{}{"fuzziness": 1, "prefix_length": 2},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"_source": false,
+		"suggest": map[string]interface{}{
+			"titles":  completion("title_suggest"),
+			"authors": completion("author_suggest"),
+		},
+	}
+}
+
+type suggestOptions []struct {
+	Options []struct {
+		ID    string  `json:"_id"`
+		Text  string  `json:"text"`
+		Score float64 `json:"_score"`
+	} `json:"options"`
+}
+
+type suggestResponse struct {
+	Suggest struct {
+		Titles  suggestOptions `json:"titles"`
+		Authors suggestOptions `json:"authors"`
+	} `json:"suggest"`
+}
+
+func (r suggestResponse) suggestions() *models.BookSuggestions {
+	result := &models.BookSuggestions{
+		Titles:  []models.Suggestion{},
+		Authors: []models.Suggestion{},
+	}
+	for _, entry := range r.Suggest.Titles {
+		for _, o := range entry.Options {
+			result.Titles = append(result.Titles, models.Suggestion{Text: o.Text, Score: o.Score, ID: o.ID})
+		}
+	}
+	for _, entry := range r.Suggest.Authors {
+		for _, o := range entry.Options {
+			result.Authors = append(result.Authors, models.Suggestion{Text: o.Text, Score: o.Score})
+		}
+	}
+	return result
+}
+
+// didYouMeanSize is the number of spelling corrections returned
+const didYouMeanSize = 3
+
+// DidYouMean proposes corrections of a search query with the phrase
+// suggester on the shingled spell field, which title and author are copied
+// into. Corrections that would not match any book are dropped.
+func (r *bookRepository) DidYouMean(ctx context.Context, query string) ([]models.SpellingSuggestion, error) {
+	queryJSON, err := json.Marshal(buildDidYouMeanQuery(query))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling query: %w", err)
+	}
+
+	req := esapi.SearchRequest{
+		Index: []string{database.BooksReadAlias},
+		Body:  bytes.NewReader(queryJSON),
+	}
+
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("error executing suggest request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("elasticsearch returned error: %s", res.String())
+	}
+
+	var response struct {
+		Suggest struct {
+			DidYouMean []struct {
+				Options []struct {
+					Text        string  `json:"text"`
+					Highlighted string  `json:"highlighted"`
+					Score       float64 `json:"score"`
+				} `json:"options"`
+			} `json:"did_you_mean"`
+		} `json:"suggest"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing response body: %w", err)
+	}
+
+	var suggestions []models.SpellingSuggestion
+	for _, entry := range response.Suggest.DidYouMean {
+		for _, o := range entry.Options {
+			suggestions = append(suggestions, models.SpellingSuggestion{Text: o.Text, Highlighted: o.Highlighted, Score: o.Score})
+		}
+	}
+	return suggestions, nil
+}
+
+func buildDidYouMeanQuery(query string) map[string]interface{} {
+	return map[string]interface{}{
+		"size": 0,
+		"suggest": map[string]interface{}{
+			"text": query,
+			"did_you_mean": map[string]interface{}{
+				"phrase": map[string]interface{}{
+					"field":      "spell",
+					"size":       didYouMeanSize,
+					"gram_size":  3,
+					"max_errors": 2,
+					"direct_generator": []interface{}{
+						map[string]interface{}{"field": "spell", "suggest_mode": "always"},
+					},
+					"highlight": map[string]interface{}{"pre_tag": "<em>", "post_tag": "</em>"},
+					"collate": map[string]interface{}{
+						"query": map[string]interface{}{
+							"source": map[string]interface{}{
+								"match": map[string]interface{}{
+									"spell": map[string]interface{}{"query": "{{suggestion}}", "operator": "and"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
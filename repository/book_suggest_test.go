@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"encoding/json"
+	"go-elastic/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBookDocument_SuggestInputs(t *testing.T) {
+	decode := func(book *models.Book) map[string]interface{} {
+		raw, err := json.Marshal(newBookDocument(book))
+		require.NoError(t, err)
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &doc))
+		return doc
+	}
+
+	doc := decode(&models.Book{Title: " The Go Programming Language ", Author: "Alan Donovan, Brian Kernighan and Rob Pike"})
+	assert.Equal(t, " The Go Programming Language ", doc["title"], "book fields are indexed as-is")
+	assert.Equal(t, map[string]interface{}{"input": []interface{}{"The Go Programming Language"}}, doc["title_suggest"])
+	assert.Equal(t, map[string]interface{}{"input": []interface{}{"Alan Donovan", "Brian Kernighan", "Rob Pike"}}, doc["author_suggest"])
+
+	doc = decode(&models.Book{Pages: 10})
+	assert.NotContains(t, doc, "title_suggest")
+	assert.NotContains(t, doc, "author_suggest")
+}
+
+func TestSuggestResponse_Suggestions(t *testing.T) {
+	body := `{"suggest": {
+		"titles": [{"text": "go", "options": [{"_id": "507f1f77bcf86cd799439011", "text": "Go in Action", "_score": 2}]}],
+		"authors": [{"text": "go", "options": [{"_id": "507f1f77bcf86cd799439012", "text": "Gopher Smith", "_score": 1}]}]
+	}}`
+	var response suggestResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &response))
+
+	got := response.suggestions()
+	assert.Equal(t, []models.Suggestion{{Text: "Go in Action", Score: 2, ID: "507f1f77bcf86cd799439011"}}, got.Titles)
+	assert.Equal(t, []models.Suggestion{{Text: "Gopher Smith", Score: 1}}, got.Authors)
+}
+
+func TestBuildSuggestQuery(t *testing.T) {
+	query := buildSuggestQuery("ken th", 5)
+
+	assert.Equal(t, false, query["_source"])
+	authors := query["suggest"].(map[string]interface{})["authors"].(map[string]interface{})
+	assert.Equal(t, "ken th", authors["prefix"])
+	completion := authors["completion"].(map[string]interface{})
+	assert.Equal(t, "author_suggest", completion["field"])
+	assert.Equal(t, 5, completion["size"])
+	assert.Equal(t, true, completion["skip_duplicates"])
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"go-elastic/models"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bookTextIndex is the MongoDB text index behind TextSearch. Weights follow
+// the default SEARCH_FIELDS boosts.
+var bookTextIndex = mongo.IndexModel{
+	Keys: bson.D{
+		{Key: "title", Value: "text"},
+		{Key: "author", Value: "text"},
+		{Key: "description", Value: "text"},
+		{Key: "publisher", Value: "text"},
+	},
+	Options: options.Index().
+		SetName("books_text").
+		SetWeights(bson.D{{Key: "title", Value: 3}, {Key: "author", Value: 2}}).
+		// Books are in several languages, so words are not stemmed or
+		// stopped. The override field is one books never have: MongoDB would
+		// otherwise read the language field and reject books in languages
+		// it has no analyzer for, such as Thai.
+		SetDefaultLanguage("none").
+		SetLanguageOverride("text_language"),
+}
+
+// EnsureTextIndex creates the text index TextSearch needs. It is a no-op if
+// the index already exists.
+func (r *bookRepository) EnsureTextIndex(ctx context.Context) error {
+	_, err := r.mongoCollection.Indexes().CreateOne(ctx, bookTextIndex)
+	return err
+}
+
+// TextSearch is the MongoDB fallback for Search while Elasticsearch is
+// unavailable. It matches words of the query in title, author, description
+// and publisher, or a phrase for MatchPhrase, and applies the same filters
+// and sorts. It has no fuzziness, prefix matching, facets, highlights or
+// cursors; MatchPrefix is searched as whole words. Type title or author
+// additionally requires every word in that field.
+func (r *bookRepository) TextSearch(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	filter := buildTextFilter(params)
+
+	total, err := r.mongoCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(buildTextSort(params)).
+		SetSkip(int64((params.Page - 1) * params.PerPage)).
+		SetLimit(int64(params.PerPage))
+	relevance := params.Query != "" && params.Sort.Key == models.SortRelevance
+	if relevance {
+		opts.SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}})
+	}
+
+	cursor, err := r.mongoCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := &models.BookSearchResult{
+		Hits:    []models.BookHit{},
+		Total:   total,
+		Page:    params.Page,
+		PerPage: params.PerPage,
+	}
+	for cursor.Next(ctx) {
+		var doc struct {
+			models.Book `bson:",inline"`
+			Score       float64 `bson:"score"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		hit := models.BookHit{Book: doc.Book}
+		if relevance {
+			score := doc.Score
+			hit.Score = &score
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, cursor.Err()
+}
+
+// buildTextFilter translates a prepared search into a MongoDB filter
+func buildTextFilter(params models.BookSearch) bson.M {
+	filter := bson.M{}
+	if params.Query != "" {
+		search := params.Query
+		if params.Match == models.MatchPhrase {
+			search = `"` + strings.ReplaceAll(search, `"`, "") + `"`
+		}
+		filter["$text"] = bson.M{"$search": search}
+
+		// $text searches every indexed field; narrow it to the one asked for.
+		field := ""
+		switch params.Type {
+		case models.SearchTypeTitle:
+			field = "title"
+		case models.SearchTypeAuthor:
+			field = "author"
+		}
+		if field != "" {
+			var words bson.A
+			for _, word := range strings.Fields(params.Query) {
+				words = append(words, bson.M{field: bson.M{"$regex": regexp.QuoteMeta(strings.Trim(word, `"`)), "$options": "i"}})
+			}
+			if len(words) > 0 {
+				filter["$and"] = words
+			}
+		}
+	}
+
+	f := params.Filters
+	if len(f.Languages) > 0 {
+		filter["language"] = bson.M{"$in": f.Languages}
+	}
+	if len(f.Authors) > 0 {
+		filter["author"] = bson.M{"$in": f.Authors}
+	}
+	if len(f.Publishers) > 0 {
+		filter["publisher"] = bson.M{"$in": f.Publishers}
+	}
+	if f.PagesMin > 0 || f.PagesMax > 0 {
+		pages := bson.M{}
+		if f.PagesMin > 0 {
+			pages["$gte"] = f.PagesMin
+		}
+		if f.PagesMax > 0 {
+			pages["$lte"] = f.PagesMax
+		}
+		filter["pages"] = pages
+	}
+	if !f.PublishedAfter.IsZero() || !f.PublishedBefore.IsZero() {
+		published := bson.M{}
+		if !f.PublishedAfter.IsZero() {
+			published["$gte"] = f.PublishedAfter
+		}
+		if !f.PublishedBefore.IsZero() {
+			published["$lte"] = f.PublishedBefore
+		}
+		filter["publish_date"] = published
+	}
+	return filter
+}
+
+// buildTextSort sorts by text score for relevance, otherwise by the mapped
+// field; _id breaks ties so that pages do not overlap.
+func buildTextSort(params models.BookSearch) bson.D {
+	direction := 1
+	if params.Sort.Desc {
+		direction = -1
+	}
+	if params.Sort.Key == models.SortRelevance {
+		if params.Query == "" {
+			return bson.D{{Key: "_id", Value: 1}}
+		}
+		return bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}}
+	}
+	sort := bson.D{{Key: "_id", Value: direction}}
+	if field, ok := mongoSortFields[params.Sort.Key]; ok {
+		sort = append(bson.D{{Key: field, Value: direction}}, sort...)
+	}
+	return sort
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"go-elastic/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuildTextFilter(t *testing.T) {
+	after := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := buildTextFilter(models.BookSearch{
+		Type:  models.SearchTypeTitle,
+		Query: "go web",
+		Match: models.MatchPhrase,
+		Filters: models.BookFilters{
+			Languages:      []string{"English"},
+			PagesMin:       100,
+			PublishedAfter: after,
+		},
+	})
+
+	assert.Equal(t, bson.M{"$search": `"go web"`}, filter["$text"])
+	assert.Equal(t, bson.A{
+		bson.M{"title": bson.M{"$regex": "go", "$options": "i"}},
+		bson.M{"title": bson.M{"$regex": "web", "$options": "i"}},
+	}, filter["$and"])
+	assert.Equal(t, bson.M{"$in": []string{"English"}}, filter["language"])
+	assert.Equal(t, bson.M{"$gte": 100}, filter["pages"])
+	assert.Equal(t, bson.M{"$gte": after}, filter["publish_date"])
+
+	multi := buildTextFilter(models.BookSearch{Type: models.SearchTypeMulti, Query: "c++ (2nd)"})
+	assert.Equal(t, bson.M{"$search": "c++ (2nd)"}, multi["$text"])
+	assert.NotContains(t, multi, "$and")
+
+	assert.Empty(t, buildTextFilter(models.BookSearch{Type: models.SearchTypeMulti}))
+}
+
+func TestBuildTextSort(t *testing.T) {
+	relevance := models.SortField{Key: models.SortRelevance}
+	assert.Equal(t, bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}},
+		buildTextSort(models.BookSearch{Query: "go", Sort: relevance}))
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}}, buildTextSort(models.BookSearch{Sort: relevance}))
+	assert.Equal(t, bson.D{{Key: "pages", Value: -1}, {Key: "_id", Value: -1}},
+		buildTextSort(models.BookSearch{Query: "go", Sort: models.SortField{Key: models.SortPages, Desc: true}}))
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrJobNotFound is returned when no job matches the given ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobLeaseLost is returned when a worker writes to a job it no longer
+// holds, because its lease ran out and the job was queued again or failed.
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+// ErrJobConflict is returned by Create when a job of an exclusive kind is
+// already queued or running.
+var ErrJobConflict = errors.New("job conflicts with an active job")
+
+// ExclusiveJobKinds run one at a time: at most one job of each is queued or
+// running, which the jobs_exclusive index enforces.
+var ExclusiveJobKinds = []string{models.JobKindReindex, models.JobKindReconcile}
+
+// jobExclusiveIndex is unique on kind over the active jobs of exclusive
+// kinds, so a second one fails to insert even when two submits race.
+// Partial filters with $in need MongoDB 6.0.
+var jobExclusiveIndex = mongo.IndexModel{
+	Keys: bson.D{{Key: "kind", Value: 1}},
+	Options: options.Index().
+		SetName("jobs_exclusive").
+		SetUnique(true).
+		SetPartialFilterExpression(bson.M{
+			"kind":   bson.M{"$in": ExclusiveJobKinds},
+			"status": bson.M{"$in": []string{models.JobStatusQueued, models.JobStatusRunning}},
+		}),
+}
+
+// JobRepository stores jobs in MongoDB. Workers claim a queued job with a
+// lease and must renew it while they run; a job whose lease has run out
+// belongs to a worker that stopped.
+type JobRepository interface {
+	// EnsureIndexes creates the index that keeps exclusive kinds to one
+	// active job. It is a no-op if the index already exists.
+	EnsureIndexes(ctx context.Context) error
+	// Create inserts a job. It returns ErrJobConflict if the job is of an
+	// exclusive kind and another one is queued or running.
+	Create(ctx context.Context, job *models.Job) error
+	Find(ctx context.Context, id string) (*models.Job, error)
+	// List returns jobs newest first
+	List(ctx context.Context, opts models.JobListOptions) ([]models.Job, error)
+	// ClaimNext marks the oldest queued job running for worker until the
+	// lease ends. It returns nil, nil when no job is queued.
+	ClaimNext(ctx context.Context, worker string, lease time.Duration) (*models.Job, error)
+	// Heartbeat renews the lease of a claimed job and records its progress
+	Heartbeat(ctx context.Context, job *models.Job, lease time.Duration) error
+	// Finish records the status, result, error and progress of a claimed
+	// job. Status queued releases it to run again.
+	Finish(ctx context.Context, job *models.Job) error
+	// ListExpired returns running jobs whose lease has run out
+	ListExpired(ctx context.Context) ([]models.Job, error)
+}
+
+type jobRepository struct {
+	collection *mongo.Collection
+}
+
+func NewJobRepository(collection *mongo.Collection) JobRepository {
+	return &jobRepository{collection: collection}
+}
+
+func (r *jobRepository) Create(ctx context.Context, job *models.Job) error {
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+	_, err := r.collection.InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrJobConflict
+	}
+	return err
+}
+
+func (r *jobRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, jobExclusiveIndex)
+	return err
+}
+
+func (r *jobRepository) Find(ctx context.Context, id string) (*models.Job, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.Job
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *jobRepository) List(ctx context.Context, opts models.JobListOptions) ([]models.Job, error) {
+	filter := bson.M{}
+	if opts.Kind != "" {
+		filter["kind"] = opts.Kind
+	}
+	if opts.Status != "" {
+		filter["status"] = opts.Status
+	}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(opts.Limit)
+
+	cursor, err := r.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.Job{}
+	err = cursor.All(ctx, &jobs)
+	return jobs, err
+}
+
+func (r *jobRepository) ClaimNext(ctx context.Context, worker string, lease time.Duration) (*models.Job, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"status":      models.JobStatusRunning,
+			"worker":      worker,
+			"lease_until": now.Add(lease),
+			"started_at":  now,
+			"updated_at":  now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.Job
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"status": models.JobStatusQueued}, update, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// claimed matches job only while the worker that claimed it still holds it
+func claimed(job *models.Job) bson.M {
+	return bson.M{
+		"_id":      job.ID,
+		"status":   models.JobStatusRunning,
+		"worker":   job.Worker,
+		"attempts": job.Attempts,
+	}
+}
+
+func (r *jobRepository) Heartbeat(ctx context.Context, job *models.Job, lease time.Duration) error {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{
+		"progress":    job.Progress,
+		"lease_until": now.Add(lease),
+		"updated_at":  now,
+	}}
+	res, err := r.collection.UpdateOne(ctx, claimed(job), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+func (r *jobRepository) Finish(ctx context.Context, job *models.Job) error {
+	now := time.Now()
+	set := bson.M{
+		"status":     job.Status,
+		"progress":   job.Progress,
+		"updated_at": now,
+	}
+	unset := bson.M{"worker": "", "lease_until": ""}
+	if job.Error != "" {
+		set["error"] = job.Error
+	} else {
+		unset["error"] = ""
+	}
+	if job.Result != nil {
+		set["result"] = job.Result
+	}
+	if job.Finished() {
+		set["finished_at"] = now
+	} else {
+		unset["started_at"] = ""
+	}
+
+	res, err := r.collection.UpdateOne(ctx, claimed(job), bson.M{"$set": set, "$unset": unset})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+func (r *jobRepository) ListExpired(ctx context.Context) ([]models.Job, error) {
+	filter := bson.M{
+		"status":      models.JobStatusRunning,
+		"lease_until": bson.M{"$lt": time.Now()},
+	}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.Job{}
+	err = cursor.All(ctx, &jobs)
+	return jobs, err
+}
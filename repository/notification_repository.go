@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotificationNotFound is returned when the user has no notification with
+// the given ID.
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// webhookQueueIndex serves ClaimWebhook, over the notifications whose
+// webhook is still pending
+var webhookQueueIndex = mongo.IndexModel{
+	Keys: bson.D{{Key: "webhook.next_attempt_at", Value: 1}},
+	Options: options.Index().
+		SetName("notifications_webhook_queue").
+		SetPartialFilterExpression(bson.M{"webhook.pending": true}),
+}
+
+// NotificationRepository is the per-user inbox of saved search notifications
+type NotificationRepository interface {
+	// EnsureIndexes creates the index of the webhook queue. It is a no-op
+	// if it already exists.
+	EnsureIndexes(ctx context.Context) error
+	// Add stores n unless the book was already notified for the saved
+	// search. It reports whether n was added. A pending n.Webhook queues it
+	// for delivery along with the insert.
+	Add(ctx context.Context, n *models.Notification) (bool, error)
+	// ClaimWebhook leases the notification whose pending webhook has been
+	// due longest, so that it is not delivered twice at once. It returns
+	// nil, nil when none is due.
+	ClaimWebhook(ctx context.Context, lease time.Duration) (*models.Notification, error)
+	// SetWebhook records a delivery attempt and releases the lease
+	SetWebhook(ctx context.Context, id primitive.ObjectID, delivery models.WebhookDelivery) error
+	// List returns the user's notifications, newest first
+	List(ctx context.Context, userID string, unreadOnly bool, limit int64) (*models.NotificationList, error)
+	MarkRead(ctx context.Context, userID, id string) (*models.Notification, error)
+}
+
+type notificationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewNotificationRepository(collection *mongo.Collection) NotificationRepository {
+	return &notificationRepository{collection: collection}
+}
+
+func (r *notificationRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, webhookQueueIndex)
+	return err
+}
+
+// Add upserts on the saved search and book, so updates to a book that
+// already matched do not notify again.
+func (r *notificationRepository) Add(ctx context.Context, n *models.Notification) (bool, error) {
+	n.ID = primitive.NewObjectID()
+	n.CreatedAt = time.Now()
+
+	filter := bson.M{"saved_search_id": n.SavedSearchID, "book.id": n.Book.ID}
+	update := bson.M{"$setOnInsert": n}
+	res, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+func (r *notificationRepository) ClaimWebhook(ctx context.Context, lease time.Duration) (*models.Notification, error) {
+	now := time.Now()
+	filter := bson.M{
+		"webhook.pending":         true,
+		"webhook.next_attempt_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"webhook.locked_until": bson.M{"$exists": false}},
+			bson.M{"webhook.locked_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"webhook.locked_until": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "webhook.next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var n models.Notification
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&n)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (r *notificationRepository) SetWebhook(ctx context.Context, id primitive.ObjectID, delivery models.WebhookDelivery) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"webhook": delivery}})
+	return err
+}
+
+func (r *notificationRepository) List(ctx context.Context, userID string, unreadOnly bool, limit int64) (*models.NotificationList, error) {
+	unread := bson.M{"user_id": userID, "read_at": bson.M{"$exists": false}}
+	filter := bson.M{"user_id": userID}
+	if unreadOnly {
+		filter = unread
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	list := &models.NotificationList{Notifications: []models.Notification{}}
+	if err := cursor.All(ctx, &list.Notifications); err != nil {
+		return nil, err
+	}
+	if list.Unread, err = r.collection.CountDocuments(ctx, unread); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// MarkRead sets read_at, keeping the first time if it was already read
+func (r *notificationRepository) MarkRead(ctx context.Context, userID, id string) (*models.Notification, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": objectID, "user_id": userID}
+	update := bson.A{bson.M{"$set": bson.M{"read_at": bson.M{"$ifNull": bson.A{"$read_at", "$$NOW"}}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var n models.Notification
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&n)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
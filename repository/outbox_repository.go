@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrOutboxEntryNotFound is returned when no outbox entry matches the given ID.
+var ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+
+// outboxIndexes serve ClaimNext, which looks for the oldest due pending
+// entry, and ListFailing, which lists failed entries oldest first
+var outboxIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "created_at", Value: 1},
+			{Key: "next_attempt_at", Value: 1},
+		},
+		Options: options.Index().SetName("outbox_claim"),
+	},
+	{
+		Keys: bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().
+			SetName("outbox_failing").
+			SetPartialFilterExpression(bson.M{"attempts": bson.M{"$gt": 0}}),
+	},
+}
+
+type OutboxRepository interface {
+	// EnsureIndexes creates the indexes the relay and the failing entry list
+	// need. It is a no-op if they already exist.
+	EnsureIndexes(ctx context.Context) error
+	// Add inserts a pending entry. Pass a mongo.SessionContext to write it in
+	// the same transaction as the book.
+	Add(ctx context.Context, bookID primitive.ObjectID, operation string) error
+	// AddLeased inserts pending entries for books the caller applies to
+	// Elasticsearch itself, leased to it for lease so that the relay only
+	// delivers those it has not completed by then. Pass a mongo.SessionContext
+	// to write them in the same transaction as the books. The entry IDs are
+	// returned in the order of bookIDs.
+	AddLeased(ctx context.Context, bookIDs []primitive.ObjectID, operation string, lease time.Duration) ([]primitive.ObjectID, error)
+	// ClaimNext leases the oldest due pending entry for the given duration so
+	// that it is not picked up again while it is being delivered.
+	ClaimNext(ctx context.Context, lease time.Duration) (*models.OutboxEntry, error)
+	Complete(ctx context.Context, id primitive.ObjectID) error
+	CompleteMany(ctx context.Context, ids []primitive.ObjectID) error
+	Fail(ctx context.Context, id primitive.ObjectID, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error
+	ListFailing(ctx context.Context, status string, limit int64) ([]models.OutboxEntry, error)
+	Requeue(ctx context.Context, id string) error
+}
+
+type outboxRepository struct {
+	collection *mongo.Collection
+}
+
+func NewOutboxRepository(collection *mongo.Collection) OutboxRepository {
+	return &outboxRepository{collection: collection}
+}
+
+func (r *outboxRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, outboxIndexes)
+	return err
+}
+
+func (r *outboxRepository) Add(ctx context.Context, bookID primitive.ObjectID, operation string) error {
+	now := time.Now()
+	entry := models.OutboxEntry{
+		ID:            primitive.NewObjectID(),
+		BookID:        bookID,
+		Operation:     operation,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	_, err := r.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (r *outboxRepository) AddLeased(ctx context.Context, bookIDs []primitive.ObjectID, operation string, lease time.Duration) ([]primitive.ObjectID, error) {
+	now := time.Now()
+	ids := make([]primitive.ObjectID, len(bookIDs))
+	entries := make([]interface{}, len(bookIDs))
+	for idx, bookID := range bookIDs {
+		ids[idx] = primitive.NewObjectID()
+		entries[idx] = models.OutboxEntry{
+			ID:            ids[idx],
+			BookID:        bookID,
+			Operation:     operation,
+			Status:        models.OutboxStatusPending,
+			NextAttemptAt: now,
+			LockedUntil:   now.Add(lease),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+	}
+	if len(entries) == 0 {
+		return ids, nil
+	}
+	if _, err := r.collection.InsertMany(ctx, entries); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ClaimNext returns nil, nil when no entry is due
+func (r *outboxRepository) ClaimNext(ctx context.Context, lease time.Duration) (*models.OutboxEntry, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status":          models.OutboxStatusPending,
+		"next_attempt_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"locked_until": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var entry models.OutboxEntry
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Complete removes a delivered entry
+func (r *outboxRepository) Complete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// CompleteMany removes delivered entries
+func (r *outboxRepository) CompleteMany(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (r *outboxRepository) Fail(ctx context.Context, id primitive.ObjectID, attempts int, lastError string, nextAttemptAt time.Time, dead bool) error {
+	status := models.OutboxStatusPending
+	if dead {
+		status = models.OutboxStatusDead
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":          status,
+			"attempts":        attempts,
+			"last_error":      lastError,
+			"next_attempt_at": nextAttemptAt,
+			"updated_at":      time.Now(),
+		},
+		"$unset": bson.M{"locked_until": ""},
+	}
+	_, err := r.collection.UpdateByID(ctx, id, update)
+	return err
+}
+
+// ListFailing returns entries that have failed at least once, oldest first.
+// An empty status matches both retrying and dead-lettered entries.
+func (r *outboxRepository) ListFailing(ctx context.Context, status string, limit int64) ([]models.OutboxEntry, error) {
+	filter := bson.M{"attempts": bson.M{"$gt": 0}}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.OutboxEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+// Requeue resets an entry so the relay picks it up again immediately
+func (r *outboxRepository) Requeue(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":          models.OutboxStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"updated_at":      time.Now(),
+		},
+		"$unset": bson.M{"locked_until": "", "last_error": ""},
+	}
+	res, err := r.collection.UpdateByID(ctx, objectID, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrOutboxEntryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ResumeTokenRepository persists change stream resume tokens, keyed by the
+// name of the stream consumer.
+type ResumeTokenRepository interface {
+	Load(ctx context.Context, name string) (bson.Raw, error)
+	Save(ctx context.Context, name string, token bson.Raw) error
+	Clear(ctx context.Context, name string) error
+}
+
+type resumeTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewResumeTokenRepository(collection *mongo.Collection) ResumeTokenRepository {
+	return &resumeTokenRepository{collection: collection}
+}
+
+// Load returns nil, nil when no token has been saved yet
+func (r *resumeTokenRepository) Load(ctx context.Context, name string) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"resume_token"`
+	}
+	err := r.collection.FindOne(ctx, bson.M{"_id": name}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.Token, nil
+}
+
+func (r *resumeTokenRepository) Save(ctx context.Context, name string, token bson.Raw) error {
+	update := bson.M{"$set": bson.M{"resume_token": token, "updated_at": time.Now()}}
+	_, err := r.collection.UpdateByID(ctx, name, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *resumeTokenRepository) Clear(ctx context.Context, name string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": name})
+	return err
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/models"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxPercolateMatches caps the saved searches a single book can match
+const maxPercolateMatches = 10000
+
+// SavedSearchIndex registers saved searches as percolator queries and finds
+// the ones a book matches.
+type SavedSearchIndex interface {
+	// Register stores the query of params, which must already be prepared
+	// by the service, under the saved search's ID, replacing any previous one.
+	Register(ctx context.Context, search *models.SavedSearch, params models.BookSearch) error
+	// Unregister removes a saved search. A missing one is not an error.
+	Unregister(ctx context.Context, id string) error
+	// Percolate returns the IDs of the saved searches the book matches
+	Percolate(ctx context.Context, book *models.Book) ([]primitive.ObjectID, error)
+}
+
+type savedSearchIndex struct {
+	index string
+}
+
+// NewSavedSearchIndex returns a SavedSearchIndex backed by a percolator index
+// created with database.EnsureSavedSearchIndex.
+func NewSavedSearchIndex(index string) SavedSearchIndex {
+	return &savedSearchIndex{index: index}
+}
+
+// savedSearchQuery is the query a saved search is registered with: the
+// query part of the equivalent search.
+func savedSearchQuery(params models.BookSearch) map[string]interface{} {
+	return buildSearchQuery(params)["query"].(map[string]interface{})
+}
+
+func (i *savedSearchIndex) Register(ctx context.Context, search *models.SavedSearch, params models.BookSearch) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":   savedSearchQuery(params),
+		"user_id": search.UserID,
+	})
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IndexRequest{
+		Index:      i.index,
+		DocumentID: search.ID.Hex(),
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error registering saved search: %s", res.String())
+	}
+	return nil
+}
+
+func (i *savedSearchIndex) Unregister(ctx context.Context, id string) error {
+	req := esapi.DeleteRequest{Index: i.index, DocumentID: id}
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error unregistering saved search: %s", res.String())
+	}
+	return nil
+}
+
+// Percolate matches the book as it is indexed, including its language
+// fields, against every registered query.
+func (i *savedSearchIndex) Percolate(ctx context.Context, book *models.Book) ([]primitive.ObjectID, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"size":    maxPercolateMatches,
+		"_source": false,
+		"query": map[string]interface{}{
+			"percolate": map[string]interface{}{
+				"field":    "query",
+				"document": newBookDocument(book),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := database.ESClient.Search(
+		database.ESClient.Search.WithContext(ctx),
+		database.ESClient.Search.WithIndex(i.index),
+		database.ESClient.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error percolating book: %s", res.String())
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		if id, err := primitive.ObjectIDFromHex(hit.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"go-elastic/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavedSearchQuery(t *testing.T) {
+	params := models.BookSearch{
+		Query:   "go",
+		Fields:  []string{"title"},
+		Filters: models.BookFilters{Languages: []string{"English"}},
+	}
+	query := savedSearchQuery(params)
+
+	assert.Equal(t, buildSearchQuery(params)["query"], query)
+	boolQuery := query["bool"].(map[string]interface{})
+	require.Len(t, boolQuery["must"], 1)
+	assert.NotEmpty(t, boolQuery["filter"])
+}
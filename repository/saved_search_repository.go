@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSavedSearchNotFound is returned when the user has no saved search with
+// the given ID.
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+
+// SavedSearchRepository stores saved searches in MongoDB. Reads and writes
+// by ID are scoped to a user, so one user cannot see or change another's.
+type SavedSearchRepository interface {
+	List(ctx context.Context, userID string) ([]models.SavedSearch, error)
+	Find(ctx context.Context, userID, id string) (*models.SavedSearch, error)
+	// FindByIDs returns the saved searches with the given IDs, of any user
+	FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.SavedSearch, error)
+	// Each calls fn for every saved search of every user
+	Each(ctx context.Context, fn func(search *models.SavedSearch) error) error
+	Create(ctx context.Context, search *models.SavedSearch) error
+	Update(ctx context.Context, search *models.SavedSearch) error
+	Delete(ctx context.Context, userID, id string) error
+}
+
+type savedSearchRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSavedSearchRepository(collection *mongo.Collection) SavedSearchRepository {
+	return &savedSearchRepository{collection: collection}
+}
+
+func (r *savedSearchRepository) List(ctx context.Context, userID string) ([]models.SavedSearch, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, byName)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	searches := []models.SavedSearch{}
+	err = cursor.All(ctx, &searches)
+	return searches, err
+}
+
+func (r *savedSearchRepository) Find(ctx context.Context, userID, id string) (*models.SavedSearch, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var search models.SavedSearch
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&search)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSavedSearchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+func (r *savedSearchRepository) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.SavedSearch, error) {
+	searches := []models.SavedSearch{}
+	if len(ids) == 0 {
+		return searches, nil
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &searches)
+	return searches, err
+}
+
+func (r *savedSearchRepository) Each(ctx context.Context, fn func(search *models.SavedSearch) error) error {
+	cursor, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var search models.SavedSearch
+		if err := cursor.Decode(&search); err != nil {
+			return err
+		}
+		if err := fn(&search); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r *savedSearchRepository) Create(ctx context.Context, search *models.SavedSearch) error {
+	search.ID = primitive.NewObjectID()
+	search.CreatedAt = time.Now()
+	search.UpdatedAt = search.CreatedAt
+	_, err := r.collection.InsertOne(ctx, search)
+	return err
+}
+
+// Update replaces everything but the owner and creation time, and decodes
+// the stored search back into search
+func (r *savedSearchRepository) Update(ctx context.Context, search *models.SavedSearch) error {
+	update := bson.M{"$set": bson.M{
+		"name":        search.Name,
+		"query":       search.Query,
+		"type":        search.Type,
+		"match":       search.Match,
+		"filters":     search.Filters,
+		"webhook_url": search.WebhookURL,
+		"updated_at":  time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": search.ID, "user_id": search.UserID}, update, opts).Decode(search)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrSavedSearchNotFound
+	}
+	return err
+}
+
+func (r *savedSearchRepository) Delete(ctx context.Context, userID, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSynonymSetNotFound is returned when no synonym set matches the given ID.
+var ErrSynonymSetNotFound = errors.New("synonym set not found")
+
+// ErrStopwordListNotFound is returned when no stopword list matches the given ID.
+var ErrStopwordListNotFound = errors.New("stopword list not found")
+
+// SearchAnalysisRepository stores the synonym sets and stopword lists that
+// are applied to searches. Changes only take effect in Elasticsearch when
+// they are reloaded.
+type SearchAnalysisRepository interface {
+	ListSynonymSets(ctx context.Context) ([]models.SynonymSet, error)
+	FindSynonymSet(ctx context.Context, id string) (*models.SynonymSet, error)
+	CreateSynonymSet(ctx context.Context, set *models.SynonymSet) error
+	UpdateSynonymSet(ctx context.Context, set *models.SynonymSet) error
+	DeleteSynonymSet(ctx context.Context, id string) error
+
+	ListStopwordLists(ctx context.Context) ([]models.StopwordList, error)
+	FindStopwordList(ctx context.Context, id string) (*models.StopwordList, error)
+	CreateStopwordList(ctx context.Context, list *models.StopwordList) error
+	UpdateStopwordList(ctx context.Context, list *models.StopwordList) error
+	DeleteStopwordList(ctx context.Context, id string) error
+}
+
+type searchAnalysisRepository struct {
+	synonyms  *mongo.Collection
+	stopwords *mongo.Collection
+}
+
+func NewSearchAnalysisRepository(synonyms, stopwords *mongo.Collection) SearchAnalysisRepository {
+	return &searchAnalysisRepository{
+		synonyms:  synonyms,
+		stopwords: stopwords,
+	}
+}
+
+// byName lists documents in name order, so rules and words are applied in a
+// stable order.
+var byName = options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+
+func (r *searchAnalysisRepository) ListSynonymSets(ctx context.Context) ([]models.SynonymSet, error) {
+	cursor, err := r.synonyms.Find(ctx, bson.M{}, byName)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sets := []models.SynonymSet{}
+	err = cursor.All(ctx, &sets)
+	return sets, err
+}
+
+func (r *searchAnalysisRepository) FindSynonymSet(ctx context.Context, id string) (*models.SynonymSet, error) {
+	var set models.SynonymSet
+	if err := findByID(ctx, r.synonyms, id, &set, ErrSynonymSetNotFound); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (r *searchAnalysisRepository) CreateSynonymSet(ctx context.Context, set *models.SynonymSet) error {
+	set.ID = primitive.NewObjectID()
+	set.CreatedAt = time.Now()
+	set.UpdatedAt = set.CreatedAt
+	_, err := r.synonyms.InsertOne(ctx, set)
+	return err
+}
+
+// UpdateSynonymSet replaces the name and rules of a set
+func (r *searchAnalysisRepository) UpdateSynonymSet(ctx context.Context, set *models.SynonymSet) error {
+	update := bson.M{"$set": bson.M{"name": set.Name, "rules": set.Rules, "updated_at": time.Now()}}
+	return updateByID(ctx, r.synonyms, set.ID, update, set, ErrSynonymSetNotFound)
+}
+
+func (r *searchAnalysisRepository) DeleteSynonymSet(ctx context.Context, id string) error {
+	return deleteByID(ctx, r.synonyms, id, ErrSynonymSetNotFound)
+}
+
+func (r *searchAnalysisRepository) ListStopwordLists(ctx context.Context) ([]models.StopwordList, error) {
+	cursor, err := r.stopwords.Find(ctx, bson.M{}, byName)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	lists := []models.StopwordList{}
+	err = cursor.All(ctx, &lists)
+	return lists, err
+}
+
+func (r *searchAnalysisRepository) FindStopwordList(ctx context.Context, id string) (*models.StopwordList, error) {
+	var list models.StopwordList
+	if err := findByID(ctx, r.stopwords, id, &list, ErrStopwordListNotFound); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (r *searchAnalysisRepository) CreateStopwordList(ctx context.Context, list *models.StopwordList) error {
+	list.ID = primitive.NewObjectID()
+	list.CreatedAt = time.Now()
+	list.UpdatedAt = list.CreatedAt
+	_, err := r.stopwords.InsertOne(ctx, list)
+	return err
+}
+
+// UpdateStopwordList replaces the name and words of a list
+func (r *searchAnalysisRepository) UpdateStopwordList(ctx context.Context, list *models.StopwordList) error {
+	update := bson.M{"$set": bson.M{"name": list.Name, "words": list.Words, "updated_at": time.Now()}}
+	return updateByID(ctx, r.stopwords, list.ID, update, list, ErrStopwordListNotFound)
+}
+
+func (r *searchAnalysisRepository) DeleteStopwordList(ctx context.Context, id string) error {
+	return deleteByID(ctx, r.stopwords, id, ErrStopwordListNotFound)
+}
+
+// findByID decodes the document with the given hex ID into out, returning
+// notFound if there is none.
+func findByID(ctx context.Context, collection *mongo.Collection, id string, out interface{}, notFound error) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(out)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return notFound
+	}
+	return err
+}
+
+// updateByID applies update and decodes the updated document into out
+func updateByID(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, update bson.M, out interface{}, notFound error) error {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(out)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return notFound
+	}
+	return err
+}
+
+func deleteByID(ctx context.Context, collection *mongo.Collection, id string, notFound error) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// SearchEventRepository stores search analytics events and aggregates them
+// per query.
+type SearchEventRepository interface {
+	// Insert indexes events with the _bulk API
+	Insert(ctx context.Context, events []models.SearchEvent) error
+	QueryReport(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error)
+}
+
+type searchEventRepository struct {
+	index string
+}
+
+func NewSearchEventRepository() SearchEventRepository {
+	return &searchEventRepository{index: database.SearchEventsIndex}
+}
+
+func (r *searchEventRepository) Insert(ctx context.Context, events []models.SearchEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := enc.Encode(map[string]interface{}{"index": map[string]interface{}{"_index": r.index}}); err != nil {
+			return err
+		}
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	req := esapi.BulkRequest{Body: &body}
+	res, err := req.Do(ctx, database.ESClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error indexing search events: %s", res.String())
+	}
+
+	var response struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return err
+	}
+	if !response.Errors {
+		return nil
+	}
+
+	failed := 0
+	var first string
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				if failed == 0 {
+					first = result.Error.Type + ": " + result.Error.Reason
+				}
+				failed++
+			}
+		}
+	}
+	return fmt.Errorf("%d of %d search events were not indexed, first error: %s", failed, len(events), first)
+}
+
+func (r *searchEventRepository) QueryReport(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	body, err := json.Marshal(buildQueryReport(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := database.ESClient.Search(
+		database.ESClient.Search.WithContext(ctx),
+		database.ESClient.Search.WithIndex(r.index),
+		database.ESClient.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error aggregating search events: %s", res.String())
+	}
+
+	var response queryReportResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.report(opts), nil
+}
+
+// successfulSearches matches search events of searches that did not fail
+var successfulSearches = map[string]interface{}{
+	"bool": map[string]interface{}{
+		"filter":   []interface{}{map[string]interface{}{"term": map[string]interface{}{"event": models.SearchEventSearch}}},
+		"must_not": []interface{}{map[string]interface{}{"exists": map[string]interface{}{"field": "error"}}},
+	},
+}
+
+var zeroHits = map[string]interface{}{"term": map[string]interface{}{"hits": 0}}
+
+var clicks = map[string]interface{}{"term": map[string]interface{}{"event": models.SearchEventClick}}
+
+// buildQueryReport aggregates the events of a window into totals and
+// per-query statistics. Queries are the most searched ones; with
+// ZeroResultsOnly they are the most searched among searches that found
+// nothing, and their statistics only count those searches.
+func buildQueryReport(opts models.QueryReportOptions) map[string]interface{} {
+	selected := map[string]interface{}{"match_all": map[string]interface{}{}}
+	if opts.ZeroResultsOnly {
+		selected = map[string]interface{}{"bool": map[string]interface{}{
+			"filter": []interface{}{successfulSearches, zeroHits},
+		}}
+	}
+
+	terms := map[string]interface{}{
+		"field": "query_normalized",
+		"size":  opts.Size,
+		"order": map[string]interface{}{"searches": "desc"},
+	}
+	// Clicks count towards doc_count too, so this only prunes; the service
+	// applies MinSearches exactly.
+	if opts.MinSearches > 1 {
+		terms["min_doc_count"] = opts.MinSearches
+	}
+
+	return map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{"range": map[string]interface{}{
+			"timestamp": map[string]interface{}{
+				"gte": opts.From.Format(time.RFC3339),
+				"lte": opts.To.Format(time.RFC3339),
+			},
+		}},
+		"aggs": map[string]interface{}{
+			"searches": map[string]interface{}{
+				"filter": successfulSearches,
+				"aggs": map[string]interface{}{
+					"zero_results": map[string]interface{}{"filter": zeroHits},
+				},
+			},
+			"clicks": map[string]interface{}{
+				"filter": clicks,
+				"aggs": map[string]interface{}{
+					"searches": map[string]interface{}{"cardinality": map[string]interface{}{"field": "search_id"}},
+				},
+			},
+			"selected": map[string]interface{}{
+				"filter": selected,
+				"aggs": map[string]interface{}{
+					"queries": map[string]interface{}{
+						"terms": terms,
+						"aggs": map[string]interface{}{
+							"searches": map[string]interface{}{
+								"filter": successfulSearches,
+								"aggs": map[string]interface{}{
+									"zero_results": map[string]interface{}{"filter": zeroHits},
+									"avg_hits":     map[string]interface{}{"avg": map[string]interface{}{"field": "hits"}},
+									"avg_latency":  map[string]interface{}{"avg": map[string]interface{}{"field": "latency_ms"}},
+									"users":        map[string]interface{}{"cardinality": map[string]interface{}{"field": "user_id"}},
+									"last":         map[string]interface{}{"max": map[string]interface{}{"field": "timestamp"}},
+								},
+							},
+							"clicks": map[string]interface{}{
+								"filter": clicks,
+								"aggs": map[string]interface{}{
+									"searches":     map[string]interface{}{"cardinality": map[string]interface{}{"field": "search_id"}},
+									"avg_position": map[string]interface{}{"avg": map[string]interface{}{"field": "position"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+type countAgg struct {
+	DocCount int64 `json:"doc_count"`
+}
+
+type valueAgg struct {
+	Value *float64 `json:"value"`
+}
+
+func (v valueAgg) float() float64 {
+	if v.Value == nil {
+		return 0
+	}
+	return *v.Value
+}
+
+type queryReportResponse struct {
+	Aggregations struct {
+		Searches struct {
+			countAgg
+			ZeroResults countAgg `json:"zero_results"`
+		} `json:"searches"`
+		Clicks struct {
+			Searches valueAgg `json:"searches"`
+		} `json:"clicks"`
+		Selected struct {
+			Queries struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					Searches struct {
+						countAgg
+						ZeroResults countAgg `json:"zero_results"`
+						AvgHits     valueAgg `json:"avg_hits"`
+						AvgLatency  valueAgg `json:"avg_latency"`
+						Users       valueAgg `json:"users"`
+						Last        valueAgg `json:"last"`
+					} `json:"searches"`
+					Clicks struct {
+						countAgg
+						Searches    valueAgg `json:"searches"`
+						AvgPosition valueAgg `json:"avg_position"`
+					} `json:"clicks"`
+				} `json:"buckets"`
+			} `json:"queries"`
+		} `json:"selected"`
+	} `json:"aggregations"`
+}
+
+func (r queryReportResponse) report(opts models.QueryReportOptions) *models.QueryReport {
+	aggs := r.Aggregations
+	report := &models.QueryReport{
+		AnalyticsWindow: opts.AnalyticsWindow,
+		Searches:        aggs.Searches.DocCount,
+		ZeroResults:     aggs.Searches.ZeroResults.DocCount,
+		ClickedSearches: int64(aggs.Clicks.Searches.float()),
+		Queries:         []models.QueryStats{},
+	}
+	report.ClickThroughRate = rate(report.ClickedSearches, report.Searches)
+
+	for _, b := range aggs.Selected.Queries.Buckets {
+		stats := models.QueryStats{
+			Query:            b.Key,
+			Searches:         b.Searches.DocCount,
+			ZeroResults:      b.Searches.ZeroResults.DocCount,
+			AvgHits:          b.Searches.AvgHits.float(),
+			AvgLatencyMS:     b.Searches.AvgLatency.float(),
+			Users:            int64(b.Searches.Users.float()),
+			Clicks:           b.Clicks.DocCount,
+			ClickedSearches:  int64(b.Clicks.Searches.float()),
+			AvgClickPosition: b.Clicks.AvgPosition.float(),
+		}
+		if last := b.Searches.Last.Value; last != nil {
+			stats.LastSearched = time.UnixMilli(int64(*last)).UTC()
+		}
+		stats.ClickThroughRate = rate(stats.ClickedSearches, stats.Searches)
+		report.Queries = append(report.Queries, stats)
+	}
+	return report
+}
+
+// rate is part/whole capped at 1, since a click can outlive its search's
+// window or name a search that was never logged.
+func rate(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return min(float64(part)/float64(whole), 1)
+}
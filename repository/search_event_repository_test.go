@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"encoding/json"
+	"go-elastic/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildQueryReport(t *testing.T) {
+	window := models.AnalyticsWindow{
+		From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+	}
+	query := buildQueryReport(models.QueryReportOptions{AnalyticsWindow: window, Size: 5, MinSearches: 3})
+
+	assert.Equal(t, 0, query["size"])
+	assert.Equal(t, map[string]interface{}{"range": map[string]interface{}{
+		"timestamp": map[string]interface{}{"gte": "2024-01-01T00:00:00Z", "lte": "2024-01-08T00:00:00Z"},
+	}}, query["query"])
+
+	selected := query["aggs"].(map[string]interface{})["selected"].(map[string]interface{})
+	assert.Contains(t, selected["filter"], "match_all")
+	terms := selected["aggs"].(map[string]interface{})["queries"].(map[string]interface{})["terms"].(map[string]interface{})
+	assert.Equal(t, "query_normalized", terms["field"])
+	assert.Equal(t, 5, terms["size"])
+	assert.Equal(t, 3, terms["min_doc_count"])
+	assert.Equal(t, map[string]interface{}{"searches": "desc"}, terms["order"])
+
+	query = buildQueryReport(models.QueryReportOptions{AnalyticsWindow: window, Size: 5, ZeroResultsOnly: true})
+	selected = query["aggs"].(map[string]interface{})["selected"].(map[string]interface{})
+	filters := selected["filter"].(map[string]interface{})["bool"].(map[string]interface{})["filter"]
+	assert.Equal(t, []interface{}{successfulSearches, zeroHits}, filters)
+	terms = selected["aggs"].(map[string]interface{})["queries"].(map[string]interface{})["terms"].(map[string]interface{})
+	assert.NotContains(t, terms, "min_doc_count")
+}
+
+func TestQueryReportResponse_Report(t *testing.T) {
+	body := `{"aggregations": {
+		"searches": {"doc_count": 40, "zero_results": {"doc_count": 6}},
+		"clicks": {"doc_count": 15, "searches": {"value": 10}},
+		"selected": {"doc_count": 55, "queries": {"buckets": [
+			{"key": "golang", "doc_count": 12,
+			 "searches": {"doc_count": 8, "zero_results": {"doc_count": 0}, "avg_hits": {"value": 12.5},
+			              "avg_latency": {"value": 4.25}, "users": {"value": 3}, "last": {"value": 1704067200000}},
+			 "clicks": {"doc_count": 4, "searches": {"value": 2}, "avg_position": {"value": 1.5}}},
+			{"key": "qwerty", "doc_count": 2,
+			 "searches": {"doc_count": 2, "zero_results": {"doc_count": 2}, "avg_hits": {"value": 0},
+			              "avg_latency": {"value": 2}, "users": {"value": 0}, "last": {"value": 1704067200000}},
+			 "clicks": {"doc_count": 0, "searches": {"value": 0}, "avg_position": {"value": null}}}
+		]}}
+	}}`
+	var response queryReportResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &response))
+
+	report := response.report(models.QueryReportOptions{})
+	assert.Equal(t, int64(40), report.Searches)
+	assert.Equal(t, int64(6), report.ZeroResults)
+	assert.Equal(t, int64(10), report.ClickedSearches)
+	assert.InDelta(t, 0.25, report.ClickThroughRate, 1e-9)
+
+	require.Len(t, report.Queries, 2)
+	assert.Equal(t, models.QueryStats{
+		Query:            "golang",
+		Searches:         8,
+		AvgHits:          12.5,
+		AvgLatencyMS:     4.25,
+		Users:            3,
+		LastSearched:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Clicks:           4,
+		ClickedSearches:  2,
+		ClickThroughRate: 0.25,
+		AvgClickPosition: 1.5,
+	}, report.Queries[0])
+	assert.Zero(t, report.Queries[1].AvgClickPosition, "no clicks")
+	assert.Zero(t, report.Queries[1].ClickThroughRate)
+
+	assert.Equal(t, 1.0, rate(5, 4), "clicks on searches outside the window")
+	assert.Zero(t, rate(1, 0))
+}
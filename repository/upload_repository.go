@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// uploadBucket is the GridFS bucket, stored in the uploads.files and
+// uploads.chunks collections
+const uploadBucket = "uploads"
+
+// ErrUploadNotFound is returned when no upload matches the given ID.
+var ErrUploadNotFound = errors.New("upload not found")
+
+// UploadRepository keeps uploaded files, such as import bodies, in MongoDB
+// GridFS until the job that reads them has finished
+type UploadRepository interface {
+	Save(ctx context.Context, name string, r io.Reader) (primitive.ObjectID, error)
+	// Open returns the file and its size in bytes
+	Open(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, int64, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type uploadRepository struct {
+	db *mongo.Database
+}
+
+func NewUploadRepository(db *mongo.Database) UploadRepository {
+	return &uploadRepository{db: db}
+}
+
+// bucket returns a new Bucket, because deadlines are set on the bucket
+// rather than per call
+func (r *uploadRepository) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(r.db, options.GridFSBucket().SetName(uploadBucket))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := bucket.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		if err := bucket.SetWriteDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+func (r *uploadRepository) Save(ctx context.Context, name string, src io.Reader) (primitive.ObjectID, error) {
+	bucket, err := r.bucket(ctx)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return bucket.UploadFromStream(name, src)
+}
+
+func (r *uploadRepository) Open(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, int64, error) {
+	bucket, err := r.bucket(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	stream, err := bucket.OpenDownloadStream(id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, 0, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return stream, stream.GetFile().Length, nil
+}
+
+func (r *uploadRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	bucket, err := r.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	err = bucket.DeleteContext(ctx, id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return ErrUploadNotFound
+	}
+	return err
+}
@@ -7,7 +7,13 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func SetupRoutes(app *fiber.App, logger *logrus.Logger, userHandler *handler.UserHandler, bookHandler *handler.BookHandler) {
+func SetupRoutes(app *fiber.App, logger *logrus.Logger, userHandler *handler.UserHandler, bookHandler *handler.BookHandler, bookImportHandler *handler.BookImportHandler, outboxHandler *handler.OutboxHandler, reconcileHandler *handler.ReconcileHandler, bookIndexHandler *handler.BookIndexHandler, searchAnalysisHandler *handler.SearchAnalysisHandler, searchAnalyticsHandler *handler.SearchAnalyticsHandler, savedSearchHandler *handler.SavedSearchHandler, jobHandler *handler.JobHandler) {
+
+	// Bulk imports stream their body, so they are registered ahead of the
+	// body limit every other route is held to.
+	api := app.Group("/api")
+	api.Post("/books/bulk", bookImportHandler.ImportBooks)
+	app.Use(BodyLimitMiddleware(app.Config().BodyLimit))
 
 	// logger test
 	app.Get("/hello", func(c *fiber.Ctx) error {
@@ -23,7 +29,6 @@ func SetupRoutes(app *fiber.App, logger *logrus.Logger, userHandler *handler.Use
 	app.Get("/v2/hello", handler.HelloHandler)
 
 	// User Routes (Three-tier pattern)
-	api := app.Group("/api")
 	users := api.Group("/users")
 	users.Post("/", userHandler.CreateUser)
 	users.Get("/", userHandler.GetAllUsers)
@@ -34,5 +39,56 @@ func SetupRoutes(app *fiber.App, logger *logrus.Logger, userHandler *handler.Use
 	books.Post("/", bookHandler.CreateBook)
 	books.Get("/", bookHandler.GetAllBooks)
 	books.Get("/search", bookHandler.SearchBooks)
+	books.Post("/search/clicks", searchAnalyticsHandler.RecordClick)
+	books.Get("/suggest", bookHandler.SuggestBooks)
+	books.Get("/export", bookHandler.ExportBooks)
 	books.Get("/:id", bookHandler.GetBook)
+	books.Get("/:id/similar", bookHandler.SimilarBooks)
+	books.Put("/:id", bookHandler.UpdateBook)
+	books.Patch("/:id", bookHandler.PatchBook)
+	books.Delete("/:id", bookHandler.DeleteBook)
+
+	// Saved searches and their notifications belong to the X-User-ID caller
+	savedSearches := api.Group("/saved-searches")
+	savedSearches.Get("/", savedSearchHandler.ListSavedSearches)
+	savedSearches.Post("/", savedSearchHandler.CreateSavedSearch)
+	savedSearches.Get("/:id", savedSearchHandler.GetSavedSearch)
+	savedSearches.Put("/:id", savedSearchHandler.UpdateSavedSearch)
+	savedSearches.Delete("/:id", savedSearchHandler.DeleteSavedSearch)
+	notifications := api.Group("/notifications")
+	notifications.Get("/", savedSearchHandler.ListNotifications)
+	notifications.Post("/:id/read", savedSearchHandler.MarkNotificationRead)
+
+	// Imports, reindexes and repairs return 202 with a job to poll here
+	jobs := api.Group("/jobs")
+	jobs.Get("/", jobHandler.ListJobs)
+	jobs.Get("/:id", jobHandler.GetJob)
+
+	// Admin Routes
+	admin := api.Group("/admin")
+	admin.Get("/outbox", outboxHandler.ListEntries)
+	admin.Post("/outbox/:id/retry", outboxHandler.RetryEntry)
+	admin.Get("/reconcile", reconcileHandler.DriftReport)
+	admin.Post("/reconcile", reconcileHandler.Repair)
+	admin.Get("/indices/books", bookIndexHandler.Status)
+	admin.Post("/indices/books/reindex", bookIndexHandler.Reindex)
+
+	// Search synonyms and stopwords are staged in MongoDB until reloaded
+	search := admin.Group("/search")
+	search.Get("/synonyms", searchAnalysisHandler.ListSynonymSets)
+	search.Post("/synonyms", searchAnalysisHandler.CreateSynonymSet)
+	search.Get("/synonyms/:id", searchAnalysisHandler.GetSynonymSet)
+	search.Put("/synonyms/:id", searchAnalysisHandler.UpdateSynonymSet)
+	search.Delete("/synonyms/:id", searchAnalysisHandler.DeleteSynonymSet)
+	search.Get("/stopwords", searchAnalysisHandler.ListStopwordLists)
+	search.Post("/stopwords", searchAnalysisHandler.CreateStopwordList)
+	search.Get("/stopwords/:id", searchAnalysisHandler.GetStopwordList)
+	search.Put("/stopwords/:id", searchAnalysisHandler.UpdateStopwordList)
+	search.Delete("/stopwords/:id", searchAnalysisHandler.DeleteStopwordList)
+	search.Post("/reload", searchAnalysisHandler.Reload)
+
+	// Search analytics over a from/to window (default: the last 7 days)
+	search.Get("/analytics/top-queries", searchAnalyticsHandler.TopQueries)
+	search.Get("/analytics/zero-results", searchAnalyticsHandler.ZeroResultQueries)
+	search.Get("/analytics/click-through", searchAnalyticsHandler.ClickThrough)
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"go-elastic/handler"
+	"go-elastic/models"
+	"go-elastic/service"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// importSizer records the size of the last import body submitted
+type importSizer struct {
+	service.BookImportService
+	size int
+}
+
+func (s *importSizer) Submit(ctx context.Context, format string, body io.Reader) (*models.Job, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	s.size = len(data)
+	return &models.Job{ID: primitive.NewObjectID(), Kind: models.JobKindImport, Status: models.JobStatusQueued}, nil
+}
+
+// chunked returns a request whose body has no Content-Length
+func chunked(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	return req
+}
+
+func TestSetupRoutes_BodyLimit(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	imports := &importSizer{}
+	app := fiber.New(fiber.Config{BodyLimit: 1024, StreamRequestBody: true})
+	SetupRoutes(app, log, nil, nil, handler.NewBookImportHandler(imports), nil, nil, nil, nil, nil, nil, nil)
+
+	oversized := `{"title": "` + strings.Repeat("a", 2048) + `"}`
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/api/books", strings.NewReader(oversized)),
+		chunked("POST", "/api/books", oversized),
+	} {
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+
+	req := httptest.NewRequest("POST", "/api/books/bulk?format=ndjson", strings.NewReader(strings.Repeat(oversized+"\n", 4)))
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode, "bulk imports are streamed past the limit")
+	assert.Equal(t, 4*(len(oversized)+1), imports.size)
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	app := fiber.New(fiber.Config{BodyLimit: 1024, StreamRequestBody: true})
+	app.Use(BodyLimitMiddleware(16))
+	app.Post("/echo", func(c *fiber.Ctx) error {
+		return c.Send(c.Body())
+	})
+
+	resp, err := app.Test(chunked("POST", "/echo", "within the limit"))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "within the limit", string(body), "a chunked body under the limit is read for the handler")
+
+	resp, err = app.Test(chunked("POST", "/echo", "one byte too long"))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/bookformat"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"io"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const importTracerName = "book-import-service"
+
+// DefaultImportBatchSize is how many books are inserted and indexed at a time
+const DefaultImportBatchSize = 500
+
+// importOutboxLease is how long the outbox entries of an import batch wait
+// for the import to index the batch itself before the relay does
+const importOutboxLease = 5 * time.Minute
+
+// maxImportReportRows caps the rows of an import report, which is stored in
+// its job's document and so has to stay well under 16 MB
+const maxImportReportRows = 1000
+
+// ErrInvalidImport is returned when a file cannot be read as books at all,
+// such as a JSON body that is not an array or a CSV with unknown columns.
+var ErrInvalidImport = errors.New("invalid import")
+
+type BookImportService interface {
+	// Import reads books from reader, validates them like CreateBook and
+	// stores them in batches. The report counts the records read and lists
+	// those that were not created and indexed. Once
+	// a record has been read the import is not undone: a later malformed
+	// record or a failed batch stops it and is recorded in report.Error.
+	Import(ctx context.Context, reader bookformat.Reader) (*models.ImportReport, error)
+	// Submit stores body as an upload and queues an import job for it
+	Submit(ctx context.Context, format string, body io.Reader) (*models.Job, error)
+	// ImportUpload runs an import job, passing the share of the upload read
+	// so far to progress
+	ImportUpload(ctx context.Context, params models.JobParams, progress func(percent float64)) (*models.ImportReport, error)
+	// DeleteUpload removes the upload of a finished import job
+	DeleteUpload(ctx context.Context, params models.JobParams) error
+}
+
+type bookImportService struct {
+	repo      repository.BookRepository
+	index     repository.BookIndex
+	outbox    repository.OutboxRepository
+	uploads   repository.UploadRepository
+	jobs      JobService
+	batchSize int
+}
+
+// NewBookImportService returns a BookImportService. Each batch is queued in
+// the outbox along with its insert, and the entries of the books indexed
+// right after are completed; the relay retries the others, and indexes a
+// batch whose import stopped in between. outbox may be nil when the change
+// stream indexer picks up the inserts instead, and must be the outbox of
+// repo otherwise. uploads and jobs are only needed to import in the
+// background. batchSize 0 means DefaultImportBatchSize.
+func NewBookImportService(repo repository.BookRepository, index repository.BookIndex, outbox repository.OutboxRepository, uploads repository.UploadRepository, jobs JobService, batchSize int) BookImportService {
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+	return &bookImportService{
+		repo:      repo,
+		index:     index,
+		outbox:    outbox,
+		uploads:   uploads,
+		jobs:      jobs,
+		batchSize: batchSize,
+	}
+}
+
+// importBatch is a batch of valid books and their rows in the file
+type importBatch struct {
+	books []*models.Book
+	rows  []int
+}
+
+// addImportRow lists a row in the report, or counts it once the report is
+// full
+func addImportRow(report *models.ImportReport, row models.ImportRow) {
+	if len(report.Rows) == maxImportReportRows {
+		report.RowsOmitted++
+		return
+	}
+	report.Rows = append(report.Rows, row)
+}
+
+func (s *bookImportService) Import(ctx context.Context, reader bookformat.Reader) (*models.ImportReport, error) {
+	tr := otel.Tracer(importTracerName)
+	ctx, span := tr.Start(ctx, "Import")
+	defer span.End()
+
+	report := &models.ImportReport{Rows: []models.ImportRow{}}
+	// Invalid rows are listed as they are read, the others once their batch
+	// is stored.
+	defer func() {
+		sort.SliceStable(report.Rows, func(i, j int) bool { return report.Rows[i].Row < report.Rows[j].Row })
+	}()
+	batch := &importBatch{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if report.Total == 0 {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+			}
+			report.Error = err.Error()
+			break
+		}
+
+		report.Total++
+		if record.Err == nil {
+			record.Err = record.Book.Validate()
+		}
+		if record.Err != nil {
+			report.Invalid++
+			addImportRow(report, models.ImportRow{
+				Row:    record.Row,
+				Status: models.ImportRowInvalid,
+				Error:  record.Err.Error(),
+			})
+			continue
+		}
+
+		book := record.Book
+		prepareImportedBook(book)
+		batch.books = append(batch.books, book)
+		batch.rows = append(batch.rows, record.Row)
+
+		if len(batch.books) == s.batchSize {
+			if err := s.flush(ctx, report, batch); err != nil {
+				report.Error = err.Error()
+				return report, nil
+			}
+			batch = &importBatch{}
+		}
+	}
+
+	if err := s.flush(ctx, report, batch); err != nil {
+		report.Error = err.Error()
+	}
+	return report, nil
+}
+
+func (s *bookImportService) Submit(ctx context.Context, format string, body io.Reader) (*models.Job, error) {
+	tr := otel.Tracer(importTracerName)
+	ctx, span := tr.Start(ctx, "Submit")
+	defer span.End()
+
+	upload, err := s.uploads.Save(ctx, "import."+format, body)
+	if err != nil {
+		return nil, fmt.Errorf("error storing upload: %w", err)
+	}
+	params := models.JobParams{Format: format, Upload: upload}
+	job, err := s.jobs.Submit(ctx, models.JobKindImport, params)
+	if err != nil {
+		if deleteErr := s.uploads.Delete(ctx, upload); deleteErr != nil {
+			span.RecordError(deleteErr)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *bookImportService) ImportUpload(ctx context.Context, params models.JobParams, progress func(percent float64)) (*models.ImportReport, error) {
+	tr := otel.Tracer(importTracerName)
+	ctx, span := tr.Start(ctx, "ImportUpload")
+	defer span.End()
+
+	file, size, err := s.uploads.Open(ctx, params.Upload)
+	if err != nil {
+		return nil, fmt.Errorf("error opening upload: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := bookformat.NewReader(params.Format, &progressReader{r: file, size: size, progress: progress})
+	if err != nil {
+		return nil, err
+	}
+	return s.Import(ctx, reader)
+}
+
+func (s *bookImportService) DeleteUpload(ctx context.Context, params models.JobParams) error {
+	err := s.uploads.Delete(ctx, params.Upload)
+	if errors.Is(err, repository.ErrUploadNotFound) {
+		return nil
+	}
+	return err
+}
+
+// progressReader reports the share of size read so far
+type progressReader struct {
+	r        io.Reader
+	size     int64
+	read     int64
+	progress func(percent float64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.size > 0 {
+		r.progress(100 * float64(r.read) / float64(r.size))
+	}
+	return n, err
+}
+
+// prepareImportedBook fills in what CreateBook would: an ID and timestamps
+func prepareImportedBook(book *models.Book) {
+	if book.ID.IsZero() {
+		book.ID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	if book.CreatedAt.IsZero() {
+		book.CreatedAt = now
+	}
+	if book.UpdatedAt.IsZero() {
+		book.UpdatedAt = now
+	}
+}
+
+// flush inserts a batch into MongoDB and indexes the books that were
+// inserted. It returns an error only if the insert failed as a whole, after
+// marking every row of the batch failed.
+func (s *bookImportService) flush(ctx context.Context, report *models.ImportReport, batch *importBatch) error {
+	if len(batch.books) == 0 {
+		return nil
+	}
+
+	failures, entries, err := s.repo.InsertMany(ctx, batch.books, importOutboxLease)
+	if err != nil {
+		for idx, book := range batch.books {
+			markImportFailed(report, batch.rows[idx], book, err)
+		}
+		return fmt.Errorf("error inserting books: %w", err)
+	}
+
+	inserted := make([]models.Book, 0, len(batch.books))
+	insertedAt := make([]int, 0, len(batch.books))
+	for idx, book := range batch.books {
+		if err, ok := failures[idx]; ok {
+			markImportFailed(report, batch.rows[idx], book, err)
+			continue
+		}
+		report.Created++
+		inserted = append(inserted, *book)
+		insertedAt = append(insertedAt, idx)
+	}
+
+	indexFailures, err := s.index.BulkIndex(ctx, inserted)
+	var delivered []primitive.ObjectID
+	for i, book := range inserted {
+		entry, queued := entries[insertedAt[i]]
+		indexErr := err
+		if indexErr == nil {
+			indexErr = indexFailures[book.ID.Hex()]
+		}
+		if indexErr != nil {
+			addImportRow(report, models.ImportRow{
+				Row:    batch.rows[insertedAt[i]],
+				ID:     book.ID.Hex(),
+				Status: models.ImportRowCreated,
+				Error:  fmt.Sprintf("not indexed: %v", indexErr),
+			})
+			continue
+		}
+		report.Indexed++
+		if queued {
+			delivered = append(delivered, entry)
+		}
+	}
+
+	// Entries left behind only make the relay index these books again.
+	if s.outbox != nil {
+		if err := s.outbox.CompleteMany(ctx, delivered); err != nil {
+			trace.SpanFromContext(ctx).RecordError(err)
+		}
+	}
+	return nil
+}
+
+func markImportFailed(report *models.ImportReport, row int, book *models.Book, err error) {
+	report.Failed++
+	addImportRow(report, models.ImportRow{
+		Row:    row,
+		ID:     book.ID.Hex(),
+		Status: models.ImportRowFailed,
+		Error:  err.Error(),
+	})
+}
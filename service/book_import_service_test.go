@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-elastic/bookformat"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertRecorder records inserted batches and rejects duplicate titles, or
+// every batch once err is set. Each book inserted gets an outbox entry.
+type insertRecorder struct {
+	repository.BookRepository
+	batches [][]*models.Book
+	titles  map[string]bool
+	entries map[primitive.ObjectID]string
+	err     error
+}
+
+func (r *insertRecorder) InsertMany(ctx context.Context, books []*models.Book, lease time.Duration) (map[int]error, map[int]primitive.ObjectID, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	r.batches = append(r.batches, books)
+	failures := make(map[int]error)
+	entries := make(map[int]primitive.ObjectID)
+	for idx, book := range books {
+		if r.titles[book.Title] {
+			failures[idx] = errors.New("duplicate key")
+			continue
+		}
+		r.titles[book.Title] = true
+		entries[idx] = primitive.NewObjectID()
+		r.entries[entries[idx]] = book.Title
+	}
+	return failures, entries, nil
+}
+
+// bulkRecorder indexes every book except those titled "Unindexable"
+type bulkRecorder struct {
+	repository.BookIndex
+	indexed []string
+}
+
+func (i *bulkRecorder) BulkIndex(ctx context.Context, books []models.Book) (map[string]error, error) {
+	failures := make(map[string]error)
+	for _, book := range books {
+		if book.Title == "Unindexable" {
+			failures[book.ID.Hex()] = errors.New("mapper_parsing_exception")
+			continue
+		}
+		i.indexed = append(i.indexed, book.Title)
+	}
+	return failures, nil
+}
+
+// outboxRecorder records the entries completed
+type outboxRecorder struct {
+	repository.OutboxRepository
+	completed []primitive.ObjectID
+}
+
+func (o *outboxRecorder) CompleteMany(ctx context.Context, ids []primitive.ObjectID) error {
+	o.completed = append(o.completed, ids...)
+	return nil
+}
+
+func importNDJSON(t *testing.T, svc BookImportService, input string) *models.ImportReport {
+	t.Helper()
+	reader, err := bookformat.NewReader(bookformat.NDJSON, strings.NewReader(input))
+	require.NoError(t, err)
+	report, err := svc.Import(context.Background(), reader)
+	require.NoError(t, err)
+	return report
+}
+
+func TestImport(t *testing.T) {
+	repo := &insertRecorder{titles: map[string]bool{}, entries: map[primitive.ObjectID]string{}}
+	index := &bulkRecorder{}
+	outbox := &outboxRecorder{}
+	svc := NewBookImportService(repo, index, outbox, nil, nil, 2)
+
+	report := importNDJSON(t, svc, strings.Join([]string{
+		`{"title": "Dune", "author": "Frank Herbert"}`,
+		`{"title": "No author"}`,
+		`{"title": "Emma", "author": "Jane Austen"}`,
+		`{"title": "Dune", "author": "Frank Herbert"}`,
+		`{"title": "Unindexable", "author": "Nobody"}`,
+		`{"title": 42}`,
+	}, "\n"))
+
+	assert.Equal(t, 6, report.Total)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 2, report.Indexed)
+	assert.Equal(t, 2, report.Invalid)
+	assert.Equal(t, 1, report.Failed)
+	assert.Empty(t, report.Error)
+
+	require.Len(t, report.Rows, 4, "only rows not created and indexed are listed")
+	rows := make([]int, len(report.Rows))
+	statuses := make([]string, len(report.Rows))
+	for i, row := range report.Rows {
+		rows[i] = row.Row
+		statuses[i] = row.Status
+	}
+	assert.Equal(t, []int{2, 4, 5, 6}, rows)
+	assert.Equal(t, []string{"invalid", "failed", "created", "invalid"}, statuses)
+	assert.Equal(t, models.ErrBookRequiredFields.Error(), report.Rows[0].Error)
+	assert.Equal(t, "duplicate key", report.Rows[1].Error)
+	assert.False(t, report.Rows[2].Indexed)
+	assert.Equal(t, "not indexed: mapper_parsing_exception", report.Rows[2].Error)
+
+	require.Len(t, repo.batches, 2, "valid books are inserted two at a time")
+	first := repo.batches[0][0]
+	assert.False(t, first.ID.IsZero())
+	assert.False(t, first.CreatedAt.IsZero())
+	assert.Equal(t, repo.batches[1][1].ID.Hex(), report.Rows[2].ID)
+	assert.Equal(t, []string{"Dune", "Emma"}, index.indexed)
+	require.Len(t, repo.entries, 3, "every book is queued along with its insert")
+	var completed []string
+	for _, id := range outbox.completed {
+		completed = append(completed, repo.entries[id])
+	}
+	assert.Equal(t, []string{"Dune", "Emma"}, completed, "the book Elasticsearch rejected is left for the relay to retry")
+}
+
+func TestImport_InsertFails(t *testing.T) {
+	repo := &insertRecorder{err: errors.New("mongo down")}
+	svc := NewBookImportService(repo, &bulkRecorder{}, nil, nil, nil, 1)
+
+	report := importNDJSON(t, svc, "{\"title\": \"Dune\", \"author\": \"Frank Herbert\"}\n{\"title\": \"Emma\", \"author\": \"Jane Austen\"}")
+	assert.Equal(t, "error inserting books: mongo down", report.Error)
+	assert.Equal(t, 1, report.Total, "the import stops at the failed batch")
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, models.ImportRowFailed, report.Rows[0].Status)
+}
+
+func TestImport_ReportRowsCapped(t *testing.T) {
+	svc := NewBookImportService(&insertRecorder{titles: map[string]bool{}, entries: map[primitive.ObjectID]string{}}, &bulkRecorder{}, nil, nil, nil, 0)
+
+	report := importNDJSON(t, svc, strings.Repeat("{\"title\": \"No author\"}\n", maxImportReportRows+5))
+	assert.Equal(t, maxImportReportRows+5, report.Invalid)
+	assert.Len(t, report.Rows, maxImportReportRows)
+	assert.Equal(t, 5, report.RowsOmitted)
+}
+
+func TestImport_Unreadable(t *testing.T) {
+	svc := NewBookImportService(&insertRecorder{titles: map[string]bool{}, entries: map[primitive.ObjectID]string{}}, &bulkRecorder{}, nil, nil, nil, 0)
+
+	reader, err := bookformat.NewReader(bookformat.JSON, strings.NewReader(`{"title": "Dune"}`))
+	require.NoError(t, err)
+	_, err = svc.Import(context.Background(), reader)
+	assert.ErrorIs(t, err, ErrInvalidImport)
+
+	reader, err = bookformat.NewReader(bookformat.JSON, strings.NewReader(`[{"title": "Dune", "author": "Frank Herbert"}, {`))
+	require.NoError(t, err)
+	report, err := svc.Import(context.Background(), reader)
+	require.NoError(t, err, "a file that breaks after the first record is imported up to there")
+	assert.Equal(t, 1, report.Created)
+	assert.NotEmpty(t, report.Error)
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/embedding"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+const bookIndexTracerName = "book-index-service"
+
+// reindexBatchSize is the number of books sent per _bulk request
+const reindexBatchSize = 500
+
+// maxReindexErrors caps the per-book errors listed in a reindex result
+const maxReindexErrors = 100
+
+// DefaultKeepBookIndices is how many earlier book indices a reindex keeps
+// for rollback
+const DefaultKeepBookIndices = 1
+
+// ErrReindexInProgress is returned when a reindex is already running
+var ErrReindexInProgress = errors.New("reindex already in progress")
+
+// ErrIndexVersionLive is returned when reindexing to the version already
+// behind the aliases.
+var ErrIndexVersionLive = errors.New("mapping version is already live")
+
+type BookIndexService interface {
+	Status(ctx context.Context) (*models.BookIndexStatus, error)
+	// Reindex builds a new physical index for the mapping version from
+	// MongoDB and swaps the aliases to it. Version 0 means the latest.
+	// progress, which may be nil, is passed the share of the work done.
+	Reindex(ctx context.Context, version int, progress func(percent float64)) (*models.ReindexResult, error)
+	// CheckReindex returns the error Reindex would fail with before it
+	// starts, such as for an unknown version or the live one.
+	CheckReindex(ctx context.Context, version int) error
+	// Rebuild is Reindex for the live mapping version, into a new
+	// generation of the live index, to apply settings such as stopwords
+	// that cannot change on an index in use.
+	Rebuild(ctx context.Context, progress func(percent float64)) (*models.ReindexResult, error)
+}
+
+type bookIndexService struct {
+	books     repository.BookRepository
+	reconcile ReconcileService
+	analysis  SearchAnalysisService
+	embedder  embedding.Embedder
+	keep      int
+	mu        sync.Mutex
+}
+
+// NewBookIndexService returns a BookIndexService. A new index gets the stored
+// synonyms and stopwords from analysis before it is filled, and its documents
+// are embedded with embedder, which may be nil. Once the aliases have moved,
+// all but the keep most recent earlier indices are deleted.
+func NewBookIndexService(books repository.BookRepository, reconcile ReconcileService, analysis SearchAnalysisService, embedder embedding.Embedder, keep int) BookIndexService {
+	return &bookIndexService{
+		books:     books,
+		reconcile: reconcile,
+		analysis:  analysis,
+		embedder:  embedder,
+		keep:      keep,
+	}
+}
+
+// LoadKeepBookIndices reads BOOK_INDEX_KEEP, the number of earlier book
+// indices a reindex keeps; 0 deletes them all.
+func LoadKeepBookIndices() int {
+	if n, err := strconv.Atoi(os.Getenv("BOOK_INDEX_KEEP")); err == nil && n >= 0 {
+		return n
+	}
+	return DefaultKeepBookIndices
+}
+
+func (s *bookIndexService) Status(ctx context.Context) (*models.BookIndexStatus, error) {
+	tr := otel.Tracer(bookIndexTracerName)
+	_, span := tr.Start(ctx, "Status")
+	defer span.End()
+
+	live, err := database.CurrentBookIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &models.BookIndexStatus{
+		LiveIndex:     live,
+		LiveVersion:   database.BookIndexVersion(live),
+		LatestVersion: database.LatestBookMappingVersion(),
+		Versions:      database.BookMappingVersions(),
+		Changes:       []models.MappingChange{},
+	}
+	if live == "" {
+		return status, nil
+	}
+
+	want, err := database.FileMapping(status.LatestVersion)
+	if err != nil {
+		return nil, err
+	}
+	have, err := database.LiveMapping(live)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Changes = database.DiffMappings(want, have)
+	status.UpToDate = status.LiveVersion == status.LatestVersion && len(status.Changes) == 0
+	return status, nil
+}
+
+// Reindex writes go to the old index until the alias swap, so a reconcile
+// pass runs afterwards to pick up books written, changed or deleted while the
+// new index was being built.
+func (s *bookIndexService) Reindex(ctx context.Context, version int, progress func(percent float64)) (*models.ReindexResult, error) {
+	tr := otel.Tracer(bookIndexTracerName)
+	ctx, span := tr.Start(ctx, "Reindex")
+	defer span.End()
+
+	if !s.mu.TryLock() {
+		return nil, ErrReindexInProgress
+	}
+	defer s.mu.Unlock()
+
+	version, from, to, err := reindexTarget(version)
+	if err != nil {
+		return nil, err
+	}
+	return s.reindex(ctx, version, from, to, progress)
+}
+
+func (s *bookIndexService) Rebuild(ctx context.Context, progress func(percent float64)) (*models.ReindexResult, error) {
+	tr := otel.Tracer(bookIndexTracerName)
+	ctx, span := tr.Start(ctx, "Rebuild")
+	defer span.End()
+
+	if !s.mu.TryLock() {
+		return nil, ErrReindexInProgress
+	}
+	defer s.mu.Unlock()
+
+	from, err := database.CurrentBookIndex()
+	if err != nil {
+		return nil, err
+	}
+	version := database.BookIndexVersion(from)
+	if version == 0 {
+		return nil, fmt.Errorf("%q has no mapping version to rebuild, reindex it instead", from)
+	}
+	return s.reindex(ctx, version, from, database.NextBookIndexName(version, from), progress)
+}
+
+// reindex builds to with the mapping version and swaps the aliases to it
+// from the live index from. Building is the first 90% of progress, the
+// catch-up the rest.
+func (s *bookIndexService) reindex(ctx context.Context, version int, from, to string, progress func(percent float64)) (*models.ReindexResult, error) {
+	result := &models.ReindexResult{
+		StartedAt: time.Now(),
+		FromIndex: from,
+		ToIndex:   to,
+		Version:   version,
+	}
+
+	// A leftover from an earlier failed run is not live, so it is safe to drop.
+	if err := database.DeleteIndex(to); err != nil {
+		return nil, err
+	}
+	if err := database.CreateBookIndex(to, version, false); err != nil {
+		return nil, err
+	}
+	// Mapping versions before 7 have no synonym and stopword filters.
+	if _, err := s.analysis.Reload(ctx, to); err != nil && !errors.Is(err, ErrSearchAnalysisUnsupported) {
+		return nil, fmt.Errorf("error applying synonyms and stopwords to %s: %w", to, err)
+	}
+
+	total, err := s.books.Count(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error counting books: %w", err)
+	}
+	built := stepProgress(progress, 0, 90, total)
+	if err := s.build(ctx, repository.NewBookIndexFor(to, s.embedder), result, built); err != nil {
+		return nil, fmt.Errorf("error building %s: %w", to, err)
+	}
+	if err := database.RefreshIndex(to); err != nil {
+		return nil, err
+	}
+	if err := database.SwapBookAliases(from, to); err != nil {
+		return nil, err
+	}
+	// A failed cleanup leaves indices behind but the swap stands.
+	result.DeletedIndices, err = database.DeleteOldBookIndices(to, s.keep)
+	if err != nil {
+		result.CleanupError = err.Error()
+	}
+
+	var caughtUp func(percent float64)
+	if progress != nil {
+		caughtUp = func(percent float64) { progress(90 + percent/10) }
+	}
+	catchUp, err := s.reconcile.Reconcile(ctx, true, caughtUp)
+	if err != nil {
+		return nil, fmt.Errorf("aliases swapped to %s but catch-up failed: %w", to, err)
+	}
+	result.CatchUp = catchUp
+	result.FinishedAt = time.Now()
+	return result, nil
+}
+
+func (s *bookIndexService) CheckReindex(ctx context.Context, version int) error {
+	tr := otel.Tracer(bookIndexTracerName)
+	_, span := tr.Start(ctx, "CheckReindex")
+	defer span.End()
+
+	_, _, _, err := reindexTarget(version)
+	return err
+}
+
+// reindexTarget resolves version 0 to the latest and returns the live index
+// and the one to build
+func reindexTarget(version int) (int, string, string, error) {
+	if version == 0 {
+		version = database.LatestBookMappingVersion()
+	}
+	if _, err := database.BookMapping(version); err != nil {
+		return 0, "", "", err
+	}
+
+	from, err := database.CurrentBookIndex()
+	if err != nil {
+		return 0, "", "", err
+	}
+	if database.BookIndexVersion(from) == version {
+		return 0, "", "", fmt.Errorf("%w: %s", ErrIndexVersionLive, from)
+	}
+	return version, from, database.BookIndexName(version), nil
+}
+
+// build indexes every book, passing the number sent so far to built
+func (s *bookIndexService) build(ctx context.Context, index repository.BookIndex, result *models.ReindexResult, built func(done int)) error {
+	batch := make([]models.Book, 0, reindexBatchSize)
+	flush := func() error {
+		failures, err := index.BulkIndex(ctx, batch)
+		if err != nil {
+			return err
+		}
+		result.Indexed += len(batch) - len(failures)
+		result.Failed += len(failures)
+		for id, ferr := range failures {
+			if len(result.Errors) < maxReindexErrors {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", id, ferr))
+			}
+		}
+		built(result.Indexed + result.Failed)
+		batch = batch[:0]
+		return nil
+	}
+
+	err := s.books.Each(ctx, func(book *models.Book) error {
+		batch = append(batch, *book)
+		if len(batch) == reindexBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -2,8 +2,15 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"go-elastic/embedding"
 	"go-elastic/models"
+	"go-elastic/querylang"
 	"go-elastic/repository"
+	"math"
+	"sort"
+	"strings"
 
 	"go.opentelemetry.io/otel"
 )
@@ -13,17 +20,77 @@ const bookTracerName = "book-service"
 type BookService interface {
 	CreateBook(ctx context.Context, book *models.Book) error
 	GetBookByID(ctx context.Context, id string) (*models.Book, error)
-	GetAllBooks(ctx context.Context) ([]models.Book, error)
-	SearchBooks(ctx context.Context, searchType string, query string) ([]models.Book, error)
+	UpdateBook(ctx context.Context, book *models.Book) error
+	PatchBook(ctx context.Context, id string, patch *models.BookPatch) (*models.Book, error)
+	DeleteBook(ctx context.Context, id string) error
+	GetAllBooks(ctx context.Context, opts models.BookListOptions) (*models.BookList, error)
+	SearchBooks(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error)
+	// SimilarBooks finds books like the one with the given ID, filtered and
+	// paged as in params. Query and Type are ignored.
+	SimilarBooks(ctx context.Context, id string, params models.BookSearch) (*models.BookSearchResult, error)
+	// SuggestBooks returns title and author completions for a partially
+	// typed query. size 0 means the default.
+	SuggestBooks(ctx context.Context, prefix string, size int) (*models.BookSuggestions, error)
+	// ExportBooks checks an export of the books matching a keyword search
+	// and returns it, ready to run once the response has started. Page,
+	// PerPage, Cursor and Mode are ignored.
+	ExportBooks(ctx context.Context, params models.BookSearch) (BookExport, error)
 }
 
+// BookExport streams the books of an export to fn, stopping at the first
+// error fn returns
+type BookExport func(ctx context.Context, fn func(book *models.Book) error) error
+
+// ErrInvalidSearch is returned for search requests with unknown or
+// inconsistent parameters.
+var ErrInvalidSearch = errors.New("invalid search")
+
+// ErrInvalidListOptions is returned for book listings with an invalid page,
+// page size or sort.
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// Page sizes for listing and search
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// maxPage keeps the offset of a page, (page-1)*per_page, in range of an
+// int32 at any page size
+const maxPage = math.MaxInt32 / maxPerPage
+
+// Suggestion counts per kind (titles, authors)
+const (
+	defaultSuggestSize = 5
+	maxSuggestSize     = 20
+)
+
+// MaxResultWindow matches the Elasticsearch index.max_result_window default.
+// Search results past it can only be reached with a cursor.
+const MaxResultWindow = 10000
+
+// MaxSemanticWindow is how deep semantic and hybrid results can be paged.
+// kNN has no cursor, and every page re-ranks all results before it.
+const MaxSemanticWindow = 1000
+
+// rrfRankConstant is the k of reciprocal rank fusion, score = Σ 1/(k+rank).
+// 60 is the value from the original paper; larger values flatten the
+// difference between top and lower ranks.
+const rrfRankConstant = 60
+
+var listSortKeys = []string{models.SortTitle, models.SortPublishDate, models.SortPages, models.SortCreatedAt}
+
+var searchSortKeys = append([]string{models.SortRelevance}, listSortKeys...)
+
 type bookService struct {
-	repo repository.BookRepository
+	repo   repository.BookRepository
+	search SearchConfig
 }
 
-func NewBookService(repo repository.BookRepository) BookService {
+func NewBookService(repo repository.BookRepository, search SearchConfig) BookService {
 	return &bookService{
-		repo: repo,
+		repo:   repo,
+		search: search,
 	}
 }
 
@@ -43,25 +110,417 @@ func (s *bookService) GetBookByID(ctx context.Context, id string) (*models.Book,
 	return s.repo.FindByID(ctx, id)
 }
 
-func (s *bookService) GetAllBooks(ctx context.Context) ([]models.Book, error) {
+func (s *bookService) UpdateBook(ctx context.Context, book *models.Book) error {
+	tr := otel.Tracer(bookTracerName)
+	ctx, span := tr.Start(ctx, "UpdateBook")
+	defer span.End()
+
+	return s.repo.Update(ctx, book)
+}
+
+func (s *bookService) PatchBook(ctx context.Context, id string, patch *models.BookPatch) (*models.Book, error) {
+	tr := otel.Tracer(bookTracerName)
+	ctx, span := tr.Start(ctx, "PatchBook")
+	defer span.End()
+
+	return s.repo.Patch(ctx, id, patch)
+}
+
+func (s *bookService) DeleteBook(ctx context.Context, id string) error {
+	tr := otel.Tracer(bookTracerName)
+	ctx, span := tr.Start(ctx, "DeleteBook")
+	defer span.End()
+
+	return s.repo.Delete(ctx, id)
+}
+
+func (s *bookService) GetAllBooks(ctx context.Context, opts models.BookListOptions) (*models.BookList, error) {
 	tr := otel.Tracer(bookTracerName)
 	ctx, span := tr.Start(ctx, "GetAllBooks")
 	defer span.End()
 
-	return s.repo.FindAll(ctx)
+	if opts.Sort.Key == "" {
+		opts.Sort.Key = models.SortCreatedAt
+	}
+	if err := preparePage(&opts.Page, &opts.PerPage, opts.Sort, listSortKeys); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidListOptions, err)
+	}
+	return s.repo.List(ctx, opts)
 }
 
-func (s *bookService) SearchBooks(ctx context.Context, searchType string, query string) ([]models.Book, error) {
+func (s *bookService) SearchBooks(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
 	tr := otel.Tracer(bookTracerName)
 	ctx, span := tr.Start(ctx, "SearchBooks")
 	defer span.End()
 
-	switch searchType {
-	case "title":
-		return s.repo.SearchByTitle(ctx, query)
-	case "author":
-		return s.repo.SearchByAuthor(ctx, query)
+	if err := s.prepareSearch(&params); err != nil {
+		return nil, err
+	}
+	if s.search.Health != nil && !s.search.Health.Healthy() {
+		return s.fallbackSearch(ctx, params)
+	}
+
+	var result *models.BookSearchResult
+	var err error
+	switch params.Mode {
+	case models.SearchModeSemantic:
+		if params.Vector, err = s.embedQuery(ctx, params.Query); err != nil {
+			return nil, err
+		}
+		result, err = s.runSearch(ctx, params)
+	case models.SearchModeHybrid:
+		result, err = s.hybridSearch(ctx, params)
+	default:
+		result, err = s.runSearch(ctx, params)
+	}
+	if errors.Is(err, repository.ErrSearchUnavailable) && s.search.Health != nil {
+		span.RecordError(err)
+		return s.fallbackSearch(ctx, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Corrections are a hint on top of the results, so a failure to compute
+	// them does not fail the search.
+	if params.Query != "" && params.Expression == nil && params.Cursor == "" && params.Mode != models.SearchModeSemantic &&
+		result.Total < int64(s.search.DidYouMeanBelow) {
+		suggestions, err := s.repo.DidYouMean(ctx, params.Query)
+		if err != nil {
+			span.RecordError(err)
+		}
+		result.DidYouMean = suggestions
+	}
+	return result, nil
+}
+
+func (s *bookService) SimilarBooks(ctx context.Context, id string, params models.BookSearch) (*models.BookSearchResult, error) {
+	tr := otel.Tracer(bookTracerName)
+	ctx, span := tr.Start(ctx, "SimilarBooks")
+	defer span.End()
+
+	book, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Type = models.SearchTypeMulti
+	params.Mode = models.SearchModeKeyword
+	params.Query = ""
+	params.Match = models.MatchBestFields
+	params.Like = book
+	if err := s.prepareSearch(&params); err != nil {
+		return nil, err
+	}
+	return s.runSearch(ctx, params)
+}
+
+// hybridSearch runs the keyword and the semantic search for every result up
+// to the requested page and merges the two rankings with reciprocal rank
+// fusion. Elasticsearch's own RRF needs a paid licence, and fusing here also
+// works with any Embedder. Facets and highlights come from the keyword
+// search; the total is the keyword total plus the books only the semantic
+// search found.
+func (s *bookService) hybridSearch(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	vector, err := s.embedQuery(ctx, params.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	keyword := params
+	keyword.Page = 1
+	keyword.PerPage = params.Page * params.PerPage
+	keywordResult, err := s.runSearch(ctx, keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	semantic := keyword
+	semantic.Vector = vector
+	semantic.Highlight = false
+	semanticResult, err := s.runSearch(ctx, semantic)
+	if err != nil {
+		return nil, err
+	}
+
+	fused := fuseRankings(keywordResult.Hits, semanticResult.Hits)
+	semanticOnly := len(fused) - len(keywordResult.Hits)
+
+	start := min((params.Page-1)*params.PerPage, len(fused))
+	end := min(start+params.PerPage, len(fused))
+	return &models.BookSearchResult{
+		Hits:    fused[start:end],
+		Total:   keywordResult.Total + int64(semanticOnly),
+		Page:    params.Page,
+		PerPage: params.PerPage,
+		Facets:  keywordResult.Facets,
+	}, nil
+}
+
+// fallbackSearch answers a keyword search from MongoDB while Elasticsearch
+// is unavailable. Searches only Elasticsearch can run fail with
+// repository.ErrSearchUnavailable.
+func (s *bookService) fallbackSearch(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	switch {
+	case params.Mode != models.SearchModeKeyword:
+		return nil, fmt.Errorf("%w: mode=%s needs Elasticsearch", repository.ErrSearchUnavailable, params.Mode)
+	case params.Expression != nil:
+		return nil, fmt.Errorf("%w: type=query needs Elasticsearch", repository.ErrSearchUnavailable)
+	case params.Cursor != "":
+		return nil, fmt.Errorf("%w: cursor paging needs Elasticsearch", repository.ErrSearchUnavailable)
+	}
+
+	result, err := s.repo.TextSearch(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	result.Degraded = true
+	return result, nil
+}
+
+// fuseRankings merges ranked hit lists by reciprocal rank fusion. A book's
+// score is the sum of 1/(rrfRankConstant+rank) over the lists it appears in,
+// so books ranked well by both searches come first. Ties keep the order in
+// which books were first seen.
+func fuseRankings(rankings ...[]models.BookHit) []models.BookHit {
+	var fused []models.BookHit
+	scores := []float64{}
+	position := make(map[string]int)
+	for _, hits := range rankings {
+		for rank, hit := range hits {
+			id := hit.Book.ID.Hex()
+			i, ok := position[id]
+			if !ok {
+				i = len(fused)
+				position[id] = i
+				fused = append(fused, hit)
+				scores = append(scores, 0)
+			}
+			scores[i] += 1 / float64(rrfRankConstant+rank+1)
+			if fused[i].Highlights == nil {
+				fused[i].Highlights = hit.Highlights
+			}
+		}
+	}
+
+	for i := range fused {
+		score := scores[i]
+		fused[i].Score = &score
+	}
+	sort.SliceStable(fused, func(a, b int) bool { return *fused[a].Score > *fused[b].Score })
+	if fused == nil {
+		fused = []models.BookHit{}
+	}
+	return fused
+}
+
+// embedQuery returns the embedding of a search query
+func (s *bookService) embedQuery(ctx context.Context, query string) ([]float32, error) {
+	vectors, err := s.search.Embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+	if len(vectors) != 1 || embedding.IsZero(vectors[0]) {
+		return nil, fmt.Errorf("%w: q has no words to compare by meaning", ErrInvalidSearch)
+	}
+	return vectors[0], nil
+}
+
+// runSearch runs a prepared search, reporting a bad cursor as an invalid
+// search.
+func (s *bookService) runSearch(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	result, err := s.repo.Search(ctx, params)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	return result, err
+}
+
+func (s *bookService) SuggestBooks(ctx context.Context, prefix string, size int) (*models.BookSuggestions, error) {
+	tr := otel.Tracer(bookTracerName)
+	ctx, span := tr.Start(ctx, "SuggestBooks")
+	defer span.End()
+
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: q is required", ErrInvalidSearch)
+	}
+	if size == 0 {
+		size = defaultSuggestSize
+	}
+	if size < 1 || size > maxSuggestSize {
+		return nil, fmt.Errorf("%w: size must be between 1 and %d", ErrInvalidSearch, maxSuggestSize)
+	}
+	return s.repo.Suggest(ctx, prefix, size)
+}
+
+// ExportBooks reads every book from MongoDB when params has no query or
+// filters. Otherwise the search runs on Elasticsearch, in the search's sort
+// order, and is refused up front while Elasticsearch is unavailable; there
+// is no fallback, as MongoDB text search cannot page through all results.
+func (s *bookService) ExportBooks(ctx context.Context, params models.BookSearch) (BookExport, error) {
+	if strings.TrimSpace(params.Query) == "" && params.Filters.IsEmpty() {
+		return func(ctx context.Context, fn func(book *models.Book) error) error {
+			tr := otel.Tracer(bookTracerName)
+			ctx, span := tr.Start(ctx, "ExportBooks")
+			defer span.End()
+			return s.repo.Each(ctx, fn)
+		}, nil
+	}
+
+	params.Mode = models.SearchModeKeyword
+	params.Page, params.PerPage, params.Cursor = 0, 0, ""
+	if err := s.prepareSearch(&params); err != nil {
+		return nil, err
+	}
+	if s.search.Health != nil && !s.search.Health.Healthy() {
+		return nil, fmt.Errorf("%w: exporting search results needs Elasticsearch", repository.ErrSearchUnavailable)
+	}
+	return func(ctx context.Context, fn func(book *models.Book) error) error {
+		tr := otel.Tracer(bookTracerName)
+		ctx, span := tr.Start(ctx, "ExportBooks")
+		defer span.End()
+		return s.repo.SearchEach(ctx, params, fn)
+	}, nil
+}
+
+// prepareSearch validates the request and fills in the fields to query and
+// any configured defaults. A query wrapped in double quotes is searched as a
+// phrase.
+func (s *bookService) prepareSearch(params *models.BookSearch) error {
+	switch params.Type {
+	case models.SearchTypeTitle:
+		params.Fields = []string{"title"}
+	case models.SearchTypeAuthor:
+		params.Fields = []string{"author"}
+	case models.SearchTypeMulti, models.SearchTypeQuery:
+		params.Fields = s.search.Fields
+	default:
+		return fmt.Errorf("%w: unknown type %q, expected title, author, multi or query", ErrInvalidSearch, params.Type)
+	}
+
+	switch params.Match {
+	case models.MatchBestFields, models.MatchPhrase, models.MatchPrefix:
 	default:
-		return s.repo.FindAll(ctx)
+		return fmt.Errorf("%w: unknown match %q, expected phrase or prefix", ErrInvalidSearch, params.Match)
+	}
+
+	if err := validateFilters(params.Filters); err != nil {
+		return err
+	}
+
+	query := strings.TrimSpace(params.Query)
+	if params.Type == models.SearchTypeQuery && query != "" {
+		if params.Match != models.MatchBestFields {
+			return fmt.Errorf("%w: match cannot be used with type=query; use quotes and * in the query instead", ErrInvalidSearch)
+		}
+		expr, err := querylang.Parse(query)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSearch, err)
+		}
+		params.Expression = expr
+	} else if len(query) > 1 && strings.HasPrefix(query, `"`) && strings.HasSuffix(query, `"`) {
+		query = strings.Trim(query, `"`)
+		if params.Match == models.MatchBestFields {
+			params.Match = models.MatchPhrase
+		}
+	}
+	params.Query = query
+	if params.Query == "" && params.Filters.IsEmpty() && params.Like == nil {
+		return fmt.Errorf("%w: a query or at least one filter is required", ErrInvalidSearch)
+	}
+
+	if params.MinimumShouldMatch == "" {
+		params.MinimumShouldMatch = s.search.MinimumShouldMatch
+	}
+
+	if params.Fuzziness == "" {
+		params.Fuzziness = s.search.Fuzziness
+	}
+	switch strings.ToUpper(params.Fuzziness) {
+	case "AUTO":
+		params.Fuzziness = "AUTO"
+	case "", "0", "1", "2":
+	default:
+		return fmt.Errorf("%w: unknown fuzziness %q, expected AUTO, 0, 1 or 2", ErrInvalidSearch, params.Fuzziness)
+	}
+
+	if params.Sort.Key == "" {
+		params.Sort.Key = models.SortRelevance
+	}
+	if err := preparePage(&params.Page, &params.PerPage, params.Sort, searchSortKeys); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	if params.Cursor == "" && params.Page > MaxResultWindow/params.PerPage {
+		return fmt.Errorf("%w: pages past the first %d results need a cursor", ErrInvalidSearch, MaxResultWindow)
+	}
+	return s.prepareMode(params)
+}
+
+// prepareMode checks that semantic and hybrid searches are enabled and only
+// use what kNN supports: a plain text query ranked by relevance, paged by
+// page number.
+func (s *bookService) prepareMode(params *models.BookSearch) error {
+	switch params.Mode {
+	case "":
+		params.Mode = models.SearchModeKeyword
+		return nil
+	case models.SearchModeKeyword:
+		return nil
+	case models.SearchModeSemantic, models.SearchModeHybrid:
+	default:
+		return fmt.Errorf("%w: unknown mode %q, expected keyword, semantic or hybrid", ErrInvalidSearch, params.Mode)
+	}
+
+	switch {
+	case s.search.Embedder == nil:
+		return fmt.Errorf("%w: mode=%s is turned off (SEARCH_EMBEDDER=none)", ErrInvalidSearch, params.Mode)
+	case params.Type == models.SearchTypeQuery:
+		return fmt.Errorf("%w: mode=%s cannot be used with type=query", ErrInvalidSearch, params.Mode)
+	case params.Query == "":
+		return fmt.Errorf("%w: mode=%s needs q", ErrInvalidSearch, params.Mode)
+	case params.Sort.Key != models.SortRelevance:
+		return fmt.Errorf("%w: mode=%s results can only be sorted by relevance", ErrInvalidSearch, params.Mode)
+	case params.Cursor != "":
+		return fmt.Errorf("%w: mode=%s does not support cursors", ErrInvalidSearch, params.Mode)
+	case params.Page > MaxSemanticWindow/params.PerPage:
+		return fmt.Errorf("%w: mode=%s only reaches the first %d results", ErrInvalidSearch, params.Mode, MaxSemanticWindow)
+	}
+	return nil
+}
+
+// preparePage applies the default page and page size and checks them and the
+// sort key against the allowed keys.
+func preparePage(page, perPage *int, sort models.SortField, sortKeys []string) error {
+	if *page == 0 {
+		*page = 1
+	}
+	if *perPage == 0 {
+		*perPage = defaultPerPage
+	}
+	if *page < 1 || *page > maxPage {
+		return fmt.Errorf("page must be between 1 and %d", maxPage)
+	}
+	if *perPage < 1 || *perPage > maxPerPage {
+		return fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+	}
+	for _, key := range sortKeys {
+		if sort.Key == key {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort %q, expected one of %s", sort.Key, strings.Join(sortKeys, ", "))
+}
+
+func validateFilters(f models.BookFilters) error {
+	if f.PagesMin < 0 || f.PagesMax < 0 {
+		return fmt.Errorf("%w: page bounds must not be negative", ErrInvalidSearch)
+	}
+	if f.PagesMax > 0 && f.PagesMin > f.PagesMax {
+		return fmt.Errorf("%w: pages_min is greater than pages_max", ErrInvalidSearch)
+	}
+	if !f.PublishedAfter.IsZero() && !f.PublishedBefore.IsZero() && f.PublishedAfter.After(f.PublishedBefore) {
+		return fmt.Errorf("%w: published_after is later than published_before", ErrInvalidSearch)
 	}
+	return nil
 }
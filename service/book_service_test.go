@@ -0,0 +1,484 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/embedding"
+	"go-elastic/models"
+	"go-elastic/querylang"
+	"go-elastic/repository"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// searchRecorder captures the search the service hands to the repository
+// and reports total hits for it.
+type searchRecorder struct {
+	repository.BookRepository
+	got        models.BookSearch
+	total      int64
+	didYouMean string
+}
+
+func (r *searchRecorder) Search(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	r.got = params
+	return &models.BookSearchResult{Hits: []models.BookHit{}, Total: r.total}, nil
+}
+
+func (r *searchRecorder) FindByID(ctx context.Context, id string) (*models.Book, error) {
+	if id != "507f1f77bcf86cd799439011" {
+		return nil, repository.ErrBookNotFound
+	}
+	return &models.Book{Title: "Go in Action", Language: "English"}, nil
+}
+
+func (r *searchRecorder) DidYouMean(ctx context.Context, query string) ([]models.SpellingSuggestion, error) {
+	r.didYouMean = query
+	return []models.SpellingSuggestion{{Text: "ken thompson"}}, nil
+}
+
+func TestSearchBooks_PreparesParams(t *testing.T) {
+	cfg := SearchConfig{Fields: []string{"title^3", "author^2"}, MinimumShouldMatch: "75%"}
+
+	tests := []struct {
+		name   string
+		in     models.BookSearch
+		fields []string
+		match  string
+		query  string
+		msm    string
+	}{
+		{"title", models.BookSearch{Type: "title", Query: "go"}, []string{"title"}, "", "go", "75%"},
+		{"author", models.BookSearch{Type: "author", Query: "pike"}, []string{"author"}, "", "pike", "75%"},
+		{"multi uses configured boosts", models.BookSearch{Type: "multi", Query: "go"}, cfg.Fields, "", "go", "75%"},
+		{"quoted query becomes phrase", models.BookSearch{Type: "multi", Query: `"the go book"`}, cfg.Fields, models.MatchPhrase, "the go book", "75%"},
+		{"explicit prefix kept", models.BookSearch{Type: "title", Query: "progr", Match: "prefix"}, []string{"title"}, models.MatchPrefix, "progr", "75%"},
+		{"request msm wins", models.BookSearch{Type: "multi", Query: "a b", MinimumShouldMatch: "1"}, cfg.Fields, "", "a b", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &searchRecorder{}
+			svc := NewBookService(repo, cfg)
+
+			_, err := svc.SearchBooks(context.Background(), tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.fields, repo.got.Fields)
+			assert.Equal(t, tt.match, repo.got.Match)
+			assert.Equal(t, tt.query, repo.got.Query)
+			assert.Equal(t, tt.msm, repo.got.MinimumShouldMatch)
+		})
+	}
+}
+
+func TestSearchBooks_RejectsUnknownParams(t *testing.T) {
+	svc := NewBookService(&searchRecorder{}, SearchConfig{})
+
+	_, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "isbn", Query: "x"})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "title", Query: "x", Match: "regex"})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: `""`})
+	assert.ErrorIs(t, err, ErrInvalidSearch, "empty query without filters")
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Filters: models.BookFilters{PagesMin: 300, PagesMax: 100}})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+}
+
+func TestSearchBooks_FiltersWithoutQuery(t *testing.T) {
+	repo := &searchRecorder{}
+	svc := NewBookService(repo, SearchConfig{})
+
+	filters := models.BookFilters{Languages: []string{"Thai"}, PagesMin: 100}
+	_, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Filters: filters})
+	require.NoError(t, err)
+	assert.Equal(t, filters, repo.got.Filters)
+}
+
+func TestSearchBooks_Paging(t *testing.T) {
+	repo := &searchRecorder{}
+	svc := NewBookService(repo, SearchConfig{})
+
+	_, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: "go"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.got.Page)
+	assert.Equal(t, defaultPerPage, repo.got.PerPage)
+	assert.Equal(t, models.SortField{Key: models.SortRelevance}, repo.got.Sort)
+
+	tests := []struct {
+		name string
+		in   models.BookSearch
+	}{
+		{"per_page too large", models.BookSearch{Query: "go", PerPage: maxPerPage + 1}},
+		{"unknown sort", models.BookSearch{Query: "go", Sort: models.SortField{Key: "isbn"}}},
+		{"past result window", models.BookSearch{Query: "go", Page: 101, PerPage: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.in.Type = models.SearchTypeMulti
+			_, err := svc.SearchBooks(context.Background(), tt.in)
+			assert.ErrorIs(t, err, ErrInvalidSearch)
+		})
+	}
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: "go", Page: 0, PerPage: 100, Cursor: "abc"})
+	assert.NoError(t, err, "a cursor reaches past the result window")
+}
+
+func TestGetAllBooks_ValidatesOptions(t *testing.T) {
+	svc := NewBookService(&listRecorder{}, SearchConfig{})
+
+	_, err := svc.GetAllBooks(context.Background(), models.BookListOptions{Sort: models.SortField{Key: models.SortRelevance}})
+	assert.ErrorIs(t, err, ErrInvalidListOptions, "relevance needs a query")
+
+	_, err = svc.GetAllBooks(context.Background(), models.BookListOptions{Page: -1})
+	assert.ErrorIs(t, err, ErrInvalidListOptions)
+
+	repo := &listRecorder{}
+	svc = NewBookService(repo, SearchConfig{})
+	_, err = svc.GetAllBooks(context.Background(), models.BookListOptions{Sort: models.SortField{Key: models.SortTitle, Desc: true}})
+	require.NoError(t, err)
+	assert.Equal(t, models.BookListOptions{Page: 1, PerPage: defaultPerPage, Sort: models.SortField{Key: models.SortTitle, Desc: true}}, repo.got)
+}
+
+// listRecorder captures the listing options the service hands to the repository
+type listRecorder struct {
+	repository.BookRepository
+	got models.BookListOptions
+}
+
+func (r *listRecorder) List(ctx context.Context, opts models.BookListOptions) (*models.BookList, error) {
+	r.got = opts
+	return &models.BookList{Books: []models.Book{}}, nil
+}
+
+func TestSuggestBooks_Validates(t *testing.T) {
+	svc := NewBookService(&searchRecorder{}, SearchConfig{})
+
+	_, err := svc.SuggestBooks(context.Background(), "  ", 0)
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	_, err = svc.SuggestBooks(context.Background(), "go", maxSuggestSize+1)
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+}
+
+func TestSearchBooks_Fuzziness(t *testing.T) {
+	repo := &searchRecorder{}
+	svc := NewBookService(repo, SearchConfig{Fuzziness: "AUTO"})
+
+	_, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "author", Query: "thompsn"})
+	require.NoError(t, err)
+	assert.Equal(t, "AUTO", repo.got.Fuzziness, "configured default")
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "author", Query: "thompsn", Fuzziness: "auto"})
+	require.NoError(t, err)
+	assert.Equal(t, "AUTO", repo.got.Fuzziness)
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "author", Query: "thompsn", Fuzziness: "0"})
+	require.NoError(t, err)
+	assert.Equal(t, "0", repo.got.Fuzziness, "request turns it off")
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "author", Query: "thompsn", Fuzziness: "3"})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+}
+
+func TestSearchBooks_DidYouMean(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  int64
+		in     models.BookSearch
+		called bool
+	}{
+		{"no hits", 0, models.BookSearch{Type: "author", Query: "ken thompsn"}, true},
+		{"few hits", 2, models.BookSearch{Type: "author", Query: "ken thompsn"}, true},
+		{"enough hits", 3, models.BookSearch{Type: "author", Query: "ken thompson"}, false},
+		{"filters only", 0, models.BookSearch{Type: "multi", Filters: models.BookFilters{PagesMin: 1}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &searchRecorder{total: tt.total}
+			svc := NewBookService(repo, SearchConfig{DidYouMeanBelow: 3})
+
+			result, err := svc.SearchBooks(context.Background(), tt.in)
+			require.NoError(t, err)
+			if tt.called {
+				assert.Equal(t, tt.in.Query, repo.didYouMean)
+				assert.Equal(t, []models.SpellingSuggestion{{Text: "ken thompson"}}, result.DidYouMean)
+			} else {
+				assert.Empty(t, repo.didYouMean)
+				assert.Nil(t, result.DidYouMean)
+			}
+		})
+	}
+}
+
+func TestSearchBooks_QueryLanguage(t *testing.T) {
+	repo := &searchRecorder{}
+	svc := NewBookService(repo, SearchConfig{Fields: []string{"title^3"}, DidYouMeanBelow: 3})
+
+	_, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: models.SearchTypeQuery, Query: `author:"John Doe" pages:>200`})
+	require.NoError(t, err)
+	assert.Equal(t, querylang.And{Clauses: []querylang.Expr{
+		querylang.Match{Field: "author", Text: "John Doe", Phrase: true},
+		querylang.Range{Field: "pages", GT: "200"},
+	}}, repo.got.Expression)
+	assert.Equal(t, []string{"title^3"}, repo.got.Fields, "unprefixed terms search the multi fields")
+	assert.Empty(t, repo.didYouMean, "no spelling corrections for query syntax")
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: models.SearchTypeQuery, Query: "pages:>many"})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+	var syntaxErr *querylang.SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Equal(t, 8, syntaxErr.Pos)
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: models.SearchTypeQuery, Query: "go", Match: models.MatchPrefix})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+}
+
+func TestSimilarBooks(t *testing.T) {
+	repo := &searchRecorder{}
+	svc := NewBookService(repo, SearchConfig{DidYouMeanBelow: 3})
+
+	params := models.BookSearch{Query: "ignored", Type: "isbn", PerPage: 5, Filters: models.BookFilters{Languages: []string{"English"}}}
+	_, err := svc.SimilarBooks(context.Background(), "507f1f77bcf86cd799439011", params)
+	require.NoError(t, err)
+	require.NotNil(t, repo.got.Like)
+	assert.Equal(t, "Go in Action", repo.got.Like.Title)
+	assert.Empty(t, repo.got.Query)
+	assert.Equal(t, 5, repo.got.PerPage)
+	assert.Equal(t, params.Filters, repo.got.Filters)
+	assert.Equal(t, models.SortField{Key: models.SortRelevance}, repo.got.Sort)
+
+	_, err = svc.SimilarBooks(context.Background(), "507f1f77bcf86cd799439012", models.BookSearch{})
+	assert.ErrorIs(t, err, repository.ErrBookNotFound)
+}
+
+// rankingRepo returns fixed keyword and semantic rankings and records the
+// searches it ran.
+type rankingRepo struct {
+	repository.BookRepository
+	keyword, semantic []models.BookHit
+	searches          []models.BookSearch
+}
+
+func (r *rankingRepo) Search(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	r.searches = append(r.searches, params)
+	if params.Vector != nil {
+		return &models.BookSearchResult{Hits: r.semantic, Total: int64(len(r.semantic))}, nil
+	}
+	return &models.BookSearchResult{Hits: r.keyword, Total: 40, Facets: &models.BookFacets{}}, nil
+}
+
+func (r *rankingRepo) DidYouMean(ctx context.Context, query string) ([]models.SpellingSuggestion, error) {
+	return nil, nil
+}
+
+func bookHits(ids ...string) []models.BookHit {
+	hits := make([]models.BookHit, len(ids))
+	for i, id := range ids {
+		oid, _ := primitive.ObjectIDFromHex(id)
+		hits[i] = models.BookHit{Book: models.Book{ID: oid, Title: id}}
+	}
+	return hits
+}
+
+func TestSearchBooks_Semantic(t *testing.T) {
+	repo := &searchRecorder{}
+	svc := NewBookService(repo, SearchConfig{Embedder: embedding.NewHashingEmbedder(16), DidYouMeanBelow: 3})
+
+	_, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Mode: "semantic", Query: "distributed systems"})
+	require.NoError(t, err)
+	assert.Len(t, repo.got.Vector, 16)
+	assert.Empty(t, repo.didYouMean, "no spelling corrections for meaning-based search")
+
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: "distributed systems"})
+	require.NoError(t, err)
+	assert.Equal(t, models.SearchModeKeyword, repo.got.Mode)
+	assert.Nil(t, repo.got.Vector)
+}
+
+func TestSearchBooks_ModeValidation(t *testing.T) {
+	svc := NewBookService(&searchRecorder{}, SearchConfig{Embedder: embedding.NewHashingEmbedder(16)})
+	tests := []struct {
+		name string
+		in   models.BookSearch
+		msg  string
+	}{
+		{"unknown mode", models.BookSearch{Type: "multi", Mode: "vector", Query: "go"}, "unknown mode"},
+		{"query language", models.BookSearch{Type: "query", Mode: "hybrid", Query: "go"}, "type=query"},
+		{"filters only", models.BookSearch{Type: "multi", Mode: "semantic", Filters: models.BookFilters{Languages: []string{"Thai"}}}, "needs q"},
+		{"sorted", models.BookSearch{Type: "multi", Mode: "semantic", Query: "go", Sort: models.SortField{Key: "title"}}, "sorted by relevance"},
+		{"cursor", models.BookSearch{Type: "multi", Mode: "hybrid", Query: "go", Cursor: "abc"}, "cursors"},
+		{"too deep", models.BookSearch{Type: "multi", Mode: "hybrid", Query: "go", Page: 11, PerPage: 100}, "first 1000"},
+		{"no words", models.BookSearch{Type: "multi", Mode: "semantic", Query: "the of"}, "no words"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.SearchBooks(context.Background(), tt.in)
+			assert.ErrorIs(t, err, ErrInvalidSearch)
+			assert.ErrorContains(t, err, tt.msg)
+		})
+	}
+
+	off := NewBookService(&searchRecorder{}, SearchConfig{})
+	_, err := off.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Mode: "semantic", Query: "go"})
+	assert.ErrorContains(t, err, "turned off")
+}
+
+func TestSearchBooks_Hybrid(t *testing.T) {
+	const a, b, c, d = "000000000000000000000001", "000000000000000000000002", "000000000000000000000003", "000000000000000000000004"
+	repo := &rankingRepo{keyword: bookHits(a, b, c), semantic: bookHits(c, d, a)}
+	repo.keyword[0].Highlights = map[string][]string{"title": {"<em>go</em>"}}
+	svc := NewBookService(repo, SearchConfig{Embedder: embedding.NewHashingEmbedder(16)})
+
+	result, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Mode: "hybrid", Query: "go", Page: 1, PerPage: 2, Highlight: true})
+	require.NoError(t, err)
+
+	require.Len(t, repo.searches, 2)
+	assert.Equal(t, 2, repo.searches[0].PerPage, "each ranking covers every hit up to the page")
+	assert.False(t, repo.searches[1].Highlight)
+
+	require.Len(t, result.Hits, 2)
+	assert.Equal(t, a, result.Hits[0].Book.Title, "ranked 1st and 3rd beats 3rd and 1st by first appearance")
+	assert.Equal(t, c, result.Hits[1].Book.Title)
+	assert.InDelta(t, 1.0/61+1.0/63, *result.Hits[0].Score, 1e-9)
+	assert.Equal(t, repo.keyword[0].Highlights, result.Hits[0].Highlights)
+	assert.Equal(t, int64(41), result.Total, "keyword total plus the semantic-only book")
+	assert.NotNil(t, result.Facets)
+
+	result, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Mode: "hybrid", Query: "go", Page: 3, PerPage: 2})
+	require.NoError(t, err)
+	assert.Empty(t, result.Hits, "past the fused results")
+}
+
+// flakyRepo fails Elasticsearch searches with err and answers text searches
+// from MongoDB
+type flakyRepo struct {
+	searchRecorder
+	err      error
+	textUsed bool
+}
+
+func (r *flakyRepo) Search(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.searchRecorder.Search(ctx, params)
+}
+
+func (r *flakyRepo) TextSearch(ctx context.Context, params models.BookSearch) (*models.BookSearchResult, error) {
+	r.textUsed = true
+	r.got = params
+	return &models.BookSearchResult{Hits: []models.BookHit{}, Total: 1, Page: params.Page, PerPage: params.PerPage}, nil
+}
+
+type staticHealth bool
+
+func (h staticHealth) Healthy() bool { return bool(h) }
+
+func TestSearchBooks_FallbackWhenUnhealthy(t *testing.T) {
+	repo := &flakyRepo{}
+	svc := NewBookService(repo, SearchConfig{Health: staticHealth(false), DidYouMeanBelow: 3})
+
+	result, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: `"go programming"`})
+	require.NoError(t, err)
+	assert.True(t, repo.textUsed)
+	assert.True(t, result.Degraded)
+	assert.Equal(t, "go programming", repo.got.Query)
+	assert.Equal(t, models.MatchPhrase, repo.got.Match)
+	assert.Empty(t, repo.didYouMean, "no corrections without Elasticsearch")
+
+	for name, params := range map[string]models.BookSearch{
+		"semantic": {Type: "multi", Mode: models.SearchModeSemantic, Query: "go"},
+		"query":    {Type: "query", Query: "title:go"},
+		"cursor":   {Type: "multi", Query: "go", Cursor: "abc"},
+	} {
+		svc := NewBookService(&flakyRepo{}, SearchConfig{Health: staticHealth(false), Embedder: embedding.NewHashingEmbedder(16)})
+		_, err := svc.SearchBooks(context.Background(), params)
+		assert.ErrorIs(t, err, repository.ErrSearchUnavailable, name)
+	}
+}
+
+func TestSearchBooks_FallbackOnUnavailable(t *testing.T) {
+	repo := &flakyRepo{err: fmt.Errorf("%w: connection refused", repository.ErrSearchUnavailable)}
+	svc := NewBookService(repo, SearchConfig{Health: staticHealth(true)})
+
+	result, err := svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: "go"})
+	require.NoError(t, err)
+	assert.True(t, result.Degraded)
+
+	// Without a health monitor the fallback is off
+	svc = NewBookService(&flakyRepo{err: repo.err}, SearchConfig{})
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: "go"})
+	assert.ErrorIs(t, err, repository.ErrSearchUnavailable)
+
+	// Searches Elasticsearch rejected are not retried on MongoDB
+	repo = &flakyRepo{err: errors.New("elasticsearch returned error: [400 Bad Request]")}
+	svc = NewBookService(repo, SearchConfig{Health: staticHealth(true)})
+	_, err = svc.SearchBooks(context.Background(), models.BookSearch{Type: "multi", Query: "go"})
+	assert.Error(t, err)
+	assert.False(t, repo.textUsed)
+}
+
+// exportRecorder streams one book from whichever source the service picks
+type exportRecorder struct {
+	repository.BookRepository
+	source string
+	got    models.BookSearch
+}
+
+func (r *exportRecorder) Each(ctx context.Context, fn func(book *models.Book) error) error {
+	r.source = "mongodb"
+	return fn(&models.Book{Title: "Dune"})
+}
+
+func (r *exportRecorder) SearchEach(ctx context.Context, params models.BookSearch, fn func(book *models.Book) error) error {
+	r.source, r.got = "elasticsearch", params
+	return fn(&models.Book{Title: "Dune"})
+}
+
+func TestExportBooks(t *testing.T) {
+	run := func(svc BookService, params models.BookSearch) ([]string, error) {
+		export, err := svc.ExportBooks(context.Background(), params)
+		if err != nil {
+			return nil, err
+		}
+		var titles []string
+		err = export(context.Background(), func(book *models.Book) error {
+			titles = append(titles, book.Title)
+			return nil
+		})
+		return titles, err
+	}
+
+	repo := &exportRecorder{}
+	svc := NewBookService(repo, SearchConfig{Fields: []string{"title"}, Health: staticHealth(true)})
+	titles, err := run(svc, models.BookSearch{Type: "multi"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Dune"}, titles)
+	assert.Equal(t, "mongodb", repo.source, "the whole catalog comes from MongoDB")
+
+	_, err = run(svc, models.BookSearch{Type: "multi", Query: `"dune"`, Mode: models.SearchModeSemantic, Page: 900})
+	require.NoError(t, err)
+	assert.Equal(t, "elasticsearch", repo.source)
+	assert.Equal(t, "dune", repo.got.Query)
+	assert.Equal(t, models.MatchPhrase, repo.got.Match)
+	assert.Equal(t, models.SearchModeKeyword, repo.got.Mode, "exports are keyword searches")
+	assert.Equal(t, []string{"title"}, repo.got.Fields)
+
+	_, err = run(svc, models.BookSearch{Type: "isbn", Filters: models.BookFilters{Languages: []string{"en"}}})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	svc = NewBookService(&exportRecorder{}, SearchConfig{Health: staticHealth(false)})
+	_, err = run(svc, models.BookSearch{Type: "multi", Filters: models.BookFilters{Languages: []string{"en"}}})
+	assert.ErrorIs(t, err, repository.ErrSearchUnavailable, "no MongoDB fallback for filtered exports")
+	_, err = run(svc, models.BookSearch{Type: "multi"})
+	assert.NoError(t, err, "the whole catalog does not need Elasticsearch")
+}
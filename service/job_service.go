@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"math"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+const jobTracerName = "job-service"
+
+// ErrJobInProgress is returned when submitting a job of a kind that runs one
+// at a time while another is queued or running.
+var ErrJobInProgress = errors.New("job already in progress")
+
+type JobService interface {
+	// Submit queues a job for the job workers. Reindexes and reconciliations
+	// are refused while another of the same kind is queued or running.
+	Submit(ctx context.Context, kind string, params models.JobParams) (*models.Job, error)
+	Get(ctx context.Context, id string) (*models.Job, error)
+	// List returns jobs newest first
+	List(ctx context.Context, opts models.JobListOptions) ([]models.Job, error)
+}
+
+type jobService struct {
+	repo repository.JobRepository
+}
+
+func NewJobService(repo repository.JobRepository) JobService {
+	return &jobService{repo: repo}
+}
+
+func (s *jobService) Submit(ctx context.Context, kind string, params models.JobParams) (*models.Job, error) {
+	tr := otel.Tracer(jobTracerName)
+	ctx, span := tr.Start(ctx, "Submit")
+	defer span.End()
+
+	// Reindexes and reconciliations both rebuild or repair the whole search
+	// index, so a second one would only repeat the first. Looking first
+	// names the active job; the repository still refuses one that slips in
+	// between.
+	if slices.Contains(repository.ExclusiveJobKinds, kind) {
+		for _, status := range []string{models.JobStatusQueued, models.JobStatusRunning} {
+			active, err := s.repo.List(ctx, models.JobListOptions{Kind: kind, Status: status, Limit: 1})
+			if err != nil {
+				return nil, err
+			}
+			if len(active) > 0 {
+				return nil, fmt.Errorf("%w: %s job %s is %s", ErrJobInProgress, kind, active[0].ID.Hex(), status)
+			}
+		}
+	}
+
+	now := time.Now()
+	job := &models.Job{
+		Kind:      kind,
+		Status:    models.JobStatusQueued,
+		Params:    params,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.Create(ctx, job); err != nil {
+		if errors.Is(err, repository.ErrJobConflict) {
+			return nil, fmt.Errorf("%w: another %s job is active", ErrJobInProgress, kind)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// stepProgress returns a function reporting done out of total to progress,
+// as the share of a job between the from and to percent. It does nothing
+// if progress is nil or total unknown.
+func stepProgress(progress func(percent float64), from, to float64, total int64) func(done int) {
+	if progress == nil || total <= 0 {
+		return func(int) {}
+	}
+	return func(done int) {
+		progress(from + (to-from)*math.Min(float64(done)/float64(total), 1))
+	}
+}
+
+func (s *jobService) Get(ctx context.Context, id string) (*models.Job, error) {
+	tr := otel.Tracer(jobTracerName)
+	ctx, span := tr.Start(ctx, "Get")
+	defer span.End()
+
+	return s.repo.Find(ctx, id)
+}
+
+func (s *jobService) List(ctx context.Context, opts models.JobListOptions) ([]models.Job, error) {
+	tr := otel.Tracer(jobTracerName)
+	ctx, span := tr.Start(ctx, "List")
+	defer span.End()
+
+	return s.repo.List(ctx, opts)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memJobQueue keeps jobs in a slice, filtering List by kind and status only
+type memJobQueue struct {
+	repository.JobRepository
+	jobs []models.Job
+}
+
+func (r *memJobQueue) Create(ctx context.Context, job *models.Job) error {
+	r.jobs = append(r.jobs, *job)
+	return nil
+}
+
+func (r *memJobQueue) List(ctx context.Context, opts models.JobListOptions) ([]models.Job, error) {
+	var found []models.Job
+	for _, job := range r.jobs {
+		if (opts.Kind == "" || job.Kind == opts.Kind) && (opts.Status == "" || job.Status == opts.Status) {
+			found = append(found, job)
+		}
+	}
+	return found, nil
+}
+
+func TestSubmitJob(t *testing.T) {
+	repo := &memJobQueue{}
+	svc := NewJobService(repo)
+
+	job, err := svc.Submit(context.Background(), models.JobKindReindex, models.JobParams{Version: 3})
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusQueued, job.Status)
+	assert.Equal(t, 3, job.Params.Version)
+	assert.False(t, job.CreatedAt.IsZero())
+
+	_, err = svc.Submit(context.Background(), models.JobKindReindex, models.JobParams{})
+	assert.ErrorIs(t, err, ErrJobInProgress, "one reindex at a time")
+
+	repo.jobs[0].Status = models.JobStatusSucceeded
+	_, err = svc.Submit(context.Background(), models.JobKindReindex, models.JobParams{})
+	assert.NoError(t, err, "a finished reindex does not block the next")
+
+	for i := 0; i < 2; i++ {
+		_, err = svc.Submit(context.Background(), models.JobKindImport, models.JobParams{Format: "csv"})
+		assert.NoError(t, err, "imports can run side by side")
+	}
+}
+
+// racedJobQueue lists no active job but refuses the insert, as the unique
+// index does when another submit got in between
+type racedJobQueue struct {
+	memJobQueue
+}
+
+func (r *racedJobQueue) Create(ctx context.Context, job *models.Job) error {
+	return repository.ErrJobConflict
+}
+
+func TestSubmitJob_Race(t *testing.T) {
+	svc := NewJobService(&racedJobQueue{})
+
+	_, err := svc.Submit(context.Background(), models.JobKindReconcile, models.JobParams{})
+	assert.ErrorIs(t, err, ErrJobInProgress)
+}
+
+func TestStepProgress(t *testing.T) {
+	var got []float64
+	step := stepProgress(func(percent float64) { got = append(got, percent) }, 50, 100, 4)
+	step(0)
+	step(2)
+	step(6)
+	assert.Equal(t, []float64{50, 75, 100}, got, "an estimate that falls short stops at the end of the step")
+
+	stepProgress(nil, 0, 100, 4)(1)
+	stepProgress(func(float64) { t.Fatal("no total, no progress") }, 0, 100, 0)(1)
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+
+	"go.opentelemetry.io/otel"
+)
+
+const outboxTracerName = "outbox-service"
+
+type OutboxService interface {
+	ListFailing(ctx context.Context, status string, limit int64) ([]models.OutboxEntry, error)
+	Retry(ctx context.Context, id string) error
+}
+
+type outboxService struct {
+	repo repository.OutboxRepository
+}
+
+func NewOutboxService(repo repository.OutboxRepository) OutboxService {
+	return &outboxService{
+		repo: repo,
+	}
+}
+
+func (s *outboxService) ListFailing(ctx context.Context, status string, limit int64) ([]models.OutboxEntry, error) {
+	tr := otel.Tracer(outboxTracerName)
+	ctx, span := tr.Start(ctx, "ListFailing")
+	defer span.End()
+
+	return s.repo.ListFailing(ctx, status, limit)
+}
+
+func (s *outboxService) Retry(ctx context.Context, id string) error {
+	tr := otel.Tracer(outboxTracerName)
+	ctx, span := tr.Start(ctx, "Retry")
+	defer span.End()
+
+	return s.repo.Requeue(ctx, id)
+}
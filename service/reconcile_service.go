@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+const reconcileTracerName = "reconcile-service"
+
+// maxDriftIDs caps the IDs listed per drift category in a report
+const maxDriftIDs = 1000
+
+type ReconcileService interface {
+	// Reconcile compares MongoDB with the search index. With repair set,
+	// missing and stale books are re-indexed and orphaned documents deleted.
+	// progress, which may be nil, is passed the share of books checked.
+	Reconcile(ctx context.Context, repair bool, progress func(percent float64)) (*models.DriftReport, error)
+}
+
+type reconcileService struct {
+	books repository.BookRepository
+	index repository.BookIndex
+}
+
+func NewReconcileService(books repository.BookRepository, index repository.BookIndex) ReconcileService {
+	return &reconcileService{
+		books: books,
+		index: index,
+	}
+}
+
+// Reconcile loads the ID and updated_at of every indexed document, then
+// streams MongoDB and checks each book off against it. Writes made while it
+// runs can show up as drift; repairing them is harmless because the current
+// MongoDB state is what gets indexed.
+func (s *reconcileService) Reconcile(ctx context.Context, repair bool, progress func(percent float64)) (*models.DriftReport, error) {
+	tr := otel.Tracer(reconcileTracerName)
+	ctx, span := tr.Start(ctx, "Reconcile")
+	defer span.End()
+
+	report := &models.DriftReport{
+		StartedAt: time.Now(),
+		Missing:   models.DriftSet{IDs: []string{}},
+		Stale:     models.DriftSet{IDs: []string{}},
+		Orphaned:  models.DriftSet{IDs: []string{}},
+		Repair:    repair,
+	}
+
+	// Both passes are measured against the books in MongoDB, which the
+	// index should match.
+	total, err := s.books.Count(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error counting books: %w", err)
+	}
+	scanned := stepProgress(progress, 0, 50, total)
+	checked := stepProgress(progress, 50, 100, total)
+
+	indexed := make(map[string]time.Time)
+	err = s.index.Scan(ctx, func(id string, updatedAt time.Time) error {
+		indexed[id] = updatedAt
+		scanned(len(indexed))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning search index: %w", err)
+	}
+	report.IndexCount = len(indexed)
+
+	err = s.books.Each(ctx, func(book *models.Book) error {
+		report.MongoCount++
+		checked(report.MongoCount)
+		id := book.ID.Hex()
+
+		indexedAt, ok := indexed[id]
+		delete(indexed, id)
+		switch {
+		case !ok:
+			addDrift(&report.Missing, id)
+		case indexedAt.Truncate(time.Millisecond).Before(book.UpdatedAt.Truncate(time.Millisecond)):
+			addDrift(&report.Stale, id)
+		default:
+			return nil
+		}
+
+		if repair {
+			s.recordRepair(report, id, s.index.Index(ctx, book))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning books collection: %w", err)
+	}
+
+	orphaned := make([]string, 0, len(indexed))
+	for id := range indexed {
+		orphaned = append(orphaned, id)
+	}
+	sort.Strings(orphaned)
+	for _, id := range orphaned {
+		addDrift(&report.Orphaned, id)
+		if repair {
+			s.recordRepair(report, id, s.index.Delete(ctx, id))
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (s *reconcileService) recordRepair(report *models.DriftReport, id string, err error) {
+	if err != nil {
+		report.RepairErrors = append(report.RepairErrors, fmt.Sprintf("%s: %v", id, err))
+		return
+	}
+	report.Repaired++
+}
+
+func addDrift(set *models.DriftSet, id string) {
+	set.Count++
+	if len(set.IDs) < maxDriftIDs {
+		set.IDs = append(set.IDs, id)
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeBookRepo serves Each from a slice; other methods are not used here.
+type fakeBookRepo struct {
+	repository.BookRepository
+	books []models.Book
+}
+
+func (f *fakeBookRepo) Each(ctx context.Context, fn func(book *models.Book) error) error {
+	for i := range f.books {
+		if err := fn(&f.books[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeBookRepo) Count(ctx context.Context) (int64, error) {
+	return int64(len(f.books)), nil
+}
+
+// fakeBookIndex is an in-memory search index keyed by book ID
+type fakeBookIndex struct {
+	docs map[string]time.Time
+}
+
+func (f *fakeBookIndex) Index(ctx context.Context, book *models.Book) error {
+	f.docs[book.ID.Hex()] = book.UpdatedAt
+	return nil
+}
+
+func (f *fakeBookIndex) BulkIndex(ctx context.Context, books []models.Book) (map[string]error, error) {
+	for i := range books {
+		f.docs[books[i].ID.Hex()] = books[i].UpdatedAt
+	}
+	return map[string]error{}, nil
+}
+
+func (f *fakeBookIndex) Delete(ctx context.Context, id string) error {
+	delete(f.docs, id)
+	return nil
+}
+
+func (f *fakeBookIndex) Scan(ctx context.Context, fn func(id string, updatedAt time.Time) error) error {
+	for id, updatedAt := range f.docs {
+		if err := fn(id, updatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestReconcile_ReportsAndRepairsDrift(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	inSync := models.Book{ID: primitive.NewObjectID(), UpdatedAt: now}
+	missing := models.Book{ID: primitive.NewObjectID(), UpdatedAt: now}
+	stale := models.Book{ID: primitive.NewObjectID(), UpdatedAt: now}
+	orphanID := primitive.NewObjectID().Hex()
+
+	books := &fakeBookRepo{books: []models.Book{inSync, missing, stale}}
+	index := &fakeBookIndex{docs: map[string]time.Time{
+		inSync.ID.Hex(): now.Add(500 * time.Microsecond), // sub-millisecond difference is not drift
+		stale.ID.Hex():  now.Add(-time.Hour),
+		orphanID:        now,
+	}}
+	svc := NewReconcileService(books, index)
+
+	var progress []float64
+	report, err := svc.Reconcile(context.Background(), false, func(percent float64) {
+		progress = append(progress, percent)
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, progress)
+	assert.IsNonDecreasing(t, progress)
+	assert.Equal(t, 100.0, progress[len(progress)-1])
+	assert.Equal(t, 3, report.MongoCount)
+	assert.Equal(t, 3, report.IndexCount)
+	assert.Equal(t, []string{missing.ID.Hex()}, report.Missing.IDs)
+	assert.Equal(t, []string{stale.ID.Hex()}, report.Stale.IDs)
+	assert.Equal(t, []string{orphanID}, report.Orphaned.IDs)
+	assert.Equal(t, 0, report.Repaired)
+	assert.Len(t, index.docs, 3, "report-only run must not touch the index")
+
+	report, err = svc.Reconcile(context.Background(), true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Repaired)
+
+	report, err = svc.Reconcile(context.Background(), false, nil)
+	require.NoError(t, err)
+	assert.True(t, report.InSync())
+}
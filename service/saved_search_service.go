@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"go-elastic/webhook"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+const savedSearchTracerName = "saved-search-service"
+
+// Inbox page sizes
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// ErrInvalidSavedSearch is returned for saved searches without a name or
+// with a bad webhook URL. Problems with the search itself are reported as
+// ErrInvalidSearch.
+var ErrInvalidSavedSearch = errors.New("invalid saved search")
+
+type SavedSearchService interface {
+	List(ctx context.Context, userID string) ([]models.SavedSearch, error)
+	Get(ctx context.Context, userID, id string) (*models.SavedSearch, error)
+	Create(ctx context.Context, search *models.SavedSearch) error
+	Update(ctx context.Context, search *models.SavedSearch) error
+	Delete(ctx context.Context, userID, id string) error
+
+	// RegisterAll registers every saved search in the percolator index, for
+	// a newly created index. It returns the number registered.
+	RegisterAll(ctx context.Context) (int, error)
+	// BookIndexed percolates a book that was just indexed and notifies the
+	// owners of the saved searches it matches, queueing the notifications
+	// of saved searches with a webhook for delivery.
+	BookIndexed(ctx context.Context, book *models.Book) error
+
+	ListNotifications(ctx context.Context, userID string, unreadOnly bool, limit int) (*models.NotificationList, error)
+	MarkNotificationRead(ctx context.Context, userID, id string) (*models.Notification, error)
+}
+
+type savedSearchService struct {
+	searches      repository.SavedSearchRepository
+	index         repository.SavedSearchIndex
+	notifications repository.NotificationRepository
+	// books prepares saved searches exactly as searches are prepared, with
+	// the same fields and defaults.
+	books *bookService
+}
+
+func NewSavedSearchService(searches repository.SavedSearchRepository, index repository.SavedSearchIndex, notifications repository.NotificationRepository, search SearchConfig) SavedSearchService {
+	return &savedSearchService{
+		searches:      searches,
+		index:         index,
+		notifications: notifications,
+		books:         &bookService{search: search},
+	}
+}
+
+func (s *savedSearchService) List(ctx context.Context, userID string) ([]models.SavedSearch, error) {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "List")
+	defer span.End()
+
+	return s.searches.List(ctx, userID)
+}
+
+func (s *savedSearchService) Get(ctx context.Context, userID, id string) (*models.SavedSearch, error) {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "Get")
+	defer span.End()
+
+	return s.searches.Find(ctx, userID, id)
+}
+
+// Create stores the saved search and then registers it; if registering
+// fails the stored copy is removed again.
+func (s *savedSearchService) Create(ctx context.Context, search *models.SavedSearch) error {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "Create")
+	defer span.End()
+
+	params, err := s.prepare(ctx, search)
+	if err != nil {
+		return err
+	}
+	if err := s.searches.Create(ctx, search); err != nil {
+		return err
+	}
+	if err := s.index.Register(ctx, search, params); err != nil {
+		if delErr := s.searches.Delete(ctx, search.UserID, search.ID.Hex()); delErr != nil {
+			span.RecordError(delErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Update re-registers the saved search before storing it, so a search that
+// Elasticsearch rejects leaves the stored one unchanged.
+func (s *savedSearchService) Update(ctx context.Context, search *models.SavedSearch) error {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "Update")
+	defer span.End()
+
+	params, err := s.prepare(ctx, search)
+	if err != nil {
+		return err
+	}
+	if _, err := s.searches.Find(ctx, search.UserID, search.ID.Hex()); err != nil {
+		return err
+	}
+	if err := s.index.Register(ctx, search, params); err != nil {
+		return err
+	}
+	return s.searches.Update(ctx, search)
+}
+
+func (s *savedSearchService) Delete(ctx context.Context, userID, id string) error {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "Delete")
+	defer span.End()
+
+	if err := s.searches.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	return s.index.Unregister(ctx, id)
+}
+
+func (s *savedSearchService) RegisterAll(ctx context.Context) (int, error) {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "RegisterAll")
+	defer span.End()
+
+	registered := 0
+	err := s.searches.Each(ctx, func(search *models.SavedSearch) error {
+		params, err := s.prepare(ctx, search)
+		if err != nil {
+			return fmt.Errorf("saved search %s: %w", search.ID.Hex(), err)
+		}
+		if err := s.index.Register(ctx, search, params); err != nil {
+			return err
+		}
+		registered++
+		return nil
+	})
+	return registered, err
+}
+
+// BookIndexed returns an error if percolation or storing a notification
+// failed, so that the caller can retry; notifications already stored are not
+// repeated. Webhooks are delivered by a separate worker, so a slow endpoint
+// does not hold up indexing.
+func (s *savedSearchService) BookIndexed(ctx context.Context, book *models.Book) error {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "BookIndexed")
+	defer span.End()
+
+	ids, err := s.index.Percolate(ctx, book)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	// Saved searches deleted since they matched are simply not found.
+	searches, err := s.searches.FindByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, search := range searches {
+		n := &models.Notification{
+			UserID:          search.UserID,
+			SavedSearchID:   search.ID,
+			SavedSearchName: search.Name,
+			Book: models.NotifiedBook{
+				ID:       book.ID,
+				Title:    book.Title,
+				Author:   book.Author,
+				Language: book.Language,
+			},
+		}
+		if search.WebhookURL != "" {
+			n.Webhook = &models.WebhookDelivery{URL: search.WebhookURL, Pending: true, NextAttemptAt: time.Now()}
+		}
+		if _, err := s.notifications.Add(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *savedSearchService) ListNotifications(ctx context.Context, userID string, unreadOnly bool, limit int) (*models.NotificationList, error) {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "ListNotifications")
+	defer span.End()
+
+	if limit == 0 {
+		limit = defaultNotificationLimit
+	}
+	if limit < 1 || limit > maxNotificationLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidSavedSearch, maxNotificationLimit)
+	}
+	return s.notifications.List(ctx, userID, unreadOnly, int64(limit))
+}
+
+func (s *savedSearchService) MarkNotificationRead(ctx context.Context, userID, id string) (*models.Notification, error) {
+	tr := otel.Tracer(savedSearchTracerName)
+	ctx, span := tr.Start(ctx, "MarkNotificationRead")
+	defer span.End()
+
+	return s.notifications.MarkRead(ctx, userID, id)
+}
+
+// prepare trims and checks a saved search and returns the search it is
+// registered with. The saved search keeps what the user typed.
+func (s *savedSearchService) prepare(ctx context.Context, search *models.SavedSearch) (models.BookSearch, error) {
+	search.Name = strings.TrimSpace(search.Name)
+	if search.Name == "" {
+		return models.BookSearch{}, fmt.Errorf("%w: name is required", ErrInvalidSavedSearch)
+	}
+	search.Query = strings.TrimSpace(search.Query)
+	if search.Type == "" {
+		search.Type = models.SearchTypeMulti
+	}
+	if search.Filters != nil && search.Filters.BookFilters().IsEmpty() {
+		search.Filters = nil
+	}
+
+	search.WebhookURL = strings.TrimSpace(search.WebhookURL)
+	if search.WebhookURL != "" {
+		if err := webhook.CheckURL(ctx, search.WebhookURL); err != nil {
+			return models.BookSearch{}, fmt.Errorf("%w: %v", ErrInvalidSavedSearch, err)
+		}
+	}
+
+	params := search.BookSearch()
+	if err := s.books.prepareSearch(&params); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// NewAlertingBookIndex returns a BookIndex that runs every book it indexes
+// one at a time past the saved searches. Bulk indexing, used by reindexing,
+// does not notify.
+func NewAlertingBookIndex(index repository.BookIndex, alerts SavedSearchService) repository.BookIndex {
+	return &alertingBookIndex{BookIndex: index, alerts: alerts}
+}
+
+type alertingBookIndex struct {
+	repository.BookIndex
+	alerts SavedSearchService
+}
+
+// Index fails if notifying fails, so the outbox relay or change stream
+// retries; indexing again is harmless and notifications are not repeated.
+func (i *alertingBookIndex) Index(ctx context.Context, book *models.Book) error {
+	if err := i.BookIndex.Index(ctx, book); err != nil {
+		return err
+	}
+	if err := i.alerts.BookIndexed(ctx, book); err != nil {
+		return fmt.Errorf("error notifying saved searches: %w", err)
+	}
+	return nil
+}
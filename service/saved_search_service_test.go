@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memSavedSearches keeps saved searches in a map, ignoring users except
+// where a test needs them
+type memSavedSearches struct {
+	repository.SavedSearchRepository
+	searches map[primitive.ObjectID]models.SavedSearch
+}
+
+func (r *memSavedSearches) Create(ctx context.Context, search *models.SavedSearch) error {
+	search.ID = primitive.NewObjectID()
+	r.searches[search.ID] = *search
+	return nil
+}
+
+func (r *memSavedSearches) Delete(ctx context.Context, userID, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	if _, ok := r.searches[objectID]; !ok {
+		return repository.ErrSavedSearchNotFound
+	}
+	delete(r.searches, objectID)
+	return nil
+}
+
+func (r *memSavedSearches) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.SavedSearch, error) {
+	var found []models.SavedSearch
+	for _, id := range ids {
+		if search, ok := r.searches[id]; ok {
+			found = append(found, search)
+		}
+	}
+	return found, nil
+}
+
+// fakePercolator records registrations and matches every book against a
+// fixed list of saved search IDs
+type fakePercolator struct {
+	registered map[primitive.ObjectID]models.BookSearch
+	matches    []primitive.ObjectID
+	err        error
+}
+
+func (p *fakePercolator) Register(ctx context.Context, search *models.SavedSearch, params models.BookSearch) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.registered[search.ID] = params
+	return nil
+}
+
+func (p *fakePercolator) Unregister(ctx context.Context, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	delete(p.registered, objectID)
+	return nil
+}
+
+func (p *fakePercolator) Percolate(ctx context.Context, book *models.Book) ([]primitive.ObjectID, error) {
+	return p.matches, p.err
+}
+
+// memInbox stores one notification per saved search and book
+type memInbox struct {
+	repository.NotificationRepository
+	notifications []models.Notification
+}
+
+func (r *memInbox) Add(ctx context.Context, n *models.Notification) (bool, error) {
+	for _, existing := range r.notifications {
+		if existing.SavedSearchID == n.SavedSearchID && existing.Book.ID == n.Book.ID {
+			return false, nil
+		}
+	}
+	n.ID = primitive.NewObjectID()
+	r.notifications = append(r.notifications, *n)
+	return true, nil
+}
+
+func newSavedSearchFixture() (*memSavedSearches, *fakePercolator, *memInbox) {
+	return &memSavedSearches{searches: map[primitive.ObjectID]models.SavedSearch{}},
+		&fakePercolator{registered: map[primitive.ObjectID]models.BookSearch{}},
+		&memInbox{}
+}
+
+func TestCreateSavedSearch(t *testing.T) {
+	searches, index, inbox := newSavedSearchFixture()
+	svc := NewSavedSearchService(searches, index, inbox, SearchConfig{Fields: []string{"title", "author"}})
+
+	search := &models.SavedSearch{UserID: "u1", Name: " Go books ", Query: `"go programming"`}
+	require.NoError(t, svc.Create(context.Background(), search))
+
+	assert.Equal(t, "Go books", search.Name)
+	assert.Equal(t, models.SearchTypeMulti, search.Type)
+	assert.Equal(t, `"go programming"`, search.Query, "the saved search keeps what was typed")
+
+	params := index.registered[search.ID]
+	assert.Equal(t, "go programming", params.Query)
+	assert.Equal(t, models.MatchPhrase, params.Match)
+	assert.Equal(t, []string{"title", "author"}, params.Fields)
+	assert.Equal(t, models.SearchModeKeyword, params.Mode)
+}
+
+func TestCreateSavedSearch_Invalid(t *testing.T) {
+	searches, index, inbox := newSavedSearchFixture()
+	svc := NewSavedSearchService(searches, index, inbox, SearchConfig{})
+
+	for name, tc := range map[string]struct {
+		search models.SavedSearch
+		err    error
+	}{
+		"no name":        {models.SavedSearch{Query: "go"}, ErrInvalidSavedSearch},
+		"ftp webhook":    {models.SavedSearch{Name: "x", Query: "go", WebhookURL: "ftp://example.com"}, ErrInvalidSavedSearch},
+		"relative hook":  {models.SavedSearch{Name: "x", Query: "go", WebhookURL: "/hook"}, ErrInvalidSavedSearch},
+		"loopback hook":  {models.SavedSearch{Name: "x", Query: "go", WebhookURL: "http://127.0.0.1:9200/books/_delete_by_query"}, ErrInvalidSavedSearch},
+		"metadata hook":  {models.SavedSearch{Name: "x", Query: "go", WebhookURL: "http://169.254.169.254/latest/meta-data/"}, ErrInvalidSavedSearch},
+		"private hook":   {models.SavedSearch{Name: "x", Query: "go", WebhookURL: "http://10.0.0.7:27017/"}, ErrInvalidSavedSearch},
+		"nothing to say": {models.SavedSearch{Name: "x"}, ErrInvalidSearch},
+		"bad type":       {models.SavedSearch{Name: "x", Query: "go", Type: "isbn"}, ErrInvalidSearch},
+		"bad syntax":     {models.SavedSearch{Name: "x", Query: "title:(go", Type: models.SearchTypeQuery}, ErrInvalidSearch},
+	} {
+		search := tc.search
+		assert.ErrorIs(t, svc.Create(context.Background(), &search), tc.err, name)
+	}
+	assert.Empty(t, searches.searches)
+}
+
+func TestCreateSavedSearch_RegisterFails(t *testing.T) {
+	searches, index, inbox := newSavedSearchFixture()
+	index.err = errors.New("es down")
+	svc := NewSavedSearchService(searches, index, inbox, SearchConfig{})
+
+	err := svc.Create(context.Background(), &models.SavedSearch{UserID: "u1", Name: "Go", Query: "go"})
+	assert.EqualError(t, err, "es down")
+	assert.Empty(t, searches.searches, "the stored saved search is removed again")
+}
+
+func TestBookIndexed(t *testing.T) {
+	searches, index, inbox := newSavedSearchFixture()
+	svc := NewSavedSearchService(searches, index, inbox, SearchConfig{})
+	withHook := &models.SavedSearch{UserID: "u1", Name: "Go", Query: "go", WebhookURL: "https://93.184.216.34/hook"}
+	inboxOnly := &models.SavedSearch{UserID: "u2", Name: "Golang", Query: "golang"}
+	require.NoError(t, svc.Create(context.Background(), withHook))
+	require.NoError(t, svc.Create(context.Background(), inboxOnly))
+	deleted := primitive.NewObjectID()
+	index.matches = []primitive.ObjectID{withHook.ID, inboxOnly.ID, deleted}
+
+	before := time.Now()
+	book := &models.Book{ID: primitive.NewObjectID(), Title: "Learning Go", Author: "Jon Bodner"}
+	require.NoError(t, svc.BookIndexed(context.Background(), book))
+
+	require.Len(t, inbox.notifications, 2)
+	first := inbox.notifications[0]
+	assert.Equal(t, "u1", first.UserID)
+	assert.Equal(t, withHook.ID, first.SavedSearchID)
+	assert.Equal(t, "Go", first.SavedSearchName)
+	assert.Equal(t, models.NotifiedBook{ID: book.ID, Title: "Learning Go", Author: "Jon Bodner"}, first.Book)
+	require.NotNil(t, first.Webhook, "the webhook is queued with the notification")
+	assert.Equal(t, withHook.WebhookURL, first.Webhook.URL)
+	assert.True(t, first.Webhook.Pending)
+	assert.Zero(t, first.Webhook.Attempts)
+	assert.False(t, first.Webhook.NextAttemptAt.Before(before), "it is due right away")
+	assert.Nil(t, inbox.notifications[1].Webhook)
+
+	// Indexing the book again, as after an update, notifies nobody
+	require.NoError(t, svc.BookIndexed(context.Background(), book))
+	assert.Len(t, inbox.notifications, 2)
+}
+
+// recordingBookIndex records the books indexed through it
+type recordingBookIndex struct {
+	repository.BookIndex
+	indexed []*models.Book
+}
+
+func (i *recordingBookIndex) Index(ctx context.Context, book *models.Book) error {
+	i.indexed = append(i.indexed, book)
+	return nil
+}
+
+func TestAlertingBookIndex(t *testing.T) {
+	searches, index, inbox := newSavedSearchFixture()
+	svc := NewSavedSearchService(searches, index, inbox, SearchConfig{})
+	search := &models.SavedSearch{UserID: "u1", Name: "Go", Query: "go"}
+	require.NoError(t, svc.Create(context.Background(), search))
+	index.matches = []primitive.ObjectID{search.ID}
+
+	books := &recordingBookIndex{}
+	alerting := NewAlertingBookIndex(books, svc)
+	book := &models.Book{ID: primitive.NewObjectID(), Title: "Go"}
+	require.NoError(t, alerting.Index(context.Background(), book))
+	assert.Equal(t, []*models.Book{book}, books.indexed)
+	assert.Len(t, inbox.notifications, 1)
+
+	// A failed percolation is returned so that indexing is retried
+	index.err = errors.New("es down")
+	assert.ErrorContains(t, alerting.Index(context.Background(), book), "es down")
+}
+
+func TestListNotifications_Limit(t *testing.T) {
+	svc := NewSavedSearchService(nil, nil, nil, SearchConfig{})
+	_, err := svc.ListNotifications(context.Background(), "u1", false, maxNotificationLimit+1)
+	assert.ErrorIs(t, err, ErrInvalidSavedSearch)
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/database"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+const searchAnalysisTracerName = "search-analysis-service"
+
+// ErrInvalidSearchAnalysis is returned for synonym sets and stopword lists
+// that are empty or malformed.
+var ErrInvalidSearchAnalysis = errors.New("invalid synonyms or stopwords")
+
+// ErrSearchAnalysisUnsupported is returned when reloading into an index
+// created before mapping version 7, which has no filters to reload.
+var ErrSearchAnalysisUnsupported = errors.New("index has no search-time synonym and stopword filters")
+
+type SearchAnalysisService interface {
+	ListSynonymSets(ctx context.Context) ([]models.SynonymSet, error)
+	GetSynonymSet(ctx context.Context, id string) (*models.SynonymSet, error)
+	CreateSynonymSet(ctx context.Context, set *models.SynonymSet) error
+	UpdateSynonymSet(ctx context.Context, set *models.SynonymSet) error
+	DeleteSynonymSet(ctx context.Context, id string) error
+
+	ListStopwordLists(ctx context.Context) ([]models.StopwordList, error)
+	GetStopwordList(ctx context.Context, id string) (*models.StopwordList, error)
+	CreateStopwordList(ctx context.Context, list *models.StopwordList) error
+	UpdateStopwordList(ctx context.Context, list *models.StopwordList) error
+	DeleteStopwordList(ctx context.Context, id string) error
+
+	// Reload applies every stored synonym set and stopword list to an index.
+	// An empty index means the one behind the books alias.
+	Reload(ctx context.Context, index string) (*models.SearchAnalysisReload, error)
+}
+
+type searchAnalysisService struct {
+	repo repository.SearchAnalysisRepository
+	mu   sync.Mutex
+}
+
+func NewSearchAnalysisService(repo repository.SearchAnalysisRepository) SearchAnalysisService {
+	return &searchAnalysisService{repo: repo}
+}
+
+func (s *searchAnalysisService) ListSynonymSets(ctx context.Context) ([]models.SynonymSet, error) {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "ListSynonymSets")
+	defer span.End()
+
+	return s.repo.ListSynonymSets(ctx)
+}
+
+func (s *searchAnalysisService) GetSynonymSet(ctx context.Context, id string) (*models.SynonymSet, error) {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "GetSynonymSet")
+	defer span.End()
+
+	return s.repo.FindSynonymSet(ctx, id)
+}
+
+func (s *searchAnalysisService) CreateSynonymSet(ctx context.Context, set *models.SynonymSet) error {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "CreateSynonymSet")
+	defer span.End()
+
+	if err := normalizeSynonymSet(set); err != nil {
+		return err
+	}
+	return s.repo.CreateSynonymSet(ctx, set)
+}
+
+func (s *searchAnalysisService) UpdateSynonymSet(ctx context.Context, set *models.SynonymSet) error {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "UpdateSynonymSet")
+	defer span.End()
+
+	if err := normalizeSynonymSet(set); err != nil {
+		return err
+	}
+	return s.repo.UpdateSynonymSet(ctx, set)
+}
+
+func (s *searchAnalysisService) DeleteSynonymSet(ctx context.Context, id string) error {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "DeleteSynonymSet")
+	defer span.End()
+
+	return s.repo.DeleteSynonymSet(ctx, id)
+}
+
+func (s *searchAnalysisService) ListStopwordLists(ctx context.Context) ([]models.StopwordList, error) {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "ListStopwordLists")
+	defer span.End()
+
+	return s.repo.ListStopwordLists(ctx)
+}
+
+func (s *searchAnalysisService) GetStopwordList(ctx context.Context, id string) (*models.StopwordList, error) {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "GetStopwordList")
+	defer span.End()
+
+	return s.repo.FindStopwordList(ctx, id)
+}
+
+func (s *searchAnalysisService) CreateStopwordList(ctx context.Context, list *models.StopwordList) error {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "CreateStopwordList")
+	defer span.End()
+
+	if err := normalizeStopwordList(list); err != nil {
+		return err
+	}
+	return s.repo.CreateStopwordList(ctx, list)
+}
+
+func (s *searchAnalysisService) UpdateStopwordList(ctx context.Context, list *models.StopwordList) error {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "UpdateStopwordList")
+	defer span.End()
+
+	if err := normalizeStopwordList(list); err != nil {
+		return err
+	}
+	return s.repo.UpdateStopwordList(ctx, list)
+}
+
+func (s *searchAnalysisService) DeleteStopwordList(ctx context.Context, id string) error {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "DeleteStopwordList")
+	defer span.End()
+
+	return s.repo.DeleteStopwordList(ctx, id)
+}
+
+// Reload replaces the Elasticsearch synonyms set, which reloads the search
+// analyzers without downtime. Stopwords are index settings that can only
+// change while the index is closed, so they are only updated on an index
+// that is not live yet, such as one being built by a reindex; when those of
+// the live index are out of date the result asks for a rebuild instead.
+func (s *searchAnalysisService) Reload(ctx context.Context, index string) (*models.SearchAnalysisReload, error) {
+	tr := otel.Tracer(searchAnalysisTracerName)
+	ctx, span := tr.Start(ctx, "Reload")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live, err := database.CurrentBookIndex()
+	if err != nil {
+		return nil, err
+	}
+	if index == "" {
+		if live == "" {
+			return nil, errors.New("there is no books index")
+		}
+		index = live
+	}
+
+	current, ok, err := database.BookIndexStopwords(index)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s was created before mapping version 7, reindex it first", ErrSearchAnalysisUnsupported, index)
+	}
+
+	sets, err := s.repo.ListSynonymSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := s.repo.ListStopwordLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := synonymRules(sets)
+	if err := database.PutBooksSynonyms(rules); err != nil {
+		return nil, err
+	}
+
+	result := &models.SearchAnalysisReload{
+		Index:        index,
+		SynonymRules: len(rules),
+		ReloadedAt:   time.Now(),
+	}
+
+	words := mergeStopwords(lists)
+	result.Stopwords = len(words)
+	switch {
+	case sameWords(current, words):
+	case index == live:
+		result.RebuildRequired = true
+	default:
+		if err := database.SetBookIndexStopwords(index, words); err != nil {
+			return nil, err
+		}
+		result.StopwordsChanged = true
+	}
+	return result, nil
+}
+
+// normalizeSynonymSet trims the name and rules, drops blank rules and checks
+// that every rule is valid Solr synonym syntax.
+func normalizeSynonymSet(set *models.SynonymSet) error {
+	set.Name = strings.TrimSpace(set.Name)
+	if set.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSearchAnalysis)
+	}
+
+	rules := make([]string, 0, len(set.Rules))
+	for _, rule := range set.Rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if err := validateSynonymRule(rule); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", ErrInvalidSearchAnalysis, rule, err)
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("%w: at least one rule is required", ErrInvalidSearchAnalysis)
+	}
+	set.Rules = rules
+	return nil
+}
+
+// validateSynonymRule checks a rule is either a comma-separated list of at
+// least two equivalent terms or "terms => replacements".
+func validateSynonymRule(rule string) error {
+	sides := strings.Split(rule, "=>")
+	switch len(sides) {
+	case 1:
+		terms, err := synonymTerms(sides[0])
+		if err != nil {
+			return err
+		}
+		if len(terms) < 2 {
+			return errors.New(`list at least two equivalent terms, or use "=>" to map terms to others`)
+		}
+	case 2:
+		for _, side := range sides {
+			if _, err := synonymTerms(side); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New(`"=>" may only appear once`)
+	}
+	return nil
+}
+
+func synonymTerms(list string) ([]string, error) {
+	terms := strings.Split(list, ",")
+	for i, term := range terms {
+		terms[i] = strings.TrimSpace(term)
+		if terms[i] == "" {
+			return nil, errors.New("empty term")
+		}
+	}
+	return terms, nil
+}
+
+// normalizeStopwordList trims the name, lower-cases the words (the stop
+// filter runs after lowercasing) and removes duplicates.
+func normalizeStopwordList(list *models.StopwordList) error {
+	list.Name = strings.TrimSpace(list.Name)
+	if list.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSearchAnalysis)
+	}
+
+	words := make([]string, 0, len(list.Words))
+	seen := make(map[string]bool)
+	for _, word := range list.Words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || seen[word] {
+			continue
+		}
+		if strings.ContainsAny(word, " \t\n") {
+			return fmt.Errorf("%w: stopword %q must be a single word", ErrInvalidSearchAnalysis, word)
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		return fmt.Errorf("%w: at least one word is required", ErrInvalidSearchAnalysis)
+	}
+	list.Words = words
+	return nil
+}
+
+// synonymRules flattens the sets into the rules of the Elasticsearch synonyms
+// set. Rule IDs are derived from the set ID so they stay unique when two sets
+// share a name.
+func synonymRules(sets []models.SynonymSet) []database.SynonymRule {
+	rules := []database.SynonymRule{}
+	for _, set := range sets {
+		for i, rule := range set.Rules {
+			rules = append(rules, database.SynonymRule{
+				ID:       fmt.Sprintf("%s-%d", set.ID.Hex(), i),
+				Synonyms: rule,
+			})
+		}
+	}
+	return rules
+}
+
+// mergeStopwords returns the words of all lists, sorted and deduplicated
+func mergeStopwords(lists []models.StopwordList) []string {
+	seen := make(map[string]bool)
+	words := []string{}
+	for _, list := range lists {
+		for _, word := range list.Words {
+			if !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+func sameWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	sort.Strings(a)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
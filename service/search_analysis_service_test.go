@@ -0,0 +1,69 @@
+package service
+
+import (
+	"go-elastic/database"
+	"go-elastic/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeSynonymSet(t *testing.T) {
+	set := &models.SynonymSet{Name: " genres ", Rules: []string{" sci-fi, science fiction ", "", "vol => volume"}}
+	require.NoError(t, normalizeSynonymSet(set))
+	assert.Equal(t, "genres", set.Name)
+	assert.Equal(t, []string{"sci-fi, science fiction", "vol => volume"}, set.Rules)
+
+	tests := []struct {
+		name string
+		set  models.SynonymSet
+		msg  string
+	}{
+		{"no name", models.SynonymSet{Rules: []string{"a, b"}}, "name is required"},
+		{"no rules", models.SynonymSet{Name: "x", Rules: []string{" "}}, "at least one rule"},
+		{"single term", models.SynonymSet{Name: "x", Rules: []string{"sci-fi"}}, "at least two"},
+		{"empty term", models.SynonymSet{Name: "x", Rules: []string{"sci-fi,, scifi"}}, "empty term"},
+		{"empty side", models.SynonymSet{Name: "x", Rules: []string{"vol =>"}}, "empty term"},
+		{"two arrows", models.SynonymSet{Name: "x", Rules: []string{"a => b => c"}}, "only appear once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := normalizeSynonymSet(&tt.set)
+			assert.ErrorIs(t, err, ErrInvalidSearchAnalysis)
+			assert.ErrorContains(t, err, tt.msg)
+		})
+	}
+}
+
+func TestNormalizeStopwordList(t *testing.T) {
+	list := &models.StopwordList{Name: "common", Words: []string{" The ", "the", "", "OF"}}
+	require.NoError(t, normalizeStopwordList(list))
+	assert.Equal(t, []string{"the", "of"}, list.Words)
+
+	err := normalizeStopwordList(&models.StopwordList{Name: "common", Words: []string{"of the"}})
+	assert.ErrorIs(t, err, ErrInvalidSearchAnalysis)
+
+	err = normalizeStopwordList(&models.StopwordList{Name: "common"})
+	assert.ErrorIs(t, err, ErrInvalidSearchAnalysis)
+}
+
+func TestSynonymRulesAndStopwords(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	rules := synonymRules([]models.SynonymSet{
+		{ID: first, Name: "same", Rules: []string{"sci-fi, science fiction", "vol => volume"}},
+		{ID: second, Name: "same", Rules: []string{"ebook, e-book"}},
+	})
+	assert.Equal(t, []database.SynonymRule{
+		{ID: first.Hex() + "-0", Synonyms: "sci-fi, science fiction"},
+		{ID: first.Hex() + "-1", Synonyms: "vol => volume"},
+		{ID: second.Hex() + "-0", Synonyms: "ebook, e-book"},
+	}, rules)
+	assert.Equal(t, []database.SynonymRule{}, synonymRules(nil), "an empty set clears the rules")
+
+	words := mergeStopwords([]models.StopwordList{{Words: []string{"the", "of"}}, {Words: []string{"a", "the"}}})
+	assert.Equal(t, []string{"a", "of", "the"}, words)
+	assert.True(t, sameWords([]string{"the", "a", "of"}, words))
+	assert.False(t, sameWords([]string{"the", "a"}, words))
+}
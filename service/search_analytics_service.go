@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+)
+
+const searchAnalyticsTracerName = "search-analytics-service"
+
+// Report defaults: the last week, ten queries, and for click-through only
+// queries searched at least five times so one search does not make a rate.
+const (
+	defaultAnalyticsWindow  = 7 * 24 * time.Hour
+	defaultAnalyticsSize    = 10
+	maxAnalyticsSize        = 100
+	defaultClickMinSearches = 5
+)
+
+// ErrInvalidAnalytics is returned for malformed clicks and report options
+var ErrInvalidAnalytics = errors.New("invalid analytics request")
+
+// SearchEventRecorder stores search events in the background, so that
+// logging never slows a search down
+type SearchEventRecorder interface {
+	Record(event models.SearchEvent)
+}
+
+type SearchAnalyticsService interface {
+	// RecordSearch logs a search. The event is stamped and its query
+	// normalized here; the rest is filled in by the caller.
+	RecordSearch(ctx context.Context, event models.SearchEvent)
+	RecordClick(ctx context.Context, click models.SearchClick, requestID, userID string) error
+
+	// TopQueries lists the most searched queries
+	TopQueries(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error)
+	// ZeroResultQueries lists the queries that most often found nothing
+	ZeroResultQueries(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error)
+	// ClickThrough lists the most searched queries by click-through rate,
+	// lowest first
+	ClickThrough(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error)
+}
+
+type searchAnalyticsService struct {
+	repo     repository.SearchEventRepository
+	recorder SearchEventRecorder
+}
+
+func NewSearchAnalyticsService(repo repository.SearchEventRepository, recorder SearchEventRecorder) SearchAnalyticsService {
+	return &searchAnalyticsService{repo: repo, recorder: recorder}
+}
+
+func (s *searchAnalyticsService) RecordSearch(ctx context.Context, event models.SearchEvent) {
+	event.Event = models.SearchEventSearch
+	event.Timestamp = time.Now().UTC()
+	event.Query = strings.TrimSpace(event.Query)
+	event.QueryNormalized = normalizeQuery(event.Query)
+	s.recorder.Record(event)
+}
+
+func (s *searchAnalyticsService) RecordClick(ctx context.Context, click models.SearchClick, requestID, userID string) error {
+	tr := otel.Tracer(searchAnalyticsTracerName)
+	_, span := tr.Start(ctx, "RecordClick")
+	defer span.End()
+
+	click.SearchID = strings.TrimSpace(click.SearchID)
+	click.Query = strings.TrimSpace(click.Query)
+	switch {
+	case click.SearchID == "":
+		return fmt.Errorf("%w: search_id is required; send the X-Request-ID of the search", ErrInvalidAnalytics)
+	case click.Query == "":
+		return fmt.Errorf("%w: query is required", ErrInvalidAnalytics)
+	case !primitive.IsValidObjectID(click.BookID):
+		return fmt.Errorf("%w: book_id must be a book ID", ErrInvalidAnalytics)
+	case click.Position < 0:
+		return fmt.Errorf("%w: position must be 1 or more, or left out", ErrInvalidAnalytics)
+	}
+
+	s.recorder.Record(models.SearchEvent{
+		Event:           models.SearchEventClick,
+		Timestamp:       time.Now().UTC(),
+		RequestID:       requestID,
+		UserID:          userID,
+		Query:           click.Query,
+		QueryNormalized: normalizeQuery(click.Query),
+		SearchID:        click.SearchID,
+		BookID:          click.BookID,
+		Position:        click.Position,
+	})
+	return nil
+}
+
+func (s *searchAnalyticsService) TopQueries(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	tr := otel.Tracer(searchAnalyticsTracerName)
+	ctx, span := tr.Start(ctx, "TopQueries")
+	defer span.End()
+
+	if err := prepareReportOptions(&opts); err != nil {
+		return nil, err
+	}
+	return s.repo.QueryReport(ctx, opts)
+}
+
+func (s *searchAnalyticsService) ZeroResultQueries(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	tr := otel.Tracer(searchAnalyticsTracerName)
+	ctx, span := tr.Start(ctx, "ZeroResultQueries")
+	defer span.End()
+
+	if err := prepareReportOptions(&opts); err != nil {
+		return nil, err
+	}
+	opts.ZeroResultsOnly = true
+	return s.repo.QueryReport(ctx, opts)
+}
+
+func (s *searchAnalyticsService) ClickThrough(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	tr := otel.Tracer(searchAnalyticsTracerName)
+	ctx, span := tr.Start(ctx, "ClickThrough")
+	defer span.End()
+
+	if opts.MinSearches == 0 {
+		opts.MinSearches = defaultClickMinSearches
+	}
+	if err := prepareReportOptions(&opts); err != nil {
+		return nil, err
+	}
+
+	report, err := s.repo.QueryReport(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := report.Queries[:0]
+	for _, q := range report.Queries {
+		if q.Searches >= int64(opts.MinSearches) {
+			queries = append(queries, q)
+		}
+	}
+	sort.SliceStable(queries, func(i, j int) bool {
+		return queries[i].ClickThroughRate < queries[j].ClickThroughRate
+	})
+	report.Queries = queries
+	return report, nil
+}
+
+// prepareReportOptions fills in the default window and size and checks them
+func prepareReportOptions(opts *models.QueryReportOptions) error {
+	if opts.To.IsZero() {
+		opts.To = time.Now().UTC()
+	}
+	if opts.From.IsZero() {
+		opts.From = opts.To.Add(-defaultAnalyticsWindow)
+	}
+	if !opts.From.Before(opts.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidAnalytics)
+	}
+
+	if opts.Size == 0 {
+		opts.Size = defaultAnalyticsSize
+	}
+	if opts.Size < 1 || opts.Size > maxAnalyticsSize {
+		return fmt.Errorf("%w: size must be between 1 and %d", ErrInvalidAnalytics, maxAnalyticsSize)
+	}
+	return nil
+}
+
+// normalizeQuery lower-cases a query and collapses its whitespace, so that
+// analytics count trivially different spellings of a query together
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"go-elastic/models"
+	"go-elastic/repository"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventRecorder struct {
+	events []models.SearchEvent
+}
+
+func (r *eventRecorder) Record(event models.SearchEvent) {
+	r.events = append(r.events, event)
+}
+
+// reportRepo returns a canned report and records the options it was given
+type reportRepo struct {
+	repository.SearchEventRepository
+	report *models.QueryReport
+	got    models.QueryReportOptions
+}
+
+func (r *reportRepo) QueryReport(ctx context.Context, opts models.QueryReportOptions) (*models.QueryReport, error) {
+	r.got = opts
+	return r.report, nil
+}
+
+func TestRecordSearch(t *testing.T) {
+	recorder := &eventRecorder{}
+	svc := NewSearchAnalyticsService(nil, recorder)
+
+	svc.RecordSearch(context.Background(), models.SearchEvent{Query: "  Go   Programming ", Hits: 3})
+	require.Len(t, recorder.events, 1)
+	event := recorder.events[0]
+	assert.Equal(t, models.SearchEventSearch, event.Event)
+	assert.Equal(t, "Go   Programming", event.Query)
+	assert.Equal(t, "go programming", event.QueryNormalized)
+	assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+}
+
+func TestRecordClick(t *testing.T) {
+	recorder := &eventRecorder{}
+	svc := NewSearchAnalyticsService(nil, recorder)
+
+	click := models.SearchClick{SearchID: "req-1", Query: "Golang", BookID: "507f1f77bcf86cd799439011", Position: 2}
+	require.NoError(t, svc.RecordClick(context.Background(), click, "req-2", "user-7"))
+	assert.Equal(t, models.SearchEvent{
+		Event:           models.SearchEventClick,
+		Timestamp:       recorder.events[0].Timestamp,
+		RequestID:       "req-2",
+		UserID:          "user-7",
+		Query:           "Golang",
+		QueryNormalized: "golang",
+		SearchID:        "req-1",
+		BookID:          "507f1f77bcf86cd799439011",
+		Position:        2,
+	}, recorder.events[0])
+
+	for name, bad := range map[string]models.SearchClick{
+		"no search id": {Query: "go", BookID: click.BookID},
+		"no query":     {SearchID: "req-1", BookID: click.BookID},
+		"bad book":     {SearchID: "req-1", Query: "go", BookID: "42"},
+		"bad position": {SearchID: "req-1", Query: "go", BookID: click.BookID, Position: -1},
+	} {
+		assert.ErrorIs(t, svc.RecordClick(context.Background(), bad, "", ""), ErrInvalidAnalytics, name)
+	}
+	assert.Len(t, recorder.events, 1)
+}
+
+func TestQueryReports_Options(t *testing.T) {
+	repo := &reportRepo{report: &models.QueryReport{}}
+	svc := NewSearchAnalyticsService(repo, nil)
+
+	_, err := svc.TopQueries(context.Background(), models.QueryReportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 10, repo.got.Size)
+	assert.Equal(t, 7*24*time.Hour, repo.got.To.Sub(repo.got.From))
+	assert.False(t, repo.got.ZeroResultsOnly)
+
+	_, err = svc.ZeroResultQueries(context.Background(), models.QueryReportOptions{Size: 3})
+	require.NoError(t, err)
+	assert.True(t, repo.got.ZeroResultsOnly)
+	assert.Equal(t, 3, repo.got.Size)
+
+	now := time.Now()
+	for name, opts := range map[string]models.QueryReportOptions{
+		"reversed window": {AnalyticsWindow: models.AnalyticsWindow{From: now, To: now.Add(-time.Hour)}},
+		"too many":        {Size: 101},
+	} {
+		_, err := svc.TopQueries(context.Background(), opts)
+		assert.ErrorIs(t, err, ErrInvalidAnalytics, name)
+	}
+}
+
+func TestClickThrough(t *testing.T) {
+	repo := &reportRepo{report: &models.QueryReport{Queries: []models.QueryStats{
+		{Query: "golang", Searches: 20, ClickThroughRate: 0.6},
+		{Query: "rare", Searches: 2, ClickThroughRate: 0},
+		{Query: "thai", Searches: 10, ClickThroughRate: 0.1},
+	}}}
+	svc := NewSearchAnalyticsService(repo, nil)
+
+	report, err := svc.ClickThrough(context.Background(), models.QueryReportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, repo.got.MinSearches)
+	require.Len(t, report.Queries, 2, "too few searches for a rate")
+	assert.Equal(t, "thai", report.Queries[0].Query, "lowest rate first")
+	assert.Equal(t, "golang", report.Queries[1].Query)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"go-elastic/embedding"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultSearchFields are the fields and boosts used by type=multi searches
+const defaultSearchFields = "title^3,author^2,description,publisher"
+
+// Defaults for typo tolerance: AUTO allows one edit in words of 3-5
+// characters and two in longer words, and "did you mean" corrections are
+// offered when a query finds fewer than three books.
+const (
+	defaultFuzziness       = "AUTO"
+	defaultDidYouMeanBelow = 3
+)
+
+// SearchConfig holds the relevance settings for book search. It is loaded
+// from the environment so boosts can be tuned without a code change.
+type SearchConfig struct {
+	// Fields lists the fields queried by type=multi, in Elasticsearch
+	// "field^boost" notation.
+	Fields []string
+	// MinimumShouldMatch is the default minimum_should_match for searches
+	// that do not set one, e.g. "75%". Empty leaves the Elasticsearch default.
+	MinimumShouldMatch string
+	// Fuzziness is the default edit distance per term for searches that do
+	// not set one; "0" turns fuzzy matching off.
+	Fuzziness string
+	// DidYouMeanBelow is the hit count under which a search response
+	// includes spelling corrections; 0 turns them off.
+	DidYouMeanBelow int
+	// Embedder embeds books and queries for semantic and hybrid search. Nil
+	// turns those modes off.
+	Embedder embedding.Embedder
+	// Health reports whether Elasticsearch is reachable. While it is not,
+	// keyword searches run on MongoDB instead. Nil turns the fallback off.
+	Health SearchHealth
+}
+
+// SearchHealth reports whether Elasticsearch can currently be searched
+type SearchHealth interface {
+	Healthy() bool
+}
+
+// LoadSearchConfig reads SEARCH_FIELDS (comma-separated "field^boost" list),
+// SEARCH_MINIMUM_SHOULD_MATCH, SEARCH_FUZZINESS, SEARCH_DID_YOU_MEAN_BELOW
+// and SEARCH_EMBEDDER ("hashing", the default, or "none").
+func LoadSearchConfig() SearchConfig {
+	fields := os.Getenv("SEARCH_FIELDS")
+	if fields == "" {
+		fields = defaultSearchFields
+	}
+
+	fuzziness := os.Getenv("SEARCH_FUZZINESS")
+	if fuzziness == "" {
+		fuzziness = defaultFuzziness
+	}
+
+	didYouMeanBelow := defaultDidYouMeanBelow
+	if n, err := strconv.Atoi(os.Getenv("SEARCH_DID_YOU_MEAN_BELOW")); err == nil && n >= 0 {
+		didYouMeanBelow = n
+	}
+
+	var embedder embedding.Embedder
+	if os.Getenv("SEARCH_EMBEDDER") != "none" {
+		embedder = embedding.NewHashingEmbedder(embedding.DefaultDims)
+	}
+
+	return SearchConfig{
+		Fields:             splitFields(fields),
+		MinimumShouldMatch: os.Getenv("SEARCH_MINIMUM_SHOULD_MATCH"),
+		Fuzziness:          fuzziness,
+		DidYouMeanBelow:    didYouMeanBelow,
+		Embedder:           embedder,
+	}
+}
+
+func splitFields(list string) []string {
+	var fields []string
+	for _, f := range strings.Split(list, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
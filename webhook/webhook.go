@@ -0,0 +1,144 @@
+// Package webhook POSTs notifications to user-supplied URLs. Anyone can set
+// such a URL, so it only ever connects to public addresses: not loopback,
+// private, link-local or other special ranges, which would let a saved search
+// reach Elasticsearch, MongoDB or a cloud metadata service.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for a webhook whose host is, or resolves
+// to, an address that is not public.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// nonPublic are the ranges outside of the stdlib address classes that are
+// not reachable on the internet either
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// Public reports whether ip is an address a webhook may connect to
+func Public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupHost resolves a host name; tests replace it
+var lookupHost = net.DefaultResolver.LookupNetIP
+
+// CheckURL checks that raw is an absolute http or https URL whose host is a
+// public address or resolves only to public addresses. The host is resolved
+// again on every delivery, so a name that later points elsewhere is still
+// refused.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("webhook_url must be an absolute http or https URL")
+	}
+
+	host := u.Hostname()
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !Public(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+		}
+		return nil
+	}
+	ips, err := lookupHost(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve webhook host %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if !Public(ip) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrForbiddenAddress, host, ip)
+		}
+	}
+	return nil
+}
+
+// Sender POSTs a JSON payload to a URL and returns the response status
+type Sender interface {
+	Send(ctx context.Context, url string, payload interface{}) (int, error)
+}
+
+// NewSender returns a Sender that gives up after timeout and only connects
+// to public addresses, checked after the host is resolved, including on
+// redirects. It does not go through a proxy, which would connect for it.
+func NewSender(timeout time.Duration) Sender {
+	return newSender(timeout, Public)
+}
+
+func newSender(timeout time.Duration, allow func(netip.Addr) bool) Sender {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allow(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &httpSender{client: &http.Client{Timeout: timeout, Transport: transport}}
+}
+
+type httpSender struct {
+	client *http.Client
+}
+
+func (s *httpSender) Send(ctx context.Context, webhookURL string, payload interface{}) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
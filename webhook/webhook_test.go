@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublic(t *testing.T) {
+	for _, ip := range []string{"93.184.216.34", "8.8.8.8", "2606:4700::1111"} {
+		assert.True(t, Public(netip.MustParseAddr(ip)), ip)
+	}
+	for _, ip := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"0.0.0.0", "100.64.0.1", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "224.0.0.1",
+	} {
+		assert.False(t, Public(netip.MustParseAddr(ip)), ip)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	defer func(lookup func(ctx context.Context, network, host string) ([]netip.Addr, error)) {
+		lookupHost = lookup
+	}(lookupHost)
+	lookupHost = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		switch host {
+		case "hooks.example.com":
+			return []netip.Addr{netip.MustParseAddr("93.184.216.34")}, nil
+		case "internal.example.com":
+			return []netip.Addr{netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("10.0.0.5")}, nil
+		}
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+	}
+
+	ctx := context.Background()
+	assert.NoError(t, CheckURL(ctx, "https://hooks.example.com/books"))
+	assert.NoError(t, CheckURL(ctx, "http://93.184.216.34:8080/books"))
+
+	for _, raw := range []string{
+		"http://localhost:9200/books/_delete_by_query",
+		"http://127.0.0.1/",
+		"http://[::1]/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/",
+		"https://internal.example.com/",
+	} {
+		assert.ErrorIs(t, CheckURL(ctx, raw), ErrForbiddenAddress, raw)
+	}
+	assert.ErrorContains(t, CheckURL(ctx, "ftp://example.com"), "absolute http or https")
+	assert.ErrorContains(t, CheckURL(ctx, "/hook"), "absolute http or https")
+}
+
+func TestSender_RefusesPrivateAddressOnDelivery(t *testing.T) {
+	var called bool
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer hook.Close()
+
+	_, err := NewSender(time.Second).Send(context.Background(), hook.URL, map[string]string{"a": "b"})
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+	assert.False(t, called)
+}
+
+func TestSender(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+
+	sender := newSender(time.Second, func(netip.Addr) bool { return true })
+	status, err := sender.Send(context.Background(), hook.URL, map[string]string{"a": "b"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, err = sender.Send(context.Background(), hook.URL+"/down", nil)
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.ErrorContains(t, err, "502")
+}